@@ -188,8 +188,8 @@ def mockten_pipeline():
             "transactions": "SELECT t.transaction_id, t.product_id, t.geo_id, t.status, t.leg_type, t.created_at, g.user_id FROM `Transaction` t JOIN Geo g ON t.geo_id = g.geo_id",
             "products": "SELECT product_id, product_name, category_id, price, avg_review, review_count FROM Product",
             "stock": "SELECT product_id, stocks FROM Stock",
-            # Wishlist.product_ids is JSON; expand to rows via SQL
-            "wishlist": "SELECT user_id, JSON_UNQUOTE(jt.product_id) AS product_id FROM Wishlist, JSON_TABLE(product_ids, '$[*]' COLUMNS(product_id VARCHAR(36) PATH '$')) AS jt",
+            # One row per user and product, whichever of the user's lists holds it
+            "wishlist": "SELECT DISTINCT w.user_id, i.product_id FROM WishlistItem i JOIN Wishlist w ON w.wishlist_id = i.wishlist_id",
             # ReviewRating table doesn't exist; use Review
             "reviews": "SELECT product_id, user_id, rating, created_at FROM Review WHERE status='active'",
             # No UserBehavior table; derive from Order (purchase events)
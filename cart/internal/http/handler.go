@@ -8,7 +8,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/mockten/mockten/cart/internal/cartstore"
 	"github.com/mockten/mockten/cart/internal/service"
+	"github.com/mockten/mockten/common/apierr"
 	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/redis/go-redis/v9"
 )
 
 type Handler struct {
@@ -23,10 +25,20 @@ func NewHandler(viewSvc *service.CartService, cartStore *cartstore.RedisCartStor
 	}
 }
 
+// storeError classifies a cart store failure: a write that kept losing the
+// optimistic-lock race is a conflict the client can retry, anything else is
+// Redis being unavailable.
+func storeError(err error) *apierr.Error {
+	if errors.Is(err, redis.TxFailedErr) {
+		return apierr.Conflict("cart was changed concurrently, please retry").WithCause(err)
+	}
+	return apierr.Unavailable("cart storage unavailable", err)
+}
+
 func (h *Handler) GetMeCart(c *gin.Context) {
 	uid, ok := commonauth.GetUserID(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing X-User-Id"})
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 		return
 	}
 
@@ -40,7 +52,7 @@ func (h *Handler) GetMeCart(c *gin.Context) {
 			})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Internal("failed to load cart", err))
 		return
 	}
 
@@ -58,18 +70,18 @@ type AddItemReq struct {
 func (h *Handler) AddItem(c *gin.Context) {
 	uid, ok := commonauth.GetUserID(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing X-User-Id"})
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 		return
 	}
 
 	var req AddItemReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
 	if _, err := h.cartStore.AddItem(c.Request.Context(), uid, req.ProductID, req.Quantity, req.ShippingFee, req.ShippingType, req.ShippingDays); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierr.Abort(c, storeError(err))
 		return
 	}
 
@@ -83,7 +95,7 @@ type SetQtyReq struct {
 func (h *Handler) SetItemQty(c *gin.Context) {
 	uid, ok := commonauth.GetUserID(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing X-User-Id"})
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 		return
 	}
 
@@ -91,12 +103,12 @@ func (h *Handler) SetItemQty(c *gin.Context) {
 
 	var req SetQtyReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
 	if _, err := h.cartStore.SetItemQty(c.Request.Context(), uid, productID, req.Quantity); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierr.Abort(c, storeError(err))
 		return
 	}
 
@@ -106,14 +118,14 @@ func (h *Handler) SetItemQty(c *gin.Context) {
 func (h *Handler) RemoveItem(c *gin.Context) {
 	uid, ok := commonauth.GetUserID(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing X-User-Id"})
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 		return
 	}
 
 	productID := c.Param("productId")
 
 	if _, err := h.cartStore.RemoveItem(c.Request.Context(), uid, productID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierr.Abort(c, storeError(err))
 		return
 	}
 
@@ -123,12 +135,12 @@ func (h *Handler) RemoveItem(c *gin.Context) {
 func (h *Handler) ClearCart(c *gin.Context) {
 	uid, ok := commonauth.GetUserID(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing X-User-Id"})
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 		return
 	}
 
 	if _, err := h.cartStore.ClearCart(c.Request.Context(), uid); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierr.Abort(c, storeError(err))
 		return
 	}
 
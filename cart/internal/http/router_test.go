@@ -0,0 +1,68 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/cart/internal/cartstore"
+	"github.com/mockten/mockten/cart/internal/model"
+	"github.com/mockten/mockten/cart/internal/service"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/auth/authtest"
+)
+
+type fakeCarts map[string]*model.RedisCart
+
+func (f fakeCarts) Get(_ context.Context, userID string) (*model.RedisCart, error) {
+	if c, ok := f[userID]; ok {
+		return c, nil
+	}
+	return nil, cartstore.ErrCartNotFound
+}
+
+type fakeProducts struct{}
+
+func (fakeProducts) GetByIDs(context.Context, []string) ([]model.Product, error) {
+	return nil, nil
+}
+
+func TestGetMeCartAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	iss := authtest.NewIssuer(t)
+	authn := iss.Authenticator(t, commonauth.Options{})
+
+	carts := fakeCarts{"a@x.io": {UpdatedAt: time.Now(), Cart: []model.RedisCartItem{}}}
+	h := NewHandler(service.NewCartService(carts, fakeProducts{}), nil)
+	r := gin.New()
+	RegisterRoutes(r, h, authn)
+
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"expired", iss.Bearer(t, authtest.WithExpiry(-time.Hour)), http.StatusUnauthorized},
+		{"known cart", iss.Bearer(t, authtest.WithEmail("a@x.io")), http.StatusOK},
+		{"no cart yet", iss.Bearer(t, authtest.WithEmail("b@x.io")), http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/v1/cart/", nil)
+		if c.header != "" {
+			req.Header.Set("Authorization", c.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != c.want {
+			t.Errorf("%s: status = %d, want %d (%s)", c.name, w.Code, c.want, w.Body.String())
+		}
+		if c.want == http.StatusUnauthorized && !strings.Contains(w.Body.String(), `"code":"unauthorized"`) {
+			t.Errorf("%s: body = %s, want the error envelope", c.name, w.Body.String())
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
@@ -21,6 +20,12 @@ import (
 	"github.com/mockten/mockten/cart/internal/service"
 
 	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/tracing"
 	"go.uber.org/zap"
 )
 
@@ -28,29 +33,17 @@ const (
 	port = "50053"
 )
 
-// func mustGetenv(key string) string {
-// 	v := os.Getenv(key)
-// 	if v == "" {
-// 		log.Fatalf("missing env: %s", key)
-// 	}
-// 	return v
-// }
-
-func getenvInt(key string, def int) int {
-	v := os.Getenv(key)
-	if v == "" {
-		return def
-	}
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		log.Fatalf("invalid int env %s=%q: %v", key, v, err)
-	}
-	return n
+// Config is read from the environment by common/config.
+type Config struct {
+	MySQL       config.MySQL `json:"mysql"`
+	Redis       config.Redis `json:"redis"`
+	Environment string       `json:"environment" env:"mockten_environment"`
+	// 0 means no expiration
+	CartTTLSeconds int `json:"cart_ttl_seconds" env:"CART_TTL_SECONDS" default:"0" validate:"min=0"`
 }
 
-func getenvDurationSeconds(key string, defSeconds int) time.Duration {
-	sec := getenvInt(key, defSeconds)
-	return time.Duration(sec) * time.Second
+func (c *Config) CartTTL() time.Duration {
+	return time.Duration(c.CartTTLSeconds) * time.Second
 }
 
 func retry(logger *zap.Logger, name string, timeout time.Duration, sleep time.Duration, fn func() error) error {
@@ -69,63 +62,31 @@ func retry(logger *zap.Logger, name string, timeout time.Duration, sleep time.Du
 }
 
 func main() {
-	var logger *zap.Logger
-	var err error
-	if os.Getenv("mockten_environment") == "production" {
-		logger, err = zap.NewProduction()
-	} else {
-		logger, err = zap.NewDevelopment()
-	}
+	cfg, err := config.Load[Config](config.Options{})
 	if err != nil {
 		log.Fatal(err)
 	}
+	logger := logging.Init("cart", logging.Options{Development: cfg.Environment != "production"})
 	defer logger.Sync()
-	zap.ReplaceGlobals(logger)
-
-	retryTimeout := 10 * time.Minute
-	retrySleep := 30 * time.Second
-
-	var authn *commonauth.Authenticator
-	if err := retry(logger, "authenticator", retryTimeout, retrySleep, func() error {
-		var e error
-		authn, e = commonauth.NewAuthenticatorFromEnv(commonauth.Options{Logger: logger})
-		return e
-	}); err != nil {
-		logger.Fatal("failed to init authenticator", zap.Error(err))
-	}
-	defer authn.Close()
+	logger.Info("config loaded", zap.String("config", config.Dump(cfg)))
 
-	// ---- env ----
-	// Example: user:pass@tcp(localhost:3306)/dbname?parseTime=true&charset=utf8mb4&loc=UTC
-	// mysqlDSN := mustGetenv("MYSQL_DSN")
-	mysqlDSN := os.Getenv("MYSQL_DSN")
-	if mysqlDSN == "" {
-		mysqlDSN = "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
-	}
-	// redis-service.default.svc.cluster.local:6379
-	// redisAddr := mustGetenv("REDIS_ADDR")
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "redis-service.default.svc.cluster.local:6379"
-	}
-	// mocktenpass
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	if redisPassword == "" {
-		redisPassword = "mocktenpass"
+	shutdownTracing, err := tracing.Init(context.Background(), "cart")
+	if err != nil {
+		logger.Fatal("failed to set up tracing", zap.Error(err))
 	}
-	redisDB := getenvInt("REDIS_DB", 0)
+	defer func() { _ = shutdownTracing(context.Background()) }()
 
-	// 0 means no expiration
-	cartTTL := getenvDurationSeconds("CART_TTL_SECONDS", 0)
+	retryTimeout := 10 * time.Minute
+	retrySleep := 30 * time.Second
 
 	// ---- MySQL ----
 	var db *sqlx.DB
 	if err := retry(logger, "mysql", retryTimeout, retrySleep, func() error {
-		var e error
-		db, e = sqlx.Open("mysql", mysqlDSN)
+		sqlDB, e := tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 		if e != nil {
 			return e
 		}
+		db = sqlx.NewDb(sqlDB, "mysql")
 		db.SetMaxOpenConns(5)
 		db.SetMaxIdleConns(3)
 		db.SetConnMaxLifetime(5 * time.Minute)
@@ -134,25 +95,50 @@ func main() {
 		logger.Fatal("failed to open/ping mysql", zap.Error(err))
 	}
 	logger.Info("MySQL connected")
+	if err := migrate.Ensure(context.Background(), db.DB, logger); err != nil {
+		logger.Fatal("mysql schema not ready", zap.Error(err))
+	}
 
 	// ---- Redis ----
 	var rdb *redis.Client
 	if err := retry(logger, "redis", retryTimeout, retrySleep, func() error {
 		rdb = redis.NewClient(&redis.Options{
-			Addr:         redisAddr,
-			Password:     redisPassword,
-			DB:           redisDB,
+			Addr:         cfg.Redis.Addr,
+			Password:     cfg.Redis.Password.Value(),
+			DB:           cfg.Redis.DB,
 			PoolSize:     5,
 			MinIdleConns: 1,
 		})
+		tracing.InstrumentRedis(rdb)
 		return rdb.Ping(context.Background()).Err()
 	}); err != nil {
 		logger.Fatal("failed to ping redis", zap.Error(err))
 	}
 	logger.Info("Redis connected")
 
+	// ---- Auth ----
+	// The denylist shares the cart Redis so an admin revoke reaches every replica.
+	authOpts := commonauth.Options{
+		Logger:   logger,
+		Denylist: commonauth.NewRedisDenylist(rdb),
+		// support staff "view as user" tokens (AUTH_IMPERSONATION_KEY), each request audited
+		Auditor: commonauth.NewSQLAuditLog(db.DB),
+	}
+	if ki := commonauth.NewKeycloakIntrospectorFromEnv(); ki != nil {
+		authOpts.Introspector = commonauth.NewCachedIntrospector(ki, rdb, 30*time.Second)
+	}
+	var authn *commonauth.Authenticator
+	if err := retry(logger, "authenticator", retryTimeout, retrySleep, func() error {
+		var e error
+		authn, e = commonauth.NewAuthenticatorFromEnv(authOpts)
+		return e
+	}); err != nil {
+		logger.Fatal("failed to init authenticator", zap.Error(err))
+	}
+	defer authn.Close()
+
 	// ---- DI ----
-	cStore := cartstore.NewRedisCartStore(rdb, cartTTL)
+	cStore := cartstore.NewRedisCartStore(rdb, cfg.CartTTL())
 	pRepo := productrepo.NewMySQLProductRepo(db)
 
 	viewSvc := service.NewCartService(cStore, pRepo)
@@ -160,8 +146,13 @@ func main() {
 
 	// ---- Router ----
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	r.Use(gin.Recovery(), tracing.Gin("cart"), logging.Gin(logger), metrics.Gin("cart"))
 	ihttp.RegisterRoutes(r, h, authn)
+	health.New("cart").
+		Critical("mysql", health.SQL(db.DB)).
+		Critical("redis", health.Redis(rdb)).
+		Optional("jwks", authn.CheckJWKS).
+		Gin(r)
 
 	// ---- HTTP server + graceful shutdown ----
 	srv := &http.Server{
@@ -170,6 +161,12 @@ func main() {
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
+	go func() {
+		if err := metrics.Serve(":9100"); err != nil {
+			logger.Error("metrics server failed", zap.Error(err))
+		}
+	}()
+
 	go func() {
 		logger.Info("cart-service listening", zap.String("addr", port))
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -1,26 +1,39 @@
 package main
 
 import (
-	"os"
 	"testing"
 	"time"
+
+	"github.com/mockten/mockten/common/config"
 )
 
-func TestGetenvInt(t *testing.T) {
-	os.Unsetenv("MOCKTEN_TEST_INT")
-	if got := getenvInt("MOCKTEN_TEST_INT", 42); got != 42 {
-		t.Errorf("default not returned: got %d want 42", got)
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("MYSQL_DSN", "")
+	if _, err := config.Load[Config](config.Options{}); err == nil {
+		t.Error("missing MYSQL_DSN accepted")
+	}
+
+	t.Setenv("MYSQL_DSN", "u:p@tcp(db:3306)/mocktendb")
+	t.Setenv("CART_TTL_SECONDS", "")
+	cfg, err := config.Load[Config](config.Options{})
+	if err != nil {
+		t.Fatal(err)
 	}
-	os.Setenv("MOCKTEN_TEST_INT", "7")
-	defer os.Unsetenv("MOCKTEN_TEST_INT")
-	if got := getenvInt("MOCKTEN_TEST_INT", 42); got != 7 {
-		t.Errorf("env value not used: got %d want 7", got)
+	if cfg.CartTTL() != 0 || cfg.Redis.Addr != "redis-service.default.svc.cluster.local:6379" {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+
+	t.Setenv("CART_TTL_SECONDS", "90")
+	t.Setenv("REDIS_DB", "2")
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CartTTL() != 90*time.Second || cfg.Redis.DB != 2 {
+		t.Errorf("env values not used: ttl %v, db %d", cfg.CartTTL(), cfg.Redis.DB)
 	}
-}
 
-func TestGetenvDurationSeconds(t *testing.T) {
-	os.Unsetenv("MOCKTEN_TEST_DUR")
-	if got := getenvDurationSeconds("MOCKTEN_TEST_DUR", 5); got != 5*time.Second {
-		t.Errorf("default duration wrong: got %v want 5s", got)
+	t.Setenv("CART_TTL_SECONDS", "-1")
+	if _, err := config.Load[Config](config.Options{}); err == nil {
+		t.Error("negative CART_TTL_SECONDS accepted")
 	}
 }
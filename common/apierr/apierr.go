@@ -0,0 +1,130 @@
+// Package apierr is the error model shared by the HTTP services: typed domain
+// errors with a stable code, mapped to an HTTP status and written as one JSON
+// envelope, so clients branch on the code instead of parsing messages.
+//
+// Only the message (and field details) reach the client. The cause of an
+// internal or dependency error — a SQL error, a Stripe response — is logged
+// with the request id and never sent.
+package apierr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Code is the machine-readable error class in the envelope.
+type Code string
+
+const (
+	CodeNotFound              Code = "not_found"
+	CodeConflict              Code = "conflict"
+	CodeValidation            Code = "validation"
+	CodeUnauthorized          Code = "unauthorized"
+	CodeForbidden             Code = "forbidden"
+	CodeMethodNotAllowed      Code = "method_not_allowed"
+	CodeRateLimited           Code = "rate_limited"
+	CodeDependencyUnavailable Code = "dependency_unavailable"
+	CodeInternal              Code = "internal"
+)
+
+// Status is the HTTP status a code is written with.
+func (c Code) Status() int {
+	switch c {
+	case CodeNotFound:
+		return http.StatusNotFound
+	case CodeConflict:
+		return http.StatusConflict
+	case CodeValidation:
+		return http.StatusBadRequest
+	case CodeUnauthorized:
+		return http.StatusUnauthorized
+	case CodeForbidden:
+		return http.StatusForbidden
+	case CodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case CodeRateLimited:
+		return http.StatusTooManyRequests
+	case CodeDependencyUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError is one invalid input field of a validation error.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is a domain error. Message is shown to the client; Cause is only
+// logged.
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Cause.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Cause }
+
+// Status is the HTTP status the error is written with.
+func (e *Error) Status() int { return e.Code.Status() }
+
+// Field adds an invalid field to a validation error.
+func (e *Error) Field(field, message string) *Error {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+	return e
+}
+
+// WithCause records the underlying error for the log.
+func (e *Error) WithCause(err error) *Error {
+	e.Cause = err
+	return e
+}
+
+func NotFound(message string) *Error     { return &Error{Code: CodeNotFound, Message: message} }
+func Conflict(message string) *Error     { return &Error{Code: CodeConflict, Message: message} }
+func Validation(message string) *Error   { return &Error{Code: CodeValidation, Message: message} }
+func Unauthorized(message string) *Error { return &Error{Code: CodeUnauthorized, Message: message} }
+func Forbidden(message string) *Error    { return &Error{Code: CodeForbidden, Message: message} }
+func RateLimited(message string) *Error  { return &Error{Code: CodeRateLimited, Message: message} }
+
+// MethodNotAllowed is for net/http handlers that switch on the method
+// themselves.
+func MethodNotAllowed() *Error {
+	return &Error{Code: CodeMethodNotAllowed, Message: "method not allowed"}
+}
+
+// Unavailable is a failing dependency (MeiliSearch, Stripe, Nominatim, another
+// service): the request may succeed later.
+func Unavailable(message string, cause error) *Error {
+	return &Error{Code: CodeDependencyUnavailable, Message: message, Cause: cause}
+}
+
+// Internal is anything else that went wrong on our side, typically a database
+// error. cause may be nil when it has already been logged.
+func Internal(message string, cause error) *Error {
+	return &Error{Code: CodeInternal, Message: message, Cause: cause}
+}
+
+// From returns err as an *Error. Errors that are not one become internal
+// errors with a generic message, except a timed-out context, which is a
+// dependency that did not answer in time.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Unavailable("upstream timed out", err)
+	}
+	return Internal("internal error", err)
+}
@@ -0,0 +1,104 @@
+package apierr
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) Envelope {
+	t.Helper()
+	var env Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &env); err != nil {
+		t.Fatalf("body %q: %v", w.Body.String(), err)
+	}
+	return env
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		err    error
+		status int
+		code   Code
+		msg    string
+	}{
+		{NotFound("product not found"), http.StatusNotFound, CodeNotFound, "product not found"},
+		{Conflict("already a favorite"), http.StatusConflict, CodeConflict, "already a favorite"},
+		{Unauthorized("missing token"), http.StatusUnauthorized, CodeUnauthorized, "missing token"},
+		{Forbidden("api key lacks scope"), http.StatusForbidden, CodeForbidden, "api key lacks scope"},
+		{RateLimited("rate limit exceeded"), http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded"},
+		{Unavailable("search unavailable", fmt.Errorf("dial tcp: connection refused")), http.StatusServiceUnavailable, CodeDependencyUnavailable, "search unavailable"},
+		{fmt.Errorf("add item: %w", Validation("quantity must be positive")), http.StatusBadRequest, CodeValidation, "quantity must be positive"},
+		{sql.ErrConnDone, http.StatusInternalServerError, CodeInternal, "internal error"},
+		{context.DeadlineExceeded, http.StatusServiceUnavailable, CodeDependencyUnavailable, "upstream timed out"},
+	}
+	for _, tc := range cases {
+		r := gin.New()
+		r.GET("/x", func(c *gin.Context) { Abort(c, tc.err) })
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+		env := decode(t, w)
+		if w.Code != tc.status || env.Code != tc.code || env.Error != tc.msg {
+			t.Errorf("%v: got %d %+v", tc.err, w.Code, env)
+		}
+		if env.RequestID == "" || w.Header().Get(RequestIDHeader) != env.RequestID {
+			t.Errorf("%v: request id %q, header %q", tc.err, env.RequestID, w.Header().Get(RequestIDHeader))
+		}
+	}
+}
+
+func TestWriteKeepsCauseAndFieldsApart(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/shipping", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+
+	w := httptest.NewRecorder()
+	Write(w, req, Internal("database error", fmt.Errorf("Error 1146: Table 'mocktendb.Geo' doesn't exist")))
+	if body := w.Body.String(); strings.Contains(body, "1146") || !strings.Contains(body, `"requestId":"req-42"`) {
+		t.Fatalf("body = %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	Write(w, req, Validation("invalid address").Field("zip", "is required").Field("country", "unknown code"))
+	env := decode(t, w)
+	if w.Code != http.StatusBadRequest || len(env.Fields) != 2 || env.Fields[0] != (FieldError{"zip", "is required"}) {
+		t.Fatalf("got %d %+v", w.Code, env)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+}
+
+func TestBind(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	type addItem struct {
+		ProductID string `json:"product_id" binding:"required"`
+		Quantity  int    `json:"quantity" binding:"required,min=1,max=99"`
+	}
+	bind := func(body string) *Error {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		var req addItem
+		return Bind(c.ShouldBindJSON(&req))
+	}
+
+	e := bind(`{"quantity": 120}`)
+	want := []FieldError{{"product_id", "is required"}, {"quantity", "must be at most 99"}}
+	if e.Code != CodeValidation || fmt.Sprint(e.Fields) != fmt.Sprint(want) {
+		t.Fatalf("missing + too large: %+v", e)
+	}
+	if e := bind(`{"product_id": "p1", "quantity": "two"}`); len(e.Fields) != 1 || e.Fields[0] != (FieldError{"quantity", "must be an integer"}) {
+		t.Fatalf("wrong type: %+v", e)
+	}
+	if e := bind(``); e.Message != "request body is empty" {
+		t.Fatalf("empty: %+v", e)
+	}
+}
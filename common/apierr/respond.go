@@ -0,0 +1,55 @@
+package apierr
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/logging"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request id in and out; see common/logging.
+const RequestIDHeader = logging.RequestIDHeader
+
+// Envelope is the JSON body of every error response. Error stays a plain
+// string so clients that only show the message keep working.
+type Envelope struct {
+	Error     string       `json:"error"`
+	Code      Code         `json:"code"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestID string       `json:"requestId"`
+}
+
+// RequestID returns the request's id, assigning one and echoing it in the
+// response header the first time it is asked for.
+func RequestID(w http.ResponseWriter, r *http.Request) string {
+	return logging.RequestID(w, r)
+}
+
+func envelope(w http.ResponseWriter, r *http.Request, err error) (int, Envelope) {
+	e := From(err)
+	id := RequestID(w, r)
+	if e.Cause != nil {
+		l := logging.From(r.Context())
+		if logging.ID(r.Context()) == "" {
+			l = l.With(zap.String("request_id", id), zap.String("method", r.Method), zap.String("path", r.URL.Path))
+		}
+		l.Error("request failed", zap.Error(e))
+	}
+	return e.Status(), Envelope{Error: e.Message, Code: e.Code, Fields: e.Fields, RequestID: id}
+}
+
+// Abort writes err as the response of a Gin handler and stops the chain.
+func Abort(c *gin.Context, err error) {
+	status, body := envelope(c.Writer, c.Request, err)
+	c.AbortWithStatusJSON(status, body)
+}
+
+// Write writes err as the response of a net/http handler.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := envelope(w, r, err)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
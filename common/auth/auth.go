@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -12,22 +14,62 @@ import (
 	"github.com/MicahParks/keyfunc/v3"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/mockten/mockten/common/apierr"
+	"github.com/mockten/mockten/common/httpclient"
+	"github.com/mockten/mockten/common/logging"
 	"go.uber.org/zap"
 )
 
 type Authenticator struct {
 	logger *zap.Logger
 
-	jwks       keyfunc.Keyfunc
+	keyfunc    jwt.Keyfunc
+	jwksURL    string
 	jwksCancel context.CancelFunc
 
 	// user-id extraction
 	claimOrder []string
+
+	// claim validation (empty/zero = check off)
+	issuers     []string
+	audiences   []string
+	maxTokenAge time.Duration
+
+	denylist     Denylist
+	introspector Introspector
+
+	impersonationKey []byte
+	auditor          ImpersonationAuditor
 }
 
 type Options struct {
 	Logger     *zap.Logger
 	ClaimOrder []string // Example: []string{"email","preferred_username","sub"}
+
+	// Key source, first match wins:
+	//   1. Keyfunc  - injected verifier (tests, sidecars)
+	//   2. JWKSFile - static JWKS document on disk (falls back to KEYCLOAK_JWKS_FILE)
+	//   3. JWKSURL  - remote JWKS (falls back to buildJWKSURL)
+	Keyfunc  jwt.Keyfunc
+	JWKSFile string
+	JWKSURL  string
+
+	// Claim validation. Empty Issuers/Audiences fall back to KEYCLOAK_ISSUER /
+	// KEYCLOAK_AUDIENCE (comma separated); still empty leaves the check off.
+	// An audience matches either the "aud" claim or the "azp" (calling client).
+	Issuers     []string
+	Audiences   []string
+	MaxTokenAge time.Duration // reject tokens whose "iat" is older than this
+
+	// Revocation. Denylist errors are logged and the token is let through;
+	// introspection errors reject the token.
+	Denylist     Denylist
+	Introspector Introspector
+
+	// Impersonation tokens (see impersonation.go) are accepted only when a key
+	// is set (falls back to AUTH_IMPERSONATION_KEY) and an Auditor is given.
+	ImpersonationKey []byte
+	Auditor          ImpersonationAuditor
 }
 
 func NewAuthenticatorFromEnv(opts Options) (*Authenticator, error) {
@@ -40,9 +82,53 @@ func NewAuthenticatorFromEnv(opts Options) (*Authenticator, error) {
 		order = []string{"email", "preferred_username", "sub"}
 	}
 
-	jwksURL, err := buildJWKSURL()
-	if err != nil {
-		return nil, err
+	a := &Authenticator{
+		logger:       l,
+		claimOrder:   order,
+		issuers:      opts.Issuers,
+		audiences:    opts.Audiences,
+		maxTokenAge:  opts.MaxTokenAge,
+		denylist:     opts.Denylist,
+		introspector: opts.Introspector,
+
+		impersonationKey: opts.ImpersonationKey,
+		auditor:          opts.Auditor,
+	}
+	if len(a.impersonationKey) == 0 {
+		a.impersonationKey = ImpersonationKeyFromEnv()
+	}
+	if len(a.issuers) == 0 {
+		a.issuers = splitEnvList("KEYCLOAK_ISSUER")
+	}
+	if len(a.audiences) == 0 {
+		a.audiences = splitEnvList("KEYCLOAK_AUDIENCE")
+	}
+
+	if opts.Keyfunc != nil {
+		a.keyfunc = opts.Keyfunc
+		return a, nil
+	}
+
+	jwksFile := strings.TrimSpace(opts.JWKSFile)
+	if jwksFile == "" {
+		jwksFile = strings.TrimSpace(os.Getenv("KEYCLOAK_JWKS_FILE"))
+	}
+	if jwksFile != "" {
+		k, err := keyfuncFromJWKSFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		l.Info("using static JWKS file", zap.String("path", jwksFile))
+		a.keyfunc = k.Keyfunc
+		return a, nil
+	}
+
+	jwksURL := strings.TrimSpace(opts.JWKSURL)
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = buildJWKSURL(); err != nil {
+			return nil, err
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -51,13 +137,23 @@ func NewAuthenticatorFromEnv(opts Options) (*Authenticator, error) {
 		cancel()
 		return nil, err
 	}
+	a.keyfunc = j.Keyfunc
+	a.jwksURL = jwksURL
+	a.jwksCancel = cancel
+	return a, nil
+}
 
-	return &Authenticator{
-		logger:     l,
-		jwks:       j,
-		jwksCancel: cancel,
-		claimOrder: order,
-	}, nil
+// keyfuncFromJWKSFile loads a JWKS document once; the keys are never refreshed.
+func keyfuncFromJWKSFile(path string) (keyfunc.Keyfunc, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks file: %w", err)
+	}
+	k, err := keyfunc.NewJWKSetJSON(json.RawMessage(b))
+	if err != nil {
+		return nil, fmt.Errorf("parse jwks file %s: %w", path, err)
+	}
+	return k, nil
 }
 
 func (a *Authenticator) Close() {
@@ -66,11 +162,44 @@ func (a *Authenticator) Close() {
 	}
 }
 
+// jwksClient is not retried: the readiness check runs again soon anyway.
+var jwksClient = httpclient.New(httpclient.Options{Target: "keycloak-jwks", Timeout: 3 * time.Second, Retries: -1})
+
+// CheckJWKS fetches the remote JWKS, for a readiness check. Cached keys keep
+// verifying while Keycloak is away, but key rotation would not be picked up.
+// Keys from a file or an injected Keyfunc need no network and always pass.
+func (a *Authenticator) CheckJWKS(ctx context.Context) error {
+	if a.jwksURL == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.jwksURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS %s: %s", a.jwksURL, resp.Status)
+	}
+	return nil
+}
+
 func buildJWKSURL() (string, error) {
 	if v := strings.TrimSpace(os.Getenv("KEYCLOAK_JWKS_URL")); v != "" {
 		return v, nil
 	}
 
+	base, realm := keycloakBaseAndRealm()
+	if realm == "" {
+		return "", errors.New("KEYCLOAK_REALM is empty")
+	}
+	return base + "/realms/" + realm + "/protocol/openid-connect/certs", nil
+}
+
+func keycloakBaseAndRealm() (string, string) {
 	base := strings.TrimSpace(os.Getenv("KEYCLOAK_BASE_URL"))
 	if base == "" {
 		base = "http://uam-service.default.svc.cluster.local"
@@ -79,12 +208,17 @@ func buildJWKSURL() (string, error) {
 	if realm == "" {
 		realm = "mockten-realm-dev"
 	}
+	return strings.TrimRight(base, "/"), realm
+}
 
-	base = strings.TrimRight(base, "/")
-	if realm == "" {
-		return "", errors.New("KEYCLOAK_REALM is empty")
+func splitEnvList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
-	return base + "/realms/" + realm + "/protocol/openid-connect/certs", nil
+	return out
 }
 
 func bearerTokenFromHeader(h string) (string, bool) {
@@ -124,31 +258,195 @@ func jwtHeaderInfo(tokenStr string) (string, string) {
 	return strings.TrimSpace(kid), strings.TrimSpace(alg)
 }
 
-// This is the main function to extract user-id from "Authorization: Bearer"
+// This is the main function to extract user-id from "Authorization: Bearer".
+// Under impersonation it returns the subject, stores the Impersonation on the
+// context (see GetImpersonation) and writes the audit record once per request.
 func (a *Authenticator) UserIDFromGinContext(c *gin.Context) (string, error) {
-	tokenStr, ok := bearerTokenFromHeader(c.GetHeader("Authorization"))
+	uid, imp, err := a.principalFromRequest(c.Request)
+	if imp == nil {
+		return uid, err
+	}
+	c.Set(CtxImpersonationKey, imp)
+	if _, done := c.Get(ctxImpersonationAudited); !done {
+		c.Set(ctxImpersonationAudited, true)
+		if aerr := a.auditImpersonation(c.Request, imp, err == nil); aerr != nil && err == nil {
+			a.logger.Error("impersonation audit failed", zap.Error(aerr))
+			return "", aerr
+		}
+	}
+	return uid, err
+}
+
+// UserIDFromRequest is UserIDFromGinContext for plain net/http handlers. Call
+// it once per request: each call under impersonation writes an audit record.
+func (a *Authenticator) UserIDFromRequest(r *http.Request) (string, error) {
+	uid, imp, err := a.principalFromRequest(r)
+	if imp != nil {
+		if aerr := a.auditImpersonation(r, imp, err == nil); aerr != nil && err == nil {
+			a.logger.Error("impersonation audit failed", zap.Error(aerr))
+			return "", aerr
+		}
+	}
+	return uid, err
+}
+
+func (a *Authenticator) principalFromRequest(r *http.Request) (string, *Impersonation, error) {
+	tokenStr, ok := bearerTokenFromHeader(r.Header.Get("Authorization"))
 	if !ok {
-		return "", errors.New("missing bearer token")
+		return "", nil, errors.New("missing bearer token")
 	}
+	claims, err := a.ParseTokenCtx(r.Context(), tokenStr)
+	if err != nil {
+		return "", nil, err
+	}
+	uid, err := a.userIDFromClaims(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	imp, ok := ImpersonationFromClaims(claims)
+	if !ok {
+		logging.SetUser(r.Context(), uid)
+		return uid, nil, nil
+	}
+	if imp.ReadOnly && !isSafeMethod(r.Method) {
+		return "", imp, ErrImpersonationReadOnly
+	}
+	logging.SetUser(r.Context(), uid, zap.String("impersonator", imp.Impersonator))
+	return uid, imp, nil
+}
+
+// UserIDFromToken verifies a raw JWT and returns the first usable claim from
+// the configured claim order. Impersonation tokens are refused here: without
+// the request there is nothing to audit or to check read-only against.
+func (a *Authenticator) UserIDFromToken(tokenStr string) (string, error) {
+	return a.UserIDFromTokenCtx(context.Background(), tokenStr)
+}
+
+func (a *Authenticator) UserIDFromTokenCtx(ctx context.Context, tokenStr string) (string, error) {
+	claims, err := a.ParseTokenCtx(ctx, tokenStr)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ImpersonationFromClaims(claims); ok {
+		return "", ErrImpersonationNotAllowed
+	}
+	return a.userIDFromClaims(claims)
+}
+
+// ParseToken verifies a raw JWT and returns its claims. Callers making
+// authorization decisions must check ImpersonationFromClaims themselves.
+func (a *Authenticator) ParseToken(tokenStr string) (jwt.MapClaims, error) {
+	return a.ParseTokenCtx(context.Background(), tokenStr)
+}
+
+// ParseTokenCtx verifies signature and expiry, then issuer, audience and
+// freshness, then asks the denylist and introspector (when configured).
+// Impersonation tokens skip introspection: Keycloak never issued them, and the
+// denylist already revokes them by jti.
+func (a *Authenticator) ParseTokenCtx(ctx context.Context, tokenStr string) (jwt.MapClaims, error) {
+	claims, err := a.verify(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.validateClaims(claims); err != nil {
+		a.logger.Warn("JWT claims rejected", zap.Error(err))
+		return nil, err
+	}
+
+	if a.denylist != nil {
+		uid, _ := a.userIDFromClaims(claims)
+		revoked, err := a.denylist.IsRevoked(ctx, uid, claims)
+		if err != nil {
+			a.logger.Warn("denylist lookup failed", zap.Error(err))
+		} else if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	if _, imp := ImpersonationFromClaims(claims); a.introspector != nil && !imp {
+		active, err := a.introspector.Active(ctx, tokenStr)
+		if err != nil {
+			a.logger.Warn("token introspection failed", zap.Error(err))
+			return nil, err
+		}
+		if !active {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
 
+var ErrTokenRevoked = errors.New("token revoked")
+
+func (a *Authenticator) validateClaims(claims jwt.MapClaims) error {
+	// impersonation tokens are checked against their own issuer in verify
+	_, imp := ImpersonationFromClaims(claims)
+	if len(a.issuers) > 0 && !imp {
+		iss, _ := claims.GetIssuer()
+		if !containsString(a.issuers, iss) {
+			return fmt.Errorf("unexpected issuer %q", iss)
+		}
+	}
+	if len(a.audiences) > 0 && !imp {
+		aud, _ := claims.GetAudience()
+		azp, _ := claims["azp"].(string)
+		ok := containsString(a.audiences, azp)
+		for _, v := range aud {
+			ok = ok || containsString(a.audiences, v)
+		}
+		if !ok {
+			return fmt.Errorf("token not issued for this audience (aud=%v azp=%q)", []string(aud), azp)
+		}
+	}
+	if a.maxTokenAge > 0 {
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil {
+			return errors.New("token has no iat")
+		}
+		if time.Since(iat.Time) > a.maxTokenAge {
+			return errors.New("token too old")
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	if v == "" {
+		return false
+	}
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) verify(tokenStr string) (jwt.MapClaims, error) {
 	kid, alg := jwtHeaderInfo(tokenStr)
+	if alg == "HS256" {
+		return a.verifyImpersonation(tokenStr)
+	}
 
 	parser := jwt.NewParser(
 		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
 		jwt.WithLeeway(30*time.Second),
+		jwt.WithIssuedAt(),
 	)
 
 	var claims jwt.MapClaims
-	tok, err := parser.ParseWithClaims(tokenStr, &claims, a.jwks.Keyfunc)
+	tok, err := parser.ParseWithClaims(tokenStr, &claims, a.keyfunc)
 	if err != nil {
 		a.logger.Warn("JWT parse failed", zap.String("kid", kid), zap.String("alg", alg), zap.Error(err))
-		return "", err
+		return nil, err
 	}
 	if !tok.Valid {
 		a.logger.Warn("JWT invalid", zap.String("kid", kid), zap.String("alg", alg))
-		return "", errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
+	return claims, nil
+}
 
+func (a *Authenticator) userIDFromClaims(claims jwt.MapClaims) (string, error) {
 	for _, k := range a.claimOrder {
 		if v, ok := claims[k]; ok {
 			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
@@ -161,13 +459,22 @@ func (a *Authenticator) UserIDFromGinContext(c *gin.Context) (string, error) {
 
 // ---- Gin middleware (usable for common) ----
 
-const CtxUserIDKey = "user_id"
+const (
+	CtxUserIDKey        = "user_id"
+	CtxImpersonationKey = "impersonation"
+
+	ctxImpersonationAudited = "impersonation_audited"
+)
 
 func (a *Authenticator) RequireUserID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		uid, err := a.UserIDFromGinContext(c)
+		if errors.Is(err, ErrImpersonationReadOnly) {
+			apierr.Abort(c, apierr.Forbidden("impersonation is read-only"))
+			return
+		}
 		if err != nil {
-			c.AbortWithStatusJSON(401, gin.H{"error": "unauthorized"})
+			apierr.Abort(c, apierr.Unauthorized("unauthorized"))
 			return
 		}
 		c.Set(CtxUserIDKey, uid)
@@ -183,3 +490,14 @@ func GetUserID(c *gin.Context) (string, bool) {
 	s, ok := v.(string)
 	return s, ok && s != ""
 }
+
+// GetImpersonation returns who is acting for the user, when the request was
+// made with an impersonation token.
+func GetImpersonation(c *gin.Context) (*Impersonation, bool) {
+	v, ok := c.Get(CtxImpersonationKey)
+	if !ok {
+		return nil, false
+	}
+	imp, ok := v.(*Impersonation)
+	return imp, ok
+}
@@ -1,6 +1,10 @@
 package auth
 
-import "testing"
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
 
 func TestBearerTokenFromHeader(t *testing.T) {
 	cases := []struct {
@@ -21,3 +25,31 @@ func TestBearerTokenFromHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestAPIKeyFromHeader(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, prefix) || HashAPIKey(key) != hash || hash == HashAPIKey(key+"x") {
+		t.Fatalf("GenerateAPIKey = (%q, %q, %q)", key, prefix, hash)
+	}
+
+	cases := []struct {
+		name   string
+		header http.Header
+		wantOK bool
+	}{
+		{"x-api-key", http.Header{"X-Api-Key": {key}}, true},
+		{"authorization", http.Header{"Authorization": {"ApiKey " + key}}, true},
+		{"bearer jwt", http.Header{"Authorization": {"Bearer abc.def.ghi"}}, false},
+		{"foreign key", http.Header{"X-Api-Key": {"sk_live_123"}}, false},
+		{"none", http.Header{}, false},
+	}
+	for _, c := range cases {
+		got, ok := APIKeyFromHeader(c.header)
+		if ok != c.wantOK || (ok && got != key) {
+			t.Errorf("%s: APIKeyFromHeader = (%q, %v)", c.name, got, ok)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package authtest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mockten/mockten/common/auth"
+)
+
+func TestAuthenticatorKeySources(t *testing.T) {
+	iss := NewIssuer(t)
+
+	sources := map[string]auth.Options{
+		"jwks url":  {},
+		"jwks file": {JWKSFile: iss.WriteJWKSFile(t)},
+		"keyfunc": {Keyfunc: func(*jwt.Token) (any, error) {
+			return &iss.key.PublicKey, nil
+		}},
+	}
+	for name, opts := range sources {
+		t.Run(name, func(t *testing.T) {
+			a := iss.Authenticator(t, opts)
+			uid, err := a.UserIDFromToken(iss.Token(t, WithEmail("a@x.io")))
+			if err != nil || uid != "a@x.io" {
+				t.Fatalf("UserIDFromToken = (%q, %v), want a@x.io", uid, err)
+			}
+		})
+	}
+}
+
+func TestCheckJWKS(t *testing.T) {
+	iss := NewIssuer(t)
+	remote := iss.Authenticator(t, auth.Options{})
+	file := iss.Authenticator(t, auth.Options{JWKSFile: iss.WriteJWKSFile(t)})
+	if err := remote.CheckJWKS(context.Background()); err != nil {
+		t.Fatalf("CheckJWKS = %v", err)
+	}
+	iss.Server.Close()
+	if err := remote.CheckJWKS(context.Background()); err == nil {
+		t.Fatal("CheckJWKS passed with the issuer down")
+	}
+	if err := file.CheckJWKS(context.Background()); err != nil {
+		t.Fatalf("CheckJWKS with a key file = %v", err)
+	}
+}
+
+func TestAuthenticatorRejects(t *testing.T) {
+	iss := NewIssuer(t)
+	a := iss.Authenticator(t, auth.Options{})
+
+	other := NewIssuer(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	other.key = otherKey
+
+	cases := map[string]string{
+		"expired":      iss.Token(t, WithExpiry(-time.Hour)),
+		"foreign key":  other.Token(t),
+		"no id claims": iss.Token(t, WithClaim("email", nil), WithClaim("preferred_username", nil), WithClaim("sub", nil)),
+	}
+	for name, tok := range cases {
+		if uid, err := a.UserIDFromToken(tok); err == nil {
+			t.Errorf("%s: accepted as %q", name, uid)
+		}
+	}
+}
+
+type denyAll struct{ jti string }
+
+func (d denyAll) IsRevoked(_ context.Context, _ string, c jwt.MapClaims) (bool, error) {
+	return c["jti"] == d.jti, nil
+}
+
+func TestAuthenticatorClaimValidation(t *testing.T) {
+	iss := NewIssuer(t)
+	a := iss.Authenticator(t, auth.Options{
+		Issuers:     []string{iss.URL()},
+		Audiences:   []string{DefaultClientID},
+		MaxTokenAge: time.Hour,
+		Denylist:    denyAll{jti: "revoked-1"},
+	})
+
+	if _, err := a.UserIDFromToken(iss.Token(t)); err != nil {
+		t.Fatalf("default token rejected: %v", err)
+	}
+	// aud alone is enough when azp is some other client
+	if _, err := a.UserIDFromToken(iss.Token(t, WithClaim("azp", "other"), WithClaim("aud", []string{DefaultClientID}))); err != nil {
+		t.Errorf("aud match rejected: %v", err)
+	}
+
+	cases := map[string]string{
+		"wrong issuer":   iss.Token(t, WithClaim("iss", "http://evil/realms/x")),
+		"wrong audience": iss.Token(t, WithClaim("azp", "other")),
+		"stale":          iss.Token(t, WithClaim("iat", time.Now().Add(-2*time.Hour).Unix())),
+		"future iat":     iss.Token(t, WithClaim("iat", time.Now().Add(time.Hour).Unix())),
+		"denylisted":     iss.Token(t, WithClaim("jti", "revoked-1")),
+	}
+	for name, tok := range cases {
+		if uid, err := a.UserIDFromToken(tok); err == nil {
+			t.Errorf("%s: accepted as %q", name, uid)
+		}
+	}
+}
+
+type auditRecorder []string
+
+func (a *auditRecorder) RecordImpersonation(_ context.Context, imp *auth.Impersonation, route string, allowed bool) error {
+	*a = append(*a, fmt.Sprintf("%s as %s: %s %v", imp.Impersonator, imp.Subject, route, allowed))
+	return nil
+}
+
+func TestImpersonation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	iss := NewIssuer(t)
+	key := []byte("test-impersonation-key")
+	var audit auditRecorder
+	a := iss.Authenticator(t, auth.Options{Issuers: []string{iss.URL()}, ImpersonationKey: key, Auditor: &audit})
+
+	r := gin.New()
+	r.Use(a.RequireUserID())
+	handler := func(c *gin.Context) {
+		uid, _ := auth.GetUserID(c)
+		imp, _ := auth.GetImpersonation(c)
+		c.JSON(http.StatusOK, gin.H{"uid": uid, "admin": imp.Impersonator})
+	}
+	r.GET("/cart", handler)
+	r.POST("/cart", handler)
+	do := func(method, tok string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/cart", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	readOnly, _, err := auth.NewImpersonationSigner(key).Issue("admin@x.io", "buyer@x.io", false, time.Hour, "ticket 42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := do(http.MethodGet, readOnly); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"uid":"buyer@x.io"`) {
+		t.Fatalf("GET as subject: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, readOnly); w.Code != http.StatusForbidden {
+		t.Errorf("POST with read-only token: %d, want 403", w.Code)
+	}
+	want := []string{"admin@x.io as buyer@x.io: GET /cart true", "admin@x.io as buyer@x.io: POST /cart false"}
+	if fmt.Sprint(audit) != fmt.Sprint(want) {
+		t.Errorf("audit = %q, want %q", audit, want)
+	}
+
+	write, _, _ := auth.NewImpersonationSigner(key).Issue("admin@x.io", "buyer@x.io", true, time.Minute, "")
+	if w := do(http.MethodPost, write); w.Code != http.StatusOK {
+		t.Errorf("POST with write token: %d", w.Code)
+	}
+	if _, err := a.UserIDFromToken(write); err == nil {
+		t.Error("raw token path accepted an impersonation token")
+	}
+
+	forged, _, _ := auth.NewImpersonationSigner([]byte("other")).Issue("admin@x.io", "buyer@x.io", true, time.Minute, "")
+	noAudit := iss.Authenticator(t, auth.Options{ImpersonationKey: key})
+	noKey := iss.Authenticator(t, auth.Options{Auditor: &audit})
+	for name, tc := range map[string]struct {
+		a   *auth.Authenticator
+		tok string
+	}{
+		"forged key": {a, forged},
+		"no auditor": {noAudit, readOnly},
+		"no key":     {noKey, readOnly},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/cart", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.tok)
+		if uid, err := tc.a.UserIDFromRequest(req); err == nil {
+			t.Errorf("%s: accepted as %q", name, uid)
+		}
+	}
+}
+
+// keycloakIntrospector knows only the Keycloak tokens it is given, like the
+// real endpoint, and counts what it is asked about.
+type keycloakIntrospector struct {
+	active map[string]bool
+	asked  int
+}
+
+func (k *keycloakIntrospector) Active(_ context.Context, token string) (bool, error) {
+	k.asked++
+	return k.active[token], nil
+}
+
+func TestImpersonationSkipsIntrospection(t *testing.T) {
+	iss := NewIssuer(t)
+	key := []byte("test-impersonation-key")
+	live, revoked := iss.Token(t), iss.Token(t, WithClaim("jti", "kc-2"))
+	intro := &keycloakIntrospector{active: map[string]bool{live: true}}
+	imp, _, _ := auth.NewImpersonationSigner(key).Issue("admin@x.io", "buyer@x.io", false, time.Minute, "")
+	denied, info, _ := auth.NewImpersonationSigner(key).Issue("admin@x.io", "buyer@x.io", false, time.Minute, "")
+	a := iss.Authenticator(t, auth.Options{
+		Issuers:          []string{iss.URL()},
+		ImpersonationKey: key,
+		Auditor:          &auditRecorder{},
+		Denylist:         denyAll{jti: info.ID},
+		Introspector:     intro,
+	})
+
+	ctx := context.Background()
+	if _, err := a.ParseTokenCtx(ctx, imp); err != nil {
+		t.Fatalf("impersonation token rejected: %v", err)
+	}
+	if intro.asked != 0 {
+		t.Errorf("impersonation token was introspected")
+	}
+	if _, err := a.ParseTokenCtx(ctx, denied); err == nil {
+		t.Error("denylisted impersonation token accepted")
+	}
+	if _, err := a.ParseTokenCtx(ctx, live); err != nil {
+		t.Errorf("active Keycloak token rejected: %v", err)
+	}
+	if _, err := a.ParseTokenCtx(ctx, revoked); err == nil {
+		t.Error("inactive Keycloak token accepted")
+	}
+	if intro.asked != 2 {
+		t.Errorf("introspector asked %d times, want 2 (Keycloak tokens only)", intro.asked)
+	}
+}
@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mockten/mockten/common/text"
+)
+
+// ---- Impersonation ("view as user") ----
+
+// Support staff can act as a customer for troubleshooting. sale mints a short
+// HS256 token for the subject, signed with AUTH_IMPERSONATION_KEY; every service
+// whose Authenticator has the same key accepts it in place of a Keycloak token.
+// The token carries the admin in an RFC 8693 "act" claim, so the impersonator
+// is never lost, and is read-only unless the admin explicitly asked for write.
+
+const (
+	ImpersonationIssuer = "mockten-impersonation"
+	MaxImpersonationTTL = 15 * time.Minute
+)
+
+var (
+	ErrImpersonationReadOnly   = errors.New("impersonation token is read-only")
+	ErrImpersonationNotAudited = errors.New("impersonation requires an audit log")
+	ErrImpersonationNotAllowed = errors.New("impersonation token not accepted here")
+)
+
+type Impersonation struct {
+	ID           string // jti; revoke it with RedisDenylist.RevokeToken
+	Impersonator string // the admin
+	Subject      string // the user being viewed
+	ReadOnly     bool
+	Reason       string
+	ExpiresAt    time.Time
+}
+
+// ImpersonationKeyFromEnv reads AUTH_IMPERSONATION_KEY; empty means
+// impersonation tokens are neither issued nor accepted.
+func ImpersonationKeyFromEnv() []byte {
+	return []byte(strings.TrimSpace(os.Getenv("AUTH_IMPERSONATION_KEY")))
+}
+
+type ImpersonationSigner struct {
+	key []byte
+}
+
+func NewImpersonationSigner(key []byte) *ImpersonationSigner {
+	if len(key) == 0 {
+		return nil
+	}
+	return &ImpersonationSigner{key: key}
+}
+
+// Issue mints a token letting admin act as subject. ttl is capped at
+// MaxImpersonationTTL.
+func (s *ImpersonationSigner) Issue(admin, subject string, write bool, ttl time.Duration, reason string) (string, *Impersonation, error) {
+	if strings.TrimSpace(admin) == "" || strings.TrimSpace(subject) == "" {
+		return "", nil, errors.New("admin and subject are required")
+	}
+	if ttl <= 0 || ttl > MaxImpersonationTTL {
+		ttl = MaxImpersonationTTL
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	imp := &Impersonation{
+		ID:           hex.EncodeToString(b),
+		Impersonator: admin,
+		Subject:      subject,
+		ReadOnly:     !write,
+		Reason:       reason,
+		ExpiresAt:    now.Add(ttl),
+	}
+	scope := "read"
+	if write {
+		scope = "read write"
+	}
+	claims := jwt.MapClaims{
+		"iss":    ImpersonationIssuer,
+		"typ":    "Impersonation",
+		"sub":    subject,
+		"email":  subject,
+		"act":    map[string]any{"sub": admin},
+		"scope":  scope,
+		"reason": reason,
+		"jti":    imp.ID,
+		"iat":    now.Unix(),
+		"exp":    imp.ExpiresAt.Unix(),
+	}
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.key)
+	if err != nil {
+		return "", nil, err
+	}
+	return tok, imp, nil
+}
+
+// ImpersonationFromClaims reports whether verified claims belong to an
+// impersonation token and, if so, who is acting for whom.
+func ImpersonationFromClaims(claims jwt.MapClaims) (*Impersonation, bool) {
+	if iss, _ := claims.GetIssuer(); iss != ImpersonationIssuer {
+		return nil, false
+	}
+	act, _ := claims["act"].(map[string]any)
+	admin, _ := act["sub"].(string)
+	subject, _ := claims.GetSubject()
+	if admin == "" || subject == "" {
+		return nil, false
+	}
+	scope, _ := claims["scope"].(string)
+	imp := &Impersonation{
+		Impersonator: admin,
+		Subject:      subject,
+		ReadOnly:     !containsString(strings.Fields(scope), "write"),
+	}
+	imp.ID, _ = claims["jti"].(string)
+	imp.Reason, _ = claims["reason"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		imp.ExpiresAt = exp.Time
+	}
+	return imp, true
+}
+
+func (a *Authenticator) verifyImpersonation(tokenStr string) (jwt.MapClaims, error) {
+	if len(a.impersonationKey) == 0 {
+		return nil, ErrImpersonationNotAllowed
+	}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"HS256"}),
+		jwt.WithIssuer(ImpersonationIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+		jwt.WithIssuedAt(),
+	)
+	var claims jwt.MapClaims
+	if _, err := parser.ParseWithClaims(tokenStr, &claims, func(*jwt.Token) (any, error) {
+		return a.impersonationKey, nil
+	}); err != nil {
+		return nil, err
+	}
+	if _, ok := ImpersonationFromClaims(claims); !ok {
+		return nil, errors.New("malformed impersonation token")
+	}
+	return claims, nil
+}
+
+// isSafeMethod is what a read-only impersonation may do.
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions
+}
+
+// ImpersonationAuditor records every request made under impersonation. The
+// Authenticator refuses impersonation tokens when it has none, or when writing
+// the record fails, so no impersonated request goes unlogged.
+type ImpersonationAuditor interface {
+	RecordImpersonation(ctx context.Context, imp *Impersonation, route string, allowed bool) error
+}
+
+// SQLAuditLog writes to the shared AuditLog table: the admin is the actor, the
+// impersonated user the target, and the route goes in the action.
+type SQLAuditLog struct {
+	db *sql.DB
+}
+
+func NewSQLAuditLog(db *sql.DB) *SQLAuditLog {
+	return &SQLAuditLog{db: db}
+}
+
+func (s *SQLAuditLog) RecordImpersonation(ctx context.Context, imp *Impersonation, route string, allowed bool) error {
+	action := text.TruncateRunes("Impersonated "+route, 128)
+	status := "success"
+	if !allowed {
+		status = "failed"
+	}
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, ?)",
+		action, imp.Impersonator, imp.Subject, status)
+	return err
+}
+
+func (a *Authenticator) auditImpersonation(r *http.Request, imp *Impersonation, allowed bool) error {
+	if a.auditor == nil {
+		return ErrImpersonationNotAudited
+	}
+	if err := a.auditor.RecordImpersonation(r.Context(), imp, r.Method+" "+r.URL.Path, allowed); err != nil {
+		return errors.Join(ErrImpersonationNotAudited, err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/mockten/mockten/common/httpclient"
+)
+
+// ---- Denylist ----
+
+// Denylist rejects tokens before they expire: a single token (jti), a whole
+// Keycloak session (sid), or every token a user was issued up to a point in
+// time (suspension).
+type Denylist interface {
+	IsRevoked(ctx context.Context, userID string, claims jwt.MapClaims) (bool, error)
+}
+
+// RedisDenylist keeps entries in Redis so a revoke on one replica is honoured
+// by all of them. Entries expire on their own once every token they could
+// match has expired anyway.
+type RedisDenylist struct {
+	rdb    redis.Cmdable
+	prefix string
+}
+
+func NewRedisDenylist(rdb redis.Cmdable) *RedisDenylist {
+	return &RedisDenylist{rdb: rdb, prefix: "auth:deny:"}
+}
+
+func (d *RedisDenylist) key(kind, id string) string {
+	return d.prefix + kind + ":" + id
+}
+
+// RevokeToken denies a single access token by its "jti".
+func (d *RedisDenylist) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if strings.TrimSpace(jti) == "" {
+		return errors.New("jti is empty")
+	}
+	return d.rdb.Set(ctx, d.key("jti", jti), "1", ttl).Err()
+}
+
+// RevokeSession denies every token carrying the Keycloak session id ("sid").
+func (d *RedisDenylist) RevokeSession(ctx context.Context, sid string, ttl time.Duration) error {
+	if strings.TrimSpace(sid) == "" {
+		return errors.New("sid is empty")
+	}
+	return d.rdb.Set(ctx, d.key("sid", sid), "1", ttl).Err()
+}
+
+// RevokeUser denies every token issued to userID up to now. Tokens minted
+// after the call (e.g. once the user is reinstated) are unaffected.
+func (d *RedisDenylist) RevokeUser(ctx context.Context, userID string, ttl time.Duration) error {
+	if strings.TrimSpace(userID) == "" {
+		return errors.New("user id is empty")
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	return d.rdb.Set(ctx, d.key("user", userID), now, ttl).Err()
+}
+
+// ReinstateUser lifts a RevokeUser before its TTL runs out.
+func (d *RedisDenylist) ReinstateUser(ctx context.Context, userID string) error {
+	return d.rdb.Del(ctx, d.key("user", userID)).Err()
+}
+
+func (d *RedisDenylist) IsRevoked(ctx context.Context, userID string, claims jwt.MapClaims) (bool, error) {
+	jti, _ := claims["jti"].(string)
+	sid, _ := claims["sid"].(string)
+
+	keys := []string{d.key("user", userID)}
+	if jti != "" {
+		keys = append(keys, d.key("jti", jti))
+	}
+	if sid != "" {
+		keys = append(keys, d.key("sid", sid))
+	}
+	vals, err := d.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	for i, v := range vals {
+		if v == nil {
+			continue
+		}
+		if i > 0 {
+			return true, nil
+		}
+		// user entry: revoked only if the token predates the revocation
+		revokedAt, _ := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+		iat, err := claims.GetIssuedAt()
+		if err != nil || iat == nil || iat.Unix() <= revokedAt {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ---- Introspection ----
+
+// Introspector asks the issuer whether a token is still active, which catches
+// logouts and admin session kills that signature checks cannot see.
+type Introspector interface {
+	Active(ctx context.Context, token string) (bool, error)
+}
+
+// KeycloakIntrospector calls the realm's RFC 7662 token-introspection endpoint
+// with a confidential client's credentials.
+type KeycloakIntrospector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+func NewKeycloakIntrospector(endpoint, clientID, clientSecret string) *KeycloakIntrospector {
+	return &KeycloakIntrospector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       httpclient.New(httpclient.Options{Target: "keycloak-introspect", Timeout: 3 * time.Second}),
+	}
+}
+
+// NewKeycloakIntrospectorFromEnv reads KEYCLOAK_INTROSPECT_CLIENT_ID and
+// KEYCLOAK_INTROSPECT_CLIENT_SECRET. It returns nil when they are unset, which
+// leaves introspection off.
+func NewKeycloakIntrospectorFromEnv() *KeycloakIntrospector {
+	id := strings.TrimSpace(os.Getenv("KEYCLOAK_INTROSPECT_CLIENT_ID"))
+	secret := strings.TrimSpace(os.Getenv("KEYCLOAK_INTROSPECT_CLIENT_SECRET"))
+	if id == "" || secret == "" {
+		return nil
+	}
+	base, realm := keycloakBaseAndRealm()
+	return NewKeycloakIntrospector(base+"/realms/"+realm+"/protocol/openid-connect/token/introspect", id, secret)
+}
+
+func (k *KeycloakIntrospector) Active(ctx context.Context, token string) (bool, error) {
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(k.clientID, k.clientSecret)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("introspection returned %d", resp.StatusCode)
+	}
+	var body struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	return body.Active, nil
+}
+
+// CachedIntrospector remembers introspection results in Redis for a short
+// time, keyed by a hash of the token, so a burst of requests costs one call.
+type CachedIntrospector struct {
+	inner Introspector
+	rdb   redis.Cmdable
+	ttl   time.Duration
+}
+
+func NewCachedIntrospector(inner Introspector, rdb redis.Cmdable, ttl time.Duration) *CachedIntrospector {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &CachedIntrospector{inner: inner, rdb: rdb, ttl: ttl}
+}
+
+func (c *CachedIntrospector) Active(ctx context.Context, token string) (bool, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := "auth:introspect:" + hex.EncodeToString(sum[:])
+
+	if v, err := c.rdb.Get(ctx, key).Result(); err == nil {
+		return v == "1", nil
+	} else if err != redis.Nil {
+		return c.inner.Active(ctx, token)
+	}
+
+	active, err := c.inner.Active(ctx, token)
+	if err != nil {
+		return false, err
+	}
+	v := "0"
+	if active {
+		v = "1"
+	}
+	_ = c.rdb.Set(ctx, key, v, c.ttl).Err()
+	return active, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import "strings"
+
+// Blocks shared by the services, with the in-cluster development values as
+// defaults. Secrets have no default, so a deployment cannot run on the dev
+// credentials by accident; docker-compose.yml sets them for local runs. Embed
+// or name them in a service's Config.
+
+type MySQL struct {
+	DSN Secret `json:"dsn" env:"MYSQL_DSN" validate:"required"`
+}
+
+type Redis struct {
+	Addr     string `json:"addr" env:"REDIS_ADDR" default:"redis-service.default.svc.cluster.local:6379" validate:"required,hostname_port"`
+	Password Secret `json:"password" env:"REDIS_PASSWORD"`
+	DB       int    `json:"db" env:"REDIS_DB" default:"0" validate:"min=0"`
+}
+
+type MinIO struct {
+	Endpoint  string `json:"endpoint" env:"MINIO_ENDPOINT" default:"minio-service.default.svc.cluster.local:9000" validate:"required,hostname_port"`
+	AccessKey Secret `json:"access_key" env:"MINIO_ACCESS_KEY" validate:"required"`
+	SecretKey Secret `json:"secret_key" env:"MINIO_SECRET_KEY" validate:"required"`
+	Secure    bool   `json:"secure" env:"MINIO_SECURE" default:"false"`
+}
+
+// URL is the endpoint with its scheme, for plain HTTP calls such as the
+// health probe.
+func (m MinIO) URL() string {
+	if m.Secure {
+		return "https://" + m.Endpoint
+	}
+	return "http://" + m.Endpoint
+}
+
+type Meili struct {
+	Host string `json:"host" env:"MEILI_SVC" default:"meilisearch-service.default.svc.cluster.local" validate:"required"`
+}
+
+func (m Meili) URL() string { return "http://" + m.Host + ":7700" }
+
+// Keycloak locates the realm whose tokens a service verifies.
+type Keycloak struct {
+	JWKS    string `json:"jwks_url" env:"KEYCLOAK_JWKS_URL" validate:"omitempty,url"`
+	BaseURL string `json:"base_url" env:"KEYCLOAK_BASE_URL" default:"http://uam-service.default.svc.cluster.local" validate:"required,url"`
+	Realm   string `json:"realm" env:"KEYCLOAK_REALM" default:"mockten-realm-dev" validate:"required"`
+}
+
+// JWKSURL is the explicit JWKS URL, or the realm's certs endpoint.
+func (k Keycloak) JWKSURL() string {
+	if k.JWKS != "" {
+		return k.JWKS
+	}
+	return strings.TrimRight(k.BaseURL, "/") + "/realms/" + k.Realm + "/protocol/openid-connect/certs"
+}
@@ -0,0 +1,353 @@
+// Package config loads a service's settings into a typed struct. Each field
+// takes its `default` tag, then the value in an optional JSON file (keyed by
+// the `json` tag), then its `env` variable when set and non-empty; the result
+// is checked against `validate` tags (go-playground/validator).
+//
+//	type Config struct {
+//		config.MySQL
+//		Port     int           `env:"PORT" default:"8080" validate:"min=1,max=65535"`
+//		CartTTL  time.Duration `env:"CART_TTL" default:"0s"`
+//		FeeScale float64       `json:"fee_scale" default:"0.1" validate:"gt=0" reload:"true"`
+//	}
+//	cfg, err := config.Load[Config](config.Options{File: "config.json"})
+//
+// Credentials are Secret values, which print and marshal as "[redacted]", so
+// a config can be logged whole with Dump. Fields tagged reload:"true" may
+// change while the service runs; see Live.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+)
+
+// Secret is a credential. Use Value for the real string.
+type Secret string
+
+func (s Secret) Value() string { return string(s) }
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+func (s Secret) GoString() string { return strconv.Quote(s.String()) }
+
+func (s Secret) MarshalJSON() ([]byte, error) { return json.Marshal(s.String()) }
+
+type Options struct {
+	File     string        // JSON file read between the defaults and the environment; "" for none
+	Logger   *zap.Logger   // Live: reloads and rejected changes
+	Interval time.Duration // Live: how often the file is checked for changes; default 5s
+}
+
+// Load builds a T from defaults, the file and the environment, and validates
+// it. Unknown keys in the file are an error, so a typo cannot silently leave
+// a default in place.
+func Load[T any](opts Options) (*T, error) {
+	v := new(T)
+	rv := reflect.ValueOf(v).Elem()
+	fields := describe(rv.Type())
+
+	for _, f := range fields {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := set(rv.FieldByIndex(f.index), def); err != nil {
+				return nil, fmt.Errorf("config: %s: default %q: %w", f.name(), def, err)
+			}
+		}
+	}
+	if opts.File != "" {
+		b, err := os.ReadFile(opts.File)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		var m map[string]any
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", opts.File, err)
+		}
+		if err := apply(rv, m, ""); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", opts.File, err)
+		}
+	}
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if s := os.Getenv(f.env); s != "" {
+			if err := set(rv.FieldByIndex(f.index), s); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", f.env, err)
+			}
+		}
+	}
+	if err := validate(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Dump renders a config as JSON with its secrets redacted, for a startup log.
+func Dump(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<%v>", err)
+	}
+	return string(b)
+}
+
+// field is one settable value: a leaf, or a map or slice taken whole.
+type field struct {
+	index  []int
+	path   string // json path, e.g. mysql.host
+	env    string
+	reload bool
+	tag    reflect.StructTag
+}
+
+func (f field) name() string {
+	if f.env != "" {
+		return f.env
+	}
+	return f.path
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+func jsonName(sf reflect.StructField) (string, bool) {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return sf.Name, true
+	}
+	return name, true
+}
+
+// describe lists the fields of a struct type, descending into nested and
+// embedded structs. A reload tag on a struct covers everything inside it.
+func describe(t reflect.Type) []field {
+	var out []field
+	var walk func(t reflect.Type, index []int, path string, reload bool)
+	walk = func(t reflect.Type, index []int, path string, reload bool) {
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+			name, ok := jsonName(sf)
+			if !ok {
+				continue
+			}
+			idx := append(append([]int(nil), index...), i)
+			r := reload || sf.Tag.Get("reload") == "true"
+			if sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+				sub := path
+				if !sf.Anonymous {
+					sub = join(path, name)
+				}
+				walk(sf.Type, idx, sub, r)
+				continue
+			}
+			out = append(out, field{index: idx, path: join(path, name), env: sf.Tag.Get("env"), reload: r, tag: sf.Tag})
+		}
+	}
+	walk(t, nil, "", false)
+	return out
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// apply sets the fields of struct v named in m, recursing into objects.
+func apply(v reflect.Value, m map[string]any, path string) error {
+	t := v.Type()
+	known := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, ok := jsonName(sf)
+		if !sf.IsExported() || !ok {
+			continue
+		}
+		fv := v.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			// an embedded struct's keys sit at this level
+			sub := map[string]any{}
+			for _, f := range describe(sf.Type) {
+				top, _, _ := strings.Cut(f.path, ".")
+				if val, ok := m[top]; ok {
+					sub[top] = val
+					known[top] = true
+				}
+			}
+			if err := apply(fv, sub, path); err != nil {
+				return err
+			}
+			continue
+		}
+		known[name] = true
+		raw, ok := m[name]
+		if !ok {
+			continue
+		}
+		key := join(path, name)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			sub, ok := raw.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: want an object", key)
+			}
+			if err := apply(fv, sub, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setAny(fv, raw); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	for k := range m {
+		if !known[k] {
+			return fmt.Errorf("unknown key %q", join(path, k))
+		}
+	}
+	return nil
+}
+
+// setAny sets v from a decoded JSON value: scalars go through set, so
+// "30s" works for a duration in the file as in the environment.
+func setAny(v reflect.Value, raw any) error {
+	switch x := raw.(type) {
+	case string:
+		return set(v, x)
+	case bool:
+		return set(v, strconv.FormatBool(x))
+	case float64:
+		return set(v, strconv.FormatFloat(x, 'f', -1, 64))
+	case nil:
+		v.SetZero()
+		return nil
+	}
+	b, _ := json.Marshal(raw)
+	return json.Unmarshal(b, v.Addr().Interface())
+}
+
+// set parses s into v. Slices are comma-separated, maps k=v pairs.
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		parts := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := set(e, p); err != nil {
+				return err
+			}
+			parts = reflect.Append(parts, e)
+		}
+		v.Set(parts)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(p, "=")
+			if !ok {
+				return fmt.Errorf("%q: want key=value", p)
+			}
+			kv, ev := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+			if err := set(kv, strings.TrimSpace(k)); err != nil {
+				return err
+			}
+			if err := set(ev, strings.TrimSpace(val)); err != nil {
+				return err
+			}
+			m.SetMapIndex(kv, ev)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+var validate = func() func(any) error {
+	vd := validator.New(validator.WithRequiredStructEnabled())
+	vd.RegisterTagNameFunc(func(sf reflect.StructField) string {
+		if env := sf.Tag.Get("env"); env != "" {
+			return env
+		}
+		name, _ := jsonName(sf)
+		return name
+	})
+	return func(v any) error {
+		err := vd.Struct(v)
+		var verrs validator.ValidationErrors
+		if !errors.As(err, &verrs) {
+			return err
+		}
+		msgs := make([]string, 0, len(verrs))
+		for _, fe := range verrs {
+			rule := fe.Tag()
+			if fe.Param() != "" {
+				rule += "=" + fe.Param()
+			}
+			_, name, _ := strings.Cut(fe.Namespace(), ".") // drop the root type
+			msgs = append(msgs, fmt.Sprintf("%s fails %s", name, rule))
+		}
+		return fmt.Errorf("config: %s", strings.Join(msgs, "; "))
+	}
+}()
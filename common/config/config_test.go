@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	MySQL
+	Redis    Redis             `json:"redis"`
+	Port     int               `json:"port" env:"TEST_PORT" default:"8080" validate:"min=1,max=65535"`
+	Tick     time.Duration     `json:"tick" env:"TEST_TICK" default:"5s"`
+	Targets  []string          `json:"targets" env:"TEST_TARGETS" default:"a,b"`
+	FeeScale float64           `json:"fee_scale" default:"0.1" validate:"gt=0" reload:"true"`
+	Limits   map[string]string `json:"limits" reload:"true"`
+}
+
+func writeFile(t *testing.T, dir, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, t.TempDir(), `{"dsn": "file-dsn", "redis": {"addr": "file:6379", "password": "file-pass"}, "port": 9000, "tick": "1m", "fee_scale": 0.25}`)
+	t.Setenv("TEST_PORT", "9100")
+	t.Setenv("TEST_TARGETS", "x, y ,")
+	t.Setenv("REDIS_PASSWORD", "")
+
+	cfg, err := Load[testConfig](Options{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DSN != "file-dsn" || cfg.Redis.Addr != "file:6379" || cfg.Tick != time.Minute || cfg.FeeScale != 0.25 {
+		t.Fatalf("file values not applied: %+v", cfg)
+	}
+	if cfg.Port != 9100 || strings.Join(cfg.Targets, "|") != "x|y" {
+		t.Fatalf("env values not applied: port %d, targets %q", cfg.Port, cfg.Targets)
+	}
+	if cfg.Redis.Password != "file-pass" {
+		t.Fatalf("an empty env var replaced the file value: %q", cfg.Redis.Password.Value())
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	dir := t.TempDir()
+	for body, want := range map[string]string{
+		`{"redis": {"adr": "x:1"}}`: `unknown key "redis.adr"`,
+		`{"port": 70000}`:           "TEST_PORT fails max=65535",
+		`{"fee_scale": 0}`:          "fee_scale fails gt=0",
+		`{"tick": 5}`:               "tick",
+		// secrets have no dev default to fall back on
+		`{}`: "MYSQL_DSN fails required",
+	} {
+		_, err := Load[testConfig](Options{File: writeFile(t, dir, body)})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", body, err, want)
+		}
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	t.Setenv("MYSQL_DSN", "mocktenusr:mocktenpassword@tcp(db:3306)/mocktendb")
+	t.Setenv("REDIS_PASSWORD", "mocktenpass")
+	cfg, err := Load[testConfig](Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{Dump(cfg), fmt.Sprintf("%v", cfg), fmt.Sprintf("%+v", *cfg), fmt.Sprintf("%#v", cfg.Redis)} {
+		if strings.Contains(out, "mocktenpass") || strings.Contains(out, "mocktenpassword") {
+			t.Errorf("secret leaked: %s", out)
+		}
+	}
+	if cfg.Redis.Password.Value() != "mocktenpass" {
+		t.Fatalf("Value() = %q", cfg.Redis.Password.Value())
+	}
+}
+
+func TestLiveReloadsOnlySafeKeys(t *testing.T) {
+	t.Setenv("MYSQL_DSN", "dsn")
+	dir := t.TempDir()
+	file := writeFile(t, dir, `{"port": 8080, "fee_scale": 0.1}`)
+	l, err := NewLive[testConfig](Options{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen *testConfig
+	l.OnChange(func(c *testConfig) { seen = c })
+
+	writeFile(t, dir, `{"port": 9000, "fee_scale": 0.2, "limits": {"search": "10"}}`)
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got := l.Get()
+	if got.FeeScale != 0.2 || got.Limits["search"] != "10" || got.Port != 8080 || seen != got {
+		t.Fatalf("after reload: %+v (hook saw %p)", got, seen)
+	}
+
+	writeFile(t, dir, `{"fee_scale": -1}`)
+	if err := l.Reload(); err == nil || l.Get().FeeScale != 0.2 {
+		t.Fatalf("invalid file applied: %v, fee scale %v", err, l.Get().FeeScale)
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Live holds a config that follows its file. When the file changes, the
+// fields tagged reload:"true" (rate limits, fee scale, feature flags) take
+// their new values; anything else keeps its startup value, with a warning that
+// it needs a restart. A file that no longer loads or validates is ignored
+// until it is fixed.
+type Live[T any] struct {
+	opts   Options
+	logger *zap.Logger
+	cur    atomic.Pointer[T]
+
+	mu    sync.Mutex
+	hooks []func(*T)
+}
+
+// NewLive loads the config once; call Run to follow the file.
+func NewLive[T any](opts Options) (*Live[T], error) {
+	v, err := Load[T](opts)
+	if err != nil {
+		return nil, err
+	}
+	l := &Live[T]{opts: opts, logger: opts.Logger}
+	if l.logger == nil {
+		l.logger = zap.NewNop()
+	}
+	l.cur.Store(v)
+	return l, nil
+}
+
+// Get returns the current config. Treat it as read-only; a reload replaces
+// the whole value rather than changing it in place.
+func (l *Live[T]) Get() *T { return l.cur.Load() }
+
+// OnChange registers fn to run with the new config after each reload that
+// changed something.
+func (l *Live[T]) OnChange(fn func(*T)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, fn)
+}
+
+// Run checks the file every Options.Interval until ctx is done.
+func (l *Live[T]) Run(ctx context.Context) {
+	if l.opts.File == "" {
+		return
+	}
+	WatchFile(ctx, l.opts.File, l.opts.Interval, func() {
+		if err := l.Reload(); err != nil {
+			l.logger.Error("config reload rejected", zap.String("file", l.opts.File), zap.Error(err))
+		}
+	})
+}
+
+// Reload reads the file now and applies its reloadable changes.
+func (l *Live[T]) Reload() error {
+	next, err := Load[T](l.opts)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cur := l.Get()
+	merged := *cur
+	mv, nv, cv := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(next).Elem(), reflect.ValueOf(cur).Elem()
+	var applied, restart []string
+	for _, f := range describe(mv.Type()) {
+		n := nv.FieldByIndex(f.index)
+		if reflect.DeepEqual(n.Interface(), cv.FieldByIndex(f.index).Interface()) {
+			continue
+		}
+		if !f.reload {
+			restart = append(restart, f.name())
+			continue
+		}
+		mv.FieldByIndex(f.index).Set(n)
+		applied = append(applied, f.name())
+	}
+	if len(restart) > 0 {
+		l.logger.Warn("config changes need a restart", zap.Strings("keys", restart))
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	if err := validate(&merged); err != nil {
+		return err
+	}
+	l.cur.Store(&merged)
+	l.logger.Info("config reloaded", zap.Strings("keys", applied))
+	for _, fn := range l.hooks {
+		fn(&merged)
+	}
+	return nil
+}
+
+// WatchFile calls fn whenever path's size or modification time changes,
+// checking every interval (default 5s) until ctx is done. Polling works the
+// same for a plain file and for a Kubernetes ConfigMap volume, where the
+// file is swapped through a symlink.
+func WatchFile(ctx context.Context, path string, interval time.Duration, fn func()) {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	stamp := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+	mod, size := stamp()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m, s := stamp()
+		if m.Equal(mod) && s == size {
+			continue
+		}
+		mod, size = m, s
+		if s >= 0 {
+			fn()
+		}
+	}
+}
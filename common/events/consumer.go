@@ -0,0 +1,290 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+)
+
+// Handler processes one event. Returning an error leaves the event pending,
+// to be retried after RetryAfter.
+type Handler func(ctx context.Context, e Event) error
+
+type ConsumerOptions struct {
+	Logger      *zap.Logger
+	Name        string        // consumer name within the group; default the hostname
+	MaxAttempts int           // deliveries before an event is dead-lettered; default 5
+	RetryAfter  time.Duration // how long a failed event waits before redelivery; default 30s
+	Block       time.Duration // how long a read waits for new events; default 5s
+	Batch       int64         // events read per stream at a time; default 10
+}
+
+// Consumer reads the streams of the event types it handles as one consumer
+// group. Every group sees every event; instances of a service share their
+// group and split the events between them.
+type Consumer struct {
+	rdb         redis.UniversalClient
+	group       string
+	name        string
+	logger      *zap.Logger
+	maxAttempts int
+	retryAfter  time.Duration
+	block       time.Duration
+	batch       int64
+	handlers    map[Type]Handler
+	streams     []string
+	grouped     bool // groups exist on all streams
+}
+
+func NewConsumer(rdb redis.UniversalClient, group string, opts ConsumerOptions) *Consumer {
+	c := &Consumer{
+		rdb:         rdb,
+		group:       group,
+		name:        opts.Name,
+		logger:      opts.Logger,
+		maxAttempts: opts.MaxAttempts,
+		retryAfter:  opts.RetryAfter,
+		block:       opts.Block,
+		batch:       opts.Batch,
+		handlers:    map[Type]Handler{},
+	}
+	if c.name == "" {
+		c.name, _ = os.Hostname()
+		if c.name == "" {
+			c.name = group
+		}
+	}
+	if c.logger == nil {
+		c.logger = zap.NewNop()
+	}
+	if c.maxAttempts <= 0 {
+		c.maxAttempts = 5
+	}
+	if c.retryAfter <= 0 {
+		c.retryAfter = 30 * time.Second
+	}
+	if c.block <= 0 {
+		c.block = 5 * time.Second
+	}
+	if c.batch <= 0 {
+		c.batch = 10
+	}
+	return c
+}
+
+// Handle registers the handler for an event type.
+func (c *Consumer) Handle(t Type, h Handler) *Consumer {
+	if _, ok := c.handlers[t]; !ok {
+		c.streams = append(c.streams, Stream(t))
+	}
+	c.handlers[t] = h
+	c.grouped = false
+	return c
+}
+
+// Run consumes until ctx is done. Redis errors are logged and retried.
+func (c *Consumer) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if _, err := c.Poll(ctx); err != nil && ctx.Err() == nil {
+			c.logger.Warn("event consumer failed", zap.String("group", c.group), zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// Poll redelivers failed events that are due, then waits up to Block for new
+// ones, and returns how many events it handled.
+func (c *Consumer) Poll(ctx context.Context) (int, error) {
+	if err := c.createGroups(ctx); err != nil {
+		return 0, err
+	}
+	n, err := c.retry(ctx)
+	if err != nil {
+		return n, err
+	}
+
+	args := &redis.XReadGroupArgs{Group: c.group, Consumer: c.name, Count: c.batch, Block: c.block}
+	args.Streams = append(args.Streams, c.streams...)
+	for range c.streams {
+		args.Streams = append(args.Streams, ">")
+	}
+	res, err := c.rdb.XReadGroup(ctx, args).Result()
+	if errors.Is(err, redis.Nil) {
+		return n, nil
+	}
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			c.grouped = false
+		}
+		return n, err
+	}
+	for _, s := range res {
+		for _, m := range s.Messages {
+			c.deliver(ctx, s.Stream, m, 1)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// createGroups creates the group on each stream, starting from the beginning
+// so events published before the first deploy are not skipped. A stream that
+// was deleted (and the group with it) is recreated on the next poll.
+func (c *Consumer) createGroups(ctx context.Context) error {
+	if c.grouped {
+		return nil
+	}
+	for _, s := range c.streams {
+		err := c.rdb.XGroupCreateMkStream(ctx, s, c.group, "0").Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("create group %s on %s: %w", c.group, s, err)
+		}
+	}
+	c.grouped = true
+	return nil
+}
+
+// retry claims pending events that have waited RetryAfter since their last
+// delivery, from any consumer of the group (including crashed ones), and
+// dead-letters those already delivered MaxAttempts times.
+func (c *Consumer) retry(ctx context.Context) (int, error) {
+	n := 0
+	for _, s := range c.streams {
+		pending, err := c.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: s, Group: c.group, Idle: c.retryAfter, Start: "-", End: "+", Count: c.batch,
+		}).Result()
+		if err != nil {
+			return n, err
+		}
+		var claim []string
+		attempts := map[string]int{}
+		for _, p := range pending {
+			if int(p.RetryCount) >= c.maxAttempts {
+				if err := c.deadLetter(ctx, s, p.ID, int(p.RetryCount)); err != nil {
+					return n, err
+				}
+				continue
+			}
+			claim = append(claim, p.ID)
+			attempts[p.ID] = int(p.RetryCount) + 1
+		}
+		if len(claim) == 0 {
+			continue
+		}
+		msgs, err := c.rdb.XClaim(ctx, &redis.XClaimArgs{
+			Stream: s, Group: c.group, Consumer: c.name, MinIdle: c.retryAfter, Messages: claim,
+		}).Result()
+		if err != nil {
+			return n, err
+		}
+		for _, m := range msgs {
+			c.deliver(ctx, s, m, attempts[m.ID])
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c *Consumer) deliver(ctx context.Context, stream string, m redis.XMessage, attempt int) {
+	e := fromValues(m.Values)
+	e.Attempt = attempt
+	h := c.handlers[e.Type]
+	if h == nil {
+		// a stream only carries its own type; anything else is not ours to retry
+		_ = c.rdb.XAck(ctx, stream, c.group, m.ID).Err()
+		return
+	}
+
+	hctx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{"traceparent": e.TraceContext})
+	hctx, span := tracing.Start(hctx, "consume "+string(e.Type),
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.destination.name", stream),
+		attribute.String("messaging.consumer.group.name", c.group),
+		attribute.String("messaging.message.id", e.ID),
+		attribute.Int("messaging.delivery_attempt", attempt),
+	)
+	// Handlers log through logging.From(ctx). The request id is the causing
+	// request's trace id, which is also its request id unless the caller
+	// sent one of its own.
+	rid := "event-" + e.ID
+	if tid := tracing.TraceID(hctx); tid != "" {
+		rid = tid
+	}
+	hctx = logging.WithRequest(hctx, c.logger.With(zap.String("group", c.group), zap.String("type", string(e.Type)), zap.String("event_id", e.ID)), rid)
+	err := safeHandle(hctx, h, e)
+	tracing.End(span, err)
+
+	if err != nil {
+		consumedTotal.WithLabelValues(c.group, string(e.Type), "failed").Inc()
+		c.logger.Warn("event handler failed",
+			zap.String("group", c.group), zap.String("type", string(e.Type)), zap.String("event_id", e.ID),
+			zap.Int("attempt", attempt), zap.Error(err))
+		_ = c.rdb.HSet(ctx, lastErrorKey(c.group, stream), m.ID, err.Error()).Err()
+		return
+	}
+	consumedTotal.WithLabelValues(c.group, string(e.Type), "ok").Inc()
+	if err := c.rdb.XAck(ctx, stream, c.group, m.ID).Err(); err != nil {
+		c.logger.Warn("event ack failed", zap.String("group", c.group), zap.String("event_id", e.ID), zap.Error(err))
+	}
+	if attempt > 1 {
+		_ = c.rdb.HDel(ctx, lastErrorKey(c.group, stream), m.ID).Err()
+	}
+}
+
+func safeHandle(ctx context.Context, h Handler, e Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return h(ctx, e)
+}
+
+// deadLetter copies the event to DeadLetterStream with the group and the last
+// error, then acknowledges it so the group moves on.
+func (c *Consumer) deadLetter(ctx context.Context, stream, id string, attempts int) error {
+	msgs, err := c.rdb.XRange(ctx, stream, id, id).Result()
+	if err != nil {
+		return err
+	}
+	errKey := lastErrorKey(c.group, stream)
+	if len(msgs) == 1 {
+		values := msgs[0].Values
+		values["group"] = c.group
+		values["stream_id"] = id
+		values["attempts"] = strconv.Itoa(attempts)
+		values["error"] = c.rdb.HGet(ctx, errKey, id).Val()
+		if err := c.rdb.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetterStream, MaxLen: 100000, Approx: true, Values: values}).Err(); err != nil {
+			return err
+		}
+		e := fromValues(values)
+		consumedTotal.WithLabelValues(c.group, string(e.Type), "dead").Inc()
+		c.logger.Error("event dead-lettered",
+			zap.String("group", c.group), zap.String("type", string(e.Type)), zap.String("event_id", e.ID),
+			zap.Int("attempts", attempts), zap.String("error", values["error"].(string)))
+	}
+	// a trimmed-away entry has nothing left to dead-letter; just drop it
+	if err := c.rdb.XAck(ctx, stream, c.group, id).Err(); err != nil {
+		return err
+	}
+	return c.rdb.HDel(ctx, errKey, id).Err()
+}
+
+func lastErrorKey(group, stream string) string {
+	return stream + ":errors:" + group
+}
@@ -0,0 +1,206 @@
+// Package events carries domain events between services. A service writes an
+// event to the Outbox table in the same MySQL transaction as the change it
+// describes (Enqueue); a Relay publishes pending rows to one Redis stream per
+// event type; a Consumer reads them in a consumer group, retries failed
+// events and moves the ones that keep failing to a dead-letter stream.
+//
+// Delivery is at least once: a handler may see an event again after a crash
+// or a lost acknowledgement, so it must be idempotent or tolerate repeats.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Type names a domain event. It is also the suffix of the event's stream.
+type Type string
+
+const (
+	OrderPlaced           Type = "OrderPlaced"
+	PaymentCaptured       Type = "PaymentCaptured"
+	StockChanged          Type = "StockChanged"
+	ProductUpdated        Type = "ProductUpdated"
+	ShipmentStatusChanged Type = "ShipmentStatusChanged"
+	ReviewPosted          Type = "ReviewPosted"
+	ReviewModerated       Type = "ReviewModerated"
+	ReviewReplied         Type = "ReviewReplied"
+	ReviewDeleted         Type = "ReviewDeleted"
+	ProductAlertRaised    Type = "ProductAlertRaised"
+)
+
+// DeadLetterStream receives events a consumer group gave up on, with the
+// group and the last error.
+const DeadLetterStream = "events:dead"
+
+// Stream is the Redis stream events of type t are published to.
+func Stream(t Type) string { return "events:" + string(t) }
+
+// Event is one domain event as a consumer sees it. ID is the outbox row id,
+// unique and increasing per database; Key is the id of the thing the event is
+// about (order, product, transaction).
+type Event struct {
+	ID           string          `json:"id"`
+	Type         Type            `json:"type"`
+	Key          string          `json:"key"`
+	Payload      json.RawMessage `json:"payload"`
+	OccurredAt   time.Time       `json:"occurredAt"`
+	TraceContext string          `json:"-"` // W3C traceparent of the request that caused it
+	Attempt      int             `json:"-"` // 1 on first delivery
+}
+
+// Decode unmarshals the payload into v, one of the *Payload types.
+func (e Event) Decode(v any) error {
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("event %s %s: %w", e.Type, e.ID, err)
+	}
+	return nil
+}
+
+func (e Event) values() map[string]any {
+	return map[string]any{
+		"id":          e.ID,
+		"type":        string(e.Type),
+		"key":         e.Key,
+		"payload":     string(e.Payload),
+		"occurred_at": e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		"trace":       e.TraceContext,
+	}
+}
+
+func fromValues(v map[string]any) Event {
+	str := func(k string) string { s, _ := v[k].(string); return s }
+	e := Event{
+		ID:           str("id"),
+		Type:         Type(str("type")),
+		Key:          str("key"),
+		Payload:      json.RawMessage(str("payload")),
+		TraceContext: str("trace"),
+	}
+	e.OccurredAt, _ = time.Parse(time.RFC3339Nano, str("occurred_at"))
+	return e
+}
+
+// OrderItem is one line of a placed order.
+type OrderItem struct {
+	ProductID  string `json:"productId"`
+	CategoryID int    `json:"categoryId"`
+	Quantity   int    `json:"quantity"`
+}
+
+// OrderPlacedPayload is published by ecpay when a paid order is created.
+type OrderPlacedPayload struct {
+	OrderID  string      `json:"orderId"`
+	UserID   string      `json:"userId"`
+	Total    float64     `json:"total"`
+	Currency string      `json:"currency"`
+	Items    []OrderItem `json:"items"`
+}
+
+// PaymentCapturedPayload is published by ecpay when Stripe captures or
+// authorizes a payment.
+type PaymentCapturedPayload struct {
+	PaymentID string  `json:"paymentId"`
+	OrderID   string  `json:"orderId"`
+	Amount    float64 `json:"amount"`
+	Currency  string  `json:"currency"`
+	Status    string  `json:"status"` // captured or authorized
+}
+
+// StockChangedPayload carries a product's stock after the change.
+type StockChangedPayload struct {
+	ProductID string `json:"productId"`
+	Stock     int    `json:"stock"`
+	Delta     int    `json:"delta"` // 0 when the stock was set rather than adjusted
+}
+
+// ProductUpdatedPayload says a product was created, edited, activated or
+// deactivated; consumers re-read the product rather than trust a snapshot.
+type ProductUpdatedPayload struct {
+	ProductID string `json:"productId"`
+	Active    bool   `json:"active"`
+}
+
+// ShipmentStatusChangedPayload is one shipment leg moving to a new status.
+type ShipmentStatusChangedPayload struct {
+	TransactionID string `json:"transactionId"`
+	ProductID     string `json:"productId"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+}
+
+// ReviewPostedPayload is a review created or edited, with the product's new
+// rating.
+type ReviewPostedPayload struct {
+	ReviewID    string  `json:"reviewId"`
+	ProductID   string  `json:"productId"`
+	UserID      string  `json:"userId"`
+	Rating      int     `json:"rating"`
+	AvgReview   float64 `json:"avgReview"`
+	ReviewCount int     `json:"reviewCount"`
+}
+
+// ReviewModeratedPayload is an admin hiding, restoring or deleting a review,
+// with the product's rating afterwards.
+type ReviewModeratedPayload struct {
+	ReviewID    string  `json:"reviewId"`
+	ProductID   string  `json:"productId"`
+	UserID      string  `json:"userId"` // the review's author
+	Action      string  `json:"action"` // "hide", "restore" or "delete"
+	Status      string  `json:"status"` // the review's status now
+	Moderator   string  `json:"moderator"`
+	AvgReview   float64 `json:"avgReview"`
+	ReviewCount int     `json:"reviewCount"`
+}
+
+// ReviewDeletedPayload is a review withdrawn by its author, with the
+// product's rating afterwards.
+type ReviewDeletedPayload struct {
+	ReviewID    string  `json:"reviewId"`
+	ProductID   string  `json:"productId"`
+	UserID      string  `json:"userId"`
+	AvgReview   float64 `json:"avgReview"`
+	ReviewCount int     `json:"reviewCount"`
+}
+
+// ReviewRepliedPayload is a seller replying to a review, or editing the
+// reply, addressed to the reviewer for notification. No service consumes it
+// yet: delivering it (push, email or an inbox) is left to a later change.
+type ReviewRepliedPayload struct {
+	ReviewID  string `json:"reviewId"`
+	ProductID string `json:"productId"`
+	UserID    string `json:"userId"` // the reviewer
+	SellerID  string `json:"sellerId"`
+	Edited    bool   `json:"edited"`
+}
+
+// ProductAlertRaisedPayload is a price-drop or back-in-stock alert for a user
+// who wishlisted or subscribed to the product. OldPrice is the price the user
+// started watching at.
+type ProductAlertRaisedPayload struct {
+	AlertID   string  `json:"alertId"`
+	UserID    string  `json:"userId"`
+	ProductID string  `json:"productId"`
+	Kind      string  `json:"kind"` // "price_drop" or "back_in_stock"
+	OldPrice  float64 `json:"oldPrice"`
+	NewPrice  float64 `json:"newPrice"`
+	Stock     int     `json:"stock"`
+}
+
+var (
+	publishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_published_total",
+		Help: "Outbox events published to Redis Streams by the relay.",
+	}, []string{"type"})
+	consumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_consumed_total",
+		Help: "Event deliveries handled by a consumer group, by result (ok, failed, dead).",
+	}, []string{"group", "type", "result"})
+)
+
+func outboxID(id int64) string { return strconv.FormatInt(id, 10) }
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type recordExec struct {
+	query string
+	args  []any
+}
+
+func (r *recordExec) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	r.query, r.args = query, args
+	return nil, nil
+}
+
+func TestEnqueue(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 1},
+		SpanID:     trace.SpanID{0x00, 0xf0, 2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), sc)
+
+	var tx recordExec
+	err := Enqueue(ctx, &tx, StockChanged, "p1", StockChangedPayload{ProductID: "p1", Stock: 7, Delta: -2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(tx.query, "INSERT INTO Outbox") || tx.args[0] != "StockChanged" || tx.args[1] != "p1" {
+		t.Fatalf("query %q args %v", tx.query, tx.args)
+	}
+	if got := string(tx.args[2].([]byte)); got != `{"productId":"p1","stock":7,"delta":-2}` {
+		t.Fatalf("payload = %s", got)
+	}
+	if tc := tx.args[3].(sql.NullString); !tc.Valid || !strings.Contains(tc.String, sc.TraceID().String()) {
+		t.Fatalf("trace context = %+v", tc)
+	}
+}
+
+func newRedis(t *testing.T) redis.UniversalClient {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func order(id string) Event {
+	payload, _ := json.Marshal(OrderPlacedPayload{OrderID: "o" + id, Items: []OrderItem{{ProductID: "p1", CategoryID: 3, Quantity: 2}}})
+	return Event{ID: id, Type: OrderPlaced, Key: "o" + id, Payload: payload, OccurredAt: time.Now()}
+}
+
+func TestEveryGroupGetsEveryEvent(t *testing.T) {
+	ctx := context.Background()
+	rdb := newRedis(t)
+	if err := Publish(ctx, rdb, 1000, order("1"), order("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][]string{}
+	for _, group := range []string{"ranking", "search-indexer"} {
+		c := NewConsumer(rdb, group, ConsumerOptions{Block: 10 * time.Millisecond}).
+			Handle(OrderPlaced, func(_ context.Context, e Event) error {
+				var p OrderPlacedPayload
+				if err := e.Decode(&p); err != nil {
+					return err
+				}
+				got[group] = append(got[group], p.OrderID+"/"+p.Items[0].ProductID)
+				return nil
+			})
+		if n, err := c.Poll(ctx); err != nil || n != 2 {
+			t.Fatalf("%s: poll = %d, %v", group, n, err)
+		}
+		if p := rdb.XPending(ctx, Stream(OrderPlaced), group).Val(); p.Count != 0 {
+			t.Fatalf("%s: %d events left pending", group, p.Count)
+		}
+	}
+	if strings.Join(got["ranking"], ",") != "o1/p1,o2/p1" || len(got["search-indexer"]) != 2 {
+		t.Fatalf("handled = %v", got)
+	}
+}
+
+func TestRetryThenDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	rdb := newRedis(t)
+	if err := Publish(ctx, rdb, 0, order("1"), order("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := map[string][]int{}
+	c := NewConsumer(rdb, "ranking", ConsumerOptions{MaxAttempts: 3, RetryAfter: time.Millisecond, Block: 10 * time.Millisecond}).
+		Handle(OrderPlaced, func(_ context.Context, e Event) error {
+			attempts[e.ID] = append(attempts[e.ID], e.Attempt)
+			switch {
+			case e.ID == "1" && e.Attempt == 1:
+				return errors.New("redis timeout") // transient
+			case e.ID == "2":
+				panic("bad payload") // never succeeds
+			}
+			return nil
+		})
+	for range 5 {
+		if _, err := c.Poll(ctx); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if a := attempts["1"]; len(a) != 2 || a[1] != 2 {
+		t.Fatalf("event 1 attempts = %v", a)
+	}
+	if a := attempts["2"]; len(a) != 3 {
+		t.Fatalf("event 2 attempts = %v, want 3", a)
+	}
+	if p := rdb.XPending(ctx, Stream(OrderPlaced), "ranking").Val(); p.Count != 0 {
+		t.Fatalf("%d events left pending", p.Count)
+	}
+	dead := rdb.XRange(ctx, DeadLetterStream, "-", "+").Val()
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %v", dead)
+	}
+	v := dead[0].Values
+	if v["id"] != "2" || v["group"] != "ranking" || v["attempts"] != "3" || !strings.Contains(v["error"].(string), "bad payload") {
+		t.Fatalf("dead letter = %v", v)
+	}
+}
@@ -0,0 +1,201 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+)
+
+// Execer is a *sql.Tx (or *sql.DB, for a change that is a single statement).
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Enqueue writes an event to the outbox. Pass the transaction that makes the
+// change, so the event exists exactly when the change does.
+func Enqueue(ctx context.Context, tx Execer, t Type, key string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("enqueue %s: %w", t, err)
+	}
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	trace := sql.NullString{String: carrier.Get("traceparent"), Valid: carrier.Get("traceparent") != ""}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO Outbox (event_type, aggregate_id, payload, trace_context) VALUES (?, ?, ?, ?)`,
+		string(t), key, body, trace)
+	if err != nil {
+		return fmt.Errorf("enqueue %s: %w", t, err)
+	}
+	return nil
+}
+
+type RelayOptions struct {
+	Logger    *zap.Logger
+	Interval  time.Duration // pause when the outbox is empty; default 1s
+	Batch     int           // rows published per transaction; default 100
+	MaxLen    int64         // approximate length each stream is trimmed to; default 100000
+	Retention time.Duration // published rows older than this are deleted; default 7 days
+}
+
+// Relay publishes pending outbox rows to Redis. Every service that enqueues
+// runs one; relays share the table through SKIP LOCKED, so each row is
+// published by one of them (and, if Redis accepts it but the commit fails,
+// published again).
+type Relay struct {
+	db        *sql.DB
+	rdb       redis.UniversalClient
+	logger    *zap.Logger
+	interval  time.Duration
+	batch     int
+	maxLen    int64
+	retention time.Duration
+}
+
+func NewRelay(db *sql.DB, rdb redis.UniversalClient, opts RelayOptions) *Relay {
+	r := &Relay{
+		db:        db,
+		rdb:       rdb,
+		logger:    opts.Logger,
+		interval:  opts.Interval,
+		batch:     opts.Batch,
+		maxLen:    opts.MaxLen,
+		retention: opts.Retention,
+	}
+	if r.logger == nil {
+		r.logger = zap.NewNop()
+	}
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	if r.batch <= 0 {
+		r.batch = 100
+	}
+	if r.maxLen <= 0 {
+		r.maxLen = 100000
+	}
+	if r.retention <= 0 {
+		r.retention = 7 * 24 * time.Hour
+	}
+	return r
+}
+
+// Run publishes until ctx is done: full batches back to back, then a pause of
+// Interval once the outbox is drained or a publish fails.
+func (r *Relay) Run(ctx context.Context) {
+	lastPurge := time.Time{}
+	for ctx.Err() == nil {
+		n, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Warn("outbox relay failed", zap.Error(err))
+		}
+		if time.Since(lastPurge) > time.Hour {
+			r.purge(ctx)
+			lastPurge = time.Now()
+		}
+		if err == nil && n == r.batch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Flush publishes one batch of pending rows, oldest first, and returns how
+// many it published.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, event_type, aggregate_id, payload, COALESCE(trace_context, ''), UNIX_TIMESTAMP(created_at)
+		FROM Outbox
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`, r.batch)
+	if err != nil {
+		return 0, err
+	}
+	var pending []Event
+	var ids []any
+	for rows.Next() {
+		var (
+			id      int64
+			e       Event
+			payload []byte
+			created float64
+		)
+		if err := rows.Scan(&id, &e.Type, &e.Key, &payload, &e.TraceContext, &created); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sec, frac := math.Modf(created)
+		e.ID = outboxID(id)
+		e.Payload = payload
+		e.OccurredAt = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+		pending = append(pending, e)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	if err := Publish(ctx, r.rdb, r.maxLen, pending...); err != nil {
+		return 0, err
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	if _, err := tx.ExecContext(ctx, `UPDATE Outbox SET published_at = CURRENT_TIMESTAMP(3) WHERE id IN (`+marks+`)`, ids...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(pending), nil
+}
+
+func (r *Relay) purge(ctx context.Context) {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM Outbox WHERE published_at < NOW() - INTERVAL ? SECOND LIMIT 10000`,
+		int64(r.retention/time.Second))
+	if err != nil && ctx.Err() == nil {
+		r.logger.Warn("outbox purge failed", zap.Error(err))
+	}
+}
+
+// Publish adds events to their streams in one round trip. The relay uses it;
+// tests and one-off tools can call it directly, bypassing the outbox.
+func Publish(ctx context.Context, rdb redis.UniversalClient, maxLen int64, evs ...Event) error {
+	_, err := rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, e := range evs {
+			p.XAdd(ctx, &redis.XAddArgs{Stream: Stream(e.Type), MaxLen: maxLen, Approx: maxLen > 0, Values: e.values()})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("publish events: %w", err)
+	}
+	for _, e := range evs {
+		publishedTotal.WithLabelValues(string(e.Type)).Inc()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Command fieldkeys manages the local key file used by fieldcrypt.
+//
+//	go run ./fieldcrypt/cmd/fieldkeys init   keys.json
+//	go run ./fieldcrypt/cmd/fieldkeys rotate keys.json
+//
+// After a rotation, restart the services; geocoding re-wraps stored Geo values
+// onto the new primary key in the background.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/mockten/mockten/common/fieldcrypt"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: fieldkeys init|rotate FILE")
+		os.Exit(2)
+	}
+	kid := time.Now().UTC().Format("20060102-150405")
+	var err error
+	switch os.Args[1] {
+	case "init":
+		err = fieldcrypt.GenerateKeyFile(os.Args[2], kid)
+	case "rotate":
+		err = fieldcrypt.RotateKeyFile(os.Args[2], kid)
+	default:
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("primary key is now %s\n", kid)
+}
@@ -0,0 +1,360 @@
+// Package fieldcrypt encrypts individual database fields (addresses, phone
+// numbers) with envelope encryption, so buyer PII is unreadable to anyone who
+// can only read the database, such as the read-only mocktenro user.
+//
+// Every value gets its own random data key (DEK); the value is sealed with
+// AES-256-GCM under the DEK, and the DEK is sealed under a key-encryption key
+// (KEK) from a Keyring. A local JSON key file stands in for a KMS:
+//
+//	{
+//	  "primary": "2026-10",
+//	  "keys": {"2026-01": "<base64 32 bytes>", "2026-10": "<base64 32 bytes>"},
+//	  "index_key": "<base64 32 bytes>"
+//	}
+//
+// Rotation: add a key, make it primary, and keep the old one until every value
+// has been rewritten with Rotate. New values always use the primary key.
+//
+// Lookups on encrypted columns go through BlindIndex, a keyed hash of the
+// normalised plaintext. The index key is separate from the KEKs and must not
+// change, or every stored index has to be recomputed.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prefix marks an encrypted value: ev1:<kid>:<wrapped dek>:<sealed value>.
+// Anything without it is treated as legacy plaintext, so columns can be
+// encrypted in place without a flag day.
+const prefix = "ev1:"
+
+var (
+	ErrUnknownKey = errors.New("fieldcrypt: unknown key id")
+	ErrMalformed  = errors.New("fieldcrypt: malformed ciphertext")
+)
+
+type Keyring struct {
+	primary  string
+	keks     map[string][]byte
+	indexKey []byte
+}
+
+type keyFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// NewKeyring builds a keyring from raw 32-byte keys.
+func NewKeyring(primary string, keks map[string][]byte, indexKey []byte) (*Keyring, error) {
+	if _, ok := keks[primary]; !ok {
+		return nil, fmt.Errorf("fieldcrypt: primary key %q not in keyring", primary)
+	}
+	for kid, k := range keks {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("fieldcrypt: key %q must be 32 bytes, got %d", kid, len(k))
+		}
+		if strings.Contains(kid, ":") || kid == "" {
+			return nil, fmt.Errorf("fieldcrypt: invalid key id %q", kid)
+		}
+	}
+	if len(indexKey) < 32 {
+		return nil, errors.New("fieldcrypt: index_key must be at least 32 bytes")
+	}
+	return &Keyring{primary: primary, keks: keks, indexKey: indexKey}, nil
+}
+
+func LoadKeyFile(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("fieldcrypt: parse %s: %w", path, err)
+	}
+	keks := make(map[string][]byte, len(f.Keys))
+	for kid, v := range f.Keys {
+		k, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("fieldcrypt: key %q: %w", kid, err)
+		}
+		keks[kid] = k
+	}
+	idx, err := base64.StdEncoding.DecodeString(f.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: index_key: %w", err)
+	}
+	return NewKeyring(f.Primary, keks, idx)
+}
+
+// LoadFromEnv reads the key file named by FIELD_KEYS_FILE.
+func LoadFromEnv() (*Keyring, error) {
+	path := strings.TrimSpace(os.Getenv("FIELD_KEYS_FILE"))
+	if path == "" {
+		return nil, errors.New("fieldcrypt: FIELD_KEYS_FILE is not set")
+	}
+	return LoadKeyFile(path)
+}
+
+func (k *Keyring) Primary() string { return k.primary }
+
+// Encrypt seals plaintext. aad names the field (e.g. "Geo.postal_code") so a
+// ciphertext copied into another column fails to decrypt. Empty stays empty.
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	sealed, err := seal(dek, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := k.wrap(k.primary, dek)
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary + ":" + b64(wrapped) + ":" + b64(sealed), nil
+}
+
+// Decrypt opens a value from Encrypt. Values without the ev1: prefix are
+// returned unchanged, so rows written before encryption still read.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	kid, wrapped, sealed, err := split(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := k.unwrap(kid, wrapped)
+	if err != nil {
+		return "", err
+	}
+	pt, err := open(dek, sealed, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(pt), nil
+}
+
+// NeedsRotation reports whether a stored value is plaintext or wrapped under a
+// key other than the primary.
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	kid, _, _, err := split(value)
+	return err != nil || kid != k.primary
+}
+
+// Rotate brings a value onto the primary key. Encrypted values only have their
+// DEK re-wrapped; the sealed payload is untouched. Plaintext is encrypted.
+func (k *Keyring) Rotate(value, aad string) (string, error) {
+	if !k.NeedsRotation(value) {
+		return value, nil
+	}
+	if !IsEncrypted(value) {
+		return k.Encrypt(value, aad)
+	}
+	kid, wrapped, sealed, err := split(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := k.unwrap(kid, wrapped)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := k.wrap(k.primary, dek)
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.primary + ":" + b64(rewrapped) + ":" + b64(sealed), nil
+}
+
+// BlindIndex is a deterministic keyed hash for equality lookups on an
+// encrypted field. domain separates fields, so equal values in different
+// columns do not share an index. Callers normalise value first (see
+// NormalizePhone). The 128-bit truncation is deliberate: collisions are
+// harmless for lookups and a shorter index leaks less.
+func (k *Keyring) BlindIndex(value, domain string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(domain))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// NormalizePhone keeps a leading + and the digits, so "090-1234-5678" and
+// "090 1234 5678" index the same.
+func NormalizePhone(s string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(s) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ---- internals ----
+
+func (k *Keyring) wrap(kid string, dek []byte) ([]byte, error) {
+	kek, ok := k.keks[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return seal(kek, dek, []byte("dek:"+kid))
+}
+
+func (k *Keyring) unwrap(kid string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keks[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+	}
+	return open(kek, wrapped, []byte("dek:"+kid))
+}
+
+func split(value string) (kid string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	if wrapped, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+// seal returns nonce || AES-GCM(key, plaintext, aad).
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ct := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	pt, err := gcm.Open(nil, nonce, ct, aad)
+	if err != nil {
+		return nil, fmt.Errorf("fieldcrypt: decrypt: %w", err)
+	}
+	return pt, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ---- key file management ----
+
+// GenerateKeyFile writes a new key file with one KEK (as primary) and an index
+// key. It refuses to overwrite an existing file: losing the index key or a
+// KEK makes stored data unreadable.
+func GenerateKeyFile(path, kid string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("fieldcrypt: %s already exists", path)
+	}
+	kek, err := randomKey()
+	if err != nil {
+		return err
+	}
+	idx, err := randomKey()
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(path, keyFile{Primary: kid, Keys: map[string]string{kid: kek}, IndexKey: idx})
+}
+
+// RotateKeyFile adds a new KEK under kid and makes it primary. Old keys stay
+// so existing values decrypt until they are rewritten with Rotate; remove them
+// by hand once nothing references them.
+func RotateKeyFile(path, kid string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var f keyFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("fieldcrypt: parse %s: %w", path, err)
+	}
+	if _, exists := f.Keys[kid]; exists {
+		return fmt.Errorf("fieldcrypt: key %q already exists", kid)
+	}
+	kek, err := randomKey()
+	if err != nil {
+		return err
+	}
+	f.Keys[kid] = kek
+	f.Primary = kid
+	return writeKeyFile(path, f)
+}
+
+func writeKeyFile(path string, f keyFile) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+package fieldcrypt
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := GenerateKeyFile(path, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := GenerateKeyFile(path, "k1"); err == nil {
+		t.Fatal("GenerateKeyFile overwrote an existing file")
+	}
+	k, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ct, err := k.Encrypt("1-2-3 Roppongi", "Geo.town")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(ct) || strings.Contains(ct, "Roppongi") {
+		t.Fatalf("not encrypted: %q", ct)
+	}
+	again, _ := k.Encrypt("1-2-3 Roppongi", "Geo.town")
+	if again == ct {
+		t.Fatal("two encryptions of the same value are identical")
+	}
+	if pt, err := k.Decrypt(ct, "Geo.town"); err != nil || pt != "1-2-3 Roppongi" {
+		t.Fatalf("Decrypt = (%q, %v)", pt, err)
+	}
+	if _, err := k.Decrypt(ct, "Geo.postal_code"); err == nil {
+		t.Fatal("ciphertext moved to another column decrypted")
+	}
+	if pt, _ := k.Decrypt("legacy plaintext", "Geo.town"); pt != "legacy plaintext" {
+		t.Fatalf("plaintext passthrough = %q", pt)
+	}
+	if ct, _ := k.Encrypt("", "Geo.town"); ct != "" {
+		t.Fatalf("empty value encrypted to %q", ct)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := GenerateKeyFile(path, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := LoadKeyFile(path)
+	ct, _ := old.Encrypt("090-1234-5678", "phone")
+	idxBefore := old.BlindIndex("09012345678", "phone")
+
+	if err := RotateKeyFile(path, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKeyFile(path)
+	if err != nil || k.Primary() != "k2" {
+		t.Fatalf("after rotate: primary %q, err %v", k.Primary(), err)
+	}
+	if !k.NeedsRotation(ct) || !k.NeedsRotation("plain") || k.NeedsRotation("") {
+		t.Fatal("NeedsRotation wrong")
+	}
+	rotated, err := k.Rotate(ct, "phone")
+	if err != nil || k.NeedsRotation(rotated) {
+		t.Fatalf("Rotate = (%q, %v)", rotated, err)
+	}
+	if pt, err := k.Decrypt(rotated, "phone"); err != nil || pt != "090-1234-5678" {
+		t.Fatalf("Decrypt(rotated) = (%q, %v)", pt, err)
+	}
+	if _, err := old.Decrypt(rotated, "phone"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("old keyring err = %v, want ErrUnknownKey", err)
+	}
+	if got := k.BlindIndex("09012345678", "phone"); got != idxBefore {
+		t.Fatal("blind index changed across KEK rotation")
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k, err := NewKeyring("k", map[string][]byte{"k": make([]byte, 32)}, []byte(strings.Repeat("i", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := k.BlindIndex(NormalizePhone("090-1234-5678"), "phone")
+	b := k.BlindIndex(NormalizePhone(" 090 1234 5678"), "phone")
+	if a == "" || a != b {
+		t.Fatalf("normalised phones index differently: %q %q", a, b)
+	}
+	if k.BlindIndex("09012345678", "other") == a {
+		t.Fatal("domains share an index")
+	}
+	if got := NormalizePhone("+81 (90) 1234-5678"); got != "+819012345678" {
+		t.Fatalf("NormalizePhone = %q", got)
+	}
+}
+
+func TestPhone(t *testing.T) {
+	k, err := NewKeyring("k", map[string][]byte{"k": make([]byte, 32)}, []byte(strings.Repeat("i", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, idx, err := k.EncryptPhone("090-1234-5678")
+	if err != nil || !IsEncrypted(ct) || idx != k.PhoneIndex("09012345678") {
+		t.Fatalf("EncryptPhone = (%q, %q, %v)", ct, idx, err)
+	}
+	if pt, err := k.DecryptPhone(ct); err != nil || pt != "090-1234-5678" {
+		t.Fatalf("DecryptPhone = (%q, %v)", pt, err)
+	}
+	if _, err := k.Decrypt(ct, GeoAAD("town")); err == nil {
+		t.Fatal("phone ciphertext decrypted as a Geo column")
+	}
+	if ct, idx, _ := k.EncryptPhone(""); ct != "" || idx != "" {
+		t.Fatalf("EncryptPhone(\"\") = (%q, %q)", ct, idx)
+	}
+}
@@ -0,0 +1,40 @@
+package fieldcrypt
+
+// Field names shared by every service that reads or writes these values. The
+// aad binds a ciphertext to its field, so they must not change.
+
+// GeoColumns are the encrypted Geo columns. country_code, prefecture and city
+// stay plaintext because queries filter and group on them.
+var GeoColumns = []string{"postal_code", "town", "building_name", "room_number", "latitude", "longitude"}
+
+// GeoAAD is the aad for a Geo column, e.g. GeoAAD("town") == "Geo.town".
+func GeoAAD(column string) string { return "Geo." + column }
+
+// Phone numbers live in Keycloak user attributes: the ciphertext under
+// PhoneAttr and its blind index under PhoneIndexAttr.
+const (
+	PhoneAttr      = "phoneNumber"
+	PhoneIndexAttr = "phoneNumberIndex"
+	phoneAAD       = "keycloak.phoneNumber"
+	phoneDomain    = "phoneNumber"
+)
+
+// EncryptPhone returns the attribute values to store for phone. An empty phone
+// gives two empty values, which clear both attributes.
+func (k *Keyring) EncryptPhone(phone string) (ciphertext, index string, err error) {
+	ciphertext, err = k.Encrypt(phone, phoneAAD)
+	if err != nil {
+		return "", "", err
+	}
+	return ciphertext, k.PhoneIndex(phone), nil
+}
+
+func (k *Keyring) DecryptPhone(value string) (string, error) {
+	return k.Decrypt(value, phoneAAD)
+}
+
+// PhoneIndex is the blind index for looking a user up by phone number; formatting
+// differences ("090-1234-5678" vs "09012345678") do not matter.
+func (k *Keyring) PhoneIndex(phone string) string {
+	return k.BlindIndex(NormalizePhone(phone), phoneDomain)
+}
@@ -0,0 +1,226 @@
+// Package flags evaluates feature flags kept in MySQL (the FeatureFlag table)
+// and cached in Redis, so a behaviour can be switched on for some users before
+// everyone gets it.
+//
+//	ff := flags.New(flags.NewSQLStore(db), flags.Options{Redis: rdb, Logger: logger})
+//	if ff.Enabled(ctx, "shipping.quote-v2", flags.Subject{UserID: uid, Country: "JP"}) {
+//		...
+//	}
+//
+// A flag that does not exist, or cannot be read, is off.
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ErrInvalid is returned, wrapped, for a flag that cannot be stored.
+var ErrInvalid = errors.New("invalid flag")
+
+type Flag struct {
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"`
+	Rollout     int       `json:"rollout_percent"` // 0-100, of the users the targeting lets through
+	Users       []string  `json:"target_users,omitempty"`
+	Roles       []string  `json:"target_roles,omitempty"`
+	Countries   []string  `json:"target_countries,omitempty"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Subject is who a flag is evaluated for. Any field may be empty.
+type Subject struct {
+	UserID  string
+	Roles   []string
+	Country string // ISO 3166-1 alpha-2
+}
+
+// FromGin is the subject for the verified user of a request, if any; add
+// roles and country where the handler knows them.
+func FromGin(c *gin.Context) Subject {
+	uid, _ := auth.GetUserID(c)
+	return Subject{UserID: uid}
+}
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Validate checks a flag before it is stored.
+func (f Flag) Validate() error {
+	switch {
+	case !keyPattern.MatchString(f.Key):
+		return fmt.Errorf("%w: key must be 1-64 of a-z, 0-9, '.', '_', '-'", ErrInvalid)
+	case f.Rollout < 0 || f.Rollout > 100:
+		return fmt.Errorf("%w: rollout_percent must be between 0 and 100", ErrInvalid)
+	}
+	return nil
+}
+
+// Evaluate reports whether f is on for s, and why: "disabled", "user" (listed
+// in Users), "role" or "country" (filtered out by targeting), "on" (full
+// rollout) or "rollout" (inside or outside the percentage).
+//
+// A partial rollout buckets users by a hash of flag key and user id, so a user
+// keeps their answer as the percentage grows, and flags roll out
+// independently of one another. Without a user id only a full rollout is on.
+func (f Flag) Evaluate(s Subject) (bool, string) {
+	switch {
+	case !f.Enabled:
+		return false, "disabled"
+	case s.UserID != "" && slices.Contains(f.Users, s.UserID):
+		return true, "user"
+	case len(f.Roles) > 0 && !slices.ContainsFunc(s.Roles, func(r string) bool { return slices.Contains(f.Roles, r) }):
+		return false, "role"
+	case len(f.Countries) > 0 && !slices.ContainsFunc(f.Countries, func(c string) bool { return strings.EqualFold(c, s.Country) }):
+		return false, "country"
+	case f.Rollout >= 100:
+		return true, "on"
+	case f.Rollout <= 0 || s.UserID == "":
+		return false, "rollout"
+	}
+	return bucket(f.Key, s.UserID) < f.Rollout, "rollout"
+}
+
+func bucket(key, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key + "/" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// Store is where flags live; see SQLStore.
+type Store interface {
+	Load(ctx context.Context) ([]Flag, error)
+}
+
+type Options struct {
+	Logger   *zap.Logger
+	Redis    redis.Cmdable // shared snapshot, so replicas do not each query MySQL; nil to skip
+	LocalTTL time.Duration // how long a process reuses the flags it has; default 5s
+	RedisTTL time.Duration // how long the Redis snapshot lives; default 1m
+}
+
+const redisKey = "flags:v1"
+
+// Client answers flag queries from an in-process copy of every flag, refreshed
+// from Redis or, failing that, the store. When both are unreachable it keeps
+// answering from the last copy it had.
+type Client struct {
+	store    Store
+	rdb      redis.Cmdable
+	logger   *zap.Logger
+	localTTL time.Duration
+	redisTTL time.Duration
+
+	mu       sync.Mutex
+	flags    map[string]Flag
+	loadedAt time.Time
+}
+
+func New(store Store, opts Options) *Client {
+	c := &Client{store: store, rdb: opts.Redis, logger: opts.Logger, localTTL: opts.LocalTTL, redisTTL: opts.RedisTTL}
+	if c.logger == nil {
+		c.logger = zap.NewNop()
+	}
+	if c.localTTL <= 0 {
+		c.localTTL = 5 * time.Second
+	}
+	if c.redisTTL <= 0 {
+		c.redisTTL = time.Minute
+	}
+	return c
+}
+
+// Enabled reports whether the flag is on for s.
+func (c *Client) Enabled(ctx context.Context, key string, s Subject) bool {
+	f, ok := c.Get(ctx, key)
+	on := false
+	if ok {
+		on, _ = f.Evaluate(s)
+	}
+	evaluations.WithLabelValues(key, fmt.Sprint(on)).Inc()
+	return on
+}
+
+// Get returns the current definition of a flag.
+func (c *Client) Get(ctx context.Context, key string) (Flag, bool) {
+	f, ok := c.snapshot(ctx)[key]
+	return f, ok
+}
+
+// Invalidate drops the cached flags here and in Redis; call it after changing
+// the store. Other replicas pick the change up within LocalTTL.
+func (c *Client) Invalidate(ctx context.Context) error {
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.mu.Unlock()
+	if c.rdb == nil {
+		return nil
+	}
+	return c.rdb.Del(ctx, redisKey).Err()
+}
+
+func (c *Client) snapshot(ctx context.Context) map[string]Flag {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.loadedAt) < c.localTTL {
+		return c.flags
+	}
+	// on failure too, so an outage costs one attempt per LocalTTL
+	c.loadedAt = time.Now()
+	flags, err := c.load(ctx)
+	if err != nil {
+		c.logger.Warn("feature flags not refreshed; using the last known set", zap.Error(err))
+		return c.flags
+	}
+	c.flags = flags
+	return flags
+}
+
+func (c *Client) load(ctx context.Context) (map[string]Flag, error) {
+	if c.rdb != nil {
+		b, err := c.rdb.Get(ctx, redisKey).Bytes()
+		if err == nil {
+			var flags map[string]Flag
+			if err := json.Unmarshal(b, &flags); err == nil {
+				return flags, nil
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			c.logger.Warn("feature flag cache unavailable", zap.Error(err))
+		}
+	}
+	list, err := c.store.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]Flag, len(list))
+	for _, f := range list {
+		flags[f.Key] = f
+	}
+	if c.rdb != nil {
+		if b, err := json.Marshal(flags); err == nil {
+			_ = c.rdb.Set(ctx, redisKey, b, c.redisTTL).Err()
+		}
+	}
+	return flags, nil
+}
+
+var evaluations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "feature_flag_evaluations_total",
+	Help: "Feature flag checks by flag and answer.",
+}, []string{"flag", "enabled"})
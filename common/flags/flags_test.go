@@ -0,0 +1,124 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestEvaluate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		flag   Flag
+		sub    Subject
+		on     bool
+		reason string
+	}{
+		{"disabled", Flag{Rollout: 100}, Subject{UserID: "u1"}, false, "disabled"},
+		{"boolean on", Flag{Enabled: true, Rollout: 100}, Subject{}, true, "on"},
+		{"zero rollout", Flag{Enabled: true}, Subject{UserID: "u1"}, false, "rollout"},
+		{"listed user beats rollout", Flag{Enabled: true, Users: []string{"u1"}}, Subject{UserID: "u1"}, true, "user"},
+		{"listed user beats role filter", Flag{Enabled: true, Users: []string{"u1"}, Roles: []string{"admin"}}, Subject{UserID: "u1"}, true, "user"},
+		{"role matches", Flag{Enabled: true, Rollout: 100, Roles: []string{"seller"}}, Subject{Roles: []string{"customer", "seller"}}, true, "on"},
+		{"role missing", Flag{Enabled: true, Rollout: 100, Roles: []string{"seller"}}, Subject{Roles: []string{"customer"}}, false, "role"},
+		{"country case-insensitive", Flag{Enabled: true, Rollout: 100, Countries: []string{"JP"}}, Subject{Country: "jp"}, true, "on"},
+		{"country missing", Flag{Enabled: true, Rollout: 100, Countries: []string{"JP"}}, Subject{}, false, "country"},
+		{"partial rollout needs a user", Flag{Key: "k", Enabled: true, Rollout: 99}, Subject{}, false, "rollout"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			on, reason := tc.flag.Evaluate(tc.sub)
+			if on != tc.on || reason != tc.reason {
+				t.Errorf("got %v (%s), want %v (%s)", on, reason, tc.on, tc.reason)
+			}
+		})
+	}
+}
+
+func TestRolloutIsStickyAndProportional(t *testing.T) {
+	at := func(pct int) map[string]bool {
+		f := Flag{Key: "shipping.quote-v2", Enabled: true, Rollout: pct}
+		on := map[string]bool{}
+		for i := 0; i < 10000; i++ {
+			uid := fmt.Sprintf("user-%d", i)
+			if ok, _ := f.Evaluate(Subject{UserID: uid}); ok {
+				on[uid] = true
+			}
+		}
+		return on
+	}
+	ten, thirty := at(10), at(30)
+	if n := len(ten); n < 800 || n > 1200 {
+		t.Errorf("10%% rollout enabled %d of 10000", n)
+	}
+	for uid := range ten {
+		if !thirty[uid] {
+			t.Fatalf("%s lost the flag when the rollout grew", uid)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, f := range []Flag{{Key: ""}, {Key: "Upper"}, {Key: "ok", Rollout: 101}, {Key: "ok", Rollout: -1}} {
+		if err := f.Validate(); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%+v: got %v", f, err)
+		}
+	}
+	if err := (Flag{Key: "shipping.quote-v2", Rollout: 50}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+type fakeStore struct {
+	flags []Flag
+	err   error
+	loads int
+}
+
+func (s *fakeStore) Load(context.Context) ([]Flag, error) {
+	s.loads++
+	return s.flags, s.err
+}
+
+func TestClientCaching(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	store := &fakeStore{flags: []Flag{{Key: "a", Enabled: true, Rollout: 100}}}
+
+	c1 := New(store, Options{Redis: rdb, LocalTTL: time.Hour})
+	if !c1.Enabled(ctx, "a", Subject{}) || c1.Enabled(ctx, "missing", Subject{}) {
+		t.Fatal("wrong answers from a fresh client")
+	}
+	if store.loads != 1 || !mr.Exists(redisKey) {
+		t.Fatalf("loads %d, redis snapshot written %v", store.loads, mr.Exists(redisKey))
+	}
+
+	// a second replica is served from Redis
+	c2 := New(store, Options{Redis: rdb})
+	if !c2.Enabled(ctx, "a", Subject{}) || store.loads != 1 {
+		t.Errorf("second client went to the store (loads %d)", store.loads)
+	}
+
+	// after a change, Invalidate makes the writer see it at once
+	store.flags = []Flag{{Key: "a"}}
+	if err := c1.Invalidate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c1.Enabled(ctx, "a", Subject{}) || store.loads != 2 {
+		t.Errorf("change not picked up after Invalidate (loads %d)", store.loads)
+	}
+
+	// with Redis and the store both down, the last known flags stay in use
+	mr.Close()
+	store.err = errors.New("mysql down")
+	store.flags = nil
+	c1.Invalidate(ctx)
+	if _, ok := c1.Get(ctx, "a"); !ok {
+		t.Error("stale flags dropped during an outage")
+	}
+}
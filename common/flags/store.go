@@ -0,0 +1,109 @@
+package flags
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SQLStore keeps flags in the FeatureFlag table (migration 0003). Services
+// only read through a Client; sale's admin API writes here and then calls
+// Client.Invalidate.
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+const flagColumns = `flag_key, description, enabled, rollout_percent,
+	target_users, target_roles, target_countries, updated_by, updated_at`
+
+// Load returns every flag, ordered by key.
+func (s *SQLStore) Load(ctx context.Context) ([]Flag, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+flagColumns+" FROM FeatureFlag ORDER BY flag_key")
+	if err != nil {
+		return nil, fmt.Errorf("load feature flags: %w", err)
+	}
+	defer rows.Close()
+	var out []Flag
+	for rows.Next() {
+		f, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// Get returns one flag; ok is false when there is no such key.
+func (s *SQLStore) Get(ctx context.Context, key string) (Flag, bool, error) {
+	f, err := scanFlag(s.db.QueryRowContext(ctx, "SELECT "+flagColumns+" FROM FeatureFlag WHERE flag_key = ?", key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Flag{}, false, nil
+	}
+	return f, err == nil, err
+}
+
+// Put creates or replaces a flag after validating it.
+func (s *SQLStore) Put(ctx context.Context, f Flag) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	users, roles, countries := jsonList(f.Users), jsonList(f.Roles), jsonList(f.Countries)
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO FeatureFlag (flag_key, description, enabled, rollout_percent, target_users, target_roles, target_countries, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			description = VALUES(description), enabled = VALUES(enabled), rollout_percent = VALUES(rollout_percent),
+			target_users = VALUES(target_users), target_roles = VALUES(target_roles),
+			target_countries = VALUES(target_countries), updated_by = VALUES(updated_by)`,
+		f.Key, f.Description, f.Enabled, f.Rollout, users, roles, countries, f.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("store feature flag %s: %w", f.Key, err)
+	}
+	return nil
+}
+
+// Delete removes a flag, reporting whether it existed.
+func (s *SQLStore) Delete(ctx context.Context, key string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM FeatureFlag WHERE flag_key = ?", key)
+	if err != nil {
+		return false, fmt.Errorf("delete feature flag %s: %w", key, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func scanFlag(row interface{ Scan(...any) error }) (Flag, error) {
+	var f Flag
+	var users, roles, countries []byte
+	if err := row.Scan(&f.Key, &f.Description, &f.Enabled, &f.Rollout, &users, &roles, &countries, &f.UpdatedBy, &f.UpdatedAt); err != nil {
+		return Flag{}, err
+	}
+	for _, l := range []struct {
+		raw []byte
+		dst *[]string
+	}{{users, &f.Users}, {roles, &f.Roles}, {countries, &f.Countries}} {
+		if len(l.raw) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(l.raw, l.dst); err != nil {
+			return Flag{}, fmt.Errorf("feature flag %s: bad target list: %w", f.Key, err)
+		}
+	}
+	return f, nil
+}
+
+// jsonList is NULL for an empty list, which targets nobody by that attribute.
+func jsonList(l []string) any {
+	if len(l) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(l)
+	return string(b)
+}
@@ -0,0 +1,73 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SQL pings the database pool.
+func SQL(db *sql.DB) CheckFunc {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// Redis pings a go-redis v9 client. For v8 (ranking) register
+// func(ctx context.Context) error { return rdb.Ping(ctx).Err() } instead.
+func Redis(rdb redis.UniversalClient) CheckFunc {
+	return func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	}
+}
+
+// HTTP passes when a GET of url answers 2xx. client may be nil. Use it for
+// dependencies with a health endpoint: MeiliSearch /health, MinIO
+// /minio/health/live, Nominatim /status, the Keycloak JWKS.
+func HTTP(client *http.Client, url string) CheckFunc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// Cached reruns fn at most once per ttl and otherwise repeats its last result,
+// for checks that cost money or quota (a Stripe API call) when probed every
+// few seconds.
+func Cached(fn CheckFunc, ttl time.Duration) CheckFunc {
+	var (
+		mu   sync.Mutex
+		at   time.Time
+		last error
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !at.IsZero() && time.Since(at) < ttl {
+			return last
+		}
+		last, at = fn(ctx), time.Now()
+		return last
+	}
+}
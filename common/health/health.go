@@ -0,0 +1,181 @@
+// Package health gives every service the same liveness and readiness probes.
+// A service registers checks for the dependencies it needs (MySQL, Redis,
+// MeiliSearch, MinIO, the Keycloak JWKS, its payment provider, ...) and serves
+// /livez and /readyz; the sale admin health view polls /readyz across services
+// with a Monitor.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Report and check statuses. A failed critical check makes the service Down
+// (/readyz answers 503); a failed optional check only makes it Degraded.
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// DefaultTimeout bounds each check when Checker.Timeout is unset.
+const DefaultTimeout = 2 * time.Second
+
+// CheckFunc reports a dependency as healthy by returning nil.
+type CheckFunc func(ctx context.Context) error
+
+// Checker holds a service's registered checks. Register them before serving;
+// Run is safe for concurrent use.
+type Checker struct {
+	Service string
+	Timeout time.Duration
+
+	started time.Time
+	checks  []check
+}
+
+type check struct {
+	name     string
+	critical bool
+	fn       CheckFunc
+}
+
+// CheckResult is one check's outcome in a Report.
+type CheckResult struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Critical   bool   `json:"critical"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Report is the /readyz body.
+type Report struct {
+	Service       string        `json:"service"`
+	Status        string        `json:"status"`
+	Checks        []CheckResult `json:"checks"`
+	CheckedAt     time.Time     `json:"checkedAt"`
+	UptimeSeconds int64         `json:"uptimeSeconds"`
+}
+
+// New returns a Checker for service with no checks; with none registered the
+// service is ready as soon as it serves.
+func New(service string) *Checker {
+	return &Checker{Service: service, started: time.Now()}
+}
+
+// Critical registers a check the service cannot serve without.
+func (c *Checker) Critical(name string, fn CheckFunc) *Checker {
+	c.checks = append(c.checks, check{name: name, critical: true, fn: fn})
+	return c
+}
+
+// Optional registers a check whose failure degrades the service but leaves it
+// ready, e.g. a search backend that only some routes use.
+func (c *Checker) Optional(name string, fn CheckFunc) *Checker {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+	return c
+}
+
+// Run executes every check concurrently, each under its own timeout.
+func (c *Checker) Run(ctx context.Context) Report {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runCheck(ctx, chk, timeout)
+		}()
+	}
+	wg.Wait()
+
+	status := StatusOK
+	for _, r := range results {
+		if r.Status == StatusOK {
+			continue
+		}
+		if r.Critical {
+			status = StatusDown
+			break
+		}
+		status = StatusDegraded
+	}
+	return Report{
+		Service:       c.Service,
+		Status:        status,
+		Checks:        results,
+		CheckedAt:     time.Now().UTC(),
+		UptimeSeconds: int64(time.Since(c.started).Seconds()),
+	}
+}
+
+func runCheck(ctx context.Context, chk check, timeout time.Duration) (res CheckResult) {
+	res = CheckResult{Name: chk.name, Status: StatusOK, Critical: chk.critical}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		res.DurationMs = time.Since(start).Milliseconds()
+		if p := recover(); p != nil {
+			res.Status, res.Error = StatusDown, "check panicked"
+		}
+	}()
+	if err := chk.fn(ctx); err != nil {
+		res.Status, res.Error = StatusDown, err.Error()
+	}
+	return res
+}
+
+// LiveHandler answers /livez: the process is up and serving. It never looks at
+// dependencies, so an outage elsewhere does not get the service restarted.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"service":       c.Service,
+			"status":        StatusOK,
+			"uptimeSeconds": int64(time.Since(c.started).Seconds()),
+		})
+	})
+}
+
+// ReadyHandler answers /readyz with the full Report: 200 while every critical
+// check passes, 503 otherwise.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rep := c.Run(r.Context())
+		code := http.StatusOK
+		if rep.Status == StatusDown {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, rep)
+	})
+}
+
+// Mount serves /livez and /readyz on a ServeMux.
+func (c *Checker) Mount(mux *http.ServeMux) {
+	mux.Handle("GET /livez", c.LiveHandler())
+	mux.Handle("GET /readyz", c.ReadyHandler())
+}
+
+// Gin serves /livez and /readyz on a Gin router.
+func (c *Checker) Gin(r gin.IRoutes) {
+	r.GET("/livez", gin.WrapH(c.LiveHandler()))
+	r.GET("/readyz", gin.WrapH(c.ReadyHandler()))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
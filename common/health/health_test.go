@@ -0,0 +1,150 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+
+	searchDown := true
+	hc := New("cart").
+		Critical("redis", Redis(rdb)).
+		Optional("meilisearch", func(ctx context.Context) error {
+			if searchDown {
+				return errors.New("connection refused")
+			}
+			return nil
+		})
+	r := gin.New()
+	hc.Gin(r)
+
+	get := func(path string) (int, Report) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var rep Report
+		if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, w.Body.String())
+		}
+		return w.Code, rep
+	}
+
+	code, rep := get("/readyz")
+	if code != http.StatusOK || rep.Status != StatusDegraded || rep.Service != "cart" {
+		t.Fatalf("optional failure: %d %+v", code, rep)
+	}
+	if len(rep.Checks) != 2 || rep.Checks[1].Error != "connection refused" || rep.Checks[1].Critical {
+		t.Fatalf("checks = %+v", rep.Checks)
+	}
+
+	searchDown = false
+	mr.Close()
+	code, rep = get("/readyz")
+	if code != http.StatusServiceUnavailable || rep.Status != StatusDown || rep.Checks[0].Status != StatusDown {
+		t.Fatalf("critical failure: %d %+v", code, rep)
+	}
+	if code, rep = get("/livez"); code != http.StatusOK || rep.Status != StatusOK {
+		t.Fatalf("livez = %d %+v", code, rep)
+	}
+}
+
+func TestCheckTimeoutAndPanic(t *testing.T) {
+	hc := New("geocoding").
+		Critical("nominatim", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}).
+		Optional("broken", func(context.Context) error { panic("boom") })
+	hc.Timeout = 20 * time.Millisecond
+
+	mux := http.NewServeMux()
+	hc.Mount(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("code = %d", w.Code)
+	}
+	var rep Report
+	_ = json.Unmarshal(w.Body.Bytes(), &rep)
+	if rep.Checks[0].Error != context.DeadlineExceeded.Error() || rep.Checks[1].Error != "check panicked" {
+		t.Fatalf("checks = %+v", rep.Checks)
+	}
+}
+
+func TestCached(t *testing.T) {
+	var calls atomic.Int32
+	fn := Cached(func(context.Context) error {
+		calls.Add(1)
+		return errors.New("stripe unreachable")
+	}, time.Hour)
+	for range 3 {
+		if err := fn(context.Background()); err == nil {
+			t.Fatal("want the cached error")
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	ready := New("shipment").Critical("mysql", func(context.Context) error { return nil })
+	srv := httptest.NewServer(ready.ReadyHandler())
+	defer srv.Close()
+
+	var saleDown atomic.Bool
+	local := New("sale").Critical("mysql", func(context.Context) error {
+		if saleDown.Load() {
+			return errors.New("ping failed")
+		}
+		return nil
+	})
+	probes, err := ParseTargets("sale=,shipment="+srv.URL+",ecpay=http://127.0.0.1:1/readyz", nil, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMonitor(MonitorOptions{Probes: probes, History: 3})
+	if s := m.Services(); len(s) != 3 || s[0].Service != "ecpay" || s[0].Status != StatusUnknown {
+		t.Fatalf("before polling: %+v", s)
+	}
+	m.Poll(context.Background())
+	saleDown.Store(true)
+	for range 3 {
+		m.Poll(context.Background())
+	}
+
+	got := map[string]ServiceStatus{}
+	for _, s := range m.Services() {
+		got[s.Service] = s
+	}
+	if s := got["shipment"]; s.Status != StatusOK || s.Uptime != 1 || len(s.History) != 3 {
+		t.Fatalf("shipment = %+v", s)
+	}
+	if s := got["ecpay"]; s.Status != StatusDown || s.Latest.Checks[0].Name != "reachable" || s.Uptime != 0 {
+		t.Fatalf("ecpay = %+v", s)
+	}
+	// the ok sample fell out of the 3-sample history
+	if s := got["sale"]; s.Status != StatusDown || s.Uptime != 0 || s.History[0].Failing[0] != "mysql" {
+		t.Fatalf("sale = %+v", s)
+	}
+	if _, err := ParseTargets("sale=", nil, nil); err == nil {
+		t.Fatal("want an error for a local target without a checker")
+	}
+	if (*Monitor)(nil).Services() != nil {
+		t.Fatal("nil monitor should report nothing")
+	}
+}
@@ -0,0 +1,252 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StatusUnknown is a monitored service that has not been probed yet.
+const StatusUnknown = "unknown"
+
+// Probe fetches one service's readiness report.
+type Probe func(ctx context.Context) Report
+
+// Remote probes another service's /readyz. A 503 still carries the report;
+// an unreachable service or unreadable body is reported as down with a single
+// "reachable" check.
+func Remote(url string, client *http.Client) Probe {
+	if client == nil {
+		client = &http.Client{Timeout: 3 * time.Second}
+	}
+	return func(ctx context.Context) Report {
+		start := time.Now()
+		rep, err := fetch(ctx, client, url)
+		if err != nil {
+			return Report{
+				Status:    StatusDown,
+				CheckedAt: time.Now().UTC(),
+				Checks: []CheckResult{{
+					Name:       "reachable",
+					Status:     StatusDown,
+					Critical:   true,
+					DurationMs: time.Since(start).Milliseconds(),
+					Error:      err.Error(),
+				}},
+			}
+		}
+		return rep
+	}
+}
+
+func fetch(ctx context.Context, client *http.Client, url string) (Report, error) {
+	var rep Report
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return rep, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return rep, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&rep); err != nil || rep.Status == "" {
+		return rep, fmt.Errorf("GET %s: %s, no health report", url, resp.Status)
+	}
+	return rep, nil
+}
+
+// ParseTargets reads "name=url,name=url" into probes. An empty url probes local
+// in-process instead of over HTTP.
+func ParseTargets(spec string, client *http.Client, local *Checker) (map[string]Probe, error) {
+	out := map[string]Probe{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(part, "=")
+		name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("health target %q: want name=url", part)
+		}
+		if url == "" {
+			if local == nil {
+				return nil, fmt.Errorf("health target %q: no local checker", name)
+			}
+			out[name] = local.Run
+			continue
+		}
+		out[name] = Remote(url, client)
+	}
+	return out, nil
+}
+
+type MonitorOptions struct {
+	Logger   *zap.Logger
+	Probes   map[string]Probe // services to poll, by name
+	Interval time.Duration    // how often Run polls; default 30s
+	History  int              // samples kept per service; default 120 (an hour at 30s)
+}
+
+// Monitor polls every service's readiness and keeps a short in-memory history
+// of the results, for the admin health view.
+type Monitor struct {
+	logger   *zap.Logger
+	probes   map[string]Probe
+	interval time.Duration
+	size     int
+
+	mu       sync.Mutex
+	services map[string]*serviceState
+}
+
+type serviceState struct {
+	latest  Report
+	history []Sample // oldest first
+}
+
+// Sample is one poll of one service.
+type Sample struct {
+	At      time.Time `json:"at"`
+	Status  string    `json:"status"`
+	Failing []string  `json:"failing,omitempty"`
+}
+
+// ServiceStatus is a service's latest report plus its recent history. Uptime
+// is the share of kept samples in which the service was ready (not down), and
+// Since is when the current status began, as far back as the history reaches.
+type ServiceStatus struct {
+	Service string    `json:"service"`
+	Status  string    `json:"status"`
+	Since   time.Time `json:"since"`
+	Uptime  float64   `json:"uptime"`
+	Latest  Report    `json:"latest"`
+	History []Sample  `json:"history"`
+}
+
+func NewMonitor(opts MonitorOptions) *Monitor {
+	m := &Monitor{
+		logger:   opts.Logger,
+		probes:   opts.Probes,
+		interval: opts.Interval,
+		size:     opts.History,
+		services: map[string]*serviceState{},
+	}
+	if m.logger == nil {
+		m.logger = zap.NewNop()
+	}
+	if m.interval <= 0 {
+		m.interval = 30 * time.Second
+	}
+	if m.size <= 0 {
+		m.size = 120
+	}
+	return m
+}
+
+// Run polls until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	tick := time.NewTicker(m.interval)
+	defer tick.Stop()
+	for {
+		m.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// Poll probes every service once, concurrently, and records the results.
+func (m *Monitor) Poll(ctx context.Context) {
+	type result struct {
+		name string
+		rep  Report
+	}
+	results := make(chan result, len(m.probes))
+	for name, probe := range m.probes {
+		go func() { results <- result{name, probe(ctx)} }()
+	}
+	for range m.probes {
+		r := <-results
+		m.record(r.name, r.rep)
+	}
+}
+
+func (m *Monitor) record(name string, rep Report) {
+	if rep.Service == "" {
+		rep.Service = name
+	}
+	if rep.CheckedAt.IsZero() {
+		rep.CheckedAt = time.Now().UTC()
+	}
+	s := Sample{At: rep.CheckedAt, Status: rep.Status}
+	for _, c := range rep.Checks {
+		if c.Status != StatusOK {
+			s.Failing = append(s.Failing, c.Name)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.services[name]
+	if st == nil {
+		st = &serviceState{}
+		m.services[name] = st
+	}
+	if prev := st.latest.Status; prev != "" && prev != rep.Status {
+		m.logger.Info("service health changed", zap.String("service", name), zap.String("from", prev), zap.String("to", rep.Status), zap.Strings("failing", s.Failing))
+	}
+	st.latest = rep
+	st.history = append(st.history, s)
+	if over := len(st.history) - m.size; over > 0 {
+		st.history = append(st.history[:0:0], st.history[over:]...)
+	}
+}
+
+// Services returns every monitored service, sorted by name. Services not yet
+// polled are StatusUnknown. A nil Monitor reports nothing.
+func (m *Monitor) Services() []ServiceStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]ServiceStatus, 0, len(m.probes))
+	for name := range m.probes {
+		st := m.services[name]
+		if st == nil {
+			out = append(out, ServiceStatus{Service: name, Status: StatusUnknown, History: []Sample{}})
+			continue
+		}
+		ss := ServiceStatus{
+			Service: name,
+			Status:  st.latest.Status,
+			Latest:  st.latest,
+			History: append([]Sample(nil), st.history...),
+		}
+		var up int
+		for i, s := range st.history {
+			if s.Status != StatusDown {
+				up++
+			}
+			if i == 0 || s.Status != st.history[i-1].Status {
+				ss.Since = s.At
+			}
+		}
+		ss.Uptime = float64(up) / float64(len(st.history))
+		out = append(out, ss)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Service < out[j].Service })
+	return out
+}
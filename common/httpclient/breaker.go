@@ -0,0 +1,162 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+type BreakerOptions struct {
+	Failures int           // consecutive failures that open the circuit; default 5
+	OpenFor  time.Duration // how long it stays open before probing; default 30s
+	Probes   int           // requests let through at once while half-open; default 1
+}
+
+const (
+	closed = iota
+	halfOpen
+	open
+)
+
+var stateNames = [...]string{closed: "closed", halfOpen: "half-open", open: "open"}
+
+type result int
+
+const (
+	success result = iota
+	failure
+	ignored
+)
+
+// breaker counts consecutive failures. Open, it rejects everything until
+// OpenFor has passed; then it lets Probes requests through, and the first
+// outcome closes it again or reopens it for another OpenFor.
+type breaker struct {
+	target    string
+	logger    *zap.Logger
+	threshold int
+	openFor   time.Duration
+	maxProbes int
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+func newBreaker(target string, opts BreakerOptions, logger *zap.Logger) *breaker {
+	b := &breaker{target: target, logger: logger, threshold: opts.Failures, openFor: opts.OpenFor, maxProbes: opts.Probes}
+	if b.threshold <= 0 {
+		b.threshold = 5
+	}
+	if b.openFor <= 0 {
+		b.openFor = 30 * time.Second
+	}
+	if b.maxProbes <= 0 {
+		b.maxProbes = 1
+	}
+	circuitState.WithLabelValues(target).Set(closed)
+	return b
+}
+
+// allow reports whether a request may go out; if so, done must be called
+// with its result.
+func (b *breaker) allow() (done func(result), ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == open && time.Since(b.openedAt) >= b.openFor {
+		b.transition(halfOpen)
+	}
+	switch b.state {
+	case closed:
+		return b.record(false), true
+	case halfOpen:
+		if b.probes < b.maxProbes {
+			b.probes++
+			return b.record(true), true
+		}
+	}
+	return nil, false
+}
+
+func (b *breaker) record(probe bool) func(result) {
+	return func(r result) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if probe {
+			b.probes--
+		}
+		switch {
+		case r == ignored:
+		case probe && b.state == halfOpen:
+			if r == success {
+				b.failures = 0
+				b.transition(closed)
+			} else {
+				b.trip()
+			}
+		case b.state == closed:
+			if r == success {
+				b.failures = 0
+			} else if b.failures++; b.failures >= b.threshold {
+				b.trip()
+			}
+		}
+		// late results from before a trip change nothing
+	}
+}
+
+func (b *breaker) trip() {
+	b.openedAt = time.Now()
+	b.transition(open)
+}
+
+func (b *breaker) transition(state int) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	circuitState.WithLabelValues(b.target).Set(float64(state))
+	switch state {
+	case open:
+		b.logger.Warn("circuit opened", zap.String("target", b.target), zap.Int("failures", b.failures), zap.Duration("open_for", b.openFor))
+	case closed:
+		b.logger.Info("circuit closed", zap.String("target", b.target))
+	}
+	if state != halfOpen {
+		b.probes = 0
+	}
+}
+
+func (b *breaker) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == open && time.Since(b.openedAt) >= b.openFor {
+		return stateNames[halfOpen]
+	}
+	return stateNames[b.state]
+}
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_requests_total",
+		Help: "Outbound HTTP requests by target and result (status class, error, circuit_open, fallback), after retries.",
+	}, []string{"target", "method", "result"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_client_request_duration_seconds",
+		Help:    "Outbound HTTP request latency by target, retries and backoff included.",
+		Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"target", "method"})
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_retries_total",
+		Help: "Outbound HTTP attempts beyond the first.",
+	}, []string{"target"})
+	circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "http_client_circuit_state",
+		Help: "Circuit breaker state per target: 0 closed, 1 half-open, 2 open.",
+	}, []string{"target"})
+)
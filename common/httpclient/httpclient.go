@@ -0,0 +1,263 @@
+// Package httpclient builds the *http.Client services use to call other
+// services and third parties. Every attempt has a deadline, idempotent
+// requests are retried with jittered exponential backoff, and each target has
+// a circuit breaker: after a run of failures calls fail fast with
+// ErrCircuitOpen until a half-open probe gets through again.
+//
+// Build one client per dependency and share it; the breaker and the metrics
+// belong to the target, not to a request.
+//
+//	minio := httpclient.New(httpclient.Options{Target: "minio", Timeout: 2 * time.Second})
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mockten/mockten/common/tracing"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned, wrapped with the target, while a target's
+// breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type Options struct {
+	Target     string        // names the dependency in metrics, logs and errors
+	Logger     *zap.Logger   // breaker state changes
+	Timeout    time.Duration // per attempt, until the body is closed; default 5s
+	Retries    int           // extra attempts for idempotent requests; default 2, negative for none
+	Backoff    time.Duration // delay before the first retry, doubling after; default 100ms
+	MaxBackoff time.Duration // cap on one delay, and on an honoured Retry-After; default 2s
+	Breaker    BreakerOptions
+
+	// Fallback, if set, is called when a request fails (an error, an open
+	// circuit, or a 5xx after the retries) and the caller has not given up. It
+	// may return a substitute response, e.g. from a cache, or an error.
+	Fallback func(req *http.Request, err error) (*http.Response, error)
+
+	// Transport sends each attempt; default an instrumented http.DefaultTransport.
+	Transport http.RoundTripper
+}
+
+// Transport is the http.RoundTripper behind New.
+type Transport struct {
+	target     string
+	base       http.RoundTripper
+	timeout    time.Duration
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	breaker    *breaker
+	fallback   func(*http.Request, error) (*http.Response, error)
+}
+
+// New returns a client for one target. It has no overall Timeout: the
+// per-attempt deadline bounds each try, and the caller's context the whole.
+func New(opts Options) *http.Client {
+	return &http.Client{Transport: NewTransport(opts)}
+}
+
+func NewTransport(opts Options) *Transport {
+	t := &Transport{
+		target:     opts.Target,
+		base:       opts.Transport,
+		timeout:    opts.Timeout,
+		retries:    opts.Retries,
+		backoff:    opts.Backoff,
+		maxBackoff: opts.MaxBackoff,
+		fallback:   opts.Fallback,
+	}
+	if t.target == "" {
+		t.target = "unnamed"
+	}
+	if t.base == nil {
+		t.base = tracing.Transport(nil)
+	}
+	if t.timeout <= 0 {
+		t.timeout = 5 * time.Second
+	}
+	if t.retries == 0 {
+		t.retries = 2
+	}
+	if t.backoff <= 0 {
+		t.backoff = 100 * time.Millisecond
+	}
+	if t.maxBackoff <= 0 {
+		t.maxBackoff = 2 * time.Second
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	t.breaker = newBreaker(t.target, opts.Breaker, logger)
+	return t
+}
+
+// State is the breaker's state: "closed", "open" or "half-open".
+func (t *Transport) State() string { return t.breaker.current() }
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.send(req)
+	result := outcome(resp, err)
+	if t.fallback != nil && req.Context().Err() == nil && (err != nil || resp.StatusCode >= 500) {
+		if err == nil {
+			err = fmt.Errorf("%s: %s", t.target, resp.Status)
+			drain(resp)
+		}
+		resp, err = t.fallback(req, err)
+		result = "fallback"
+	}
+	requestsTotal.WithLabelValues(t.target, req.Method, result).Inc()
+	requestDuration.WithLabelValues(t.target, req.Method).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// send makes the attempts. The last attempt's response is returned as is,
+// 5xx included; only transport errors and an open circuit are errors.
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := 1
+	if t.retries > 0 && retryable(req) {
+		attempts += t.retries
+	}
+	var wait time.Duration
+	for i := 0; ; i++ {
+		if i > 0 {
+			retriesTotal.WithLabelValues(t.target).Inc()
+			if err := sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req = req.Clone(ctx)
+				req.Body = body
+			}
+		}
+
+		done, ok := t.breaker.allow()
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", t.target, ErrCircuitOpen)
+		}
+		resp, err := t.attempt(req)
+		switch {
+		case ctx.Err() != nil:
+			done(ignored) // the caller gave up; says nothing about the target
+		case err != nil || resp.StatusCode >= 500:
+			done(failure)
+		default:
+			done(success)
+		}
+
+		last := i == attempts-1 || ctx.Err() != nil
+		if last || !shouldRetry(resp, err) {
+			if err != nil {
+				err = fmt.Errorf("%s: %w", t.target, err)
+			}
+			return resp, err
+		}
+		wait = t.delay(i, resp)
+		if resp != nil {
+			drain(resp)
+		}
+	}
+}
+
+// attempt sends one try under its own deadline, which stays armed until the
+// caller closes the body.
+func (t *Transport) attempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// delay is the wait before retry i+1: exponential with equal jitter, or the
+// server's Retry-After when it is no longer than MaxBackoff.
+func (t *Transport) delay(i int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 {
+			if d := time.Duration(s) * time.Second; d <= t.maxBackoff {
+				return d
+			}
+		}
+	}
+	d := min(t.backoff<<i, t.maxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether req may be sent twice: an idempotent method, or
+// any method carrying an Idempotency-Key, with a body that can be replayed.
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func outcome(resp *http.Response, err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case err != nil:
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// drain reads a little of a discarded body so the connection can be reused.
+func drain(resp *http.Response) {
+	_, _ = io.CopyN(io.Discard, resp.Body, 4<<10)
+	resp.Body.Close()
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
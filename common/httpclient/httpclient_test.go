@@ -0,0 +1,156 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// flaky answers status for the first n requests, then 200 with the body it got.
+func flaky(n int32, status int) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= n {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(append([]byte("ok:"), body...))
+	}))
+	return srv, &hits
+}
+
+func TestRetriesIdempotentRequests(t *testing.T) {
+	srv, hits := flaky(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+	c := New(Options{Target: "retry", Backoff: time.Millisecond})
+	retries := testutil.ToFloat64(retriesTotal.WithLabelValues("retry"))
+
+	resp, err := c.Get(srv.URL)
+	if err != nil || resp.StatusCode != 200 || hits.Load() != 3 {
+		t.Fatalf("GET: %v, %v after %d hits", resp, err, hits.Load())
+	}
+	resp.Body.Close()
+
+	hits.Store(0)
+	resp, err = c.Post(srv.URL, "text/plain", strings.NewReader("x"))
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Fatalf("POST without a key was retried: %v, %v after %d hits", resp, err, hits.Load())
+	}
+	resp.Body.Close()
+
+	hits.Store(0)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("charge-1"))
+	req.Header.Set("Idempotency-Key", "k1")
+	resp, err = c.Do(req)
+	if err != nil || hits.Load() != 3 {
+		t.Fatalf("POST with a key: %v after %d hits", err, hits.Load())
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "ok:charge-1" {
+		t.Fatalf("replayed body = %q", body)
+	}
+	resp.Body.Close()
+	if n := testutil.ToFloat64(retriesTotal.WithLabelValues("retry")) - retries; n != 4 {
+		t.Fatalf("retries metric grew by %v, want 4", n)
+	}
+}
+
+func TestTimeoutIsPerAttempt(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte("fast"))
+	}))
+	defer srv.Close()
+	c := New(Options{Target: "timeout", Timeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond})
+
+	resp, err := c.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "fast" || hits.Load() != 2 {
+		t.Fatalf("body %q after %d hits", body, hits.Load())
+	}
+}
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	srv, hits := flaky(2, http.StatusInternalServerError)
+	defer srv.Close()
+	var fellBack error
+	tr := NewTransport(Options{
+		Target:  "breaker",
+		Retries: -1,
+		Breaker: BreakerOptions{Failures: 2, OpenFor: 30 * time.Millisecond},
+		Fallback: func(req *http.Request, err error) (*http.Response, error) {
+			fellBack = err
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("cached")), Request: req}, nil
+		},
+	})
+	c := &http.Client{Transport: tr}
+	fallbacks := testutil.ToFloat64(requestsTotal.WithLabelValues("breaker", "GET", "fallback"))
+	get := func() string {
+		t.Helper()
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// the two 500s also go to the fallback
+	get()
+	get()
+	if tr.State() != "open" || hits.Load() != 2 {
+		t.Fatalf("state %s after %d hits", tr.State(), hits.Load())
+	}
+	if body := get(); body != "cached" || !errors.Is(fellBack, ErrCircuitOpen) || hits.Load() != 2 {
+		t.Fatalf("open circuit: body %q, fallback error %v, %d hits", body, fellBack, hits.Load())
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if tr.State() != "half-open" {
+		t.Fatalf("state %s, want half-open", tr.State())
+	}
+	if body := get(); body != "ok:" || tr.State() != "closed" {
+		t.Fatalf("probe: body %q, state %s", body, tr.State())
+	}
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("breaker", "GET", "fallback")) - fallbacks; n != 3 {
+		t.Fatalf("fallback metric grew by %v, want 3", n)
+	}
+}
+
+func TestCallerCancelDoesNotTrip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	tr := NewTransport(Options{Target: "cancel", Breaker: BreakerOptions{Failures: 1}})
+	c := &http.Client{Transport: tr}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := c.Do(req); err == nil {
+		t.Fatal("expected the caller's deadline")
+	}
+	if tr.State() != "closed" {
+		t.Fatalf("state %s after the caller gave up", tr.State())
+	}
+}
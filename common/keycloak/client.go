@@ -0,0 +1,306 @@
+// Package keycloak is a small client for the Keycloak Admin REST API, so
+// services read and write user data through Keycloak instead of querying its
+// USER_ENTITY / USER_ATTRIBUTE tables.
+//
+//	kc, err := keycloak.NewClientFromEnv()
+//	profiles := keycloak.NewProfileCache(kc, 5*time.Minute)
+//	p, err := profiles.Profile(ctx, "seller@example.com")
+//	name := p.Attribute("storeName")
+//
+// The client authenticates as a confidential client's service account
+// (client_credentials grant), which needs the realm-management roles
+// view-users, query-users, query-groups and manage-users.
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mockten/mockten/common/httpclient"
+)
+
+var (
+	ErrNotFound      = errors.New("keycloak: not found")
+	ErrNotConfigured = errors.New("keycloak: admin client not configured")
+)
+
+// APIError is a non-2xx answer from Keycloak.
+type APIError struct {
+	Method string
+	Path   string
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("keycloak: %s %s: %d %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// User mirrors Keycloak's UserRepresentation. Attributes are multi-valued in
+// Keycloak; use Attribute for the usual single value.
+type User struct {
+	ID         string              `json:"id,omitempty"`
+	Username   string              `json:"username,omitempty"`
+	Email      string              `json:"email,omitempty"`
+	FirstName  string              `json:"firstName,omitempty"`
+	LastName   string              `json:"lastName,omitempty"`
+	Enabled    bool                `json:"enabled"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+func (u *User) Attribute(name string) string {
+	if v := u.Attributes[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type Config struct {
+	BaseURL      string // e.g. http://uam-service.default.svc.cluster.local
+	Realm        string
+	ClientID     string
+	ClientSecret string
+	HTTPClient   *http.Client
+}
+
+// ConfigFromEnv reads KEYCLOAK_BASE_URL and KEYCLOAK_REALM (same defaults as
+// common/auth) and the service account's KEYCLOAK_ADMIN_CLIENT_ID (default
+// mockten-backend) / KEYCLOAK_ADMIN_CLIENT_SECRET.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		BaseURL:      strings.TrimSpace(os.Getenv("KEYCLOAK_BASE_URL")),
+		Realm:        strings.TrimSpace(os.Getenv("KEYCLOAK_REALM")),
+		ClientID:     strings.TrimSpace(os.Getenv("KEYCLOAK_ADMIN_CLIENT_ID")),
+		ClientSecret: strings.TrimSpace(os.Getenv("KEYCLOAK_ADMIN_CLIENT_SECRET")),
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://uam-service.default.svc.cluster.local"
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = "mockten-realm-dev"
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "mockten-backend"
+	}
+	return cfg
+}
+
+type Client struct {
+	base   string
+	realm  string
+	id     string
+	secret string
+	http   *http.Client
+
+	mu       sync.Mutex
+	token    string
+	tokenExp time.Time
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.ClientSecret == "" {
+		return nil, ErrNotConfigured
+	}
+	hc := cfg.HTTPClient
+	if hc == nil {
+		hc = httpclient.New(httpclient.Options{Target: "keycloak-admin", Timeout: 5 * time.Second})
+	}
+	return &Client{
+		base:   strings.TrimRight(cfg.BaseURL, "/"),
+		realm:  cfg.Realm,
+		id:     cfg.ClientID,
+		secret: cfg.ClientSecret,
+		http:   hc,
+	}, nil
+}
+
+func NewClientFromEnv() (*Client, error) {
+	return NewClient(ConfigFromEnv())
+}
+
+// ---- users ----
+
+func (c *Client) GetUser(ctx context.Context, id string) (*User, error) {
+	var u User
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(id), nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (c *Client) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	return c.findOne(ctx, url.Values{"email": {email}, "exact": {"true"}})
+}
+
+func (c *Client) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	return c.findOne(ctx, url.Values{"username": {username}, "exact": {"true"}})
+}
+
+// LookupUser resolves the identifiers our tables store (normally the email,
+// sometimes the username) to a Keycloak user.
+func (c *Client) LookupUser(ctx context.Context, emailOrUsername string) (*User, error) {
+	if strings.Contains(emailOrUsername, "@") {
+		u, err := c.FindUserByEmail(ctx, emailOrUsername)
+		if !errors.Is(err, ErrNotFound) {
+			return u, err
+		}
+	}
+	return c.FindUserByUsername(ctx, emailOrUsername)
+}
+
+// FindUsersByAttribute returns the users whose attribute name equals value
+// exactly, e.g. a blind index such as phoneNumberIndex.
+func (c *Client) FindUsersByAttribute(ctx context.Context, name, value string) ([]User, error) {
+	var users []User
+	q := url.Values{"q": {name + ":" + value}, "exact": {"true"}}
+	if err := c.do(ctx, http.MethodGet, "/users?"+q.Encode(), nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (c *Client) findOne(ctx context.Context, q url.Values) (*User, error) {
+	var users []User
+	if err := c.do(ctx, http.MethodGet, "/users?"+q.Encode(), nil, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// SetAttributes merges attrs into the user's attributes; an empty value
+// removes that attribute. Keycloak replaces the whole attribute map on update,
+// so the current map is read first.
+func (c *Client) SetAttributes(ctx context.Context, id string, attrs map[string]string) error {
+	u, err := c.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if u.Attributes == nil {
+		u.Attributes = map[string][]string{}
+	}
+	for k, v := range attrs {
+		if v == "" {
+			delete(u.Attributes, k)
+		} else {
+			u.Attributes[k] = []string{v}
+		}
+	}
+	return c.do(ctx, http.MethodPut, "/users/"+url.PathEscape(id), u, nil)
+}
+
+// ---- transport ----
+
+func (c *Client) adminURL(path string) string {
+	return c.base + "/admin/realms/" + url.PathEscape(c.realm) + path
+}
+
+// do calls an admin endpoint, refreshing the service-account token once if
+// Keycloak rejects it (expired early, or the session was dropped).
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	err := c.doOnce(ctx, method, path, in, out)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		err = c.doOnce(ctx, method, path, in, out)
+	}
+	return err
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string, in, out any) error {
+	tok, err := c.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.adminURL(path), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{Method: method, Path: path, Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExp) {
+		return c.token, nil
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	endpoint := c.base + "/realms/" + url.PathEscape(c.realm) + "/protocol/openid-connect/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.id, c.secret)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", &APIError{Method: http.MethodPost, Path: "token", Status: resp.StatusCode, Body: strings.TrimSpace(string(b))}
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+	if tr.AccessToken == "" {
+		return "", errors.New("keycloak: token response without access_token")
+	}
+	// Renew a little early so a token never expires mid-request.
+	ttl := time.Duration(tr.ExpiresIn)*time.Second - 30*time.Second
+	if ttl < 5*time.Second {
+		ttl = 5 * time.Second
+	}
+	c.token, c.tokenExp = tr.AccessToken, time.Now().Add(ttl)
+	return c.token, nil
+}
@@ -0,0 +1,384 @@
+// Package keycloaktest runs an in-process stand-in for the Keycloak Admin REST
+// API: the client_credentials token endpoint plus the user, attribute, realm
+// role and group endpoints common/keycloak uses, over an in-memory realm.
+//
+//	srv := keycloaktest.NewServer(t)
+//	srv.AddUser(keycloak.User{Email: "a@x.io", FirstName: "Alice"})
+//	kc := srv.Client(t)
+package keycloaktest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mockten/mockten/common/keycloak"
+)
+
+const (
+	DefaultRealm        = "mockten-realm-dev"
+	DefaultClientID     = "mockten-backend"
+	DefaultClientSecret = "keycloaktest-secret"
+)
+
+type Server struct {
+	Server       *httptest.Server
+	Realm        string
+	ClientID     string
+	ClientSecret string
+
+	adminCalls atomic.Int64
+
+	mu      sync.Mutex
+	tokens  map[string]bool
+	users   map[string]*keycloak.User
+	roles   map[string]keycloak.Role  // realm roles by name
+	groups  map[string]keycloak.Group // by id
+	userRol map[string]map[string]bool
+	userGrp map[string]map[string]bool
+}
+
+// NewServer starts a fake with the realm roles seller and customer and the
+// group admin-group, mirroring mockten-realm-dev. It is closed when the test
+// finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	s := &Server{
+		Realm:        DefaultRealm,
+		ClientID:     DefaultClientID,
+		ClientSecret: DefaultClientSecret,
+		tokens:       map[string]bool{},
+		users:        map[string]*keycloak.User{},
+		roles:        map[string]keycloak.Role{},
+		groups:       map[string]keycloak.Group{},
+		userRol:      map[string]map[string]bool{},
+		userGrp:      map[string]map[string]bool{},
+	}
+	s.AddRole("seller")
+	s.AddRole("customer")
+	s.AddGroup("admin-group")
+
+	admin := "/admin/realms/" + s.Realm
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /realms/"+s.Realm+"/protocol/openid-connect/token", s.handleToken)
+	mux.HandleFunc("GET "+admin+"/users", s.admin(s.handleFindUsers))
+	mux.HandleFunc("GET "+admin+"/users/{id}", s.admin(s.handleGetUser))
+	mux.HandleFunc("PUT "+admin+"/users/{id}", s.admin(s.handlePutUser))
+	mux.HandleFunc("GET "+admin+"/roles/{name}", s.admin(s.handleGetRole))
+	mux.HandleFunc("GET "+admin+"/users/{id}/role-mappings/realm", s.admin(s.handleUserRoles))
+	mux.HandleFunc("POST "+admin+"/users/{id}/role-mappings/realm", s.admin(s.handleMapRoles(true)))
+	mux.HandleFunc("DELETE "+admin+"/users/{id}/role-mappings/realm", s.admin(s.handleMapRoles(false)))
+	mux.HandleFunc("GET "+admin+"/users/{id}/groups", s.admin(s.handleUserGroups))
+	mux.HandleFunc("PUT "+admin+"/users/{id}/groups/{gid}", s.admin(s.handleMembership(true)))
+	mux.HandleFunc("DELETE "+admin+"/users/{id}/groups/{gid}", s.admin(s.handleMembership(false)))
+	mux.HandleFunc("GET "+admin+"/group-by-path/{path...}", s.admin(s.handleGroupByPath))
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+// Client returns a keycloak.Client authenticated against the fake.
+func (s *Server) Client(t testing.TB) *keycloak.Client {
+	t.Helper()
+	c, err := keycloak.NewClient(keycloak.Config{
+		BaseURL:      s.Server.URL,
+		Realm:        s.Realm,
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+	})
+	if err != nil {
+		t.Fatalf("keycloaktest: client: %v", err)
+	}
+	return c
+}
+
+// AddUser stores u and returns its id. Username defaults to the email, as in
+// the realm (registrationEmailAsUsername).
+func (s *Server) AddUser(u keycloak.User) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.ID == "" {
+		u.ID = newID()
+	}
+	if u.Username == "" {
+		u.Username = u.Email
+	}
+	u.Username = strings.ToLower(u.Username)
+	u.Enabled = true
+	u.Attributes = copyAttrs(u.Attributes)
+	s.users[u.ID] = &u
+	return u.ID
+}
+
+// User returns a copy of the stored user, for assertions.
+func (s *Server) User(id string) (keycloak.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return keycloak.User{}, false
+	}
+	cp := *u
+	cp.Attributes = copyAttrs(u.Attributes)
+	return cp, true
+}
+
+func (s *Server) AddRole(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[name] = keycloak.Role{ID: newID(), Name: name}
+}
+
+// AddGroup creates a top-level group and returns its id.
+func (s *Server) AddGroup(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := keycloak.Group{ID: newID(), Name: name, Path: "/" + name}
+	s.groups[g.ID] = g
+	return g.ID
+}
+
+// AdminCalls counts admin API requests served, to check caching.
+func (s *Server) AdminCalls() int {
+	return int(s.adminCalls.Load())
+}
+
+// ---- handlers ----
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if r.FormValue("grant_type") != "client_credentials" || id != s.ClientID || secret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized_client"})
+		return
+	}
+	tok := newID()
+	s.mu.Lock()
+	s.tokens[tok] = true
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"access_token": tok, "token_type": "Bearer", "expires_in": 300})
+}
+
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.adminCalls.Add(1)
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		ok := s.tokens[tok]
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "HTTP 401 Unauthorized"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) handleFindUsers(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	username := r.URL.Query().Get("username")
+	attrName, attrValue, _ := strings.Cut(r.URL.Query().Get("q"), ":")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []keycloak.User{}
+	for _, u := range s.users {
+		if email != "" && !strings.EqualFold(u.Email, email) {
+			continue
+		}
+		if username != "" && !strings.EqualFold(u.Username, username) {
+			continue
+		}
+		if attrName != "" && u.Attribute(attrName) != attrValue {
+			continue
+		}
+		out = append(out, *u)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[r.PathValue("id")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// handlePutUser applies the fields Keycloak would: attributes are replaced
+// wholesale when present.
+func (s *Server) handlePutUser(w http.ResponseWriter, r *http.Request) {
+	var in keycloak.User
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[r.PathValue("id")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	if in.FirstName != "" {
+		u.FirstName = in.FirstName
+	}
+	if in.LastName != "" {
+		u.LastName = in.LastName
+	}
+	if in.Email != "" {
+		u.Email = in.Email
+	}
+	if in.Attributes != nil {
+		u.Attributes = copyAttrs(in.Attributes)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetRole(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.roles[r.PathValue("name")]
+	if !ok {
+		notFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, role)
+}
+
+func (s *Server) handleUserRoles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := s.users[id]; !ok {
+		notFound(w)
+		return
+	}
+	out := []keycloak.Role{}
+	for name := range s.userRol[id] {
+		out = append(out, s.roles[name])
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleMapRoles(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var roles []keycloak.Role
+		if err := json.NewDecoder(r.Body).Decode(&roles); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		id := r.PathValue("id")
+		if _, ok := s.users[id]; !ok {
+			notFound(w)
+			return
+		}
+		if s.userRol[id] == nil {
+			s.userRol[id] = map[string]bool{}
+		}
+		for _, role := range roles {
+			if _, ok := s.roles[role.Name]; !ok {
+				notFound(w)
+				return
+			}
+			if add {
+				s.userRol[id][role.Name] = true
+			} else {
+				delete(s.userRol[id], role.Name)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleUserGroups(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := s.users[id]; !ok {
+		notFound(w)
+		return
+	}
+	out := []keycloak.Group{}
+	for gid := range s.userGrp[id] {
+		out = append(out, s.groups[gid])
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleMembership(join bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		id, gid := r.PathValue("id"), r.PathValue("gid")
+		if _, ok := s.users[id]; !ok {
+			notFound(w)
+			return
+		}
+		if _, ok := s.groups[gid]; !ok {
+			notFound(w)
+			return
+		}
+		if s.userGrp[id] == nil {
+			s.userGrp[id] = map[string]bool{}
+		}
+		if join {
+			s.userGrp[id][gid] = true
+		} else {
+			delete(s.userGrp[id], gid)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleGroupByPath(w http.ResponseWriter, r *http.Request) {
+	path := "/" + r.PathValue("path")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, g := range s.groups {
+		if g.Path == path {
+			writeJSON(w, http.StatusOK, g)
+			return
+		}
+	}
+	notFound(w)
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not Found"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func copyAttrs(in map[string][]string) map[string][]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(in))
+	for k, v := range in {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
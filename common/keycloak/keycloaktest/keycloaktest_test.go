@@ -0,0 +1,126 @@
+package keycloaktest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mockten/mockten/common/keycloak"
+)
+
+func TestClientUsersAndAttributes(t *testing.T) {
+	srv := NewServer(t)
+	id := srv.AddUser(keycloak.User{
+		Email:      "seller@example.com",
+		FirstName:  "Sam",
+		Attributes: map[string][]string{"storeName": {"Sam's"}, "phoneNumber": {"0"}},
+	})
+	kc := srv.Client(t)
+	ctx := context.Background()
+
+	u, err := kc.LookupUser(ctx, "Seller@Example.com")
+	if err != nil || u.ID != id || u.Attribute("storeName") != "Sam's" {
+		t.Fatalf("LookupUser = (%+v, %v)", u, err)
+	}
+	if _, err := kc.LookupUser(ctx, "nobody@example.com"); !errors.Is(err, keycloak.ErrNotFound) {
+		t.Fatalf("LookupUser(unknown) err = %v, want ErrNotFound", err)
+	}
+
+	if err := kc.SetAttributes(ctx, id, map[string]string{"phoneNumber": "", "countryCode": "+81"}); err != nil {
+		t.Fatalf("SetAttributes: %v", err)
+	}
+	if users, err := kc.FindUsersByAttribute(ctx, "storeName", "Sam's"); err != nil || len(users) != 1 || users[0].ID != id {
+		t.Fatalf("FindUsersByAttribute = (%v, %v)", users, err)
+	}
+
+	got, _ := srv.User(id)
+	if got.Attribute("storeName") != "Sam's" || got.Attribute("countryCode") != "+81" {
+		t.Fatalf("attributes not merged: %v", got.Attributes)
+	}
+	if _, ok := got.Attributes["phoneNumber"]; ok {
+		t.Fatalf("empty value should remove phoneNumber: %v", got.Attributes)
+	}
+}
+
+func TestClientRolesAndGroups(t *testing.T) {
+	srv := NewServer(t)
+	id := srv.AddUser(keycloak.User{Email: "a@x.io"})
+	kc := srv.Client(t)
+	ctx := context.Background()
+
+	if err := kc.AddRealmRoles(ctx, id, "seller"); err != nil {
+		t.Fatalf("AddRealmRoles: %v", err)
+	}
+	if err := kc.AddRealmRoles(ctx, id, "no-such-role"); !errors.Is(err, keycloak.ErrNotFound) {
+		t.Fatalf("AddRealmRoles(unknown) err = %v, want ErrNotFound", err)
+	}
+	if roles, err := kc.RealmRoles(ctx, id); err != nil || !slices.Equal(roles, []string{"seller"}) {
+		t.Fatalf("RealmRoles = (%v, %v)", roles, err)
+	}
+
+	if err := kc.JoinGroup(ctx, id, "/admin-group"); err != nil {
+		t.Fatalf("JoinGroup: %v", err)
+	}
+	if in, err := kc.InGroup(ctx, id, "admin-group"); err != nil || !in {
+		t.Fatalf("InGroup after join = (%v, %v)", in, err)
+	}
+	if err := kc.LeaveGroup(ctx, id, "admin-group"); err != nil {
+		t.Fatalf("LeaveGroup: %v", err)
+	}
+	if in, _ := kc.InGroup(ctx, id, "admin-group"); in {
+		t.Fatal("still in group after leave")
+	}
+}
+
+func TestProfileCache(t *testing.T) {
+	srv := NewServer(t)
+	srv.AddUser(keycloak.User{Email: "a@x.io", FirstName: "Ann", LastName: "Lee"})
+	pc := keycloak.NewProfileCache(srv.Client(t), time.Minute)
+	ctx := context.Background()
+
+	for range 3 {
+		p, err := pc.Profile(ctx, "a@x.io")
+		if err != nil || p.FullName() != "Ann Lee" {
+			t.Fatalf("Profile = (%+v, %v)", p, err)
+		}
+	}
+	if n := srv.AdminCalls(); n != 1 {
+		t.Fatalf("admin calls = %d, want 1 (cached)", n)
+	}
+
+	if err := pc.SetAttributes(ctx, "a@x.io", map[string]string{"phoneNumber": "123"}); err != nil {
+		t.Fatalf("SetAttributes: %v", err)
+	}
+	if p, _ := pc.Profile(ctx, "a@x.io"); p.Attribute("phoneNumber") != "123" {
+		t.Fatalf("stale profile after write: %v", p.Attributes)
+	}
+
+	got, err := pc.Profiles(ctx, []string{"a@x.io", "ghost@x.io", "a@x.io"})
+	if err != nil || len(got) != 1 || got["a@x.io"] == nil {
+		t.Fatalf("Profiles = (%v, %v)", got, err)
+	}
+
+	var nilCache *keycloak.ProfileCache
+	if _, err := nilCache.Profile(ctx, "a@x.io"); !errors.Is(err, keycloak.ErrNotConfigured) {
+		t.Fatalf("nil cache err = %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestClientRefreshesRejectedToken(t *testing.T) {
+	srv := NewServer(t)
+	id := srv.AddUser(keycloak.User{Email: "a@x.io"})
+	kc := srv.Client(t)
+	ctx := context.Background()
+
+	if _, err := kc.GetUser(ctx, id); err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	srv.mu.Lock()
+	clear(srv.tokens) // Keycloak restarted / session dropped
+	srv.mu.Unlock()
+	if _, err := kc.GetUser(ctx, id); err != nil {
+		t.Fatalf("GetUser after token loss: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package keycloak
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ---- realm roles and groups ----
+
+type Role struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type Group struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// RealmRoles lists the realm roles mapped directly to the user (seller,
+// customer, ...). Composite and default roles are not expanded.
+func (c *Client) RealmRoles(ctx context.Context, userID string) ([]string, error) {
+	var roles []Role
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/role-mappings/realm", nil, &roles); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, r := range roles {
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+func (c *Client) AddRealmRoles(ctx context.Context, userID string, names ...string) error {
+	return c.mapRealmRoles(ctx, http.MethodPost, userID, names)
+}
+
+func (c *Client) RemoveRealmRoles(ctx context.Context, userID string, names ...string) error {
+	return c.mapRealmRoles(ctx, http.MethodDelete, userID, names)
+}
+
+// mapRealmRoles needs full role representations, so each name is resolved
+// first; an unknown role is ErrNotFound.
+func (c *Client) mapRealmRoles(ctx context.Context, method, userID string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	roles := make([]Role, 0, len(names))
+	for _, n := range names {
+		var r Role
+		if err := c.do(ctx, http.MethodGet, "/roles/"+url.PathEscape(n), nil, &r); err != nil {
+			return err
+		}
+		roles = append(roles, r)
+	}
+	return c.do(ctx, method, "/users/"+url.PathEscape(userID)+"/role-mappings/realm", roles, nil)
+}
+
+func (c *Client) Groups(ctx context.Context, userID string) ([]Group, error) {
+	var groups []Group
+	if err := c.do(ctx, http.MethodGet, "/users/"+url.PathEscape(userID)+"/groups", nil, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// InGroup reports whether the user is a direct member of the group, given by
+// name ("admin-group") or path ("/admin-group").
+func (c *Client) InGroup(ctx context.Context, userID, group string) (bool, error) {
+	groups, err := c.Groups(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, g := range groups {
+		if g.Name == group || g.Path == group {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *Client) JoinGroup(ctx context.Context, userID, groupPath string) error {
+	return c.groupMembership(ctx, http.MethodPut, userID, groupPath)
+}
+
+func (c *Client) LeaveGroup(ctx context.Context, userID, groupPath string) error {
+	return c.groupMembership(ctx, http.MethodDelete, userID, groupPath)
+}
+
+func (c *Client) groupMembership(ctx context.Context, method, userID, groupPath string) error {
+	var g Group
+	segs := strings.Split(strings.Trim(groupPath, "/"), "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	if err := c.do(ctx, http.MethodGet, "/group-by-path/"+strings.Join(segs, "/"), nil, &g); err != nil {
+		return err
+	}
+	return c.do(ctx, method, "/users/"+url.PathEscape(userID)+"/groups/"+url.PathEscape(g.ID), nil, nil)
+}
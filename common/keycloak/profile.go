@@ -0,0 +1,175 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ---- profile read model ----
+
+// Profile is the flattened view of a user that services display: names plus
+// single-valued attributes (storeName, phoneNumber, countryCode, ...).
+type Profile struct {
+	ID         string
+	Username   string
+	Email      string
+	FirstName  string
+	LastName   string
+	Attributes map[string]string
+}
+
+func profileFromUser(u *User) *Profile {
+	p := &Profile{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Attributes: make(map[string]string, len(u.Attributes)),
+	}
+	for k := range u.Attributes {
+		p.Attributes[k] = u.Attribute(k)
+	}
+	return p
+}
+
+func (p *Profile) Attribute(name string) string {
+	return p.Attributes[name]
+}
+
+// FullName is "First Last", trimmed; empty when neither is set.
+func (p *Profile) FullName() string {
+	return strings.TrimSpace(p.FirstName + " " + p.LastName)
+}
+
+// ProfileCache answers profile reads from memory for ttl, so a page of reviews
+// or a product detail view does not cost a Keycloak round trip per user.
+// Unknown users are remembered too, for a shorter time. Writes made through
+// the cache invalidate the entry; writes made elsewhere (the user editing their
+// account in Keycloak) show up once the entry expires.
+//
+// A nil *ProfileCache is valid and returns ErrNotConfigured, so a service
+// without admin credentials degrades to its fallbacks instead of crashing.
+type ProfileCache struct {
+	client *Client
+	ttl    time.Duration
+	negTTL time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]profileEntry
+}
+
+type profileEntry struct {
+	p   *Profile // nil: user not found
+	exp time.Time
+}
+
+func NewProfileCache(c *Client, ttl time.Duration) *ProfileCache {
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &ProfileCache{
+		client:  c,
+		ttl:     ttl,
+		negTTL:  min(ttl, time.Minute),
+		now:     time.Now,
+		entries: map[string]profileEntry{},
+	}
+}
+
+// Profile looks a user up by email or username.
+func (pc *ProfileCache) Profile(ctx context.Context, emailOrUsername string) (*Profile, error) {
+	if pc == nil || pc.client == nil {
+		return nil, ErrNotConfigured
+	}
+	key := strings.ToLower(strings.TrimSpace(emailOrUsername))
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	pc.mu.Lock()
+	e, ok := pc.entries[key]
+	pc.mu.Unlock()
+	if ok && pc.now().Before(e.exp) {
+		if e.p == nil {
+			return nil, ErrNotFound
+		}
+		return e.p, nil
+	}
+
+	u, err := pc.client.LookupUser(ctx, emailOrUsername)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		pc.store(key, nil, pc.negTTL)
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	p := profileFromUser(u)
+	pc.store(key, p, pc.ttl)
+	return p, nil
+}
+
+// Profiles resolves several users at once, e.g. the authors on a page of
+// reviews. Users that cannot be resolved are left out; the first lookup
+// error, if any, is returned alongside whatever did resolve.
+func (pc *ProfileCache) Profiles(ctx context.Context, keys []string) (map[string]*Profile, error) {
+	out := make(map[string]*Profile, len(keys))
+	var firstErr error
+	for _, k := range keys {
+		if _, seen := out[k]; seen {
+			continue
+		}
+		p, err := pc.Profile(ctx, k)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out[k] = p
+	}
+	return out, firstErr
+}
+
+// SetAttributes writes through to Keycloak and drops the cached profile.
+func (pc *ProfileCache) SetAttributes(ctx context.Context, emailOrUsername string, attrs map[string]string) error {
+	if pc == nil || pc.client == nil {
+		return ErrNotConfigured
+	}
+	u, err := pc.client.LookupUser(ctx, emailOrUsername)
+	if err != nil {
+		return err
+	}
+	defer pc.Invalidate(emailOrUsername)
+	return pc.client.SetAttributes(ctx, u.ID, attrs)
+}
+
+func (pc *ProfileCache) Invalidate(emailOrUsername string) {
+	if pc == nil {
+		return
+	}
+	pc.mu.Lock()
+	delete(pc.entries, strings.ToLower(strings.TrimSpace(emailOrUsername)))
+	pc.mu.Unlock()
+}
+
+func (pc *ProfileCache) store(key string, p *Profile, ttl time.Duration) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	// Expired entries are only dropped here; the map is bounded by the number
+	// of distinct users a replica sees, which is fine for this deployment.
+	if len(pc.entries) > 10000 {
+		now := pc.now()
+		for k, e := range pc.entries {
+			if now.After(e.exp) {
+				delete(pc.entries, k)
+			}
+		}
+	}
+	pc.entries[key] = profileEntry{p: p, exp: pc.now().Add(ttl)}
+}
@@ -0,0 +1,67 @@
+// Package logging gives every service the same structured logs: JSON lines in
+// production, a request id that follows a request across services, and a
+// request-scoped logger carrying that id, the route and the user.
+//
+//	logger := logging.Init("cart", logging.Options{})
+//	defer logger.Sync()
+//	r.Use(logging.Gin(logger), tracing.Gin("cart"), ...)
+//	...
+//	logging.From(c.Request.Context()).Info("cart updated", zap.Int("items", n))
+//
+// Emails, phone and card numbers, tokens and the like are scrubbed before a
+// line is written; see scrub.go.
+package logging
+
+import (
+	"log"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type Options struct {
+	// Development writes coloured console lines at debug level instead of
+	// JSON at info.
+	Development bool
+	// Level overrides the level: "debug", "info", "warn", "error". Default
+	// is LOG_LEVEL, then the mode's level.
+	Level string
+}
+
+// New builds a service logger: JSON (or console in development) with the
+// service name on every line and PII scrubbed.
+func New(service string, opts Options) (*zap.Logger, error) {
+	zc := zap.NewProductionConfig()
+	if opts.Development {
+		zc = zap.NewDevelopmentConfig()
+	}
+	level := opts.Level
+	if level == "" {
+		level = os.Getenv("LOG_LEVEL")
+	}
+	if level != "" {
+		l, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		zc.Level = zap.NewAtomicLevelAt(l)
+	}
+	return zc.Build(
+		zap.WrapCore(func(c zapcore.Core) zapcore.Core { return scrubCore{c} }),
+		zap.Fields(zap.String("service", service)),
+	)
+}
+
+// Init is New for main: it becomes zap's global logger and the std log's
+// output, so log.Printf calls come out as the same JSON lines. A logger that
+// cannot be built is fatal.
+func Init(service string, opts Options) *zap.Logger {
+	logger, err := New(service, opts)
+	if err != nil {
+		log.Fatalf("logging: %v", err)
+	}
+	zap.ReplaceGlobals(logger)
+	zap.RedirectStdLog(logger)
+	return logger
+}
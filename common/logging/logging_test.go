@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func newObserved() (*zap.Logger, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	return zap.New(scrubCore{core}), logs
+}
+
+func TestScrub(t *testing.T) {
+	for in, want := range map[string]string{
+		"order for Alice@Example.com failed":       "order for " + hashEmail("alice@example.com") + " failed",
+		"Authorization: Bearer eyJhbGciOi.x.y":     "Authorization: Bearer " + Redacted,
+		"card 4242424242424242 declined":           "card " + Redacted + " declined",
+		"call +81 90-1234-5678 now":                "call " + Redacted + " now",
+		"at 2026-10-19 12:00:05, 3 items":          "at 2026-10-19 12:00:05, 3 items",
+		"txn 0b0c4f8e-1c2d-4e5f-9a8b-7c6d5e4f3a2b": "txn 0b0c4f8e-1c2d-4e5f-9a8b-7c6d5e4f3a2b",
+	} {
+		if got := Scrub(in); got != want {
+			t.Errorf("Scrub(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScrubCore(t *testing.T) {
+	logger, logs := newObserved()
+	logger.With(zap.String("Phone_Number", "09012345678")).Info("signup by bob@example.com",
+		zap.String("password", "hunter2"),
+		zap.Error(errors.New("smtp: bob@example.com rejected")),
+		zap.Int("items", 3))
+
+	e := logs.All()[0]
+	if strings.Contains(e.Message, "bob@") {
+		t.Errorf("message not scrubbed: %q", e.Message)
+	}
+	f := e.ContextMap()
+	if f["Phone_Number"] != Redacted || f["password"] != Redacted {
+		t.Errorf("sensitive keys kept: %v", f)
+	}
+	if s, _ := f["error"].(string); strings.Contains(s, "bob@") {
+		t.Errorf("error not scrubbed: %q", s)
+	}
+	if f["items"] != int64(3) {
+		t.Errorf("plain field changed: %v", f["items"])
+	}
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger, logs := newObserved()
+
+	var forwarded string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	r := gin.New()
+	r.Use(Gin(logger))
+	r.GET("/v1/cart/:id", func(c *gin.Context) {
+		SetUser(c.Request.Context(), "u-1")
+		SetUser(c.Request.Context(), "u-2") // only the first sticks
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		From(c.Request.Context()).Info("loaded")
+		c.Status(http.StatusNotFound)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/cart/42", nil)
+	req.Header.Set(RequestIDHeader, "req-42")
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get(RequestIDHeader) != "req-42" || forwarded != "req-42" {
+		t.Errorf("request id: response %q, forwarded %q", w.Header().Get(RequestIDHeader), forwarded)
+	}
+	if logs.Len() != 2 {
+		t.Fatalf("want handler line + access line, got %d", logs.Len())
+	}
+	access := logs.All()[1]
+	f := access.ContextMap()
+	if access.Level != zapcore.WarnLevel || f["request_id"] != "req-42" || f["user_id"] != "u-1" ||
+		f["route"] != "/v1/cart/:id" || f["status"] != int64(404) {
+		t.Errorf("access line: %s %v", access.Level, f)
+	}
+}
+
+func TestRequestIDReplacesUnsafeInput(t *testing.T) {
+	for _, in := range []string{"", "bad id\nforged=1", strings.Repeat("a", 129)} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(RequestIDHeader, in)
+		if id := RequestID(w, r); id == in || len(id) != 32 {
+			t.Errorf("%q: got %q", in, id)
+		}
+	}
+}
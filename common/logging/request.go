@@ -0,0 +1,239 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request id in and out. An incoming id (from Kong
+// or a calling service) is kept; otherwise the trace id is used, so the id in
+// a log line or an error response finds the trace.
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID returns the request's id, assigning one and echoing it in the
+// response header the first time it is asked for. Incoming ids longer than
+// 128 bytes or with characters outside [A-Za-z0-9._:-] are replaced.
+func RequestID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := r.Header.Get(RequestIDHeader)
+	if !validID(id) {
+		id = ""
+		if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+			id = sc.TraceID().String()
+		}
+	}
+	if id == "" {
+		b := make([]byte, 16)
+		_, _ = rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == ':', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// request is the per-request state behind the context. It is a pointer so
+// fields added further down the chain (the user id, once auth has run) reach
+// the access log written by the middleware.
+type request struct {
+	id     string
+	mu     sync.Mutex
+	logger *zap.Logger
+	user   bool
+}
+
+type ctxKey struct{}
+
+// WithRequest starts request-scoped logging on ctx: logger gets the request
+// id, and further fields from AddFields. The middleware calls it; use it
+// directly for work that does not arrive over HTTP, such as an event.
+func WithRequest(ctx context.Context, logger *zap.Logger, id string) context.Context {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return context.WithValue(ctx, ctxKey{}, &request{id: id, logger: logger.With(zap.String("request_id", id))})
+}
+
+// From returns the request-scoped logger, or zap's global logger outside a
+// request.
+func From(ctx context.Context) *zap.Logger {
+	if r, ok := ctx.Value(ctxKey{}).(*request); ok {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.logger
+	}
+	return zap.L()
+}
+
+// ID returns the request id carried by ctx, if any.
+func ID(ctx context.Context) string {
+	if r, ok := ctx.Value(ctxKey{}).(*request); ok {
+		return r.id
+	}
+	return ""
+}
+
+// AddFields adds fields to every later line of the request, the access log
+// included. A no-op outside a request.
+func AddFields(ctx context.Context, fields ...zap.Field) {
+	if r, ok := ctx.Value(ctxKey{}).(*request); ok {
+		r.mu.Lock()
+		r.logger = r.logger.With(fields...)
+		r.mu.Unlock()
+	}
+}
+
+// SetUser adds the authenticated user id, with any extra fields, to the
+// request's lines; common/auth calls it once a token checks out. Only the
+// first call counts, since a handler may resolve the user more than once.
+func SetUser(ctx context.Context, userID string, fields ...zap.Field) {
+	r, ok := ctx.Value(ctxKey{}).(*request)
+	if !ok || userID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.user {
+		r.user = true
+		r.logger = r.logger.With(append([]zap.Field{zap.String("user_id", userID)}, fields...)...)
+	}
+}
+
+// quiet are probe and scrape paths, which get a request id but no access log.
+func quiet(path string) bool {
+	switch path {
+	case "/metrics", "/health", "/healthz", "/livez", "/readyz":
+		return true
+	}
+	return false
+}
+
+// begin assigns the request id and the request-scoped logger. Run it after
+// tracing's middleware so an id-less request takes its trace id.
+func begin(w http.ResponseWriter, r *http.Request, logger *zap.Logger, route string) context.Context {
+	ctx := WithRequest(r.Context(), logger, RequestID(w, r))
+	fields := []zap.Field{zap.String("method", r.Method), zap.String("route", route)}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()))
+	}
+	AddFields(ctx, fields...)
+	return ctx
+}
+
+// done writes the access log line: 5xx as errors, 4xx as warnings.
+func done(ctx context.Context, status, size int, elapsed time.Duration, errs string) {
+	l := From(ctx)
+	fields := []zap.Field{zap.Int("status", status), zap.Int("bytes", max(size, 0)), zap.Duration("latency", elapsed)}
+	if errs != "" {
+		fields = append(fields, zap.String("errors", errs))
+	}
+	switch {
+	case status >= 500:
+		l.Error("request", fields...)
+	case status >= 400:
+		l.Warn("request", fields...)
+	default:
+		l.Info("request", fields...)
+	}
+}
+
+// Gin is the request logging middleware for Gin, in place of gin.Logger().
+// The route is the template ("/v1/cart/:id").
+func Gin(logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		c.Request = c.Request.WithContext(begin(c.Writer, c.Request, logger, route))
+		c.Next()
+		if !quiet(c.Request.URL.Path) {
+			done(c.Request.Context(), c.Writer.Status(), c.Writer.Size(), time.Since(start), c.Errors.String())
+		}
+	}
+}
+
+// Handler is the net/http equivalent of Gin. The route is the request path.
+func Handler(h http.Handler, logger *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r = r.WithContext(begin(w, r, logger, r.URL.Path))
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		if !quiet(r.URL.Path) {
+			done(r.Context(), sw.code, sw.size, time.Since(start), "")
+		}
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	size        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Transport wraps base (http.DefaultTransport when nil) so outbound requests
+// carry the caller's request id, unless they set one themselves.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return roundTripper{base}
+}
+
+type roundTripper struct{ base http.RoundTripper }
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := ID(req.Context()); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(RequestIDHeader, id)
+	}
+	return t.base.RoundTrip(req)
+}
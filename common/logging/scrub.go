@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces a value that must not reach the logs.
+const Redacted = "[redacted]"
+
+// sensitiveKeys are field names whose values are dropped whatever they hold.
+// Matching ignores case, '-' and '_'.
+var sensitiveKeys = map[string]bool{
+	"password": true, "passwd": true, "secret": true, "secretkey": true, "clientsecret": true,
+	"token": true, "accesstoken": true, "refreshtoken": true, "idtoken": true,
+	"authorization": true, "cookie": true, "setcookie": true, "apikey": true,
+	"card": true, "cardnumber": true, "cvc": true, "cvv": true,
+	"phone": true, "phonenumber": true, "address": true, "postalcode": true,
+	"town": true, "buildingname": true, "roomnumber": true,
+	"firstname": true, "lastname": true,
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	bearerPattern = regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`)
+	// card numbers and phone numbers written without separators, and
+	// international numbers with them; dates and times do not match
+	numberPattern = regexp.MustCompile(`\b\d{10,19}\b|\+\d[\d -]{7,}\d`)
+)
+
+// Scrub masks personal data in free text: an email becomes "email:" and a
+// short hash of it, so one user's lines can still be found together; bearer
+// tokens and long numbers become [redacted].
+func Scrub(s string) string {
+	if s == "" {
+		return s
+	}
+	s = emailPattern.ReplaceAllStringFunc(s, hashEmail)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+Redacted)
+	return numberPattern.ReplaceAllString(s, Redacted)
+}
+
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return "email:" + hex.EncodeToString(sum[:4])
+}
+
+func sensitive(key string) bool {
+	k := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	return sensitiveKeys[k]
+}
+
+// scrubCore applies Scrub to the message and to string, error and Stringer
+// fields, and drops fields with sensitive names. Other values (numbers,
+// objects from zap.Any) are written as they are, so keep personal data out of
+// them.
+type scrubCore struct {
+	zapcore.Core
+}
+
+func (c scrubCore) With(fields []zapcore.Field) zapcore.Core {
+	return scrubCore{c.Core.With(scrubFields(fields))}
+}
+
+func (c scrubCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c scrubCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = Scrub(e.Message)
+	return c.Core.Write(e, scrubFields(fields))
+}
+
+func scrubFields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, f := range fields {
+		out[i] = scrubField(f)
+	}
+	return out
+}
+
+func scrubField(f zapcore.Field) zapcore.Field {
+	if sensitive(f.Key) {
+		return zap.String(f.Key, Redacted)
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		f.String = Scrub(f.String)
+	case zapcore.ErrorType:
+		if err, ok := f.Interface.(error); ok && err != nil {
+			return zap.String(f.Key, Scrub(err.Error()))
+		}
+	case zapcore.StringerType:
+		if s, ok := f.Interface.(fmt.Stringer); ok && s != nil {
+			return zap.String(f.Key, Scrub(s.String()))
+		}
+	}
+	return f
+}
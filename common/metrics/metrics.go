@@ -0,0 +1,114 @@
+// Package metrics records RED metrics (rate, errors, duration) for HTTP
+// services, labelled by route template, and evaluates them against the
+// per-route latency budgets in the ApiSLA table.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	requestsName = "http_server_requests_total"
+	durationName = "http_server_request_duration_seconds"
+
+	// Unmatched is the route label for requests no route matched, so scanners
+	// probing random paths cannot blow up the label set.
+	Unmatched = "unmatched"
+)
+
+// Buckets are the latency histogram bounds in seconds. Every sla_ms budget in
+// ApiSLA is a bound, so the share of requests within budget is exact.
+var Buckets = []float64{.025, .05, .1, .2, .3, .4, .5, .6, .8, 1, 1.5, 2, 3, 5, 10}
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: requestsName,
+		Help: "HTTP requests served, by route template and status code.",
+	}, []string{"service", "method", "route", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    durationName,
+		Help:    "HTTP request latency, by route template.",
+		Buckets: Buckets,
+	}, []string{"service", "method", "route"})
+)
+
+// unobserved are probe and scrape paths, which would only dilute the numbers.
+func unobserved(path string) bool {
+	switch path {
+	case "/metrics", "/health", "/healthz", "/livez", "/readyz":
+		return true
+	}
+	return false
+}
+
+func observe(service, method, route string, code int, elapsed time.Duration) {
+	if route == "" {
+		route = Unmatched
+	}
+	requestsTotal.WithLabelValues(service, method, route, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(service, method, route).Observe(elapsed.Seconds())
+}
+
+// Gin is middleware recording every request under its route template
+// ("/v1/item/detail/:productId"), not the concrete path.
+func Gin(service string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if unobserved(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		c.Next()
+		observe(service, c.Request.Method, c.FullPath(), c.Writer.Status(), time.Since(start))
+	}
+}
+
+// Handler is the net/http equivalent of Gin. The route is the ServeMux pattern
+// that matched, without its method prefix.
+func Handler(h http.Handler, service string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unobserved(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		route := r.Pattern
+		if i := strings.IndexByte(route, ' '); i >= 0 {
+			route = route[i+1:]
+		}
+		observe(service, r.Method, route, sw.code, time.Since(start))
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Serve exposes the default registry at addr/metrics. It blocks, so run it in
+// a goroutine; services that already serve /metrics need not call it.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareLabelsByRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Gin("product"))
+	r.GET("/v1/item/detail/:productId", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	for _, path := range []string{"/v1/item/detail/a", "/v1/item/detail/b", "/wp-login.php", "/healthz"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("product", "GET", "/v1/item/detail/:productId", "500")); n != 2 {
+		t.Fatalf("detail requests = %v, want 2", n)
+	}
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("product", "GET", Unmatched, "404")); n != 1 {
+		t.Fatalf("unmatched requests = %v, want 1", n)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/shipment/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) })
+	Handler(mux, "shipment").ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/shipment/42", nil))
+	if n := testutil.ToFloat64(requestsTotal.WithLabelValues("shipment", "GET", "/v1/shipment/{id}", "202")); n != 1 {
+		t.Fatalf("shipment requests = %v, want 1", n)
+	}
+}
+
+func TestSLAEvaluator(t *testing.T) {
+	e := NewSLAEvaluator(SLAOptions{Sources: map[string]prometheus.Gatherer{"self": prometheus.DefaultGatherer}})
+	e.SetObjectives([]Objective{
+		{Method: "GET", Path: "/api/search", Service: "sla-test", Route: "/v1/search", Budget: 800 * time.Millisecond},
+		{Method: "GET", Path: "/api/storage/:id.png", Budget: 500 * time.Millisecond},
+	})
+	serve := func(n int, d time.Duration, code int) {
+		for i := 0; i < n; i++ {
+			observe("sla-test", "GET", "/v1/search", code, d)
+		}
+	}
+	t0 := time.Now()
+	e.Collect(t0)
+
+	// 10% over budget: a 10x burn in both windows.
+	serve(90, 100*time.Millisecond, 200)
+	serve(10, 2*time.Second, 200)
+	e.Collect(t0.Add(30 * time.Minute))
+	got := e.Report()
+	search := got[0]
+	if search.Status != StatusBreaching || search.Windows[0].Compliance != 0.9 || search.Windows[0].BurnRate != 10 || search.Windows[1].AvgMs != 290 {
+		t.Fatalf("after slow traffic: %+v", search)
+	}
+	if got[1].Status != StatusNoData || got[1].Windows != nil {
+		t.Fatalf("unmeasured route: %+v", got[1])
+	}
+
+	// The last 5 minutes are clean; the hour still carries the slow requests
+	// plus a handful of errors.
+	serve(95, 50*time.Millisecond, 200)
+	serve(5, 50*time.Millisecond, 503)
+	e.Collect(t0.Add(40 * time.Minute))
+	search = e.Report()[0]
+	short, long := search.Windows[0], search.Windows[1]
+	if short.Requests != 100 || short.Errors != 5 || short.Compliance != 0.95 || short.Covered != "10m" {
+		t.Fatalf("5m window: %+v", short)
+	}
+	if long.Requests != 200 || long.Compliance != 0.925 || long.Covered != "40m" || search.Status != StatusBreaching {
+		t.Fatalf("1h window: %+v (%s)", long, search.Status)
+	}
+}
+
+func TestDeltaAfterRestart(t *testing.T) {
+	prev := &counts{requests: 100, buckets: map[float64]uint64{0.8: 100}}
+	cur := &counts{requests: 3, buckets: map[float64]uint64{0.8: 2}}
+	if d := delta(cur, prev); d.requests != 3 || d.buckets[0.8] != 2 {
+		t.Fatalf("delta after restart = %+v", d)
+	}
+	if d := delta(nil, prev); d.requests != 0 {
+		t.Fatalf("delta of a vanished series = %+v", d)
+	}
+}
+
+func TestScrapeTargets(t *testing.T) {
+	observe("scrape-test", "POST", "/api/payment", 201, 30*time.Millisecond)
+	srv := httptest.NewServer(promhttp.Handler())
+	defer srv.Close()
+
+	sources, err := ParseTargets("ecpay="+srv.URL+", sale=", srv.Client())
+	if err != nil || len(sources) != 2 || sources["sale"] != prometheus.DefaultGatherer {
+		t.Fatalf("ParseTargets = (%v, %v)", sources, err)
+	}
+	fams, err := sources["ecpay"].Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range fams {
+		found = found || f.GetName() == durationName
+	}
+	if !found {
+		t.Fatal("scraped families lack the duration histogram")
+	}
+	if _, err := ParseTargets("ecpay", nil); err == nil {
+		t.Fatal("target without a name accepted")
+	}
+}
@@ -0,0 +1,408 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// Route statuses in an SLA report.
+const (
+	StatusOK        = "ok"        // within budget in every window
+	StatusAtRisk    = "at risk"   // burning error budget in some window
+	StatusBreaching = "breaching" // burning it in every window, or too slow on average
+	StatusNoData    = "no data"   // not measured, or no traffic
+)
+
+// Objective is one ApiSLA row: the public route, its latency budget, and where
+// it is served. Service and Route are empty for routes no Go service measures
+// (Keycloak, MinIO, the recommendation service).
+type Objective struct {
+	Method      string
+	Path        string
+	Service     string
+	Route       string
+	Budget      time.Duration
+	Description string
+}
+
+// LoadObjectives reads the ApiSLA table.
+func LoadObjectives(ctx context.Context, db *sql.DB) ([]Objective, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT method, path, sla_ms, COALESCE(description, ''), COALESCE(service, ''), COALESCE(route, '')
+		FROM ApiSLA ORDER BY path, method`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var objs []Objective
+	for rows.Next() {
+		var o Objective
+		var ms int64
+		if err := rows.Scan(&o.Method, &o.Path, &ms, &o.Description, &o.Service, &o.Route); err != nil {
+			return nil, err
+		}
+		o.Method = strings.ToUpper(o.Method)
+		o.Budget = time.Duration(ms) * time.Millisecond
+		objs = append(objs, o)
+	}
+	return objs, rows.Err()
+}
+
+// Scrape is a Gatherer that reads another service's /metrics endpoint.
+func Scrape(url string, client *http.Client) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s returned %d", url, resp.StatusCode)
+		}
+		var p expfmt.TextParser
+		fams, err := p.TextToMetricFamilies(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]*dto.MetricFamily, 0, len(fams))
+		for _, f := range fams {
+			out = append(out, f)
+		}
+		return out, nil
+	})
+}
+
+// ParseTargets reads "name=url,name=url" into scrape sources. An empty url
+// ("sale=") means this process's own default registry.
+func ParseTargets(spec string, client *http.Client) (map[string]prometheus.Gatherer, error) {
+	out := map[string]prometheus.Gatherer{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(part, "=")
+		name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("metrics target %q: want name=url", part)
+		}
+		if url == "" {
+			out[name] = prometheus.DefaultGatherer
+			continue
+		}
+		out[name] = Scrape(url, client)
+	}
+	return out, nil
+}
+
+type SLAOptions struct {
+	Logger    *zap.Logger
+	Sources   map[string]prometheus.Gatherer // where to read RED metrics from, by name
+	Windows   []time.Duration                // rolling windows, shortest first; default 5m and 1h
+	Objective float64                        // target share of good requests; default 0.99
+	Interval  time.Duration                  // how often Run scrapes; default 30s
+}
+
+// SLAEvaluator keeps a short history of RED counters and reports, per ApiSLA
+// route and window, the share of requests that were good (not a 5xx and within
+// the latency budget) and how fast the error budget is burning.
+type SLAEvaluator struct {
+	logger    *zap.Logger
+	sources   map[string]prometheus.Gatherer
+	windows   []time.Duration
+	objective float64
+	interval  time.Duration
+
+	mu         sync.Mutex
+	objectives []Objective
+	snapshots  []snapshot // oldest first
+}
+
+func NewSLAEvaluator(opts SLAOptions) *SLAEvaluator {
+	e := &SLAEvaluator{
+		logger:    opts.Logger,
+		sources:   opts.Sources,
+		windows:   append([]time.Duration(nil), opts.Windows...),
+		objective: opts.Objective,
+		interval:  opts.Interval,
+	}
+	if e.logger == nil {
+		e.logger = zap.NewNop()
+	}
+	if len(e.windows) == 0 {
+		e.windows = []time.Duration{5 * time.Minute, time.Hour}
+	}
+	sort.Slice(e.windows, func(i, j int) bool { return e.windows[i] < e.windows[j] })
+	if e.objective <= 0 || e.objective >= 1 {
+		e.objective = 0.99
+	}
+	if e.interval <= 0 {
+		e.interval = 30 * time.Second
+	}
+	return e
+}
+
+// SetObjectives replaces the routes being evaluated.
+func (e *SLAEvaluator) SetObjectives(objs []Objective) {
+	e.mu.Lock()
+	e.objectives = objs
+	e.mu.Unlock()
+}
+
+// Run reloads ApiSLA every few minutes and collects every interval until ctx
+// is done. A failed reload keeps the previous objectives.
+func (e *SLAEvaluator) Run(ctx context.Context, db *sql.DB) {
+	const reloadEvery = 5 * time.Minute
+	var loaded time.Time
+	tick := time.NewTicker(e.interval)
+	defer tick.Stop()
+	for {
+		if time.Since(loaded) >= reloadEvery {
+			if objs, err := LoadObjectives(ctx, db); err != nil {
+				e.logger.Warn("failed to load ApiSLA", zap.Error(err))
+			} else {
+				e.SetObjectives(objs)
+				loaded = time.Now()
+			}
+		}
+		e.Collect(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// routeKey identifies one series set: service, method, route template.
+type routeKey struct{ service, method, route string }
+
+type counts struct {
+	requests float64
+	errors   float64
+	sum      float64            // seconds
+	buckets  map[float64]uint64 // cumulative, by upper bound in seconds
+}
+
+type snapshot struct {
+	at     time.Time
+	routes map[routeKey]*counts
+}
+
+// Collect gathers every source and records a snapshot taken at now. A source
+// that fails is logged and skipped; its routes report on older data until it
+// recovers.
+func (e *SLAEvaluator) Collect(now time.Time) {
+	snap := snapshot{at: now, routes: map[routeKey]*counts{}}
+	for name, g := range e.sources {
+		fams, err := g.Gather()
+		if err != nil {
+			e.logger.Warn("failed to gather metrics", zap.String("source", name), zap.Error(err))
+			continue
+		}
+		for _, f := range fams {
+			switch f.GetName() {
+			case requestsName:
+				for _, m := range f.GetMetric() {
+					c := snap.get(m)
+					v := m.GetCounter().GetValue()
+					c.requests += v
+					if code, _ := strconv.Atoi(label(m, "code")); code >= 500 {
+						c.errors += v
+					}
+				}
+			case durationName:
+				for _, m := range f.GetMetric() {
+					c := snap.get(m)
+					h := m.GetHistogram()
+					c.sum += h.GetSampleSum()
+					for _, b := range h.GetBucket() {
+						c.buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+					}
+				}
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.snapshots = append(e.snapshots, snap)
+	// Keep one snapshot older than the longest window as its baseline.
+	keep := now.Add(-e.windows[len(e.windows)-1])
+	for len(e.snapshots) > 2 && !e.snapshots[1].at.After(keep) {
+		e.snapshots = e.snapshots[1:]
+	}
+}
+
+func (s snapshot) get(m *dto.Metric) *counts {
+	k := routeKey{label(m, "service"), label(m, "method"), label(m, "route")}
+	c := s.routes[k]
+	if c == nil {
+		c = &counts{buckets: map[float64]uint64{}}
+		s.routes[k] = c
+	}
+	return c
+}
+
+func label(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// RouteReport is the evaluation of one ApiSLA route.
+type RouteReport struct {
+	Method   string         `json:"method"`
+	Path     string         `json:"path"`
+	Service  string         `json:"service,omitempty"`
+	BudgetMs int64          `json:"slaMs"`
+	Status   string         `json:"status"`
+	Windows  []WindowReport `json:"windows"`
+}
+
+// WindowReport covers one rolling window. Covered is shorter than the window
+// while the evaluator has not been running that long.
+type WindowReport struct {
+	Window     string  `json:"window"`
+	Covered    string  `json:"covered"`
+	Requests   float64 `json:"requests"`
+	Errors     float64 `json:"errors"`
+	Compliance float64 `json:"compliance"` // good / requests, 1 without traffic
+	AvgMs      float64 `json:"avgMs"`
+	BurnRate   float64 `json:"burnRate"` // (1 - compliance) / (1 - objective); 1 spends the budget exactly
+}
+
+// Report evaluates every objective as of the latest snapshot. A nil evaluator
+// reports nothing.
+func (e *SLAEvaluator) Report() []RouteReport {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	reports := make([]RouteReport, 0, len(e.objectives))
+	for _, o := range e.objectives {
+		r := RouteReport{Method: o.Method, Path: o.Path, Service: o.Service, BudgetMs: o.Budget.Milliseconds(), Status: StatusNoData}
+		if o.Service == "" || len(e.snapshots) == 0 {
+			reports = append(reports, r)
+			continue
+		}
+		k := routeKey{o.Service, o.Method, o.Route}
+		latest := e.snapshots[len(e.snapshots)-1]
+		burning := 0
+		for _, w := range e.windows {
+			base := e.baseline(latest.at.Add(-w))
+			wr := e.window(delta(latest.routes[k], base.routes[k]), o.Budget)
+			wr.Window = shortDuration(w)
+			wr.Covered = shortDuration(latest.at.Sub(base.at))
+			if wr.BurnRate > 1 {
+				burning++
+			}
+			r.Windows = append(r.Windows, wr)
+		}
+		longest := r.Windows[len(r.Windows)-1]
+		switch {
+		case longest.Requests == 0:
+			r.Status = StatusNoData
+		case longest.AvgMs > float64(r.BudgetMs) || burning == len(e.windows):
+			r.Status = StatusBreaching
+		case burning > 0:
+			r.Status = StatusAtRisk
+		default:
+			r.Status = StatusOK
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// baseline is the newest snapshot taken at or before t, else the oldest.
+func (e *SLAEvaluator) baseline(t time.Time) snapshot {
+	base := e.snapshots[0]
+	for _, s := range e.snapshots {
+		if s.at.After(t) {
+			break
+		}
+		base = s
+	}
+	return base
+}
+
+// delta is the change between two readings of the same series. A counter that
+// went backwards means the service restarted, so everything since is new.
+func delta(cur, prev *counts) counts {
+	if cur == nil {
+		return counts{}
+	}
+	if prev == nil || cur.requests < prev.requests {
+		return *cur
+	}
+	d := counts{
+		requests: cur.requests - prev.requests,
+		errors:   cur.errors - prev.errors,
+		sum:      cur.sum - prev.sum,
+		buckets:  map[float64]uint64{},
+	}
+	for le, n := range cur.buckets {
+		d.buckets[le] = n - prev.buckets[le]
+	}
+	return d
+}
+
+// window scores one window. A request is good when it is within budget and not
+// a 5xx. The histogram does not split by status, so errors are assumed to have
+// been within budget; this errs towards reporting too few good requests.
+func (e *SLAEvaluator) window(d counts, budget time.Duration) WindowReport {
+	wr := WindowReport{Requests: d.requests, Errors: d.errors, Compliance: 1}
+	if d.requests <= 0 {
+		return wr
+	}
+	within := withinBudget(d.buckets, budget.Seconds())
+	good := math.Max(0, math.Min(within, d.requests)-d.errors)
+	wr.Compliance = good / d.requests
+	wr.AvgMs = round1(d.sum / d.requests * 1000)
+	wr.BurnRate = round1((1 - wr.Compliance) / (1 - e.objective))
+	wr.Compliance = math.Round(wr.Compliance*10000) / 10000
+	return wr
+}
+
+// withinBudget counts observations in the largest bucket not above budget.
+// Buckets covers every ApiSLA budget, so this is normally exact.
+func withinBudget(buckets map[float64]uint64, budget float64) float64 {
+	best, n := -1.0, uint64(0)
+	for le, c := range buckets {
+		if le <= budget+1e-9 && le > best {
+			best, n = le, c
+		}
+	}
+	return float64(n)
+}
+
+func round1(v float64) float64 { return math.Round(v*10) / 10 }
+
+func shortDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	case d >= time.Minute && d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	}
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,115 @@
+// Command migrate applies the schema migrations in common/migrate to the
+// database in MYSQL_DSN (default: the compose MySQL on localhost).
+//
+//	go run ./migrate/cmd/migrate status
+//	go run ./migrate/cmd/migrate up
+//	go run ./migrate/cmd/migrate down [STEPS]   # default 1
+//	go run ./migrate/cmd/migrate force VERSION
+//
+// Services run the same migrations on start when MIGRATE_ON_START=true.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/mockten/mockten/common/migrate"
+	"go.uber.org/zap"
+)
+
+const defaultDSN = "mocktenusr:mocktenpassword@tcp(localhost:3306)/mocktendb?parseTime=true"
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		dsn = defaultDSN
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		fail(err)
+	}
+	defer db.Close()
+	logger, _ := zap.NewDevelopment()
+	m, err := migrate.New(db, migrate.Options{Logger: logger})
+	if err != nil {
+		fail(err)
+	}
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "status":
+		st, err := m.Status(ctx)
+		if err != nil {
+			fail(err)
+		}
+		for _, s := range st {
+			at := ""
+			if !s.AppliedAt.IsZero() {
+				at = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-40s %-9s %s\n", s.ID(), s.State, at)
+		}
+		if err := m.Check(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(3)
+		}
+	case "up":
+		done, err := m.Up(ctx)
+		report("applied", done)
+		if err != nil {
+			fail(err)
+		}
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			if steps, err = strconv.Atoi(os.Args[2]); err != nil || steps < 1 {
+				usage()
+			}
+		}
+		done, err := m.Down(ctx, steps)
+		report("reverted", done)
+		if err != nil {
+			fail(err)
+		}
+	case "force":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		version, err := strconv.Atoi(os.Args[2])
+		if err != nil || version < 0 {
+			usage()
+		}
+		if err := m.Force(ctx, version); err != nil {
+			fail(err)
+		}
+		fmt.Printf("schema marked at version %d\n", version)
+	default:
+		usage()
+	}
+}
+
+func report(verb string, done []migrate.Migration) {
+	if len(done) == 0 {
+		fmt.Printf("nothing %s\n", verb)
+	}
+	for _, m := range done {
+		fmt.Printf("%s %s\n", verb, m.ID())
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate status|up|down [STEPS]|force VERSION")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,485 @@
+// Package migrate versions the MySQL schema. Migrations are numbered SQL files
+// embedded from migrations/ (NNNN_name.up.sql, plus NNNN_name.down.sql when
+// the change can be reverted); 0001 is the baseline init.sql created.
+//
+// Applied versions are recorded in schema_version with a checksum of the up
+// script, so a migration edited after it ran is reported instead of silently
+// diverging. A run holds a MySQL named lock, so when several replicas start
+// at once one migrates and the others wait for it, then find nothing to do.
+//
+// MySQL commits DDL as it goes, so a migration that fails halfway is left
+// marked dirty; nothing runs until someone repairs the schema and clears the
+// mark with Force.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+// Files holds the embedded migrations.
+var Files, _ = fs.Sub(embedded, "migrations")
+
+// Migration is one schema change.
+type Migration struct {
+	Version    int
+	Name       string
+	Up         string
+	Down       string
+	Reversible bool   // a down script exists (it may be empty)
+	Checksum   string // hex SHA-256 of Up
+}
+
+// ID is the migration's file name stem, e.g. 0002_backfill_late_columns.
+func (m Migration) ID() string { return fmt.Sprintf("%04d_%s", m.Version, m.Name) }
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	downs := map[int]string{}
+	for _, name := range names {
+		match := fileName.FindStringSubmatch(path.Base(name))
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", name)
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "down" {
+			downs[version] = string(body)
+			continue
+		}
+		if prev, ok := byVersion[version]; ok {
+			return nil, fmt.Errorf("migration %s: version %d is already %s", name, version, prev.ID())
+		}
+		sum := sha256.Sum256(body)
+		byVersion[version] = &Migration{Version: version, Name: match[2], Up: string(body), Checksum: hex.EncodeToString(sum[:])}
+	}
+	for version, down := range downs {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d has a down script but no up script", version)
+		}
+		m.Down, m.Reversible = down, true
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type Options struct {
+	Logger      *zap.Logger
+	Files       fs.FS         // default the embedded migrations
+	LockTimeout time.Duration // how long to wait for another replica's run; default 5m
+}
+
+// Migrator applies migrations to one database.
+type Migrator struct {
+	db          *sql.DB
+	migrations  []Migration
+	logger      *zap.Logger
+	lockTimeout time.Duration
+}
+
+func New(db *sql.DB, opts Options) (*Migrator, error) {
+	files := opts.Files
+	if files == nil {
+		files = Files
+	}
+	migrations, err := Load(files)
+	if err != nil {
+		return nil, err
+	}
+	m := &Migrator{db: db, migrations: migrations, logger: opts.Logger, lockTimeout: opts.LockTimeout}
+	if m.logger == nil {
+		m.logger = zap.NewNop()
+	}
+	if m.lockTimeout <= 0 {
+		m.lockTimeout = 5 * time.Minute
+	}
+	return m, nil
+}
+
+// Ensure is what services call after connecting: with MIGRATE_ON_START=true
+// it applies pending migrations, otherwise it only checks that there are
+// none, so a service never runs against a schema older than it expects.
+func Ensure(ctx context.Context, db *sql.DB, logger *zap.Logger) error {
+	m, err := New(db, Options{Logger: logger})
+	if err != nil {
+		return err
+	}
+	if v, _ := strconv.ParseBool(os.Getenv("MIGRATE_ON_START")); v {
+		_, err = m.Up(ctx)
+		return err
+	}
+	return m.Check(ctx)
+}
+
+// State of a migration in Status.
+const (
+	StatePending  = "pending"
+	StateApplied  = "applied"
+	StateDirty    = "dirty"    // failed partway; see Force
+	StateModified = "modified" // the up script changed after it was applied
+	StateUnknown  = "unknown"  // applied, but not in this build (a newer one ran it)
+)
+
+// Status is one migration as the database sees it.
+type Status struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+func (s Status) ID() string { return fmt.Sprintf("%04d_%s", s.Version, s.Name) }
+
+type record struct {
+	name      string
+	checksum  string
+	dirty     bool
+	appliedAt time.Time
+}
+
+const createTable = `CREATE TABLE IF NOT EXISTS schema_version (
+  version     INT          NOT NULL PRIMARY KEY,
+  name        VARCHAR(255) NOT NULL,
+  checksum    CHAR(64)     NOT NULL,
+  dirty       TINYINT(1)   NOT NULL DEFAULT 0,
+  duration_ms INT          NOT NULL DEFAULT 0,
+  applied_at  DATETIME(3)  NOT NULL DEFAULT CURRENT_TIMESTAMP(3)
+)`
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (m *Migrator) records(ctx context.Context, q querier) (map[int]record, error) {
+	if _, err := q.ExecContext(ctx, createTable); err != nil {
+		return nil, fmt.Errorf("create schema_version: %w", err)
+	}
+	rows, err := q.QueryContext(ctx, `SELECT version, name, checksum, dirty, UNIX_TIMESTAMP(applied_at) FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("read schema_version: %w", err)
+	}
+	defer rows.Close()
+	out := map[int]record{}
+	for rows.Next() {
+		var (
+			version int
+			r       record
+			at      float64
+		)
+		if err := rows.Scan(&version, &r.name, &r.checksum, &r.dirty, &at); err != nil {
+			return nil, err
+		}
+		r.appliedAt = time.UnixMilli(int64(at * 1000)).UTC()
+		out[version] = r
+	}
+	return out, rows.Err()
+}
+
+func (m *Migrator) status(records map[int]record) []Status {
+	var out []Status
+	known := map[int]bool{}
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		s := Status{Version: mig.Version, Name: mig.Name, State: StatePending}
+		if r, ok := records[mig.Version]; ok {
+			s.AppliedAt = r.appliedAt
+			switch {
+			case r.dirty:
+				s.State = StateDirty
+			case r.checksum != mig.Checksum:
+				s.State = StateModified
+			default:
+				s.State = StateApplied
+			}
+		}
+		out = append(out, s)
+	}
+	for version, r := range records {
+		if !known[version] {
+			state := StateUnknown
+			if r.dirty {
+				state = StateDirty
+			}
+			out = append(out, Status{Version: version, Name: r.name, State: state, AppliedAt: r.appliedAt})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out
+}
+
+// Status lists every migration, known or recorded, by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := m.records(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(records), nil
+}
+
+// Check returns an error unless every migration has been applied cleanly.
+// Versions applied by a newer build are fine: they only add to the schema
+// this one knows.
+func (m *Migrator) Check(ctx context.Context) error {
+	st, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if err := blocked(st); err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range st {
+		if s.State == StatePending {
+			pending = append(pending, s.ID())
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("schema is behind: %d pending migrations (%s); run \"migrate up\" or set MIGRATE_ON_START=true",
+			len(pending), strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// blocked is the error that stops any run: a dirty or modified migration.
+func blocked(st []Status) error {
+	for _, s := range st {
+		switch s.State {
+		case StateDirty:
+			return fmt.Errorf("migration %s failed partway; repair the schema by hand, then run \"migrate force %d\" (or the version before it)", s.ID(), s.Version)
+		case StateModified:
+			return fmt.Errorf("migration %s was changed after it was applied; restore it and add a new migration instead", s.ID())
+		}
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		if err := blocked(m.status(records)); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if _, ok := records[mig.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, mig, "up", mig.Up); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps applied migrations, newest first, and returns
+// them. It stops before a migration without a down script.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, records map[int]record) error {
+		if err := blocked(m.status(records)); err != nil {
+			return err
+		}
+		known := map[int]Migration{}
+		for _, mig := range m.migrations {
+			known[mig.Version] = mig
+		}
+		versions := make([]int, 0, len(records))
+		for v := range records {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		for _, v := range versions[:min(steps, len(versions))] {
+			mig, ok := known[v]
+			if !ok {
+				return fmt.Errorf("migration %04d_%s is not in this build; revert it with the build that applied it", v, records[v].name)
+			}
+			if !mig.Reversible {
+				return fmt.Errorf("migration %s cannot be reverted", mig.ID())
+			}
+			if err := m.run(ctx, conn, mig, "down", mig.Down); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Force records the schema as exactly at version: known migrations up to it
+// are marked applied and clean (with their current checksums), later ones
+// are forgotten. No SQL beyond schema_version runs. Use it after repairing a
+// failed migration by hand, or to adopt a database built some other way.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	return m.locked(ctx, func(conn *sql.Conn, _ map[int]record) error {
+		if _, err := conn.ExecContext(ctx, `DELETE FROM schema_version WHERE version > ?`, version); err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if mig.Version > version {
+				break
+			}
+			_, err := conn.ExecContext(ctx, `
+				INSERT INTO schema_version (version, name, checksum, dirty) VALUES (?, ?, ?, 0)
+				ON DUPLICATE KEY UPDATE name = VALUES(name), checksum = VALUES(checksum), dirty = 0`,
+				mig.Version, mig.Name, mig.Checksum)
+			if err != nil {
+				return err
+			}
+		}
+		m.logger.Warn("schema version forced", zap.Int("version", version))
+		return nil
+	})
+}
+
+// locked runs fn on one connection holding the migration lock. The lock is
+// named after the database, so migrating one schema never waits on another.
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int]record) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var got sql.NullInt64
+	err = conn.QueryRowContext(ctx, `SELECT GET_LOCK(CONCAT(DATABASE(), '.schema_version'), ?)`,
+		int(m.lockTimeout/time.Second)).Scan(&got)
+	if err != nil {
+		return fmt.Errorf("migration lock: %w", err)
+	}
+	if got.Int64 != 1 {
+		return fmt.Errorf("migration lock: another instance has been migrating for over %s", m.lockTimeout)
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.schema_version'))`)
+	}()
+
+	records, err := m.records(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, records)
+}
+
+// run executes one direction of a migration, keeping its schema_version row
+// dirty while the statements run.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, dir, script string) error {
+	start := time.Now()
+	m.logger.Info("running migration", zap.String("migration", mig.ID()), zap.String("direction", dir))
+	_, err := conn.ExecContext(ctx, `
+		INSERT INTO schema_version (version, name, checksum, dirty) VALUES (?, ?, ?, 1)
+		ON DUPLICATE KEY UPDATE dirty = 1`,
+		mig.Version, mig.Name, mig.Checksum)
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", mig.ID(), err)
+	}
+	for i, stmt := range Split(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %s %s, statement %d: %w", mig.ID(), dir, i+1, err)
+		}
+	}
+	if dir == "down" {
+		_, err = conn.ExecContext(ctx, `DELETE FROM schema_version WHERE version = ?`, mig.Version)
+	} else {
+		_, err = conn.ExecContext(ctx,
+			`UPDATE schema_version SET dirty = 0, duration_ms = ?, applied_at = CURRENT_TIMESTAMP(3) WHERE version = ?`,
+			time.Since(start).Milliseconds(), mig.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", mig.ID(), err)
+	}
+	m.logger.Info("migration done", zap.String("migration", mig.ID()), zap.String("direction", dir),
+		zap.Duration("took", time.Since(start)))
+	return nil
+}
+
+// Split breaks a script into statements on the semicolons outside quotes and
+// comments, dropping the comments. The driver runs one statement per call.
+func Split(script string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		b     = []byte(script)
+		n     = len(b)
+		flush = func() {
+			if s := strings.TrimSpace(cur.String()); s != "" {
+				out = append(out, s)
+			}
+			cur.Reset()
+		}
+	)
+	for i := 0; i < n; i++ {
+		c := b[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < n && b[j] != c {
+				if b[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			j = min(j, n-1)
+			cur.Write(b[i : j+1])
+			i = j
+		case c == '#' || (c == '-' && i+1 < n && b[i+1] == '-' && (i+2 == n || isSpace(b[i+2]))):
+			for i < n && b[i] != '\n' {
+				i++
+			}
+			cur.WriteByte('\n')
+		case c == '/' && i+1 < n && b[i+1] == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				i = n
+			} else {
+				i += end + 3
+			}
+			cur.WriteByte(' ')
+		case c == ';':
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return out
+}
+
+func isSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' }
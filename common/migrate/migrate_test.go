@@ -0,0 +1,96 @@
+package migrate
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSplit(t *testing.T) {
+	script := `-- header; not a statement
+CREATE TABLE a (
+  x VARCHAR(8) COMMENT 'one; two', -- trailing; comment
+  y INT
+);
+# hash comment;
+INSERT INTO ` + "`Order`" + ` VALUES ('it''s', "a\";b", 'c\';d'); /* block; */
+SELECT 1--1;`
+	want := []string{
+		"CREATE TABLE a (\n  x VARCHAR(8) COMMENT 'one; two', \n  y INT\n)",
+		"INSERT INTO `Order` VALUES ('it''s', \"a\\\";b\", 'c\\';d')",
+		"SELECT 1--1",
+	}
+	if got := Split(script); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Split =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migs, err := Load(fstest.MapFS{
+		"0002_add_b.up.sql":    {Data: []byte("ALTER TABLE t ADD b INT;")},
+		"0002_add_b.down.sql":  {Data: []byte("ALTER TABLE t DROP b;")},
+		"0001_baseline.up.sql": {Data: []byte("CREATE TABLE t (a INT);")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migs) != 2 || migs[0].ID() != "0001_baseline" || migs[1].ID() != "0002_add_b" {
+		t.Fatalf("loaded %+v", migs)
+	}
+	if migs[0].Reversible || !migs[1].Reversible || migs[1].Down != "ALTER TABLE t DROP b;" {
+		t.Fatalf("down scripts: %+v", migs)
+	}
+	if migs[0].Checksum == migs[1].Checksum || len(migs[0].Checksum) != 64 {
+		t.Fatalf("checksums %q %q", migs[0].Checksum, migs[1].Checksum)
+	}
+
+	for name, files := range map[string]fstest.MapFS{
+		"duplicate version": {"0001_a.up.sql": {}, "0001_b.up.sql": {}},
+		"bad name":          {"1-baseline.sql": {}},
+		"down without up":   {"0003_c.down.sql": {}},
+	} {
+		if _, err := Load(files); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migs, err := Load(Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migs {
+		if m.Version != i+1 {
+			t.Fatalf("migration %s: versions must run 1, 2, 3, ... without gaps", m.ID())
+		}
+	}
+}
+
+// The CREATE TABLEs in init.sql, which builds fresh databases with their seed
+// data, must stay exactly the baseline; later changes go in new migrations.
+func TestBaselineMatchesInitSQL(t *testing.T) {
+	initSQL, err := os.ReadFile("../../mysql/init.sql")
+	if err != nil {
+		t.Skip("mysql/init.sql not found:", err)
+	}
+	migs, err := Load(Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := func(script string) []string {
+		var out []string
+		for _, s := range Split(script) {
+			if strings.HasPrefix(s, "CREATE TABLE") {
+				out = append(out, strings.Join(strings.Fields(s), " "))
+			}
+		}
+		return out
+	}
+	got, want := tables(migs[0].Up), tables(string(initSQL))
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("0001_baseline has %d tables, init.sql %d; they differ", len(got), len(want))
+	}
+}
@@ -0,0 +1,281 @@
+-- Baseline: the schema as mysql/init.sql created it when migrations were
+-- introduced. Every statement is IF NOT EXISTS, so on a database init.sql
+-- already built this only records the version. There is no down migration.
+
+CREATE TABLE IF NOT EXISTS TimeSale (
+  id VARCHAR(36) PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  start_date DATETIME NOT NULL,
+  end_date DATETIME NOT NULL,
+  discount_rate DECIMAL(3,2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Seller (
+  seller_id VARCHAR(64) PRIMARY KEY,
+  seller_name VARCHAR(255),
+  description TEXT,
+  last_update DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- Seller API keys for programmatic access. Only the SHA-256 of the key is
+-- stored; key_prefix is the first few characters, shown so sellers can tell
+-- keys apart. scopes is a comma-separated subset of products:read,
+-- products:write, orders:read, stock:write.
+CREATE TABLE IF NOT EXISTS SellerApiKey (
+  key_id VARCHAR(36) PRIMARY KEY,
+  seller_id VARCHAR(64) NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  key_prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL,
+  scopes VARCHAR(255) NOT NULL,
+  rate_limit_per_min INT NOT NULL DEFAULT 60,
+  last_used_at DATETIME NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  revoked_at DATETIME NULL,
+  UNIQUE KEY uq_apikey_hash (key_hash),
+  INDEX idx_apikey_seller (seller_id)
+);
+
+CREATE TABLE IF NOT EXISTS AuditLog (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  action VARCHAR(128) NOT NULL,
+  actor VARCHAR(255) NOT NULL,
+  actor_type VARCHAR(16) NOT NULL DEFAULT 'system',
+  target VARCHAR(255),
+  status ENUM('success','failed','warning') NOT NULL DEFAULT 'success',
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_audit_created (created_at),
+  INDEX idx_audit_actor_type (actor_type)
+);
+
+CREATE TABLE IF NOT EXISTS Category (
+  category_id VARCHAR(3) PRIMARY KEY,
+  category_name VARCHAR(255),
+  category_image VARCHAR(255),
+  last_update DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Product (
+  product_id VARCHAR(36) PRIMARY KEY,
+  product_name VARCHAR(255),
+  seller_id VARCHAR(64),
+  price INT,
+  category_id VARCHAR(3),
+  summary TEXT,
+  product_condition ENUM('new', 'used') NOT NULL DEFAULT 'new',
+  geo_id VARCHAR(64),
+  avg_review DECIMAL(3,1) NOT NULL DEFAULT 0.0,
+  review_count INT NOT NULL DEFAULT 0,
+  regist_day DATETIME DEFAULT CURRENT_TIMESTAMP,
+  last_update DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  sale_flag TINYINT(1) NOT NULL DEFAULT 0,
+  sale_id VARCHAR(36) NULL,
+  -- is_active is the seller's own on/off switch: an inactive product stays in
+  -- their list and can be switched back on.
+  is_active TINYINT(1) NOT NULL DEFAULT 1,
+  -- deleted_at retires a product for good. Deleting the row outright would strip
+  -- it from the sync's SELECT, so the sync could never tell MeiliSearch to drop
+  -- it and the product stayed searchable (and 404'd when opened) forever. It
+  -- would also orphan Transaction rows, i.e. buyers' order history. Keeping the
+  -- row lets the existing is_active=0 path clean the index, and history resolves.
+  deleted_at DATETIME NULL,
+  KEY idx_product_category (category_id),
+  KEY idx_product_geo (geo_id),
+  KEY idx_product_last_update (last_update)
+);
+
+CREATE TABLE IF NOT EXISTS Stock (
+  product_id VARCHAR(36) PRIMARY KEY,
+  stocks INT,
+  last_update DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  KEY idx_stock_last_update (last_update)
+);
+
+CREATE TABLE IF NOT EXISTS BrowsingHistory (
+  id BIGINT AUTO_INCREMENT PRIMARY KEY,
+  user_id VARCHAR(255) NOT NULL,
+  product_id VARCHAR(36) NOT NULL,
+  viewed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_bh_user_viewed (user_id, viewed_at DESC),
+  INDEX idx_bh_product (product_id)
+);
+
+CREATE TABLE IF NOT EXISTS Wishlist (
+  user_id VARCHAR(36) PRIMARY KEY,
+  product_ids JSON NOT NULL,
+  updated DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS ShippingRate (
+  country_code CHAR(2),
+  shipping_type ENUM('standard', 'express') NOT NULL,
+  rate_per_10km FLOAT NOT NULL,
+  PRIMARY KEY (country_code, shipping_type)
+);
+
+CREATE TABLE IF NOT EXISTS DomesticAirCost (
+  origin VARCHAR(10),
+  destination VARCHAR(10),
+  cost_usd DECIMAL(10,2),
+  PRIMARY KEY (origin, destination)
+);
+
+CREATE TABLE IF NOT EXISTS SeaCost (
+  origin_country_code VARCHAR(10),
+  destination_country_code VARCHAR(10),
+  cost_usd DECIMAL(10,2),
+  PRIMARY KEY (origin_country_code, destination_country_code)
+);
+
+CREATE TABLE IF NOT EXISTS AirCost (
+  origin VARCHAR(10),
+  destination VARCHAR(10),
+  cost_usd DECIMAL(10,2),
+  PRIMARY KEY (origin, destination)
+);
+
+-- postal_code, town, building_name, room_number, latitude and longitude hold
+-- envelope-encrypted values (common/fieldcrypt, "ev1:..."), hence the width and
+-- the string type for coordinates. Seed rows start as plaintext; geocoding
+-- encrypts them in the background on startup. country_code, prefecture and city
+-- stay plaintext because queries filter and group on them.
+-- node_type marks logistics nodes ('airport', 'port') so shipping can find them
+-- without matching on the encrypted building_name.
+CREATE TABLE IF NOT EXISTS Geo (
+  geo_id VARCHAR(36) PRIMARY KEY,
+  user_id VARCHAR(255) NOT NULL,
+  country_code VARCHAR(2),
+  postal_code VARCHAR(512),
+  prefecture VARCHAR(50),
+  city VARCHAR(100),
+  town VARCHAR(512),
+  building_name VARCHAR(512),
+  room_number VARCHAR(512),
+  latitude VARCHAR(512),
+  longitude VARCHAR(512),
+  is_primary TINYINT(1) NOT NULL DEFAULT 0,
+  node_type VARCHAR(16) NULL,
+  KEY idx_geo_user_primary (user_id, is_primary),
+  KEY idx_geo_node (country_code, node_type)
+);
+
+CREATE TABLE IF NOT EXISTS `Order` (
+  order_id         VARCHAR(36) PRIMARY KEY,
+  user_id          VARCHAR(255) NOT NULL,
+  currency         CHAR(3)      NOT NULL DEFAULT 'USD',
+  subtotal_amount  DECIMAL(12,2) NOT NULL,
+  shipping_amount  DECIMAL(12,2) NOT NULL,
+  total_amount     DECIMAL(12,2) NOT NULL,
+  quantity         INT,
+  status           ENUM('created','paid','picking','shipped','delivered','canceled','refunded') NOT NULL DEFAULT 'created',
+  transactions_json JSON NOT NULL,
+  created_at       DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at       DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  KEY idx_order_status (status)
+);
+
+CREATE TABLE IF NOT EXISTS `Transaction` (
+  transaction_id VARCHAR(36) PRIMARY KEY,
+  product_id     VARCHAR(36) NOT NULL,
+  geo_id         VARCHAR(36) NOT NULL,
+  status         ENUM('quoted','booked','picked_up','in_transit','delayed','delivered','canceled','failed') NOT NULL DEFAULT 'quoted',
+  leg_type       ENUM('road','air','sea') NOT NULL DEFAULT 'road',
+  scheduled_start DATETIME NULL,
+  quantity       INT NOT NULL DEFAULT 1,
+  created_at     DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at     DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  KEY idx_tx_geo     (geo_id),
+  KEY idx_tx_product (product_id),
+  KEY idx_tx_legtype (leg_type),
+  KEY idx_tx_created (created_at DESC)
+);
+
+CREATE TABLE IF NOT EXISTS PaymentProfile (
+  user_id            VARCHAR(255) PRIMARY KEY,
+  stripe_customer_id VARCHAR(64)  NOT NULL,
+  created_at         DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at         DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS PaymentMethod (
+  payment_method_id        VARCHAR(36) PRIMARY KEY,
+  user_id                  VARCHAR(255) NOT NULL,
+  stripe_customer_id       VARCHAR(64)  NOT NULL,
+  stripe_payment_method_id VARCHAR(64)  NOT NULL,
+  brand                    VARCHAR(20)  NOT NULL,
+  last4                    CHAR(4)      NOT NULL,
+  exp_month                TINYINT      NOT NULL,
+  exp_year                 SMALLINT     NOT NULL,
+  is_default               TINYINT(1)   NOT NULL DEFAULT 0,
+  status                   ENUM('active','inactive') NOT NULL DEFAULT 'active',
+  created_at               DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at               DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_user_pm (user_id, stripe_payment_method_id),
+  KEY idx_user_default (user_id, is_default),
+  FOREIGN KEY (user_id) REFERENCES PaymentProfile(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS Payment (
+  payment_id        VARCHAR(36) PRIMARY KEY,
+  order_id_list     JSON NOT NULL,
+  payment_method_id VARCHAR(36) NULL,
+  amount            DECIMAL(12,2) NOT NULL,
+  currency          CHAR(3) NOT NULL,
+  status            ENUM('authorized','captured','failed','canceled','refunded') NOT NULL,
+  idempotency_key   VARCHAR(64),
+  stripe_payment_intent_id VARCHAR(64),
+  created_at        DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at        DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_payment_idem (idempotency_key)
+);
+
+CREATE TABLE IF NOT EXISTS Review (
+  review_id  VARCHAR(36) PRIMARY KEY,
+  product_id VARCHAR(36) NOT NULL,
+  user_id    VARCHAR(255) NOT NULL,
+  rating     TINYINT NOT NULL,
+  comment    TEXT,
+  status     ENUM('active','deleted','hidden') NOT NULL DEFAULT 'active',
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_review_product_user (product_id, user_id),
+  KEY idx_review_product_created (product_id, created_at),
+  CONSTRAINT chk_review_rating CHECK (rating BETWEEN 1 AND 5)
+);
+
+CREATE TABLE IF NOT EXISTS DashboardMetrics (
+  id INT AUTO_INCREMENT PRIMARY KEY,
+  ts VARCHAR(8) NOT NULL,
+  cpu DECIMAL(6,2) NOT NULL DEFAULT 0,
+  mem DECIMAL(6,2) NOT NULL DEFAULT 0,
+  mem_mb DECIMAL(8,1) NOT NULL DEFAULT 0,
+  mysql_conn INT NOT NULL DEFAULT 0,
+  redis_conn INT NOT NULL DEFAULT 0,
+  kong_total BIGINT NOT NULL DEFAULT 0,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_dm_created (created_at)
+);
+
+-- Transactional outbox (common/events): domain events written in the same
+-- transaction as the change they describe, published to Redis Streams by the
+-- services' relays.
+CREATE TABLE IF NOT EXISTS Outbox (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  event_type    VARCHAR(64)  NOT NULL,
+  aggregate_id  VARCHAR(255) NOT NULL,
+  payload       JSON         NOT NULL,
+  trace_context VARCHAR(255) NULL,
+  created_at    DATETIME(3)  NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  published_at  DATETIME(3)  NULL,
+  KEY idx_outbox_pending (published_at, id)
+);
+
+CREATE TABLE IF NOT EXISTS ApiSLA (
+  method      VARCHAR(10)  NOT NULL,
+  path        VARCHAR(255) NOT NULL,
+  sla_ms      INT          NOT NULL COMMENT 'Max acceptable avg response time in ms',
+  description VARCHAR(255),
+  service     VARCHAR(32)  NULL COMMENT 'Go service serving the route (RED metrics "service" label); NULL when not measured',
+  route       VARCHAR(255) NULL COMMENT 'Route template inside that service (RED metrics "route" label)',
+  PRIMARY KEY (method, path)
+);
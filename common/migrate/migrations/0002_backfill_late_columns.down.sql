@@ -0,0 +1,2 @@
+-- The columns are part of the baseline schema; reverting this migration
+-- leaves them in place.
@@ -0,0 +1,61 @@
+-- Columns that were added to init.sql after some databases had been created
+-- from it. CREATE TABLE IF NOT EXISTS in the baseline leaves such tables as
+-- they were, so add each column only where it is missing.
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+               WHERE table_schema = DATABASE() AND table_name = 'Product' AND column_name = 'deleted_at') = 0,
+  'ALTER TABLE Product ADD COLUMN deleted_at DATETIME NULL AFTER is_active',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+               WHERE table_schema = DATABASE() AND table_name = 'Transaction' AND column_name = 'scheduled_start') = 0,
+  'ALTER TABLE `Transaction` ADD COLUMN scheduled_start DATETIME NULL AFTER leg_type',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+               WHERE table_schema = DATABASE() AND table_name = 'ApiSLA' AND column_name = 'service') = 0,
+  'ALTER TABLE ApiSLA ADD COLUMN service VARCHAR(32) NULL, ADD COLUMN route VARCHAR(255) NULL',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Geo address fields and coordinates became envelope-encrypted strings
+-- (common/fieldcrypt), so older narrow or DECIMAL columns must widen before
+-- geocoding rewrites them. Coordinates convert to their decimal text.
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+               WHERE table_schema = DATABASE() AND table_name = 'Geo'
+                 AND column_name IN ('postal_code', 'town', 'building_name', 'room_number', 'latitude', 'longitude')
+                 AND (data_type <> 'varchar' OR character_maximum_length < 512)) > 0,
+  'ALTER TABLE Geo MODIFY postal_code VARCHAR(512), MODIFY town VARCHAR(512), MODIFY building_name VARCHAR(512), MODIFY room_number VARCHAR(512), MODIFY latitude VARCHAR(512), MODIFY longitude VARCHAR(512)',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.columns
+               WHERE table_schema = DATABASE() AND table_name = 'Geo' AND column_name = 'node_type') = 0,
+  'ALTER TABLE Geo ADD COLUMN node_type VARCHAR(16) NULL AFTER is_primary',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.statistics
+               WHERE table_schema = DATABASE() AND table_name = 'Geo' AND index_name = 'idx_geo_node') = 0,
+  'ALTER TABLE Geo ADD KEY idx_geo_node (country_code, node_type)',
+  'DO 0');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Tag logistics nodes as init.sql does. Rows whose building_name is already
+-- encrypted no longer match and keep node_type NULL.
+UPDATE Geo SET node_type = 'airport' WHERE node_type IS NULL AND building_name LIKE '%Airport%';
+UPDATE Geo SET node_type = 'port' WHERE node_type IS NULL AND (building_name LIKE '%Port%' OR building_name LIKE '%Terminal%');
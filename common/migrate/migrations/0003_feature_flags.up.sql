@@ -0,0 +1,16 @@
+-- Feature flags for common/flags. A flag is off unless enabled; when it is,
+-- users listed in target_users always get it, the role and country lists (if
+-- any) narrow who can, and rollout_percent picks a stable share of the rest.
+
+CREATE TABLE IF NOT EXISTS FeatureFlag (
+  flag_key          VARCHAR(64)      NOT NULL PRIMARY KEY,
+  description       VARCHAR(255)     NOT NULL DEFAULT '',
+  enabled           BOOLEAN          NOT NULL DEFAULT FALSE,
+  rollout_percent   TINYINT UNSIGNED NOT NULL DEFAULT 100,
+  target_users      JSON             NULL,
+  target_roles      JSON             NULL,
+  target_countries  JSON             NULL,
+  updated_by        VARCHAR(255)     NOT NULL DEFAULT '',
+  updated_at        DATETIME         NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  CHECK (rollout_percent <= 100)
+);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS ReviewReport;
+
+ALTER TABLE Review
+  DROP COLUMN moderated_at,
+  DROP COLUMN moderated_by;
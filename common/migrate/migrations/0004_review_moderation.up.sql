@@ -0,0 +1,23 @@
+-- Review moderation. Buyers report a review (one report per reviewer and
+-- review); admins work the open reports as a queue and hide, restore or delete
+-- the review. moderated_by / moderated_at mark a review taken down by an
+-- admin, which its author cannot bring back by posting again.
+
+CREATE TABLE IF NOT EXISTS ReviewReport (
+  report_id    BIGINT AUTO_INCREMENT PRIMARY KEY,
+  review_id    VARCHAR(36)  NOT NULL,
+  reporter_id  VARCHAR(255) NOT NULL,
+  reason       ENUM('spam','offensive','off_topic','fake','personal_info','other') NOT NULL,
+  details      VARCHAR(500) NOT NULL DEFAULT '',
+  status       ENUM('open','actioned','dismissed') NOT NULL DEFAULT 'open',
+  created_at   DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  resolved_at  DATETIME     NULL,
+  resolved_by  VARCHAR(255) NULL,
+  UNIQUE KEY uq_report_review_reporter (review_id, reporter_id),
+  KEY idx_report_status_created (status, created_at),
+  CONSTRAINT fk_report_review FOREIGN KEY (review_id) REFERENCES Review(review_id) ON DELETE CASCADE
+);
+
+ALTER TABLE Review
+  ADD COLUMN moderated_by VARCHAR(255) NULL AFTER status,
+  ADD COLUMN moderated_at DATETIME     NULL AFTER moderated_by;
@@ -0,0 +1,3 @@
+ALTER TABLE Review
+  DROP KEY idx_review_product_verified,
+  DROP COLUMN verified_purchase;
@@ -0,0 +1,18 @@
+-- Review.verified_purchase marks reviews whose author bought the product
+-- (a Transaction on one of their Geo addresses, in a paid Order). Existing
+-- reviews are backfilled from the same history.
+ALTER TABLE Review
+  ADD COLUMN verified_purchase TINYINT(1) NOT NULL DEFAULT 0 AFTER comment,
+  ADD KEY idx_review_product_verified (product_id, verified_purchase, created_at);
+
+UPDATE Review r
+SET r.verified_purchase = 1
+WHERE EXISTS (
+  SELECT 1
+  FROM `Transaction` t
+  JOIN Geo g ON g.geo_id = t.geo_id
+  JOIN `Order` o ON JSON_CONTAINS(o.transactions_json, JSON_QUOTE(t.transaction_id))
+  WHERE g.user_id = r.user_id
+    AND t.product_id = r.product_id
+    AND o.status IN ('paid','picking','shipped','delivered')
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS ReviewHistogram;
+
+ALTER TABLE Review
+  DROP KEY idx_review_product_helpful,
+  DROP COLUMN unhelpful_count,
+  DROP COLUMN helpful_count;
+
+DROP TABLE IF EXISTS ReviewVote;
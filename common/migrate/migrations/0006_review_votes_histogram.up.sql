@@ -0,0 +1,34 @@
+-- Helpfulness votes, one per user and review. Review keeps the running
+-- counts so reviews sort by helpfulness without a join.
+CREATE TABLE IF NOT EXISTS ReviewVote (
+  review_id  VARCHAR(36)  NOT NULL,
+  user_id    VARCHAR(255) NOT NULL,
+  helpful    TINYINT(1)   NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (review_id, user_id),
+  CONSTRAINT fk_review_vote_review FOREIGN KEY (review_id) REFERENCES Review(review_id) ON DELETE CASCADE
+);
+
+ALTER TABLE Review
+  ADD COLUMN helpful_count   INT NOT NULL DEFAULT 0 AFTER verified_purchase,
+  ADD COLUMN unhelpful_count INT NOT NULL DEFAULT 0 AFTER helpful_count,
+  ADD KEY idx_review_product_helpful (product_id, helpful_count);
+
+-- Star distribution of each product's active reviews, kept in step with
+-- Product.avg_review / review_count by the same transactions.
+CREATE TABLE IF NOT EXISTS ReviewHistogram (
+  product_id VARCHAR(36) PRIMARY KEY,
+  stars_1 INT NOT NULL DEFAULT 0,
+  stars_2 INT NOT NULL DEFAULT 0,
+  stars_3 INT NOT NULL DEFAULT 0,
+  stars_4 INT NOT NULL DEFAULT 0,
+  stars_5 INT NOT NULL DEFAULT 0
+);
+
+INSERT INTO ReviewHistogram (product_id, stars_1, stars_2, stars_3, stars_4, stars_5)
+SELECT product_id,
+       SUM(rating = 1), SUM(rating = 2), SUM(rating = 3), SUM(rating = 4), SUM(rating = 5)
+FROM Review
+WHERE status = 'active'
+GROUP BY product_id;
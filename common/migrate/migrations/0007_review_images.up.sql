@@ -0,0 +1,13 @@
+-- Photos attached to a review. The objects live in MinIO's review-photos
+-- bucket under "<review_id>/"; a row per object keeps their order and lets
+-- review lists build URLs without listing the bucket.
+CREATE TABLE IF NOT EXISTS ReviewImage (
+  image_id     VARCHAR(36)  PRIMARY KEY,
+  review_id    VARCHAR(36)  NOT NULL,
+  object_key   VARCHAR(255) NOT NULL,
+  content_type VARCHAR(32)  NOT NULL,
+  size_bytes   INT          NOT NULL,
+  created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+  KEY idx_review_image_review (review_id, created_at),
+  CONSTRAINT fk_review_image_review FOREIGN KEY (review_id) REFERENCES Review(review_id) ON DELETE CASCADE
+);
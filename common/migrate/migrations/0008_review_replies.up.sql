@@ -0,0 +1,11 @@
+-- A seller's public reply to a review of one of their products: at most one
+-- per review, editable, gone with the review.
+CREATE TABLE IF NOT EXISTS ReviewReply (
+  review_id  VARCHAR(36)   PRIMARY KEY,
+  seller_id  VARCHAR(64)   NOT NULL,
+  body       VARCHAR(2000) NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  KEY idx_review_reply_seller (seller_id, updated_at),
+  CONSTRAINT fk_review_reply_review FOREIGN KEY (review_id) REFERENCES Review(review_id) ON DELETE CASCADE
+);
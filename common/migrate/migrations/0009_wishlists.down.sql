@@ -0,0 +1,23 @@
+-- Folds every list back into one array per user, oldest item first; names,
+-- notes, quantities and share links are lost.
+CREATE TABLE IF NOT EXISTS WishlistLegacy (
+  user_id VARCHAR(36) PRIMARY KEY,
+  product_ids JSON NOT NULL,
+  updated DATETIME
+);
+
+INSERT INTO WishlistLegacy (user_id, product_ids, updated)
+SELECT user_id, JSON_ARRAYAGG(product_id), MAX(added_at)
+FROM (
+  SELECT w.user_id, i.product_id, MIN(i.added_at) AS added_at
+  FROM Wishlist w
+  JOIN WishlistItem i ON i.wishlist_id = w.wishlist_id
+  GROUP BY w.user_id, i.product_id
+  ORDER BY MIN(i.added_at)
+) AS items
+GROUP BY user_id;
+
+DROP TABLE IF EXISTS WishlistItem;
+DROP TABLE IF EXISTS Wishlist;
+
+RENAME TABLE WishlistLegacy TO Wishlist;
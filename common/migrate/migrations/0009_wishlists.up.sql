@@ -0,0 +1,46 @@
+-- Wishlist was one JSON array of product ids per user. It becomes one row per
+-- named list, with the items in WishlistItem. Every user with a list gets a
+-- default one holding their old items; the array order is kept through
+-- added_at, one second apart, ending at the old row's updated time.
+RENAME TABLE Wishlist TO WishlistLegacy;
+
+CREATE TABLE IF NOT EXISTS Wishlist (
+  wishlist_id   VARCHAR(36)  PRIMARY KEY,
+  user_id       VARCHAR(255) NOT NULL,
+  name          VARCHAR(100) NOT NULL,
+  is_default    TINYINT(1)   NOT NULL DEFAULT 0,
+  -- user_id on the default list only, so a user has at most one.
+  default_owner VARCHAR(255) AS (IF(is_default, user_id, NULL)) STORED,
+  share_token   VARCHAR(64)  NULL,
+  created_at    DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at    DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_wishlist_user_name (user_id, name),
+  UNIQUE KEY uq_wishlist_default (default_owner),
+  UNIQUE KEY uq_wishlist_share_token (share_token)
+);
+
+CREATE TABLE IF NOT EXISTS WishlistItem (
+  wishlist_id      VARCHAR(36)  NOT NULL,
+  product_id       VARCHAR(36)  NOT NULL,
+  note             VARCHAR(500) NOT NULL DEFAULT '',
+  desired_quantity INT          NOT NULL DEFAULT 1,
+  added_at         DATETIME DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (wishlist_id, product_id),
+  KEY idx_wishlist_item_added (wishlist_id, added_at),
+  KEY idx_wishlist_item_product (product_id),
+  CONSTRAINT fk_wishlist_item_wishlist FOREIGN KEY (wishlist_id) REFERENCES Wishlist(wishlist_id) ON DELETE CASCADE
+);
+
+INSERT INTO Wishlist (wishlist_id, user_id, name, is_default, created_at, updated_at)
+SELECT UUID(), user_id, 'My Favorites', 1, COALESCE(updated, NOW()), COALESCE(updated, NOW())
+FROM WishlistLegacy;
+
+INSERT IGNORE INTO WishlistItem (wishlist_id, product_id, added_at)
+SELECT w.wishlist_id, jt.product_id,
+       COALESCE(l.updated, NOW()) - INTERVAL (JSON_LENGTH(l.product_ids) - jt.pos) SECOND
+FROM WishlistLegacy l
+JOIN Wishlist w ON w.default_owner = l.user_id
+CROSS JOIN JSON_TABLE(l.product_ids, '$[*]' COLUMNS (pos FOR ORDINALITY, product_id VARCHAR(36) PATH '$')) AS jt
+WHERE jt.product_id IS NOT NULL;
+
+DROP TABLE WishlistLegacy;
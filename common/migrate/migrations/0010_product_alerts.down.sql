@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS ProductAlert;
+DROP TABLE IF EXISTS ProductAlertSnapshot;
+
+ALTER TABLE WishlistItem DROP COLUMN added_price;
+
+DROP TABLE IF EXISTS AlertPreference;
+DROP TABLE IF EXISTS ProductSubscription;
@@ -0,0 +1,72 @@
+-- Explicit "notify me" subscriptions. The user is alerted when the price
+-- drops by price_drop_pct or more from reference_price (the price when they
+-- subscribed; NULL pct means no price alerts), and with back_in_stock when
+-- the product is restocked.
+CREATE TABLE IF NOT EXISTS ProductSubscription (
+  user_id         VARCHAR(255)  NOT NULL,
+  product_id      VARCHAR(36)   NOT NULL,
+  price_drop_pct  INT           NULL,
+  back_in_stock   TINYINT(1)    NOT NULL DEFAULT 1,
+  reference_price DECIMAL(12,2) NOT NULL,
+  created_at      DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, product_id),
+  KEY idx_product_subscription_product (product_id)
+);
+
+-- Alert settings for wishlisted items. Users without a row get the defaults;
+-- a NULL price_drop_pct is the service's ALERT_DEFAULT_DROP_PCT.
+CREATE TABLE IF NOT EXISTS AlertPreference (
+  user_id         VARCHAR(255) PRIMARY KEY,
+  wishlist_alerts TINYINT(1)   NOT NULL DEFAULT 1,
+  price_drop_pct  INT          NULL,
+  back_in_stock   TINYINT(1)   NOT NULL DEFAULT 1,
+  updated_at      DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- What a wishlisted item cost (time sale included) when it was added; price
+-- drops are measured from it. Existing items start from today's price.
+ALTER TABLE WishlistItem ADD COLUMN added_price DECIMAL(12,2) NULL AFTER desired_quantity;
+
+UPDATE WishlistItem i
+JOIN Product p ON p.product_id = i.product_id
+LEFT JOIN TimeSale ts ON ts.id = p.sale_id
+SET i.added_price = ROUND(COALESCE(p.price, 0) * (1 - IF(p.sale_flag = 1 AND NOW() BETWEEN ts.start_date AND ts.end_date, ts.discount_rate, 0)), 2);
+
+-- The price and stock the alert watcher last saw for each watched product.
+-- A change is measured against it, so a repeated event finds nothing new.
+CREATE TABLE IF NOT EXISTS ProductAlertSnapshot (
+  product_id VARCHAR(36)   PRIMARY KEY,
+  price      DECIMAL(12,2) NULL,
+  stock      INT           NULL,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+INSERT IGNORE INTO ProductAlertSnapshot (product_id, price, stock)
+SELECT p.product_id,
+       ROUND(COALESCE(p.price, 0) * (1 - IF(p.sale_flag = 1 AND NOW() BETWEEN ts.start_date AND ts.end_date, ts.discount_rate, 0)), 2),
+       COALESCE(s.stocks, 0)
+FROM Product p
+LEFT JOIN Stock s ON s.product_id = p.product_id
+LEFT JOIN TimeSale ts ON ts.id = p.sale_id
+WHERE p.product_id IN (SELECT product_id FROM WishlistItem);
+
+-- Alerts raised for users. dedupe_key makes a repeated evaluation a no-op:
+-- one price alert per user, product and price, one restock alert per user,
+-- product and day.
+CREATE TABLE IF NOT EXISTS ProductAlert (
+  alert_id   VARCHAR(36)   PRIMARY KEY,
+  user_id    VARCHAR(255)  NOT NULL,
+  product_id VARCHAR(36)   NOT NULL,
+  kind       ENUM('price_drop', 'back_in_stock') NOT NULL,
+  source     ENUM('wishlist', 'subscription')    NOT NULL,
+  old_price  DECIMAL(12,2) NOT NULL,
+  new_price  DECIMAL(12,2) NOT NULL,
+  stock      INT           NOT NULL,
+  dedupe_key VARCHAR(191)  NOT NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  read_at    DATETIME NULL,
+  UNIQUE KEY uq_product_alert_dedupe (dedupe_key),
+  KEY idx_product_alert_user (user_id, created_at),
+  KEY idx_product_alert_user_product (user_id, product_id, kind, created_at)
+);
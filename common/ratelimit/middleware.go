@@ -0,0 +1,188 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/apierr"
+	"github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	rejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_rejected_total",
+		Help: "Requests rejected with 429 by a rate-limit policy.",
+	}, []string{"policy"})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_errors_total",
+		Help: "Rate-limit checks that failed (Redis unavailable); the request was let through.",
+	}, []string{"policy"})
+)
+
+// KeyFunc returns the identity a request is counted against, or false when the
+// request does not carry that kind of identity.
+type KeyFunc func(c *gin.Context) (string, bool)
+
+// ByUser keys on the verified user id from common/auth.
+func ByUser(a *auth.Authenticator) KeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		if uid, ok := auth.GetUserID(c); ok {
+			return uid, true
+		}
+		uid, err := a.UserIDFromGinContext(c)
+		return uid, err == nil
+	}
+}
+
+// ByAPIKey keys on a seller API key once store has resolved it, using the
+// key's id so plaintext keys never end up in Redis. Unknown or revoked keys
+// (and lookup errors) do not count as an identity: otherwise a caller could
+// mint a fresh bucket per request by sending random "mk_" values.
+func ByAPIKey(store auth.APIKeyStore) KeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		plain, ok := auth.APIKeyFromHeader(c.Request.Header)
+		if !ok {
+			return "", false
+		}
+		k, err := auth.ResolveAPIKey(c.Request.Context(), store, plain)
+		if err != nil {
+			return "", false
+		}
+		return k.ID, true
+	}
+}
+
+// ByIP keys on the client address as resolved by Gin (trusted proxies apply).
+func ByIP() KeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		ip := c.ClientIP()
+		return ip, ip != ""
+	}
+}
+
+type Options struct {
+	Logger   *zap.Logger
+	Policies map[string]Policy
+	Keys     map[string]KeyFunc // identity name ("user", "apikey", "ip") -> resolver
+}
+
+type Middleware struct {
+	logger   *zap.Logger
+	limiter  Limiter
+	policies atomic.Pointer[map[string]Policy]
+	keys     map[string]KeyFunc
+}
+
+func New(l Limiter, opts Options) *Middleware {
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	keys := opts.Keys
+	if keys == nil {
+		keys = map[string]KeyFunc{"ip": ByIP()}
+	}
+	m := &Middleware{logger: logger, limiter: l, keys: keys}
+	m.SetPolicies(opts.Policies)
+	return m
+}
+
+// SetPolicies swaps the policies in use; handlers from For pick up the new
+// ones on their next request.
+func (m *Middleware) SetPolicies(p map[string]Policy) {
+	m.policies.Store(&p)
+}
+
+// Watch reloads the policies from path (usually RATE_LIMIT_CONFIG) whenever
+// the file changes, until ctx is done. A file that fails to load leaves the
+// current policies in place.
+func (m *Middleware) Watch(ctx context.Context, path string, defaults map[string]Policy) {
+	if path == "" {
+		return
+	}
+	config.WatchFile(ctx, path, 0, func() {
+		p, err := LoadPolicies(path, defaults)
+		if err != nil {
+			m.logger.Error("rate limit policies not reloaded", zap.String("file", path), zap.Error(err))
+			return
+		}
+		m.SetPolicies(p)
+		m.logger.Info("rate limit policies reloaded", zap.String("file", path), zap.Int("policies", len(p)))
+	})
+}
+
+// For returns a handler enforcing the named policy. Unknown or disabled
+// policies pass every request, so a limit can be switched off from config.
+// Redis failures also let the request through: a broken limiter must not take
+// the service down with it.
+func (m *Middleware) For(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := (*m.policies.Load())[name]
+		if !ok || p.Limit <= 0 {
+			c.Next()
+			return
+		}
+		kind, id, ok := m.identify(c, p)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		res, err := m.limiter.Allow(c.Request.Context(), name+":"+kind+":"+id, p)
+		if err != nil {
+			errorsTotal.WithLabelValues(name).Inc()
+			m.logger.Warn("rate limit check failed", zap.String("policy", name), zap.Error(err))
+			c.Next()
+			return
+		}
+		SetHeaders(c.Writer.Header(), p, res)
+		if !res.Allowed {
+			rejectedTotal.WithLabelValues(name).Inc()
+			apierr.Abort(c, apierr.RateLimited("rate limit exceeded"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func (m *Middleware) identify(c *gin.Context, p Policy) (string, string, bool) {
+	keyBy := p.KeyBy
+	if len(keyBy) == 0 {
+		keyBy = []string{"ip"}
+	}
+	for _, kind := range keyBy {
+		fn, ok := m.keys[kind]
+		if !ok {
+			continue
+		}
+		if id, ok := fn(c); ok && id != "" {
+			return kind, id, true
+		}
+	}
+	return "", "", false
+}
+
+// SetHeaders writes the IETF RateLimit-* fields, plus Retry-After on a
+// rejection. Exported for handlers that check a Limiter themselves.
+func SetHeaders(h http.Header, p Policy, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	h.Set("RateLimit-Policy", strconv.Itoa(p.Limit)+";w="+strconv.Itoa(ceilSeconds(p.Window)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
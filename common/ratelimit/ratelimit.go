@@ -0,0 +1,207 @@
+// Package ratelimit throttles requests with counters kept in Redis, so a limit
+// holds across every replica of a service.
+//
+//	lim := ratelimit.New(ratelimit.NewRedisLimiter(rdb), ratelimit.Options{
+//		Policies: policies, // usually from LoadPoliciesFromEnv
+//		Keys:     map[string]ratelimit.KeyFunc{"user": ratelimit.ByUser(authn), "ip": ratelimit.ByIP()},
+//	})
+//	r.POST("/v1/item/review", lim.For("review"), postReviewHandler)
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// TokenBucket allows bursts up to Limit and refills Limit tokens evenly
+	// over Window.
+	TokenBucket = "token_bucket"
+	// SlidingWindow allows at most Limit requests in any Window-long span.
+	SlidingWindow = "sliding_window"
+)
+
+// Policy is one named limit. KeyBy lists identities to key the counter on, in
+// order of preference ("user", "apikey", "ip"); the first one the request
+// carries is used.
+type Policy struct {
+	Algorithm string        `json:"algorithm"`
+	Limit     int           `json:"limit"`
+	Window    time.Duration `json:"-"`
+	KeyBy     []string      `json:"key_by"`
+
+	WindowSeconds int `json:"window_seconds"`
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the counter is fully replenished
+	RetryAfter time.Duration // set when !Allowed
+}
+
+type Limiter interface {
+	Allow(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// RedisLimiter runs each check as a single Lua script, so concurrent requests
+// on different replicas cannot both take the last slot.
+type RedisLimiter struct {
+	rdb    redis.Cmdable
+	prefix string
+	now    func() time.Time
+}
+
+func NewRedisLimiter(rdb redis.Cmdable) *RedisLimiter {
+	return &RedisLimiter{rdb: rdb, prefix: "rl:", now: time.Now}
+}
+
+// Both scripts return {allowed, remaining, retry_ms, reset_ms}.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local now = tonumber(ARGV[1])
+local rate = capacity / window
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil then
+  tokens = capacity
+  ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  retry = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[4])
+  redis.call('PEXPIRE', KEYS[1], window)
+  count = count + 1
+  allowed = 1
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local reset = 0
+if oldest[2] then
+  reset = tonumber(oldest[2]) + window - now
+end
+local retry = 0
+if allowed == 0 then
+  retry = reset
+end
+return {allowed, limit - count, retry, reset}
+`)
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, p Policy) (Result, error) {
+	now := l.now().UnixMilli()
+	window := p.Window.Milliseconds()
+	if window <= 0 || p.Limit <= 0 {
+		return Result{Allowed: true, Limit: p.Limit}, nil
+	}
+
+	var (
+		vals []int64
+		err  error
+	)
+	switch p.Algorithm {
+	case SlidingWindow:
+		// The member must be unique per request; the timestamp alone collides
+		// when two requests land in the same millisecond.
+		member := strconv.FormatInt(now, 10) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		vals, err = slidingWindowScript.Run(ctx, l.rdb, []string{l.prefix + key}, now, p.Limit, window, member).Int64Slice()
+	default:
+		vals, err = tokenBucketScript.Run(ctx, l.rdb, []string{l.prefix + key}, now, p.Limit, window).Int64Slice()
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected script result %v", vals)
+	}
+	return Result{
+		Allowed:    vals[0] == 1,
+		Limit:      p.Limit,
+		Remaining:  int(max(vals[1], 0)),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+		Reset:      time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
+
+// ---- config ----
+
+// LoadPolicies overlays the policies in a JSON file onto defaults:
+//
+//	{"policies": {"review": {"algorithm": "sliding_window", "limit": 5, "window_seconds": 60, "key_by": ["user", "ip"]}}}
+//
+// A policy with limit 0 turns that limit off. An empty path returns defaults.
+func LoadPolicies(path string, defaults map[string]Policy) (map[string]Policy, error) {
+	out := make(map[string]Policy, len(defaults))
+	for name, p := range defaults {
+		out[name] = p
+	}
+	if strings.TrimSpace(path) == "" {
+		return out, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Policies map[string]Policy `json:"policies"`
+	}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("ratelimit: parse %s: %w", path, err)
+	}
+	for name, p := range file.Policies {
+		def := out[name]
+		if p.WindowSeconds > 0 {
+			p.Window = time.Duration(p.WindowSeconds) * time.Second
+		} else {
+			p.Window = def.Window
+		}
+		if p.Algorithm == "" {
+			p.Algorithm = def.Algorithm
+		}
+		if p.Algorithm != "" && p.Algorithm != TokenBucket && p.Algorithm != SlidingWindow {
+			return nil, fmt.Errorf("ratelimit: policy %q: unknown algorithm %q", name, p.Algorithm)
+		}
+		if p.Limit > 0 && p.Window <= 0 {
+			return nil, fmt.Errorf("ratelimit: policy %q: window_seconds is required", name)
+		}
+		if len(p.KeyBy) == 0 {
+			p.KeyBy = def.KeyBy
+		}
+		out[name] = p
+	}
+	return out, nil
+}
+
+// LoadPoliciesFromEnv reads the file named by RATE_LIMIT_CONFIG, if any.
+func LoadPoliciesFromEnv(defaults map[string]Policy) (map[string]Policy, error) {
+	return LoadPolicies(os.Getenv("RATE_LIMIT_CONFIG"), defaults)
+}
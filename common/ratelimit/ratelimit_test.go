@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/auth"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLimiter(t *testing.T) (*RedisLimiter, *time.Time) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	l := NewRedisLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	now := time.Unix(1_700_000_000, 0)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterAlgorithms(t *testing.T) {
+	for _, algo := range []string{TokenBucket, SlidingWindow} {
+		t.Run(algo, func(t *testing.T) {
+			l, now := newTestLimiter(t)
+			p := Policy{Algorithm: algo, Limit: 3, Window: time.Minute}
+			ctx := context.Background()
+
+			for i := 0; i < 3; i++ {
+				res, err := l.Allow(ctx, "k", p)
+				if err != nil || !res.Allowed || res.Remaining != 2-i {
+					t.Fatalf("request %d: %+v, %v", i, res, err)
+				}
+			}
+			res, _ := l.Allow(ctx, "k", p)
+			if res.Allowed || res.RetryAfter <= 0 {
+				t.Fatalf("4th request: %+v, want rejected with RetryAfter", res)
+			}
+			if res, _ := l.Allow(ctx, "other", p); !res.Allowed {
+				t.Errorf("separate key was throttled")
+			}
+
+			*now = now.Add(time.Minute + time.Second)
+			if res, _ := l.Allow(ctx, "k", p); !res.Allowed {
+				t.Errorf("still throttled after the window: %+v", res)
+			}
+		})
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l, _ := newTestLimiter(t)
+	m := New(l, Options{Policies: map[string]Policy{
+		"search": {Algorithm: SlidingWindow, Limit: 1, Window: 10 * time.Second, KeyBy: []string{"ip"}},
+	}})
+	r := gin.New()
+	r.GET("/limited", m.For("search"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/open", m.For("not-configured"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	if w := get("/limited"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("RateLimit-Policy") != "1;w=10" {
+		t.Fatalf("first request: %d %v", w.Code, w.Header())
+	}
+	if w := get("/limited"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("second request: %d %v", w.Code, w.Header())
+	}
+	for i := 0; i < 3; i++ {
+		if w := get("/open"); w.Code != http.StatusOK {
+			t.Fatalf("unconfigured policy throttled: %d", w.Code)
+		}
+	}
+
+	// a reload that turns the limit off applies to routes already registered
+	m.SetPolicies(map[string]Policy{"search": {Limit: 0}})
+	if w := get("/limited"); w.Code != http.StatusOK {
+		t.Fatalf("after SetPolicies: %d", w.Code)
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	_ = os.WriteFile(path, []byte(`{"policies": {"review": {"limit": 10}, "search": {"limit": 0}}}`), 0o600)
+	defaults := map[string]Policy{
+		"review": {Algorithm: SlidingWindow, Limit: 5, Window: time.Minute, KeyBy: []string{"user", "ip"}},
+		"search": {Algorithm: TokenBucket, Limit: 60, Window: time.Minute},
+	}
+	got, err := LoadPolicies(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := got["review"]; r.Limit != 10 || r.Window != time.Minute || r.Algorithm != SlidingWindow || len(r.KeyBy) != 2 {
+		t.Errorf("review = %+v, want limit overridden and the rest inherited", r)
+	}
+	if got["search"].Limit != 0 {
+		t.Errorf("search not disabled: %+v", got["search"])
+	}
+
+	_ = os.WriteFile(path, []byte(`{"policies": {"x": {"limit": 1, "window_seconds": 1, "algorithm": "leaky"}}}`), 0o600)
+	if _, err := LoadPolicies(path, nil); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+}
+
+type keyStore map[string]*auth.APIKey
+
+func (s keyStore) LookupAPIKey(_ context.Context, hash string) (*auth.APIKey, error) {
+	return s[hash], nil
+}
+
+func TestByAPIKeyNeedsResolvedKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, _, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _ := newTestLimiter(t)
+	m := New(l, Options{
+		Policies: map[string]Policy{"search": {Algorithm: SlidingWindow, Limit: 1, Window: time.Minute, KeyBy: []string{"apikey", "ip"}}},
+		Keys:     map[string]KeyFunc{"apikey": ByAPIKey(keyStore{hash: {ID: "k1"}}), "ip": ByIP()},
+	})
+	r := gin.New()
+	r.GET("/search", m.For("search"), func(c *gin.Context) { c.Status(http.StatusOK) })
+	get := func(apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// made-up keys share the caller's IP bucket instead of getting one each
+	if got := get("mk_forged1"); got != http.StatusOK {
+		t.Fatalf("first forged key: %d", got)
+	}
+	if got := get("mk_forged2"); got != http.StatusTooManyRequests {
+		t.Fatalf("second forged key: %d, want 429", got)
+	}
+	// a real key has its own bucket
+	if got := get(key); got != http.StatusOK {
+		t.Fatalf("resolved key: %d", got)
+	}
+	if got := get(key); got != http.StatusTooManyRequests {
+		t.Fatalf("resolved key again: %d, want 429", got)
+	}
+}
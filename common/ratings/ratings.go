@@ -0,0 +1,23 @@
+// Package ratings is the arithmetic behind Product.avg_review and
+// review_count, shared so product (reviews) and sale (moderation) move the
+// aggregates the same way.
+package ratings
+
+import "math"
+
+// Round rounds an average to the one decimal avg_review (DECIMAL(3,1)) keeps.
+func Round(avg float64) float64 {
+	return math.Round(avg*10) / 10
+}
+
+// After is avg / cnt once one review of the given stars is counted (delta 1)
+// or dropped (delta -1): the average is rounded by Round, and is 0 once no
+// review is left.
+func After(avg float64, cnt, stars, delta int) (float64, int) {
+	n := cnt + delta
+	if n <= 0 {
+		return 0, 0
+	}
+	sum := avg*float64(cnt) + float64(delta*stars)
+	return Round(sum / float64(n)), n
+}
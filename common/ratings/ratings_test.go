@@ -0,0 +1,34 @@
+package ratings
+
+import "testing"
+
+func TestAfter(t *testing.T) {
+	for _, tc := range []struct {
+		avg               float64
+		cnt, stars, delta int
+		wantAvg           float64
+		wantCnt           int
+	}{
+		{4.0, 2, 5, -1, 3.0, 1}, // hide a 5 from {3,5}
+		{3.0, 1, 5, 1, 4.0, 2},  // restore it
+		{4.0, 3, 2, -1, 5.0, 2}, // author deletes a 2 from {2,5,5}
+		{4.0, 1, 4, -1, 0, 0},   // last review gone
+		{0, 0, 3, -1, 0, 0},     // nothing to drop
+		{4.0, 2, 3, 1, 3.7, 3},  // rounded to one decimal
+		{0, 0, 3, 1, 3.0, 1},    // first review back
+	} {
+		avg, cnt := After(tc.avg, tc.cnt, tc.stars, tc.delta)
+		if avg != tc.wantAvg || cnt != tc.wantCnt {
+			t.Errorf("After(%v, %d, %d, %d) = (%v, %d), want (%v, %d)",
+				tc.avg, tc.cnt, tc.stars, tc.delta, avg, cnt, tc.wantAvg, tc.wantCnt)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	for in, want := range map[float64]float64{3.66: 3.7, 3.64: 3.6, 4.25: 4.3, 0: 0} {
+		if got := Round(in); got != want {
+			t.Errorf("Round(%v) = %v, want %v", in, got, want)
+		}
+	}
+}
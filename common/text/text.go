@@ -0,0 +1,15 @@
+// Package text holds small string helpers shared by the services.
+package text
+
+// TruncateRunes cuts s to at most n characters, matching how MySQL counts a
+// VARCHAR(n), without splitting a multi-byte character.
+func TruncateRunes(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
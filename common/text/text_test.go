@@ -0,0 +1,32 @@
+package text
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	cases := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"GET /v1/cart", 128, "GET /v1/cart"},
+		{"abcdef", 3, "abc"},
+		{"日本語のパス", 3, "日本語"},
+		{"", 3, ""},
+	}
+	for _, c := range cases {
+		if got := TruncateRunes(c.in, c.n); got != c.want {
+			t.Errorf("TruncateRunes(%q, %d) = %q, want %q", c.in, c.n, got, c.want)
+		}
+	}
+
+	// a Japanese moderation reason must not be cut in the middle of a character
+	reason := "Review Hidden (" + strings.Repeat("不適切", 50) + ")"
+	got := TruncateRunes(reason, 128)
+	if !utf8.ValidString(got) || utf8.RuneCountInString(got) != 128 {
+		t.Errorf("TruncateRunes = %q (%d runes)", got, utf8.RuneCountInString(got))
+	}
+}
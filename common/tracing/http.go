@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/logging"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// untraced are probe and scrape paths; tracing them only adds noise.
+func untraced(path string) bool {
+	switch path {
+	case "/metrics", "/health", "/healthz", "/livez", "/readyz":
+		return true
+	}
+	return false
+}
+
+// Gin is server middleware for Gin: it continues the caller's trace (W3C
+// headers) or starts one, and names spans after the route template.
+func Gin(service string) gin.HandlerFunc {
+	return otelgin.Middleware(service, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return !untraced(c.Request.URL.Path)
+	}))
+}
+
+// Handler is the net/http equivalent of Gin, for services on a plain ServeMux.
+// Spans are named "METHOD /path".
+func Handler(h http.Handler, service string) http.Handler {
+	return otelhttp.NewHandler(h, service,
+		otelhttp.WithFilter(func(r *http.Request) bool { return !untraced(r.URL.Path) }),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + strings.TrimRight(r.URL.Path, "/")
+		}),
+	)
+}
+
+// Transport wraps base (http.DefaultTransport when nil) so every request gets a
+// client span and carries the trace context and request id to the callee. Use
+// it for clients built by libraries, e.g. minio.Options.Transport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(logging.Transport(base))
+}
+
+// HTTPClient is an instrumented client for calls to other services. Build
+// requests with http.NewRequestWithContext so the span joins the caller's trace.
+func HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport(nil)}
+}
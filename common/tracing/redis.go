@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentRedis adds a client span per command (or pipeline) to a go-redis
+// v9 client. Like OpenDB, only commands inside a trace are recorded, and
+// command arguments are left out since keys and values can hold user data.
+// For go-redis v8 see the redisv8 subpackage.
+func InstrumentRedis(rdb *redis.Client) {
+	rdb.AddHook(redisHook{attrs: RedisAttributes(rdb.Options().Addr)})
+}
+
+// RedisAttributes are the span attributes for a Redis server at addr.
+func RedisAttributes(addr string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{semconv.DBSystemNameRedis}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		attrs = append(attrs, semconv.ServerAddress(host), attribute.String("server.port", port))
+	}
+	return attrs
+}
+
+// StartRedisSpan starts the span for one Redis operation, or returns ok=false
+// outside a trace.
+func StartRedisSpan(ctx context.Context, name string, attrs []attribute.KeyValue) (context.Context, trace.Span, bool) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, nil, false
+	}
+	ctx, span := tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, semconv.DBOperationName(name))...))
+	return ctx, span, true
+}
+
+type redisHook struct {
+	attrs []attribute.KeyValue
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span, ok := StartRedisSpan(ctx, cmd.FullName(), h.attrs)
+		if !ok {
+			return next(ctx, cmd)
+		}
+		err := next(ctx, cmd)
+		End(span, redisErr(err))
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span, ok := StartRedisSpan(ctx, "pipeline", h.attrs)
+		if !ok {
+			return next(ctx, cmds)
+		}
+		span.SetAttributes(attribute.Int("db.operation.batch.size", len(cmds)))
+		err := next(ctx, cmds)
+		End(span, redisErr(err))
+		return err
+	}
+}
+
+// redisErr drops redis.Nil, which is a cache miss rather than a failure.
+func redisErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+// Package redisv8 is tracing.InstrumentRedis for go-redis v8 clients
+// (ranking). It lives apart so v9 users do not compile against v8.
+package redisv8
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mockten/mockten/common/tracing"
+)
+
+// Instrument adds a client span per command (or pipeline) to rdb; see
+// tracing.InstrumentRedis.
+func Instrument(rdb *redis.Client) {
+	rdb.AddHook(hook{attrs: tracing.RedisAttributes(rdb.Options().Addr)})
+}
+
+// spanKey carries the span from Before* to After*. v8 hooks are split in two,
+// and a command outside a trace has no span of ours to end.
+type spanKey struct{}
+
+type hook struct {
+	attrs []attribute.KeyValue
+}
+
+func (h hook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return h.start(ctx, cmd.FullName(), 0), nil
+}
+
+func (h hook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	end(ctx, cmd.Err())
+	return nil
+}
+
+func (h hook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return h.start(ctx, "pipeline", len(cmds)), nil
+}
+
+func (h hook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if e := cmd.Err(); e != nil && !errors.Is(e, redis.Nil) {
+			err = e
+			break
+		}
+	}
+	end(ctx, err)
+	return nil
+}
+
+func (h hook) start(ctx context.Context, name string, batch int) context.Context {
+	ctx, span, ok := tracing.StartRedisSpan(ctx, name, h.attrs)
+	if !ok {
+		return ctx
+	}
+	if batch > 0 {
+		span.SetAttributes(attribute.Int("db.operation.batch.size", batch))
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+func end(ctx context.Context, err error) {
+	span, ok := ctx.Value(spanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if errors.Is(err, redis.Nil) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
@@ -0,0 +1,40 @@
+package redisv8
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/mockten/mockten/common/tracing"
+)
+
+func TestInstrument(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	Instrument(rdb)
+
+	rdb.ZIncrBy(context.Background(), "ranking", 1, "p1") // outside a trace
+	ctx, span := tracing.Start(context.Background(), "update ranking")
+	rdb.ZIncrBy(ctx, "ranking", 1, "p1")
+	rdb.Get(ctx, "missing")
+	tracing.End(span, nil)
+
+	spans := rec.Ended()
+	if len(spans) != 3 || spans[0].Name() != "zincrby" || spans[1].Name() != "get" || spans[2].Name() != "update ranking" {
+		t.Fatalf("spans = %v", spans)
+	}
+	if spans[1].Status().Description != "" {
+		t.Fatalf("cache miss recorded as error: %v", spans[1].Status())
+	}
+}
@@ -0,0 +1,39 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/XSAM/otelsql"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenDB is sql.Open with a span per query, exec and transaction. For sqlx,
+// wrap the result with sqlx.NewDb(db, driverName).
+//
+// Spans are only recorded inside an existing trace, so use the *Context
+// methods (QueryContext, ExecContext, ...) with the request context. Queries
+// without one, such as background loops and startup pings, stay untraced
+// rather than each starting a trace of its own.
+func OpenDB(driverName, dsn string) (*sql.DB, error) {
+	attrs := otelsql.AttributesFromDSN(dsn)
+	if driverName == "mysql" {
+		attrs = append(attrs, semconv.DBSystemNameMySQL)
+	}
+	return otelsql.Open(driverName, dsn,
+		otelsql.WithAttributes(attrs...),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitConnPrepare:      true,
+			OmitRows:             true,
+			DisableErrSkip:       true,
+			SpanFilter:           inTrace,
+		}),
+	)
+}
+
+func inTrace(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
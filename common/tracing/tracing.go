@@ -0,0 +1,195 @@
+// Package tracing sets up OpenTelemetry distributed tracing for the Go
+// services, so one request can be followed across cart, product, sale, ecpay,
+// ranking, searchitem, geocoding and shipment.
+//
+//	shutdown, err := tracing.Init(ctx, "ecpay")
+//	defer shutdown(context.Background())
+//	r.Use(tracing.Gin("ecpay"))
+//	db, err := tracing.OpenDB("mysql", dsn)
+//	client := tracing.HTTPClient(5 * time.Second)
+//
+// Trace context travels in W3C traceparent / tracestate (and baggage) headers.
+// Spans are exported over OTLP/HTTP, to stdout or to a file, chosen by
+// environment (see ConfigFromEnv). With no exporter configured the service
+// still propagates incoming trace context to its outbound calls, it just
+// records nothing itself.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const instrumentationName = "github.com/mockten/mockten/common/tracing"
+
+// Exporters accepted in Config.Exporter / OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout" // "console" is accepted as an alias
+	ExporterFile   = "file"
+)
+
+type Config struct {
+	ServiceName string
+	Exporter    string
+	// File is where ExporterFile appends spans, one JSON object per line.
+	File string
+}
+
+// ConfigFromEnv reads the standard OTEL_SERVICE_NAME (defaulting to service),
+// OTEL_TRACES_EXPORTER (otlp, stdout/console, file or none) and
+// OTEL_TRACES_FILE (default traces.jsonl). Without OTEL_TRACES_EXPORTER the
+// exporter is otlp when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, else none. The OTLP exporter and
+// the sampler (OTEL_TRACES_SAMPLER, OTEL_TRACES_SAMPLER_ARG) read their own
+// OTEL_* variables.
+func ConfigFromEnv(service string) Config {
+	cfg := Config{
+		ServiceName: strings.TrimSpace(os.Getenv("OTEL_SERVICE_NAME")),
+		Exporter:    strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER"))),
+		File:        strings.TrimSpace(os.Getenv("OTEL_TRACES_FILE")),
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = service
+	}
+	if cfg.Exporter == "" {
+		cfg.Exporter = ExporterNone
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+			cfg.Exporter = ExporterOTLP
+		}
+	}
+	if cfg.File == "" {
+		cfg.File = "traces.jsonl"
+	}
+	return cfg
+}
+
+// Init configures tracing from the environment; see Setup.
+func Init(ctx context.Context, service string) (func(context.Context) error, error) {
+	return Setup(ctx, ConfigFromEnv(service))
+}
+
+// Setup installs the W3C propagators and, unless the exporter is none, a
+// batching tracer provider as the otel globals. The returned function flushes
+// and stops the exporter; call it on shutdown.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	noop := func(context.Context) error { return nil }
+
+	var (
+		exp    sdktrace.SpanExporter
+		closer io.Closer
+		err    error
+	)
+	switch cfg.Exporter {
+	case ExporterNone, "":
+		return noop, nil
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(ctx)
+	case ExporterStdout, "console":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err == nil {
+			closer = f
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return noop, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return noop, fmt.Errorf("tracing: %s exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		// Only a schema URL conflict; the service name is what matters.
+		res = resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName))
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp, sdktrace.WithBatchTimeout(5*time.Second)),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins an internal span, for work worth seeing on its own in a trace
+// (a batch job, a cache rebuild). Callers end it with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err (if any) on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Call wraps a call to a backend whose client library has no hooks of its own
+// (MeiliSearch) in a client span named "<system> <operation>".
+func Call(ctx context.Context, system, operation string, fn func() error, attrs ...attribute.KeyValue) error {
+	attrs = append(attrs, attribute.String("peer.service", system), attribute.String("rpc.method", operation))
+	_, span := tracer().Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	err := fn()
+	End(span, err)
+	return err
+}
+
+// TraceID is the current trace id in hex, or "" outside a sampled trace. Put it
+// in log lines so logs and traces can be joined.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// ZapFields are trace_id / span_id fields for a zap log line, empty outside a
+// trace.
+func ZapFields(ctx context.Context) []zap.Field {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []zap.Field{zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String())}
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// record installs an in-memory tracer provider for the test.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return rec
+}
+
+func TestPropagationAcrossServices(t *testing.T) {
+	rec := record(t)
+	gin.SetMode(gin.TestMode)
+
+	var downstreamTrace string
+	ranking := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstreamTrace = TraceID(r.Context())
+	}), "ranking"))
+	defer ranking.Close()
+
+	var upstreamTrace string
+	r := gin.New()
+	r.Use(Gin("ecpay"))
+	r.POST("/v1/pay/:id", func(c *gin.Context) {
+		upstreamTrace = TraceID(c.Request.Context())
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, ranking.URL+"/api/ranking/update", nil)
+		resp, err := HTTPClient(0).Do(req)
+		if err != nil {
+			t.Errorf("downstream call: %v", err)
+			return
+		}
+		resp.Body.Close()
+	})
+	r.GET("/metrics", func(c *gin.Context) {})
+
+	// The caller (say, the gateway) already started the trace.
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodPost, "/v1/pay/42", nil)
+	req.Header.Set("traceparent", parent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if upstreamTrace != "4bf92f3577b34da6a3ce929d0e0e4736" || downstreamTrace != upstreamTrace {
+		t.Fatalf("trace ids: upstream %q, downstream %q", upstreamTrace, downstreamTrace)
+	}
+	var names []string
+	for _, s := range rec.Ended() {
+		names = append(names, s.Name())
+		if s.SpanContext().TraceID().String() != upstreamTrace {
+			t.Errorf("span %q in another trace", s.Name())
+		}
+	}
+	if len(names) != 3 || names[2] != "POST /v1/pay/:id" {
+		t.Fatalf("spans = %v, want downstream server, client, POST /v1/pay/:id (no /metrics)", names)
+	}
+}
+
+func TestRedisSpansOnlyInsideTrace(t *testing.T) {
+	rec := record(t)
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	InstrumentRedis(rdb)
+
+	rdb.Get(context.Background(), "cart:1") // no trace: not recorded
+	ctx, span := Start(context.Background(), "checkout")
+	rdb.Get(ctx, "cart:1") // miss, not an error
+	rdb.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, "a", 1, 0)
+		p.Incr(ctx, "b")
+		return nil
+	})
+	End(span, errors.New("payment declined"))
+
+	spans := rec.Ended()
+	if len(spans) != 3 || spans[0].Name() != "get" || spans[1].Name() != "pipeline" {
+		t.Fatalf("spans = %v", spans)
+	}
+	if spans[0].Status().Code == codes.Error {
+		t.Fatal("redis.Nil recorded as an error")
+	}
+	if spans[2].Status().Code != codes.Error || spans[0].Parent().SpanID() != spans[2].SpanContext().SpanID() {
+		t.Fatalf("checkout span: status %v, get parent %v", spans[2].Status(), spans[0].Parent())
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_TRACES_FILE", path)
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	shutdown, err := Init(context.Background(), "shipment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Call(context.Background(), "meilisearch", "search", func() error { return nil })
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(b), `"meilisearch search"`) || !strings.Contains(string(b), `"shipment"`) {
+		t.Fatalf("file contents = %s (%v)", b, err)
+	}
+
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Fatal("unknown exporter accepted")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_SERVICE_NAME", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	if cfg := ConfigFromEnv("cart"); cfg.Exporter != ExporterNone || cfg.ServiceName != "cart" {
+		t.Fatalf("defaults = %+v", cfg)
+	}
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://jaeger:4318")
+	if cfg := ConfigFromEnv("cart"); cfg.Exporter != ExporterOTLP {
+		t.Fatalf("with endpoint = %+v", cfg)
+	}
+}
@@ -24,6 +24,7 @@ import MyCartCheckout from './pages/MyCartCheckout';
 import MyCartConfirm from './pages/MyCartConfirm';
 import MyCartOrderComplete from './pages/MyCartOrderComplete';
 import MyFavoriteList from './pages/MyFavoriteList';
+import SharedFavoriteList from './pages/SharedFavoriteList';
 import OrderHistory from './pages/OrderHistory';
 import AdminCreateSeller from './pages/admin/AdminCreateSeller';
 import AdminUpdateSeller from './pages/admin/AdminUpdateSeller';
@@ -131,6 +132,7 @@ const App: React.FC = () => {
           <Route path="/cart/confirm" element={<PrivateRoute><MyCartConfirm /></PrivateRoute>} />
           <Route path="/cart/complete" element={<PrivateRoute><MyCartOrderComplete /></PrivateRoute>} />
           <Route path="/fav/list" element={<PrivateRoute><MyFavoriteList /></PrivateRoute>} />
+          <Route path="/fav/shared/:token" element={<SharedFavoriteList />} />
           <Route path="/order-history" element={<PrivateRoute><OrderHistory /></PrivateRoute>} />
 
           <Route path="/seller/login" element={<SellerLoginPage />} />
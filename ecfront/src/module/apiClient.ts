@@ -40,6 +40,33 @@ export const clearTokens = () => {
   REFRESH_TOKEN_KEYS.forEach(k => localStorage.removeItem(k));
 };
 
+// =================================================================
+// Error Envelope
+// =================================================================
+// Every Go service answers errors with this body (common/apierr).
+export type ApiErrorCode =
+  | 'not_found'
+  | 'conflict'
+  | 'validation'
+  | 'unauthorized'
+  | 'forbidden'
+  | 'method_not_allowed'
+  | 'rate_limited'
+  | 'dependency_unavailable'
+  | 'internal';
+
+export interface ApiError {
+  error: string;
+  code: ApiErrorCode;
+  fields?: { field: string; message: string }[];
+  requestId: string;
+}
+
+export const apiError = (error: unknown): ApiError | null => {
+  const data = (error as AxiosError<ApiError>)?.response?.data;
+  return data && typeof data.code === 'string' ? data : null;
+};
+
 // =================================================================
 // Axios Instance
 // =================================================================
@@ -89,7 +116,9 @@ apiClient.interceptors.response.use(
   async (error: AxiosError) => {
     const originalRequest = error.config as InternalAxiosRequestConfig & { _retry?: boolean };
 
-    if ((error.response?.status === 401 || error.response?.status === 400) && !originalRequest._retry) {
+    // A 400 from our own services is a validation error, not an expired token
+    const isValidation = apiError(error)?.code === 'validation';
+    if ((error.response?.status === 401 || (error.response?.status === 400 && !isValidation)) && !originalRequest._retry) {
       const refreshToken = getRefreshToken();
 
       if (!refreshToken) {
@@ -112,9 +112,9 @@ const DashboardNew: React.FC = () => {
   useEffect(() => {
     const fetchFavorites = async () => {
       try {
-        const res = await apiClient.get('/api/fav');
-        if (res.data && Array.isArray(res.data)) {
-          const favSet = new Set<string>(res.data.map((f: any) => String(f.productId)));
+        const res = await apiClient.get('/api/fav/ids');
+        if (res.data && Array.isArray(res.data.productIds)) {
+          const favSet = new Set<string>(res.data.productIds.map((id: any) => String(id)));
           setFavorites(favSet);
         }
       } catch (e) {
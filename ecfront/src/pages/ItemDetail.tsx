@@ -39,6 +39,8 @@ import {
   Flight,
   Favorite,
   FavoriteBorder,
+  NotificationsActive,
+  NotificationsNone,
 } from '@mui/icons-material';
 import Appbar from '../components/Appbar';
 import Footer from '../components/Footer';
@@ -50,6 +52,7 @@ interface Review {
   userName: string;
   rating: number;
   comment: string;
+  verifiedPurchase: boolean;
   date: string;
 }
 
@@ -79,6 +82,7 @@ interface ApiReview {
   userName?: string;
   rating: number;
   comment: string;
+  verifiedPurchase?: boolean;
   createdAt: string;
 }
 
@@ -202,6 +206,7 @@ const mapApiReviewsToUi = (apiReviews: ApiReview[] | undefined): Review[] => {
     userName: safeUserName(r.userName),
     rating: typeof r.rating === 'number' ? r.rating : 0,
     comment: r.comment || '',
+    verifiedPurchase: r.verifiedPurchase === true,
     date: r.createdAt || '',
   }));
 };
@@ -278,6 +283,8 @@ const ItemDetailNew: React.FC = () => {
 
   const [isFavorite, setIsFavorite] = useState(false);
   const [favoriteLoading, setFavoriteLoading] = useState(false);
+  const [isWatching, setIsWatching] = useState(false);
+  const [watchLoading, setWatchLoading] = useState(false);
 
   const reviewsPageSize = 20;
 
@@ -346,9 +353,9 @@ const ItemDetailNew: React.FC = () => {
   useEffect(() => {
     const checkFavorite = async () => {
       try {
-        const res = await apiClient.get('/api/fav');
-        if (res.data && Array.isArray(res.data)) {
-          const found = res.data.some((item: any) => item.productId === id);
+        const res = await apiClient.get('/api/fav/ids');
+        if (res.data && Array.isArray(res.data.productIds)) {
+          const found = res.data.productIds.includes(id);
           setIsFavorite(found);
         }
       } catch (e) {
@@ -360,6 +367,13 @@ const ItemDetailNew: React.FC = () => {
     }
   }, [id]);
 
+  useEffect(() => {
+    if (!id) return;
+    apiClient.get(`/api/item/watch/${id}`)
+      .then(() => setIsWatching(true))
+      .catch(() => setIsWatching(false));
+  }, [id]);
+
   useEffect(() => {
     const run = async () => {
       if (!productIdForImages) {
@@ -549,6 +563,32 @@ const ItemDetailNew: React.FC = () => {
     }
   };
 
+  // "Notify me": alert on a 10% price drop or a restock.
+  const handleToggleWatch = async () => {
+    if (!product?.product_id || watchLoading) return;
+    setWatchLoading(true);
+    try {
+      if (isWatching) {
+        await apiClient.delete(`/api/item/watch/${product.product_id}`);
+        setIsWatching(false);
+        setSnackbarMessage('You will no longer be notified about this item');
+      } else {
+        await apiClient.put(`/api/item/watch/${product.product_id}`, { priceDropPct: 10, backInStock: true });
+        setIsWatching(true);
+        setSnackbarMessage('We will notify you when the price drops or it is back in stock');
+      }
+      setSnackbarSeverity('success');
+      setSnackbarOpen(true);
+    } catch (err: any) {
+      console.error(err);
+      setSnackbarMessage('Failed to update notifications');
+      setSnackbarSeverity('error');
+      setSnackbarOpen(true);
+    } finally {
+      setWatchLoading(false);
+    }
+  };
+
   const [relatedProducts, setRelatedProducts] = useState<any[]>([]);
 
   useEffect(() => {
@@ -827,6 +867,9 @@ const ItemDetailNew: React.FC = () => {
                 <IconButton onClick={handleToggleFavorite} disabled={favoriteLoading} size="large" aria-label="Toggle Favorite">
                   {isFavorite ? <Favorite sx={{ color: 'red', fontSize: '32px' }} /> : <FavoriteBorder sx={{ fontSize: '32px' }} />}
                 </IconButton>
+                <IconButton onClick={handleToggleWatch} disabled={watchLoading} size="large" aria-label="Notify Me">
+                  {isWatching ? <NotificationsActive sx={{ color: '#1976d2', fontSize: '32px' }} /> : <NotificationsNone sx={{ fontSize: '32px' }} />}
+                </IconButton>
               </Box>
 
               <Box sx={{ marginBottom: '16px' }}>
@@ -1345,6 +1388,11 @@ const ItemDetailNew: React.FC = () => {
                         </Box>
                         <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '16px', color: 'black', fontWeight: 'bold', marginBottom: '8px' }}>
                           {review.userName}
+                          {review.verifiedPurchase && (
+                            <Box component="span" sx={{ marginLeft: '8px', fontSize: '13px', fontWeight: 'normal', color: '#2e7d32' }}>
+                              Verified Purchase
+                            </Box>
+                          )}
                         </Typography>
                         <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '16px', color: 'black', lineHeight: 1.8, textAlign: 'left' }}>
                           {review.comment}
@@ -1530,6 +1578,11 @@ const ItemDetailNew: React.FC = () => {
                         </Box>
                         <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '16px', color: 'black', fontWeight: 'bold', marginBottom: '8px' }}>
                           {review.userName}
+                          {review.verifiedPurchase && (
+                            <Box component="span" sx={{ marginLeft: '8px', fontSize: '13px', fontWeight: 'normal', color: '#2e7d32' }}>
+                              Verified Purchase
+                            </Box>
+                          )}
                         </Typography>
                         <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '16px', color: 'black', lineHeight: 1.8, textAlign: 'left' }}>
                           {review.comment}
@@ -18,7 +18,7 @@ import Appbar from '../components/Appbar';
 import Footer from '../components/Footer';
 import photoSvg from '../assets/photo.svg';
 // Import the apiClient we created in the module folder
-import apiClient from '../module/apiClient';
+import apiClient, { apiError } from '../module/apiClient';
 
 interface ApiItemDetailResponse {
   productId: string;
@@ -239,6 +239,36 @@ const ItemReview: React.FC = () => {
     }
   };
 
+  // Delete the caller's own review of this product (DELETE /api/item/review/:productId)
+  const handleDeleteReview = async () => {
+    setSubmitError('');
+    setSubmitOk('');
+
+    const productId = productData?.id || '';
+    if (!productId) {
+      setSubmitError('Missing product id.');
+      return;
+    }
+    if (!window.confirm('Delete your review of this product?')) {
+      return;
+    }
+
+    setSubmitting(true);
+    try {
+      await apiClient.delete(`/api/item/review/${encodeURIComponent(productId)}`);
+      navigate(`/item/${productId}`, {
+        state: { successMessage: 'Review deleted.' },
+      });
+    } catch (error: any) {
+      const apiErr = apiError(error);
+      setSubmitError(apiErr?.code === 'not_found'
+        ? 'You have not reviewed this product.'
+        : apiErr?.error || 'Failed to delete review.');
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
   const renderStars = (currentRating: number) => {
     const stars = [];
     for (let i = 1; i <= 5; i++) {
@@ -549,7 +579,28 @@ const ItemReview: React.FC = () => {
                 </Typography>
               )}
 
-              <Box sx={{ display: 'flex', justifyContent: 'center', marginTop: '16px', width: '960px' }}>
+              <Box sx={{ display: 'flex', justifyContent: 'center', gap: '16px', marginTop: '16px', width: '960px' }}>
+                <Button
+                  variant="outlined"
+                  onClick={handleDeleteReview}
+                  disabled={submitting}
+                  sx={{
+                    borderColor: '#d32f2f',
+                    color: '#d32f2f',
+                    padding: '16px 32px',
+                    borderRadius: '4px',
+                    fontFamily: 'Noto Sans',
+                    fontWeight: 'bold',
+                    fontSize: '16px',
+                    textTransform: 'none',
+                    '&:hover': {
+                      borderColor: '#b71c1c',
+                      backgroundColor: '#fdecea',
+                    },
+                  }}
+                >
+                  Delete my review
+                </Button>
                 <Button
                   variant="contained"
                   onClick={handleSubmitReview}
@@ -106,9 +106,9 @@ const CartListNew: React.FC = () => {
   };
 
   useEffect(() => {
-    apiClient.get('/api/fav').then(res => {
-      if (res.data && Array.isArray(res.data)) {
-        setFavorites(new Set(res.data.map((f: any) => String(f.productId))));
+    apiClient.get('/api/fav/ids').then(res => {
+      if (res.data && Array.isArray(res.data.productIds)) {
+        setFavorites(new Set(res.data.productIds.map((id: any) => String(id))));
       }
     }).catch(() => {});
   }, []);
@@ -12,9 +12,17 @@ import {
   Snackbar,
   Alert,
   Skeleton,
+  Pagination,
+  Dialog,
+  DialogTitle,
+  DialogContent,
+  DialogActions,
+  TextField,
 } from '@mui/material';
 import {
   Close,
+  Share,
+  Add,
 } from '@mui/icons-material';
 import Appbar from '../components/Appbar';
 import Footer from '../components/Footer';
@@ -37,8 +45,28 @@ interface FavoriteItem {
   selectedShippingLabel: string;
   discountRate?: number;
   saleFlag?: boolean;
+  note?: string;
+  desiredQuantity?: number;
 }
 
+interface WishlistSummary {
+  wishlistId: string;
+  name: string;
+  isDefault: boolean;
+  itemCount: number;
+  shareToken?: string;
+}
+
+const PAGE_SIZE = 10;
+
+const SORT_OPTIONS = [
+  { value: 'newest', label: 'Newest added' },
+  { value: 'oldest', label: 'Oldest added' },
+  { value: 'price_asc', label: 'Price: low to high' },
+  { value: 'price_desc', label: 'Price: high to low' },
+  { value: 'name', label: 'Name' },
+];
+
 
 interface ShippingInfo {
   sea_standard_fee?: number;
@@ -62,13 +90,43 @@ const FavoritesListNew: React.FC = () => {
   const [snackbarOpen, setSnackbarOpen] = useState(false);
   const [snackbarMessage, setSnackbarMessage] = useState('');
   const [snackbarSeverity, setSnackbarSeverity] = useState<'success' | 'error'>('success');
+  const [lists, setLists] = useState<WishlistSummary[]>([]);
+  const [selectedListId, setSelectedListId] = useState('');
+  const [currentListId, setCurrentListId] = useState('');
+  const [shareToken, setShareToken] = useState('');
+  const [sort, setSort] = useState('newest');
+  const [page, setPage] = useState(1);
+  const [total, setTotal] = useState(0);
+  const [newListOpen, setNewListOpen] = useState(false);
+  const [newListName, setNewListName] = useState('');
+
+  const notify = (message: string, severity: 'success' | 'error') => {
+    setSnackbarMessage(message);
+    setSnackbarSeverity(severity);
+    setSnackbarOpen(true);
+  };
+
+  const fetchLists = async () => {
+    try {
+      const res = await apiClient.get('/api/fav/lists');
+      setLists(Array.isArray(res.data?.wishlists) ? res.data.wishlists : []);
+    } catch (e) {
+      console.error('Failed to fetch wishlists', e);
+    }
+  };
 
   const fetchFavorites = async () => {
     setLoading(true);
     try {
-      const favRes = await apiClient.get('/api/fav');
-
-      if (!favRes.data || !Array.isArray(favRes.data)) {
+      const params: Record<string, string | number> = { sort, limit: PAGE_SIZE, offset: (page - 1) * PAGE_SIZE };
+      if (selectedListId) params.list = selectedListId;
+      const favRes = await apiClient.get('/api/fav', { params });
+
+      setCurrentListId(favRes.data?.wishlistId || '');
+      setShareToken(favRes.data?.shareToken || '');
+      setTotal(favRes.data?.total || 0);
+      const items = favRes.data?.items;
+      if (!Array.isArray(items)) {
         setFavoriteItems([]);
         setLoading(false);
         return;
@@ -76,7 +134,7 @@ const FavoritesListNew: React.FC = () => {
 
       // Render items immediately without waiting for cart
       const defaultShipping = [{ fee: 0, label: 'Standard Delivery', days: 3 }];
-      const initialItems: FavoriteItem[] = favRes.data.map((item: any) => {
+      const initialItems: FavoriteItem[] = items.map((item: any) => {
         const availableStocks = item.stocks || 0;
         return {
           id: item.productId,
@@ -93,6 +151,8 @@ const FavoritesListNew: React.FC = () => {
           selectedShippingLabel: defaultShipping[0].label,
           discountRate: item.discountRate || 0,
           saleFlag: item.saleFlag || false,
+          note: item.note || '',
+          desiredQuantity: item.quantity || 1,
         };
       });
       setFavoriteItems(initialItems);
@@ -114,7 +174,7 @@ const FavoritesListNew: React.FC = () => {
       }).catch(() => {});
 
       // Enrich with actual shipping options asynchronously
-      const enriched = await Promise.all(favRes.data.map(async (item: any, idx: number) => {
+      const enriched = await Promise.all(items.map(async (item: any, idx: number) => {
         let shippingOptions: { fee: number, label: string, days: number }[] = [];
         try {
           const shipRes = await apiClient.get<ShippingInfo>('/api/shipping', { params: { product_id: item.productId }});
@@ -143,13 +203,22 @@ const FavoritesListNew: React.FC = () => {
   };
 
   useEffect(() => {
-    fetchFavorites();
+    fetchLists();
   }, []);
 
+  useEffect(() => {
+    fetchFavorites();
+  }, [selectedListId, sort, page]);
+
+  // Removes the item from the list on screen only, not from the user's other lists.
+  const removeParams = () => (currentListId ? { params: { list: currentListId } } : undefined);
+
   const handleRemoveItem = async (itemId: string | number) => {
     try {
-      await apiClient.delete(`/api/fav/${itemId}`);
+      await apiClient.delete(`/api/fav/${itemId}`, removeParams());
       setFavoriteItems(prevItems => prevItems.filter(item => item.id !== itemId));
+      setTotal(prev => Math.max(0, prev - 1));
+      fetchLists();
     } catch (e) {
       console.error('Failed to remove favorite item', e);
     }
@@ -158,12 +227,61 @@ const FavoritesListNew: React.FC = () => {
   const handleRemoveAll = async () => {
     for (const item of favoriteItems) {
       try {
-        await apiClient.delete(`/api/fav/${item.id}`);
+        await apiClient.delete(`/api/fav/${item.id}`, removeParams());
       } catch (e) {
         console.error('Failed to remove favorite item', e);
       }
     }
     setFavoriteItems([]);
+    setPage(1);
+    fetchFavorites();
+    fetchLists();
+  };
+
+  const handleSelectList = (listId: string) => {
+    setSelectedListId(listId);
+    setPage(1);
+  };
+
+  const handleCreateList = async () => {
+    const name = newListName.trim();
+    if (!name) return;
+    try {
+      const res = await apiClient.post('/api/fav/lists', { name });
+      setNewListOpen(false);
+      setNewListName('');
+      await fetchLists();
+      handleSelectList(res.data.wishlistId);
+      notify(`Created "${name}"`, 'success');
+    } catch (err: any) {
+      notify(err?.response?.data?.error || 'Failed to create list', 'error');
+    }
+  };
+
+  const handleShare = async () => {
+    if (!currentListId) return;
+    try {
+      const res = await apiClient.post(`/api/fav/lists/${currentListId}/share`);
+      setShareToken(res.data.shareToken);
+      const url = `${window.location.origin}/fav/shared/${res.data.shareToken}`;
+      await navigator.clipboard?.writeText(url).catch(() => {});
+      notify('Share link copied to clipboard', 'success');
+      fetchLists();
+    } catch (e) {
+      notify('Failed to share list', 'error');
+    }
+  };
+
+  const handleStopSharing = async () => {
+    if (!currentListId) return;
+    try {
+      await apiClient.delete(`/api/fav/lists/${currentListId}/share`);
+      setShareToken('');
+      notify('Sharing turned off', 'success');
+      fetchLists();
+    } catch (e) {
+      notify('Failed to stop sharing', 'error');
+    }
   };
 
   const handleQuantityChange = (itemId: string | number, newQuantity: number) => {
@@ -295,6 +413,45 @@ const FavoritesListNew: React.FC = () => {
           </Typography>
         </Box>
 
+        {/* List, sort and sharing controls */}
+        <Box sx={{ display: 'flex', flexWrap: 'wrap', alignItems: 'center', gap: '12px', marginBottom: '24px' }}>
+          <Select
+            size="small"
+            value={selectedListId || (lists.find(l => l.isDefault)?.wishlistId ?? '')}
+            displayEmpty
+            onChange={(e) => handleSelectList(String(e.target.value))}
+            sx={{ minWidth: '200px' }}
+          >
+            {lists.length === 0 && <MenuItem value="">My Favorites</MenuItem>}
+            {lists.map(l => (
+              <MenuItem key={l.wishlistId} value={l.wishlistId}>
+                {l.name} ({l.itemCount})
+              </MenuItem>
+            ))}
+          </Select>
+          <Button startIcon={<Add />} onClick={() => setNewListOpen(true)} sx={{ textTransform: 'none', color: '#5C59E8' }}>
+            New List
+          </Button>
+          <Select size="small" value={sort} onChange={(e) => { setSort(String(e.target.value)); setPage(1); }} sx={{ minWidth: '180px' }}>
+            {SORT_OPTIONS.map(o => <MenuItem key={o.value} value={o.value}>{o.label}</MenuItem>)}
+          </Select>
+          <Box sx={{ flex: 1 }} />
+          {shareToken ? (
+            <>
+              <Button startIcon={<Share />} onClick={handleShare} sx={{ textTransform: 'none', color: '#5C59E8' }}>
+                Copy Share Link
+              </Button>
+              <Button onClick={handleStopSharing} sx={{ textTransform: 'none', color: '#666666' }}>
+                Stop Sharing
+              </Button>
+            </>
+          ) : (
+            <Button startIcon={<Share />} onClick={handleShare} disabled={!currentListId} sx={{ textTransform: 'none', color: '#5C59E8' }}>
+              Share
+            </Button>
+          )}
+        </Box>
+
         {/* Favorite Items */}
         <Box sx={{ marginBottom: '64px' }}>
           {loading ? (
@@ -401,6 +558,11 @@ const FavoritesListNew: React.FC = () => {
                     >
                       {item.description}
                     </Typography>
+                    {item.note && (
+                      <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '14px', color: '#8c8c8c', fontStyle: 'italic', marginBottom: '8px' }}>
+                        "{item.note}"{item.desiredQuantity && item.desiredQuantity > 1 ? ` · wants ${item.desiredQuantity}` : ''}
+                      </Typography>
+                    )}
                     {item.saleFlag && item.discountRate && item.discountRate > 0 ? (
                       <Box sx={{ display: 'flex', alignItems: 'center', gap: '8px', marginTop: '8px' }}>
                         <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '14px', color: 'red', textDecoration: 'line-through' }}>
@@ -508,6 +670,12 @@ const FavoritesListNew: React.FC = () => {
                 </Box>
               ))}
 
+              {total > PAGE_SIZE && (
+                <Box sx={{ display: 'flex', justifyContent: 'center' }}>
+                  <Pagination count={Math.ceil(total / PAGE_SIZE)} page={page} onChange={(_, p) => setPage(p)} />
+                </Box>
+              )}
+
               {/* Remove All Button */}
               <Box sx={{ display: 'flex', justifyContent: 'center', marginTop: '8px' }}>
                 <Button
@@ -663,6 +831,26 @@ const FavoritesListNew: React.FC = () => {
         */}
       </Container>
 
+      <Dialog open={newListOpen} onClose={() => setNewListOpen(false)}>
+        <DialogTitle>New wishlist</DialogTitle>
+        <DialogContent>
+          <TextField
+            autoFocus
+            fullWidth
+            margin="dense"
+            label="Name"
+            value={newListName}
+            inputProps={{ maxLength: 100 }}
+            onChange={(e) => setNewListName(e.target.value)}
+            onKeyDown={(e) => { if (e.key === 'Enter') handleCreateList(); }}
+          />
+        </DialogContent>
+        <DialogActions>
+          <Button onClick={() => setNewListOpen(false)}>Cancel</Button>
+          <Button onClick={handleCreateList} disabled={!newListName.trim()}>Create</Button>
+        </DialogActions>
+      </Dialog>
+
       {/* Snackbar for notifications */}
       <Snackbar
         open={snackbarOpen}
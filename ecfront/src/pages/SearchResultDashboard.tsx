@@ -420,10 +420,10 @@ const SearchResultNew: React.FC = () => {
   useEffect(() => {
     const fetchFavorites = async () => {
       try {
-        const res = await apiClient.get('/api/fav');
-        if (res.data && Array.isArray(res.data)) {
+        const res = await apiClient.get('/api/fav/ids');
+        if (res.data && Array.isArray(res.data.productIds)) {
           const favIds = new Set<string>();
-          res.data.forEach((item: any) => favIds.add(item.productId));
+          res.data.productIds.forEach((productId: string) => favIds.add(productId));
           setFavorites(favIds);
         }
       } catch (e) {
@@ -0,0 +1,153 @@
+import React, { useState, useEffect } from 'react';
+import { useNavigate, useParams } from 'react-router-dom';
+import apiClient from '../module/apiClient';
+import {
+  Box,
+  Container,
+  Typography,
+  Select,
+  MenuItem,
+  Pagination,
+  Skeleton,
+} from '@mui/material';
+import Footer from '../components/Footer';
+
+// Sample photo icon when a customer does not set prodct image.
+import photoSvg from "../assets/photo.svg";
+
+interface SharedItem {
+  productId: string;
+  productName: string;
+  summary: string;
+  price: number;
+  stocks: number;
+  saleFlag: boolean;
+  discountRate: number;
+  note: string;
+  quantity: number;
+}
+
+const PAGE_SIZE = 10;
+
+// Public, read-only view of a wishlist someone shared by link. No login needed.
+const SharedFavoriteList: React.FC = () => {
+  const { token } = useParams<{ token: string }>();
+  const navigate = useNavigate();
+  const [name, setName] = useState('');
+  const [items, setItems] = useState<SharedItem[]>([]);
+  const [total, setTotal] = useState(0);
+  const [sort, setSort] = useState('newest');
+  const [page, setPage] = useState(1);
+  const [loading, setLoading] = useState(true);
+  const [notFound, setNotFound] = useState(false);
+
+  useEffect(() => {
+    if (!token) return;
+    setLoading(true);
+    apiClient.get(`/api/fav/shared/${token}`, { params: { sort, limit: PAGE_SIZE, offset: (page - 1) * PAGE_SIZE } })
+      .then(res => {
+        setName(res.data.name);
+        setItems(Array.isArray(res.data.items) ? res.data.items : []);
+        setTotal(res.data.total || 0);
+        setNotFound(false);
+      })
+      .catch(() => setNotFound(true))
+      .finally(() => setLoading(false));
+  }, [token, sort, page]);
+
+  return (
+    <Box sx={{ width: '100vw', minHeight: '100vh', backgroundColor: 'white' }}>
+      <Container maxWidth="lg" sx={{ padding: '24px 16px' }}>
+        <Box sx={{ borderLeft: '5px solid black', paddingLeft: '20px', paddingY: '8px', marginBottom: '24px' }}>
+          <Typography sx={{ fontFamily: 'Noto Sans', fontWeight: 'bold', fontSize: '20px', color: 'black' }}>
+            {notFound ? 'Wishlist not found' : name || 'Shared Wishlist'}
+          </Typography>
+          {!notFound && (
+            <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '14px', color: '#8c8c8c' }}>
+              A wishlist shared with you · {total} item{total === 1 ? '' : 's'}
+            </Typography>
+          )}
+        </Box>
+
+        {notFound ? (
+          <Typography sx={{ fontFamily: 'Noto Sans', color: '#666666', marginBottom: '64px' }}>
+            This link is no longer shared, or never existed.
+          </Typography>
+        ) : (
+          <>
+            <Box sx={{ display: 'flex', justifyContent: 'flex-end', marginBottom: '16px' }}>
+              <Select size="small" value={sort} onChange={(e) => { setSort(String(e.target.value)); setPage(1); }}>
+                <MenuItem value="newest">Newest added</MenuItem>
+                <MenuItem value="oldest">Oldest added</MenuItem>
+                <MenuItem value="price_asc">Price: low to high</MenuItem>
+                <MenuItem value="price_desc">Price: high to low</MenuItem>
+                <MenuItem value="name">Name</MenuItem>
+              </Select>
+            </Box>
+
+            <Box sx={{ display: 'flex', flexDirection: 'column', gap: '24px', marginBottom: '64px' }}>
+              {loading ? (
+                [1, 2].map(i => <Skeleton key={i} variant="rectangular" height={120} sx={{ borderRadius: '8px' }} />)
+              ) : items.map(item => (
+                <Box key={item.productId} sx={{ display: 'flex', gap: '16px', padding: '16px', border: '1px solid #f0f0f0', borderRadius: '8px' }}>
+                  <Box sx={{ width: '100px', height: '100px', backgroundColor: '#f5f5f5', borderRadius: '8px', flexShrink: 0 }}>
+                    <img
+                      src={`/api/storage/${item.productId}.png`}
+                      alt={item.productName}
+                      style={{ width: '100%', height: '100%', objectFit: 'contain', padding: '8px' }}
+                      onError={(e) => { e.currentTarget.src = photoSvg; }}
+                    />
+                  </Box>
+                  <Box sx={{ flex: 1 }}>
+                    <Typography
+                      onClick={() => navigate(`/item/${item.productId}`)}
+                      sx={{ fontFamily: 'Noto Sans', fontWeight: 'bold', fontSize: '18px', cursor: 'pointer', '&:hover': { textDecoration: 'underline' } }}
+                    >
+                      {item.productName}
+                    </Typography>
+                    <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '14px', color: '#666666' }}>
+                      {item.summary}
+                    </Typography>
+                    {item.note && (
+                      <Typography sx={{ fontFamily: 'Noto Sans', fontSize: '14px', color: '#8c8c8c', fontStyle: 'italic' }}>
+                        "{item.note}"
+                      </Typography>
+                    )}
+                    <Box sx={{ display: 'flex', alignItems: 'center', gap: '12px', marginTop: '8px' }}>
+                      {item.saleFlag && item.discountRate > 0 ? (
+                        <>
+                          <Typography sx={{ fontSize: '14px', color: 'red', textDecoration: 'line-through' }}>
+                            ${item.price.toLocaleString()}
+                          </Typography>
+                          <Typography sx={{ fontWeight: 'bold', fontSize: '16px' }}>
+                            ${(item.price * (1 - item.discountRate)).toLocaleString(undefined, { minimumFractionDigits: 2, maximumFractionDigits: 2 })}
+                          </Typography>
+                        </>
+                      ) : (
+                        <Typography sx={{ fontWeight: 'bold', fontSize: '16px' }}>${item.price.toLocaleString()}</Typography>
+                      )}
+                      {item.quantity > 1 && (
+                        <Typography sx={{ fontSize: '14px', color: '#666666' }}>Wants {item.quantity}</Typography>
+                      )}
+                      {item.stocks === 0 && (
+                        <Typography sx={{ fontSize: '14px', color: '#c62828' }}>Out of stock</Typography>
+                      )}
+                    </Box>
+                  </Box>
+                </Box>
+              ))}
+              {total > PAGE_SIZE && (
+                <Box sx={{ display: 'flex', justifyContent: 'center' }}>
+                  <Pagination count={Math.ceil(total / PAGE_SIZE)} page={page} onChange={(_, p) => setPage(p)} />
+                </Box>
+              )}
+            </Box>
+          </>
+        )}
+      </Container>
+      <Footer />
+    </Box>
+  );
+};
+
+export default SharedFavoriteList;
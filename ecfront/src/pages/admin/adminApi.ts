@@ -403,9 +403,53 @@ export interface HealthComponent {
   detail: string;
 }
 
+export interface SlaWindow {
+  window: string;
+  covered: string;
+  requests: number;
+  errors: number;
+  compliance: number; // share of good requests, 0..1
+  avgMs: number;
+  burnRate: number;
+}
+
+export interface SlaRoute {
+  method: string;
+  path: string;
+  service?: string;
+  slaMs: number;
+  status: string; // ok | at risk | breaching | no data
+  windows: SlaWindow[] | null;
+}
+
+export interface ServiceCheck {
+  name: string;
+  status: string; // ok | down
+  critical: boolean;
+  durationMs: number;
+  error?: string;
+}
+
+export interface ServiceHealthSample {
+  at: string;
+  status: string;
+  failing?: string[];
+}
+
+export interface ServiceHealth {
+  service: string;
+  status: string; // ok | degraded | down | unknown
+  since: string;
+  uptime: number; // share of kept samples in which the service was ready, 0..1
+  latest: { checks: ServiceCheck[] | null; checkedAt: string; uptimeSeconds: number };
+  history: ServiceHealthSample[];
+}
+
 export interface HealthResponse {
   components: HealthComponent[];
   alerts: string[];
+  services: ServiceHealth[] | null;
+  sla: SlaRoute[] | null;
   metrics: { products: number; orders: number; outOfStock: number; dbPingMs: number };
 }
 
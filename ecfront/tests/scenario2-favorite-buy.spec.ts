@@ -69,11 +69,11 @@ test.describe('Scenario 2: Favorite and Buy Blueberry Jam', () => {
     await page.waitForURL('**/item/*');
     // Wait for product data to load
     await expect(page.getByRole('heading', { name: 'Blueberry Jam' })).toBeVisible({ timeout: 15000 });
-    // Wait for checkFavorite GET /api/fav to complete (ensures isFavorite state is accurate)
+    // Wait for checkFavorite GET /api/fav/ids to complete (ensures isFavorite state is accurate)
     const checkFavResp = await checkFavPromise;
-    const favData = await checkFavResp.json().catch(() => []);
+    const favData = await checkFavResp.json().catch(() => ({}));
     // If item is already favorited (e.g. from a previous test run), delete it first
-    if (favData.some((f: any) => f.productId === 'b91a5d68-6acb-48e7-8e5d-3d85b7e76af2')) {
+    if ((favData.productIds || []).includes('b91a5d68-6acb-48e7-8e5d-3d85b7e76af2')) {
       await Promise.all([
         page.getByRole('button', { name: 'Toggle Favorite' }).click(),
         page.waitForResponse(resp => resp.url().includes('/api/fav/') && resp.request().method() === 'DELETE', { timeout: 10000 }),
@@ -1,17 +1,14 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -19,30 +16,32 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql" // registers the "mysql" sql driver used by initDB
 	"github.com/google/uuid"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/events"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/httpclient"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/ratelimit"
+	"github.com/mockten/mockten/common/tracing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/balance"
 	"github.com/stripe/stripe-go/v74/customer"
 	"github.com/stripe/stripe-go/v74/paymentintent"
 	"github.com/stripe/stripe-go/v74/paymentmethod"
+	"go.uber.org/zap"
 )
 
 const (
-	logFilePath = "/var/log/apl/apl.log"
 	// MySQLHost is the DSN template used by initDB.
 	MySQLHost = "%v:%v@tcp(%v)/%v"
 )
 
-func init() {
-	loggingSettings(logFilePath)
-}
-
-// loggingSettings initializes logging to both stdout and a log file.
-func loggingSettings(logFile string) {
-	logfile, _ := os.OpenFile(filepath.Clean(logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	log.SetOutput(io.MultiWriter(os.Stdout, logfile))
-}
-
 // exportMetrics serves Prometheus metrics on :9100 (run in a goroutine).
 func exportMetrics() {
 	http.Handle("/metrics", promhttp.Handler())
@@ -51,7 +50,45 @@ func exportMetrics() {
 	}
 }
 
+// Config keeps the environment names ecpay has always used for MySQL and
+// Stripe; see common/config.
+type Config struct {
+	MySQL struct {
+		User     string        `json:"user" env:"MysqlUser" default:"mocktenusr" validate:"required"`
+		Password config.Secret `json:"password" env:"MysqlPassword" validate:"required"`
+		Host     string        `json:"host" env:"DbHost" default:"mysql-service.default.svc.cluster.local:3306" validate:"required,hostname_port"`
+		DB       string        `json:"db" env:"MysqlDB" default:"mocktendb" validate:"required"`
+	} `json:"mysql"`
+	Redis    config.Redis    `json:"redis"`
+	Keycloak config.Keycloak `json:"keycloak"`
+	// empty means mockStripeKey, which Stripe rejects
+	StripeKey       config.Secret `json:"stripe_key" env:"SecretKeyString"`
+	RateLimitConfig string        `json:"rate_limit_config" env:"RATE_LIMIT_CONFIG"`
+}
+
+func (c *Config) DSN() string {
+	return fmt.Sprintf(MySQLHost, c.MySQL.User, c.MySQL.Password.Value(), c.MySQL.Host, c.MySQL.DB)
+}
+
+var cfg *Config
+
 func main() {
+	// JSON on stdout; the std log calls below go through it too.
+	logger := logging.Init("ecpay", logging.Options{})
+	defer func() { _ = logger.Sync() }()
+
+	var err error
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		log.Fatalf("ecpay: %v", err)
+	}
+	log.Printf("ecpay: config %s", config.Dump(cfg))
+
+	shutdownTracing, err := tracing.Init(context.Background(), "ecpay")
+	if err != nil {
+		log.Fatalf("ecpay: tracing: %v", err)
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
 	// Expose Prometheus metrics, then serve the payment REST API (Gin, :8080).
 	go exportMetrics()
 	startHttpServer()
@@ -61,8 +98,7 @@ func main() {
 var ecpayDB *sql.DB
 
 func initDB() {
-	dsn := fmt.Sprintf(MySQLHost, os.Getenv("MysqlUser"), os.Getenv("MysqlPassword"), os.Getenv("DbHost"), os.Getenv("MysqlDB"))
-	db, err := sql.Open("mysql", dsn)
+	db, err := tracing.OpenDB("mysql", cfg.DSN())
 	if err != nil {
 		log.Fatalf("ecpay: db open error: %v", err)
 	}
@@ -79,6 +115,9 @@ func initDB() {
 	if err != nil {
 		log.Fatalf("ecpay: db ping failed: %v", err)
 	}
+	if err := migrate.Ensure(context.Background(), db, nil); err != nil {
+		log.Fatalf("ecpay: schema not ready: %v", err)
+	}
 	ecpayDB = db
 }
 
@@ -109,80 +148,174 @@ type UserContext struct {
 	Email  string
 }
 
-// Extract user details from Authorization Header token or fallback to mock
-func getUser(c *gin.Context) UserContext {
-	return parseUserFromAuthHeader(c.GetHeader("Authorization"))
+// mockUser acts for requests without an Authorization header, so local/test
+// flows still work.
+var mockUser = UserContext{UserID: "testuser@example.com", Email: "testuser@example.com"}
+
+// authn verifies Bearer JWTs against Keycloak and the shared token denylist.
+var authn *commonauth.Authenticator
+
+// getUser verifies the caller's Bearer JWT (signature, issuer, audience and
+// the denylist) and returns who they are. Without an Authorization header it
+// returns mockUser. Impersonation tokens are refused: support staff never pay
+// as a customer.
+func getUser(c *gin.Context) (UserContext, error) {
+	h := c.GetHeader("Authorization")
+	if h == "" {
+		return mockUser, nil
+	}
+	tok, ok := strings.CutPrefix(h, "Bearer ")
+	if !ok || strings.TrimSpace(tok) == "" {
+		return UserContext{}, errors.New("invalid Authorization header")
+	}
+	ctx := c.Request.Context()
+	claims, err := authn.ParseTokenCtx(ctx, strings.TrimSpace(tok))
+	if err != nil {
+		return UserContext{}, err
+	}
+	if _, imp := commonauth.ImpersonationFromClaims(claims); imp {
+		return UserContext{}, commonauth.ErrImpersonationNotAllowed
+	}
+	u, ok := userFromClaims(claims)
+	if !ok {
+		return UserContext{}, errors.New("no usable user identifier in token claims")
+	}
+	logging.SetUser(ctx, u.UserID)
+	return u, nil
+}
+
+// requireUser is getUser for handlers: it answers 401 and reports false when
+// the caller cannot be verified.
+func requireUser(c *gin.Context) (UserContext, bool) {
+	u, err := getUser(c)
+	if err != nil {
+		logging.From(c.Request.Context()).Warn("unauthorized request", zap.Error(err))
+		apierr.Abort(c, apierr.Unauthorized("unauthorized"))
+		return UserContext{}, false
+	}
+	return u, true
 }
 
-// parseUserFromAuthHeader derives the acting user from a Bearer JWT's claims
-// (email / preferred_username / sub) without verifying the signature — the API
-// gateway is responsible for authentication. It returns a mock testuser when the
-// header is missing or malformed so local/test flows still work.
-func parseUserFromAuthHeader(authHeader string) UserContext {
-	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-		token := authHeader[7:]
-		parts := strings.Split(token, ".")
-		if len(parts) == 3 {
-			// Base64 decode payload (parts[1])
-			payload, err := base64.RawURLEncoding.DecodeString(parts[1])
-			if err == nil {
-				var claims struct {
-					Email             string `json:"email"`
-					PreferredUsername string `json:"preferred_username"`
-					Sub               string `json:"sub"`
-				}
-				if json.Unmarshal(payload, &claims) == nil {
-					email := claims.Email
-					if email == "" {
-						email = claims.PreferredUsername + "@example.com"
-					}
-					userID := claims.Email
-					if userID == "" {
-						userID = claims.PreferredUsername
-					}
-					if userID == "" {
-						userID = claims.Sub
-					}
-					if userID != "" {
-						return UserContext{
-							UserID: userID,
-							Email:  email,
-						}
-					}
-				}
-			}
-		}
+// userFromClaims derives the acting user from verified claims: the user id is
+// email, else preferred_username, else sub; the email falls back to
+// preferred_username@example.com.
+func userFromClaims(claims map[string]any) (UserContext, bool) {
+	str := func(k string) string {
+		s, _ := claims[k].(string)
+		return strings.TrimSpace(s)
+	}
+	email, username, sub := str("email"), str("preferred_username"), str("sub")
+	u := UserContext{UserID: email, Email: email}
+	if u.Email == "" {
+		u.Email = username + "@example.com"
 	}
+	if u.UserID == "" {
+		u.UserID = username
+	}
+	if u.UserID == "" {
+		u.UserID = sub
+	}
+	return u, u.UserID != ""
+}
 
-	// Fallback mock if no valid auth header
-	return UserContext{
-		UserID: "testuser@example.com",
-		Email:  "testuser@example.com",
+// defaultRateLimits apply unless RATE_LIMIT_CONFIG overrides them. Each
+// payment attempt creates a Stripe PaymentIntent, so the budget is small.
+var defaultRateLimits = map[string]ratelimit.Policy{
+	"payment": {Algorithm: ratelimit.SlidingWindow, Limit: 10, Window: time.Minute, KeyBy: []string{"user", "ip"}},
+}
+
+// rateLimitUser keys payment limits on the verified caller. Unlike getUser it
+// does not fall back to the mock testuser, which would put every anonymous
+// caller in one shared bucket; those, and callers whose token fails
+// verification, are keyed by IP instead.
+func rateLimitUser(c *gin.Context) (string, bool) {
+	if c.GetHeader("Authorization") == "" {
+		return "", false
 	}
+	u, err := getUser(c)
+	return u.UserID, err == nil && u.UserID != ""
+}
+
+// newRedis connects to the Redis holding the rate limit counters.
+func newRedis() *redis.Client {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password.Value(),
+		DB:           cfg.Redis.DB,
+		PoolSize:     3,
+		MinIdleConns: 1,
+	})
+	tracing.InstrumentRedis(rdb)
+	return rdb
+}
+
+// newRateLimits builds the limits from RATE_LIMIT_CONFIG and keeps them in
+// step with the file.
+func newRateLimits(rdb *redis.Client) *ratelimit.Middleware {
+	policies, err := ratelimit.LoadPolicies(cfg.RateLimitConfig, defaultRateLimits)
+	if err != nil {
+		log.Fatalf("ecpay: rate limit config: %v", err)
+	}
+	m := ratelimit.New(ratelimit.NewRedisLimiter(rdb), ratelimit.Options{
+		Policies: policies,
+		Keys:     map[string]ratelimit.KeyFunc{"user": rateLimitUser, "ip": ratelimit.ByIP()},
+	})
+	go m.Watch(context.Background(), cfg.RateLimitConfig, defaultRateLimits)
+	return m
 }
 
 func startHttpServer() {
 	initDB()
+	rdb := newRedis()
+	limits := newRateLimits(rdb)
 
-	r := gin.Default()
+	logger := zap.L()
+	var err error
+	authn, err = commonauth.NewAuthenticatorFromEnv(commonauth.Options{
+		Logger:   logger,
+		JWKSURL:  cfg.Keycloak.JWKSURL(),
+		Denylist: commonauth.NewRedisDenylist(rdb),
+	})
+	if err != nil {
+		log.Fatalf("ecpay: authenticator: %v", err)
+	}
+	defer authn.Close()
+	go events.NewRelay(ecpayDB, rdb, events.RelayOptions{Logger: logger}).Run(context.Background())
+
+	r := gin.New()
+	r.Use(gin.Recovery(), tracing.Gin("ecpay"), logging.Gin(logger), metrics.Gin("ecpay"))
 
 	// CORS config
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	r.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	r.Use(cors.New(corsConfig))
 
-	stripe.Key = os.Getenv("SecretKeyString")
+	stripe.Key = cfg.StripeKey.Value()
 	if stripe.Key == "" {
-		// Mock config if not exists
-		stripe.Key = "sk_test_mock"
+		stripe.Key = mockStripeKey
 	}
+	// stripe-go retries on its own, with idempotency keys, so the client only
+	// adds a deadline, the breaker and per-target metrics.
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		HTTPClient: httpclient.New(httpclient.Options{Target: "stripe", Timeout: 30 * time.Second, Retries: -1}),
+	}))
 
 	r.POST("/api/payment-method", handleAddPaymentMethod)
 	r.GET("/api/payment-method", handleGetPaymentMethods)
 	r.PUT("/api/payment-method/default", handleSetDefaultPaymentMethod)
 	r.DELETE("/api/payment-method", handleDeletePaymentMethod)
-	r.POST("/api/payment", handleCreatePayment)
+	r.POST("/api/payment", limits.For("payment"), handleCreatePayment)
+
+	// Redis holds rate limit counters, which fail open, and the event streams,
+	// which the relay catches up on from the outbox; a Stripe outage only
+	// fails payments. None of them takes ecpay out of service.
+	health.New("ecpay").
+		Critical("mysql", health.SQL(ecpayDB)).
+		Optional("redis", health.Redis(rdb)).
+		Optional("stripe", health.Cached(checkStripe, 5*time.Minute)).
+		Optional("jwks", authn.CheckJWKS).
+		Gin(r)
 
 	log.Println("Starting Gin server on :8080")
 	if err := r.Run(":8080"); err != nil {
@@ -193,18 +326,21 @@ func startHttpServer() {
 func handleAddPaymentMethod(c *gin.Context) {
 	var req PaymentMethodRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
-	user := getUser(c)
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
 
 	db := ecpayDB
 	var err error
 
 	// 1. Get or create Customer
 	var stripeCustomerID string
-	err = db.QueryRow("SELECT stripe_customer_id FROM PaymentProfile WHERE user_id = ?", user.UserID).Scan(&stripeCustomerID)
+	err = db.QueryRowContext(c.Request.Context(), "SELECT stripe_customer_id FROM PaymentProfile WHERE user_id = ?", user.UserID).Scan(&stripeCustomerID)
 	
 	if err == sql.ErrNoRows {
 		// Create Customer in Stripe
@@ -213,18 +349,18 @@ func handleAddPaymentMethod(c *gin.Context) {
 		}
 		cus, err := customer.New(params)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create customer"})
+			apierr.Abort(c, stripeError("failed to create customer", err))
 			return
 		}
 		stripeCustomerID = cus.ID
 
-		_, err = db.Exec("INSERT INTO PaymentProfile (user_id, stripe_customer_id) VALUES (?, ?)", user.UserID, stripeCustomerID)
+		_, err = db.ExecContext(c.Request.Context(), "INSERT INTO PaymentProfile (user_id, stripe_customer_id) VALUES (?, ?)", user.UserID, stripeCustomerID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save profile"})
+			apierr.Abort(c, apierr.Internal("failed to save profile", err))
 			return
 		}
 	} else if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db query error"})
+		apierr.Abort(c, apierr.Internal("db query error", err))
 		return
 	}
 
@@ -234,20 +370,20 @@ func handleAddPaymentMethod(c *gin.Context) {
 	}
 	pm, err := paymentmethod.Attach(req.PaymentMethodID, pmParams)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to attach payment method: " + err.Error()})
+		apierr.Abort(c, stripeError("failed to attach payment method", err))
 		return
 	}
 
 	// 3. Save PaymentMethod to DB
 	// Set all previous ones to false
-	_, err = db.Exec("UPDATE PaymentMethod SET is_default = 0 WHERE user_id = ?", user.UserID)
+	_, err = db.ExecContext(c.Request.Context(), "UPDATE PaymentMethod SET is_default = 0 WHERE user_id = ?", user.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unset existing default cards"})
+		apierr.Abort(c, apierr.Internal("failed to unset existing default cards", err))
 		return
 	}
 
 	pmID := uuid.New().String()
-	_, err = db.Exec(`
+	_, err = db.ExecContext(c.Request.Context(), `
 		INSERT INTO PaymentMethod 
 		(payment_method_id, user_id, stripe_customer_id, stripe_payment_method_id, brand, last4, exp_month, exp_year, is_default, status) 
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
@@ -255,7 +391,7 @@ func handleAddPaymentMethod(c *gin.Context) {
 	`, pmID, user.UserID, stripeCustomerID, pm.ID, pm.Card.Brand, pm.Card.Last4, pm.Card.ExpMonth, pm.Card.ExpYear, 1, "active")
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save payment method to db"})
+		apierr.Abort(c, apierr.Internal("failed to save payment method to db", err))
 		return
 	}
 
@@ -263,12 +399,15 @@ func handleAddPaymentMethod(c *gin.Context) {
 }
 
 func handleGetPaymentMethods(c *gin.Context) {
-	user := getUser(c)
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
 
 	db := ecpayDB
 	var err error
 
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(c.Request.Context(), `
 		SELECT payment_method_id, stripe_payment_method_id, brand, last4, exp_month, exp_year, is_default 
 		FROM PaymentMethod 
 		WHERE user_id = ? AND status = 'active'
@@ -276,7 +415,7 @@ func handleGetPaymentMethods(c *gin.Context) {
 	`, user.UserID)
 	
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db query error"})
+		apierr.Abort(c, apierr.Internal("db query error", err))
 		return
 	}
 	defer rows.Close()
@@ -308,32 +447,35 @@ type SetDefaultRequest struct {
 func handleSetDefaultPaymentMethod(c *gin.Context) {
 	var req SetDefaultRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
-	user := getUser(c)
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
 
 	db := ecpayDB
 	var err error
 
 	// Update all methods for this user to is_default = 0
-	_, err = db.Exec("UPDATE PaymentMethod SET is_default = 0 WHERE user_id = ?", user.UserID)
+	_, err = db.ExecContext(c.Request.Context(), "UPDATE PaymentMethod SET is_default = 0 WHERE user_id = ?", user.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset default flags"})
+		apierr.Abort(c, apierr.Internal("failed to reset default flags", err))
 		return
 	}
 
 	// Set the selected method to is_default = 1
-	result, err := db.Exec("UPDATE PaymentMethod SET is_default = 1 WHERE user_id = ? AND payment_method_id = ?", user.UserID, req.PaymentMethodID)
+	result, err := db.ExecContext(c.Request.Context(), "UPDATE PaymentMethod SET is_default = 1 WHERE user_id = ? AND payment_method_id = ?", user.UserID, req.PaymentMethodID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set new default"})
+		apierr.Abort(c, apierr.Internal("failed to set new default", err))
 		return
 	}
 	
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found or already default"})
+		apierr.Abort(c, apierr.NotFound("Payment method not found or already default"))
 		return
 	}
 
@@ -347,23 +489,26 @@ type DeletePaymentRequest struct {
 func handleDeletePaymentMethod(c *gin.Context) {
 	var req DeletePaymentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
-	user := getUser(c)
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
 
 	db := ecpayDB
 	var err error
 
 	// Soft delete by updating status
-	_, err = db.Exec(`
+	_, err = db.ExecContext(c.Request.Context(), `
 		UPDATE PaymentMethod SET status = 'inactive', is_default = 0
 		WHERE user_id = ? AND payment_method_id = ?
 	`, user.UserID, req.PaymentMethodID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete payment method"})
+		apierr.Abort(c, apierr.Internal("failed to delete payment method", err))
 		return
 	}
 
@@ -373,28 +518,67 @@ func handleDeletePaymentMethod(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// mockStripeKey is used when SecretKeyString is unset; Stripe rejects it.
+const mockStripeKey = "sk_test_mock"
+
+// checkStripe reads the account balance, the cheapest call that proves the key
+// works. It is cached by the readiness check rather than run on every probe.
+func checkStripe(ctx context.Context) error {
+	if stripe.Key == mockStripeKey {
+		return errors.New("no Stripe key configured (SecretKeyString); payments will fail")
+	}
+	params := &stripe.BalanceParams{}
+	params.Context = ctx
+	_, err := balance.Get(params)
+	return err
+}
+
+// stripeError maps a Stripe failure to the error the client sees. Card errors
+// ("Your card was declined.") are written for customers and passed on; invalid
+// requests name Stripe object ids and are not; anything else means Stripe is
+// unavailable.
+func stripeError(message string, err error) *apierr.Error {
+	var se *stripe.Error
+	if errors.As(err, &se) {
+		switch se.Type {
+		case stripe.ErrorTypeCard:
+			return apierr.Validation(message + ": " + se.Msg).WithCause(err)
+		case stripe.ErrorTypeInvalidRequest:
+			return apierr.Validation(message).WithCause(err)
+		}
+	}
+	return apierr.Unavailable(message+": payment provider unavailable", err)
+}
+
 func handleCreatePayment(c *gin.Context) {
 	var req CheckoutRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
-	user := getUser(c)
+	user, ok := requireUser(c)
+	if !ok {
+		return
+	}
 
 	db := ecpayDB
 	var err error
 
 	// Get specific payment method details
 	var spmID, stripeCustomerID string
-	err = db.QueryRow(`
+	err = db.QueryRowContext(c.Request.Context(), `
 		SELECT stripe_payment_method_id, stripe_customer_id 
 		FROM PaymentMethod 
 		WHERE payment_method_id = ? AND user_id = ?
 	`, req.PaymentMethodID, user.UserID).Scan(&spmID, &stripeCustomerID)
 
+	if errors.Is(err, sql.ErrNoRows) {
+		apierr.Abort(c, apierr.NotFound("payment method not found"))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment method not found"})
+		apierr.Abort(c, apierr.Internal("db query error", err))
 		return
 	}
 
@@ -415,7 +599,7 @@ func handleCreatePayment(c *gin.Context) {
 	pi, err := paymentintent.New(params)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment failed: " + err.Error()})
+		apierr.Abort(c, stripeError("payment failed", err))
 		return
 	}
 
@@ -435,75 +619,95 @@ func handleCreatePayment(c *gin.Context) {
 	// Just mock order
 	orderID := uuid.New().String()
 
-	orderListJSON, _ := json.Marshal([]string{orderID})
+	if err := recordPayment(c.Request.Context(), db, user, req, pi.ID, statusStr, paymentID, orderID); err != nil {
+		apierr.Abort(c, apierr.Internal("failed to record payment", err))
+		return
+	}
 
-	// pi.ID is Stripe's PaymentIntent id (e.g. "pi_..."), kept as an internal
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payment_intent_id": pi.ID, "payment_id": paymentID, "order_id": orderID})
+}
+
+// recordPayment stores the payment and, when Stripe took the money, the stock
+// it consumed and the order, together with their events in one transaction:
+// ranking and the search index hear about exactly the orders that exist.
+func recordPayment(ctx context.Context, db *sql.DB, user UserContext, req CheckoutRequest, piID, status, paymentID, orderID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	orderListJSON, _ := json.Marshal([]string{orderID})
+	// piID is Stripe's PaymentIntent id (e.g. "pi_..."), kept as an internal
 	// reference for reconciliation/support lookups in the Stripe dashboard.
 	// The customer-facing identifier is the system-generated order_id.
-	_, err = db.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		INSERT INTO Payment (payment_id, order_id_list, payment_method_id, amount, currency, status, idempotency_key, stripe_payment_intent_id)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, paymentID, orderListJSON, req.PaymentMethodID, req.Amount, "USD", statusStr, pi.ID, pi.ID)
+	`, paymentID, orderListJSON, req.PaymentMethodID, req.Amount, "USD", status, piID, piID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record payment: " + err.Error()})
-		return
+		return err
+	}
+	if status != "captured" && status != "authorized" {
+		return tx.Commit()
 	}
 
-	if statusStr == "captured" || statusStr == "authorized" {
-		for _, item := range req.Items {
-			_, err := db.Exec("UPDATE Stock SET stocks = GREATEST(0, stocks - ?) WHERE product_id = ?", item.Quantity, item.ProductID)
-			if err != nil {
-				log.Printf("failed to decrement stock for product %s: %v", item.ProductID, err)
-			}
-
-			// Update ranking by calling Ranking service
-			var categoryID int
-			err = db.QueryRow("SELECT category_id FROM Product WHERE product_id = ?", item.ProductID).Scan(&categoryID)
-			if err == nil {
-				updateReq := map[string]interface{}{
-					"product_id":  item.ProductID,
-					"category_id": categoryID,
-					"quantity":    item.Quantity,
-				}
-				jsonBody, _ := json.Marshal(updateReq)
-				rankingURL := os.Getenv("RANKING_SERVICE_URL")
-				if rankingURL == "" {
-					rankingURL = "http://ranking-service:8080" // Default internal URL
-				}
-				resp, err := http.Post(rankingURL+"/api/ranking/update", "application/json", bytes.NewBuffer(jsonBody))
-				if err != nil {
-					log.Printf("failed to call ranking service: %v", err)
-				} else {
-					resp.Body.Close()
-				}
-			} else {
-				log.Printf("failed to get category for ranking: %v", err)
-			}
-		}
-
-		// Create the Order record so sellers can see the purchase in the Seller Portal.
-		// The Order is linked to products (and thus sellers) via transactions_json,
-		// which references the shipment-leg Transaction rows created by the frontend.
-		totalQty := 0
-		for _, item := range req.Items {
-			totalQty += item.Quantity
-		}
-		subtotal := req.Subtotal
-		if subtotal == 0 {
-			subtotal = req.Amount
+	items := make([]events.OrderItem, 0, len(req.Items))
+	totalQty := 0
+	for _, item := range req.Items {
+		totalQty += item.Quantity
+		_, err := tx.ExecContext(ctx, "UPDATE Stock SET stocks = GREATEST(0, stocks - ?) WHERE product_id = ?", item.Quantity, item.ProductID)
+		if err != nil {
+			logging.From(ctx).Error("failed to decrement stock", zap.String("product_id", item.ProductID), zap.Error(err))
+			continue
 		}
-		txnJSON, _ := json.Marshal(req.TransactionIDs)
-		if len(req.TransactionIDs) == 0 {
-			txnJSON = []byte("[]")
+		var categoryID, stock int
+		err = tx.QueryRowContext(ctx, `
+			SELECT p.category_id, COALESCE(s.stocks, 0)
+			FROM Product p LEFT JOIN Stock s ON s.product_id = p.product_id
+			WHERE p.product_id = ?`, item.ProductID).Scan(&categoryID, &stock)
+		if err != nil {
+			logging.From(ctx).Error("failed to read product for order events", zap.String("product_id", item.ProductID), zap.Error(err))
+			continue
 		}
-		_, err = db.Exec(`
-			INSERT INTO `+"`Order`"+` (order_id, user_id, currency, subtotal_amount, shipping_amount, total_amount, quantity, status, transactions_json)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, orderID, user.UserID, "USD", subtotal, req.Shipping, req.Amount, totalQty, "paid", txnJSON)
+		items = append(items, events.OrderItem{ProductID: item.ProductID, CategoryID: categoryID, Quantity: item.Quantity})
+		err = events.Enqueue(ctx, tx, events.StockChanged, item.ProductID,
+			events.StockChangedPayload{ProductID: item.ProductID, Stock: stock, Delta: -item.Quantity})
 		if err != nil {
-			log.Printf("failed to create order %s: %v", orderID, err)
+			return err
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success", "payment_intent_id": pi.ID, "payment_id": paymentID, "order_id": orderID})
+	// Create the Order record so sellers can see the purchase in the Seller Portal.
+	// The Order is linked to products (and thus sellers) via transactions_json,
+	// which references the shipment-leg Transaction rows created by the frontend.
+	subtotal := req.Subtotal
+	if subtotal == 0 {
+		subtotal = req.Amount
+	}
+	txnJSON, _ := json.Marshal(req.TransactionIDs)
+	if len(req.TransactionIDs) == 0 {
+		txnJSON = []byte("[]")
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+"`Order`"+` (order_id, user_id, currency, subtotal_amount, shipping_amount, total_amount, quantity, status, transactions_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, orderID, user.UserID, "USD", subtotal, req.Shipping, req.Amount, totalQty, "paid", txnJSON)
+	if err != nil {
+		return fmt.Errorf("create order %s: %w", orderID, err)
+	}
+
+	err = events.Enqueue(ctx, tx, events.OrderPlaced, orderID, events.OrderPlacedPayload{
+		OrderID: orderID, UserID: user.UserID, Total: req.Amount, Currency: "USD", Items: items,
+	})
+	if err != nil {
+		return err
+	}
+	err = events.Enqueue(ctx, tx, events.PaymentCaptured, paymentID, events.PaymentCapturedPayload{
+		PaymentID: paymentID, OrderID: orderID, Amount: req.Amount, Currency: "USD", Status: status,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
@@ -3,7 +3,17 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/auth/authtest"
+	"github.com/stripe/stripe-go/v74"
 )
 
 func makeToken(claims map[string]string) string {
@@ -14,42 +24,85 @@ func makeToken(claims map[string]string) string {
 	return enc(map[string]string{"alg": "none"}) + "." + enc(claims) + ".sig"
 }
 
-func TestParseUserFromAuthHeader(t *testing.T) {
-	t.Run("email claim wins", func(t *testing.T) {
-		tok := makeToken(map[string]string{"email": "a@x.io", "preferred_username": "alice", "sub": "s1"})
-		u := parseUserFromAuthHeader("Bearer " + tok)
-		if u.UserID != "a@x.io" || u.Email != "a@x.io" {
-			t.Errorf("got %+v", u)
-		}
-	})
+// setupAuth points authn at a test issuer that also accepts impersonation
+// tokens signed with impersonationKey.
+func setupAuth(t *testing.T) *authtest.Issuer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	iss := authtest.NewIssuer(t)
+	authn = iss.Authenticator(t, commonauth.Options{ImpersonationKey: impersonationKey})
+	t.Cleanup(func() { authn = nil })
+	return iss
+}
 
-	t.Run("falls back to preferred_username", func(t *testing.T) {
-		tok := makeToken(map[string]string{"preferred_username": "bob", "sub": "s2"})
-		u := parseUserFromAuthHeader("Bearer " + tok)
-		if u.UserID != "bob" || u.Email != "bob@example.com" {
-			t.Errorf("got %+v", u)
-		}
-	})
+var impersonationKey = []byte("impersonation-test-key")
 
-	t.Run("falls back to sub for user id", func(t *testing.T) {
-		tok := makeToken(map[string]string{"sub": "s3"})
-		u := parseUserFromAuthHeader("Bearer " + tok)
-		if u.UserID != "s3" {
-			t.Errorf("expected UserID s3, got %+v", u)
-		}
-	})
+func requestWith(header string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/payment", nil)
+	if header != "" {
+		c.Request.Header.Set("Authorization", header)
+	}
+	return c
+}
 
-	t.Run("missing header returns mock user", func(t *testing.T) {
-		u := parseUserFromAuthHeader("")
-		if u.UserID != "testuser@example.com" {
-			t.Errorf("expected mock user, got %+v", u)
-		}
-	})
+func TestGetUser(t *testing.T) {
+	iss := setupAuth(t)
+	impTok, _, err := commonauth.NewImpersonationSigner(impersonationKey).Issue("ops@x.io", "a@x.io", true, time.Minute, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	t.Run("malformed token returns mock user", func(t *testing.T) {
-		u := parseUserFromAuthHeader("Bearer not-a-jwt")
-		if u.UserID != "testuser@example.com" {
-			t.Errorf("expected mock user, got %+v", u)
+	for _, tc := range []struct {
+		name   string
+		header string
+		want   UserContext
+		ok     bool
+	}{
+		{"email claim wins", iss.Bearer(t, authtest.WithEmail("a@x.io")), UserContext{UserID: "a@x.io", Email: "a@x.io"}, true},
+		{"falls back to preferred_username", iss.Bearer(t, authtest.WithClaim("email", nil), authtest.WithClaim("preferred_username", "bob")), UserContext{UserID: "bob", Email: "bob@example.com"}, true},
+		{"falls back to sub", iss.Bearer(t, authtest.WithClaim("email", nil), authtest.WithClaim("preferred_username", nil), authtest.WithSubject("s3")), UserContext{UserID: "s3", Email: "@example.com"}, true},
+		{"missing header returns mock user", "", mockUser, true},
+		{"malformed token", "Bearer not-a-jwt", UserContext{}, false},
+		{"unsigned token", "Bearer " + makeToken(map[string]string{"email": "a@x.io"}), UserContext{}, false},
+		{"expired token", iss.Bearer(t, authtest.WithExpiry(-time.Hour)), UserContext{}, false},
+		{"impersonation token", "Bearer " + impTok, UserContext{}, false},
+	} {
+		u, err := getUser(requestWith(tc.header))
+		if (err == nil) != tc.ok || u != tc.want {
+			t.Errorf("%s: getUser = (%+v, %v), want %+v", tc.name, u, err, tc.want)
 		}
-	})
+	}
+}
+
+func TestRateLimitUser(t *testing.T) {
+	iss := setupAuth(t)
+	key := func(header string) (string, bool) {
+		return rateLimitUser(requestWith(header))
+	}
+	if id, ok := key(iss.Bearer(t, authtest.WithEmail("a@x.io"))); !ok || id != "a@x.io" {
+		t.Errorf("with token = (%q, %v), want a@x.io", id, ok)
+	}
+	// no header must not share the mock testuser's bucket
+	if id, ok := key(""); ok {
+		t.Errorf("without token = (%q, true), want no user key", id)
+	}
+	// a made-up subject per request must not get a fresh bucket each time
+	if id, ok := key("Bearer " + makeToken(map[string]string{"email": "forged@x.io"})); ok {
+		t.Errorf("unsigned token = (%q, true), want no user key", id)
+	}
+}
+
+func TestStripeError(t *testing.T) {
+	card := &stripe.Error{Type: stripe.ErrorTypeCard, Msg: "Your card was declined."}
+	if e := stripeError("payment failed", card); e.Code != apierr.CodeValidation || e.Message != "payment failed: Your card was declined." {
+		t.Errorf("card error = %+v", e)
+	}
+	invalid := &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, Msg: "No such PaymentMethod: 'pm_123'"}
+	if e := stripeError("failed to attach payment method", invalid); e.Code != apierr.CodeValidation || strings.Contains(e.Message, "pm_123") {
+		t.Errorf("invalid request = %+v", e)
+	}
+	if e := stripeError("payment failed", errors.New("dial tcp: i/o timeout")); e.Code != apierr.CodeDependencyUnavailable {
+		t.Errorf("network error = %+v", e)
+	}
 }
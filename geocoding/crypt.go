@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mockten/mockten/common/fieldcrypt"
+)
+
+// keyring encrypts the Geo address columns and the phone number attribute
+// (see common/fieldcrypt). Loaded from FIELD_KEYS_FILE at startup.
+var keyring *fieldcrypt.Keyring
+
+// sealGeo encrypts one Geo column value; empty values stay empty (NULL-able
+// columns are written as NULL by the callers).
+func sealGeo(column, v string) (string, error) {
+	return keyring.Encrypt(v, fieldcrypt.GeoAAD(column))
+}
+
+func openGeo(column string, v sql.NullString) (string, error) {
+	if !v.Valid {
+		return "", nil
+	}
+	return keyring.Decrypt(v.String, fieldcrypt.GeoAAD(column))
+}
+
+// sealCoord normalises a Nominatim coordinate and encrypts it. An unparseable
+// coordinate is stored as NULL, as before.
+func sealCoord(column, v string) (sql.NullString, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return sql.NullString{}, nil
+	}
+	ct, err := sealGeo(column, strconv.FormatFloat(f, 'f', 7, 64))
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: ct, Valid: true}, nil
+}
+
+func openCoord(column string, v sql.NullString) (float64, bool, error) {
+	pt, err := openGeo(column, v)
+	if err != nil || pt == "" {
+		return 0, false, err
+	}
+	f, err := strconv.ParseFloat(pt, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return f, true, nil
+}
+
+// sealedAddress holds the encrypted columns of a GeocodeRequest.
+type sealedAddress struct {
+	PostalCode, Town, BuildingName, RoomNumber string
+	Latitude, Longitude                        sql.NullString
+}
+
+func sealAddress(in GeocodeRequest, latStr, lonStr string) (sealedAddress, error) {
+	var s sealedAddress
+	var err error
+	for _, f := range []struct {
+		column string
+		in     string
+		out    *string
+	}{
+		{"postal_code", in.PostalCode, &s.PostalCode},
+		{"town", in.Town, &s.Town},
+		{"building_name", in.BuildingName, &s.BuildingName},
+		{"room_number", in.RoomNumber, &s.RoomNumber},
+	} {
+		if *f.out, err = sealGeo(f.column, f.in); err != nil {
+			return s, err
+		}
+	}
+	if s.Latitude, err = sealCoord("latitude", latStr); err != nil {
+		return s, err
+	}
+	if s.Longitude, err = sealCoord("longitude", lonStr); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// rotateGeo encrypts legacy plaintext rows (the seed data, rows written before
+// encryption) and re-wraps rows still under an old key, in geo_id order. The
+// UPDATE only applies if the row is unchanged since it was read, so a
+// concurrent address edit is never overwritten; that row is picked up on the
+// next run instead.
+func rotateGeo(ctx context.Context) {
+	const batch = 200
+	cols := fieldcrypt.GeoColumns
+	var after string
+	var rotated int
+	for {
+		rows, err := db.QueryContext(ctx, `
+SELECT geo_id, postal_code, town, building_name, room_number, latitude, longitude
+FROM Geo WHERE geo_id > ? ORDER BY geo_id LIMIT ?`, after, batch)
+		if err != nil {
+			log.Printf("Geo rotation query error: %v", err)
+			return
+		}
+		type row struct {
+			id   string
+			vals [6]sql.NullString
+		}
+		var page []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.vals[0], &r.vals[1], &r.vals[2], &r.vals[3], &r.vals[4], &r.vals[5]); err != nil {
+				rows.Close()
+				log.Printf("Geo rotation scan error: %v", err)
+				return
+			}
+			page = append(page, r)
+		}
+		rows.Close()
+		if len(page) == 0 {
+			break
+		}
+
+		for _, r := range page {
+			after = r.id
+			stale := false
+			for _, v := range r.vals {
+				stale = stale || (v.Valid && keyring.NeedsRotation(v.String))
+			}
+			if !stale {
+				continue
+			}
+			var next [6]sql.NullString
+			args := make([]any, 0, 19)
+			ok := true
+			for i, v := range r.vals {
+				next[i] = v
+				if v.Valid {
+					if next[i].String, err = keyring.Rotate(v.String, fieldcrypt.GeoAAD(cols[i])); err != nil {
+						log.Printf("Geo rotation of %s.%s failed: %v", r.id, cols[i], err)
+						ok = false
+						break
+					}
+				}
+				args = append(args, next[i])
+			}
+			if !ok {
+				continue
+			}
+			args = append(args, r.id)
+			for _, v := range r.vals {
+				args = append(args, v)
+			}
+			if _, err := db.ExecContext(ctx, `
+UPDATE Geo SET postal_code = ?, town = ?, building_name = ?, room_number = ?, latitude = ?, longitude = ?
+WHERE geo_id = ? AND postal_code <=> ? AND town <=> ? AND building_name <=> ? AND room_number <=> ? AND latitude <=> ? AND longitude <=> ?`,
+				args...); err != nil {
+				log.Printf("Geo rotation update of %s failed: %v", r.id, err)
+				continue
+			}
+			rotated++
+		}
+	}
+	if rotated > 0 {
+		log.Printf("Geo rotation: %d rows moved to key %s", rotated, keyring.Primary())
+	}
+}
+
+// startGeoRotation runs rotateGeo once at startup and then daily, so a key
+// rotation (fieldkeys rotate + restart) eventually retires the old key.
+func startGeoRotation() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+			rotateGeo(ctx)
+			cancel()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
+
+func openAddress(g *GeoResponse, postal, town, building, room sql.NullString) error {
+	var err error
+	if g.PostalCode, err = openGeo("postal_code", postal); err != nil {
+		return err
+	}
+	if g.Town, err = openGeo("town", town); err != nil {
+		return err
+	}
+	if g.BuildingName, err = openGeo("building_name", building); err != nil {
+		return err
+	}
+	g.RoomNumber, err = openGeo("room_number", room)
+	return err
+}
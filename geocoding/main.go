@@ -2,9 +2,7 @@ package main
 
 import (
 	"context"
-	crand "crypto/rand"
 	"database/sql"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,27 +12,47 @@ import (
 	"math/rand"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/MicahParks/keyfunc/v3"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/fieldcrypt"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/httpclient"
+	"github.com/mockten/mockten/common/keycloak"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
+// Config is config.json, loaded by common/config. Fields tagged reload take
+// effect when the file changes; the rest need a restart.
 type Config struct {
-	NominatimURL   string            `json:"nominatim_url"`
-	UserAgent      string            `json:"user_agent"`
-	CountryMapping map[string]string `json:"country_mapping"`
+	NominatimURL   string            `json:"nominatim_url" default:"https://nominatim.openstreetmap.org/search" validate:"url"`
+	UserAgent      string            `json:"user_agent" validate:"required"`
+	CountryMapping map[string]string `json:"country_mapping" reload:"true"`
 	MySQL          struct {
-		Host   string `json:"host"`
-		User   string `json:"user"`
-		Pass   string `json:"pass"`
-		DB     string `json:"db"`
-		Params string `json:"params"`
+		Host   string        `json:"host" validate:"required"`
+		User   string        `json:"user" validate:"required"`
+		Pass   config.Secret `json:"pass" env:"GEOCODING_MYSQL_PASS"`
+		DB     string        `json:"db" validate:"required"`
+		Params string        `json:"params" default:"parseTime=true&charset=utf8mb4&collation=utf8mb4_unicode_ci"`
 	} `json:"mysql"`
+	// FeeScale uniformly scales every shipping fee (road legs + air/sea
+	// freight) so the customer-facing quotes stay in a realistic range for a
+	// demo storefront. Applied at the source of each fee so the cheaper-route
+	// comparisons and the derived delivery-day estimates remain consistent.
+	FeeScale float64         `json:"fee_scale" default:"0.1" validate:"gt=0" reload:"true"`
+	Keycloak config.Keycloak `json:"keycloak"`
+	// Redis holds the shared token denylist.
+	Redis config.Redis `json:"redis"`
 }
 
 type GeocodeRequest struct {
@@ -102,45 +120,50 @@ type GeoResponse struct {
 }
 
 var (
-	cfg        Config
-	db         *sql.DB
-	jwks       keyfunc.Keyfunc
-	jwksCancel context.CancelFunc
+	live     *config.Live[Config]
+	db       *sql.DB
+	authn    *commonauth.Authenticator
+	profiles *keycloak.ProfileCache
+
+	// nominatimClient carries the trace context to Nominatim, so geocoding
+	// latency shows up in the profile request's trace. The public instance
+	// allows about one request a second, so a 429 is retried once, a second
+	// later; while Nominatim is down, lookups fail fast and addresses are
+	// saved without coordinates, as on any geocoding error.
+	nominatimClient = httpclient.New(httpclient.Options{
+		Target:  "nominatim",
+		Timeout: 10 * time.Second,
+		Retries: 1,
+		Backoff: 1100 * time.Millisecond,
+	})
 )
 
+// loadConfig reads path and follows it for changes to the reloadable keys.
 func loadConfig(path string) {
-	f, err := os.Open(path)
+	var err error
+	live, err = config.NewLive[Config](config.Options{File: path, Logger: zap.L()})
 	if err != nil {
 		log.Fatalf("Config error: %v", err)
 	}
-	defer f.Close()
-	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
-		log.Fatalf("Config decode error: %v", err)
-	}
-	if strings.TrimSpace(cfg.UserAgent) == "" {
-		log.Fatalf("Config error: user_agent is empty")
-	}
-	if strings.TrimSpace(cfg.NominatimURL) == "" {
-		cfg.NominatimURL = "https://nominatim.openstreetmap.org/search"
-	}
-	if cfg.CountryMapping == nil {
-		cfg.CountryMapping = map[string]string{}
-	}
-	if strings.TrimSpace(cfg.MySQL.Params) == "" {
-		cfg.MySQL.Params = "parseTime=true&charset=utf8mb4&collation=utf8mb4_unicode_ci"
-	}
+	log.Printf("Config: %s", config.Dump(live.Get()))
+	go live.Run(context.Background())
 }
 
+// conf is the current config. A reload swaps the whole value, so a *Config
+// held by the caller stays consistent.
+func conf() *Config { return live.Get() }
+
 func initDBWait() {
+	cfg := conf()
 	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
 		cfg.MySQL.User,
-		cfg.MySQL.Pass,
+		cfg.MySQL.Pass.Value(),
 		cfg.MySQL.Host,
 		cfg.MySQL.DB,
 		cfg.MySQL.Params,
 	)
 	var err error
-	db, err = sql.Open("mysql", dsn)
+	db, err = tracing.OpenDB("mysql", dsn)
 	if err != nil {
 		log.Fatalf("DB open error: %v", err)
 	}
@@ -161,6 +184,9 @@ func initDBWait() {
 		cancel()
 		if err == nil {
 			log.Printf("DB connected after %d attempt(s)", attempt)
+			if err := migrate.Ensure(context.Background(), db, nil); err != nil {
+				log.Fatalf("DB schema not ready: %v", err)
+			}
 			return
 		}
 		log.Printf("DB ping failed (attempt %d): %v", attempt, err)
@@ -188,7 +214,7 @@ func buildParams(req GeocodeRequest) url.Values {
 	if cc != "" {
 		p.Set("countrycodes", cc)
 	}
-	if cn, ok := cfg.CountryMapping[cc]; ok && strings.TrimSpace(cn) != "" {
+	if cn, ok := conf().CountryMapping[cc]; ok && strings.TrimSpace(cn) != "" {
 		p.Set("country", cn)
 	}
 	if s := strings.TrimSpace(req.Town); s != "" {
@@ -219,13 +245,36 @@ func buildParams(req GeocodeRequest) url.Values {
 	return p
 }
 
-func geocodeOnce(params url.Values) (lat string, lon string, found bool, err error) {
-	req, err := http.NewRequest(http.MethodGet, cfg.NominatimURL+"?"+params.Encode(), nil)
+// checkNominatim asks Nominatim's /status endpoint (next to /search) whether
+// it can serve lookups. The public instance allows about one request a second,
+// so readiness caches the result.
+func checkNominatim(ctx context.Context) error {
+	cfg := conf()
+	statusURL := strings.TrimSuffix(cfg.NominatimURL, "/search") + "/status"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", cfg.UserAgent)
+	resp, err := nominatimClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("nominatim status: %s", resp.Status)
+	}
+	return nil
+}
+
+func geocodeOnce(ctx context.Context, params url.Values) (lat string, lon string, found bool, err error) {
+	cfg := conf()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.NominatimURL+"?"+params.Encode(), nil)
 	if err != nil {
 		return "", "", false, err
 	}
 	req.Header.Set("User-Agent", cfg.UserAgent)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := nominatimClient.Do(req)
 	if err != nil {
 		return "", "", false, err
 	}
@@ -244,100 +293,77 @@ func geocodeOnce(params url.Values) (lat string, lon string, found bool, err err
 	return items[0].Lat, items[0].Lon, true, nil
 }
 
-func generateUUID() string {
-	b := make([]byte, 16)
-	_, err := crand.Read(b)
-	if err != nil {
-		return fmt.Sprintf("%d", time.Now().UnixNano())
-	}
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
-}
-
-func updateUserPhoneNumber(userID, phoneNumber, countryCode string) error {
+// updateUserPhoneNumber stores the phone number (and country code, when
+// given) as Keycloak user attributes through the Admin API. The number is
+// encrypted, with a blind index next to it for admin lookups by phone.
+func updateUserPhoneNumber(ctx context.Context, userID, phoneNumber, countryCode string) error {
 	if phoneNumber == "" && countryCode == "" {
 		return nil
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var internalID string
-	err := db.QueryRowContext(ctx, "SELECT ID FROM USER_ENTITY WHERE EMAIL = ? OR USERNAME = ?", userID, userID).Scan(&internalID)
+	ct, idx, err := keyring.EncryptPhone(phoneNumber)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("User not found in USER_ENTITY for phone update: %s", userID)
-			return nil
-		}
-		return fmt.Errorf("user lookup error: %v", err)
+		return fmt.Errorf("phone encryption error: %v", err)
 	}
-
-	var attrID string
-	err = db.QueryRowContext(ctx, "SELECT ID FROM USER_ATTRIBUTE WHERE USER_ID = ? AND NAME = 'phoneNumber'", internalID).Scan(&attrID)
-	if err == sql.ErrNoRows {
-		// Insert
-		newID := generateUUID()
-		_, err = db.ExecContext(ctx, "INSERT INTO USER_ATTRIBUTE (ID, NAME, VALUE, USER_ID) VALUES (?, 'phoneNumber', ?, ?)", newID, phoneNumber, internalID)
-	} else if err == nil {
-		// Update
-		_, err = db.ExecContext(ctx, "UPDATE USER_ATTRIBUTE SET VALUE = ? WHERE ID = ?", phoneNumber, attrID)
+	attrs := map[string]string{fieldcrypt.PhoneAttr: ct, fieldcrypt.PhoneIndexAttr: idx}
+	if countryCode != "" {
+		attrs["countryCode"] = countryCode
 	}
-	if err != nil {
-		return err
+	err = profiles.SetAttributes(ctx, userID, attrs)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		logging.From(ctx).Warn("User not found in Keycloak for phone update", zap.String("user_id", userID))
+		return nil
 	}
-
-	// Handle countryCode
-	if countryCode != "" {
-		var ccAttrID string
-		err = db.QueryRowContext(ctx, "SELECT ID FROM USER_ATTRIBUTE WHERE USER_ID = ? AND NAME = 'countryCode'", internalID).Scan(&ccAttrID)
-		if err == sql.ErrNoRows {
-			newID := generateUUID()
-			_, err = db.ExecContext(ctx, "INSERT INTO USER_ATTRIBUTE (ID, NAME, VALUE, USER_ID) VALUES (?, 'countryCode', ?, ?)", newID, countryCode, internalID)
-		} else if err == nil {
-			_, err = db.ExecContext(ctx, "UPDATE USER_ATTRIBUTE SET VALUE = ? WHERE ID = ?", countryCode, ccAttrID)
-		}
+	if err != nil {
+		return fmt.Errorf("keycloak attribute update error: %v", err)
 	}
-	return err
+	return nil
 }
 
-func getUserProfile(userID string) (map[string]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// getUserProfile returns the user's Keycloak attributes with the phone number
+// decrypted, or nil for an unknown user. A phone number still in plaintext or
+// under an old key is re-saved on the way out.
+func getUserProfile(ctx context.Context, userID string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var internalID string
-	err := db.QueryRowContext(ctx, "SELECT ID FROM USER_ENTITY WHERE EMAIL = ? OR USERNAME = ?", userID, userID).Scan(&internalID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("user lookup error: %v", err)
+	p, err := profiles.Profile(ctx, userID)
+	if errors.Is(err, keycloak.ErrNotFound) {
+		return nil, nil
 	}
-
-	rows, err := db.QueryContext(ctx, "SELECT NAME, VALUE FROM USER_ATTRIBUTE WHERE USER_ID = ?", internalID)
 	if err != nil {
-		return nil, fmt.Errorf("attribute retrieval error: %v", err)
+		return nil, fmt.Errorf("keycloak profile error: %v", err)
 	}
-	defer rows.Close()
-
-	attrs := make(map[string]string)
-	for rows.Next() {
-		var name, value string
-		if err := rows.Scan(&name, &value); err != nil {
-			return nil, err
+	// The cached map is shared; never modify it in place.
+	attrs := make(map[string]string, len(p.Attributes))
+	for k, v := range p.Attributes {
+		attrs[k] = v
+	}
+	delete(attrs, fieldcrypt.PhoneIndexAttr)
+	if stored := attrs[fieldcrypt.PhoneAttr]; stored != "" {
+		phone, err := keyring.DecryptPhone(stored)
+		if err != nil {
+			return nil, fmt.Errorf("phone decryption error: %v", err)
+		}
+		attrs[fieldcrypt.PhoneAttr] = phone
+		if keyring.NeedsRotation(stored) {
+			if err := updateUserPhoneNumber(ctx, userID, phone, ""); err != nil {
+				logging.From(ctx).Warn("Phone re-encryption error", zap.Error(err))
+			}
 		}
-		attrs[name] = value
 	}
 	return attrs, nil
 }
 
-func updateGeo(in GeocodeRequest, latStr, lonStr string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func updateGeo(ctx context.Context, in GeocodeRequest, latStr, lonStr string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var lat, lon sql.NullFloat64
-	if v, err := strconv.ParseFloat(latStr, 64); err == nil {
-		lat = sql.NullFloat64{Float64: v, Valid: true}
-	}
-	if v, err := strconv.ParseFloat(lonStr, 64); err == nil {
-		lon = sql.NullFloat64{Float64: v, Valid: true}
+	enc, err := sealAddress(in, latStr, lonStr)
+	if err != nil {
+		return err
 	}
 
 	q := `
@@ -345,36 +371,33 @@ UPDATE Geo SET
   country_code = ?, postal_code = ?, prefecture = ?, city = ?, town = ?, building_name = ?, room_number = ?, latitude = ?, longitude = ?
 WHERE geo_id = ? AND user_id = ?
 `
-	_, err := db.ExecContext(ctx, q,
+	_, err = db.ExecContext(ctx, q,
 		strings.ToUpper(strings.TrimSpace(in.CountryCode)),
-		in.PostalCode,
+		enc.PostalCode,
 		in.Prefecture,
 		in.City,
-		in.Town,
-		in.BuildingName,
-		in.RoomNumber,
-		lat, lon,
+		enc.Town,
+		enc.BuildingName,
+		enc.RoomNumber,
+		enc.Latitude, enc.Longitude,
 		in.GeoID,
 		in.UserID,
 	)
 	return err
 }
 
-func insertGeo(in GeocodeRequest, latStr, lonStr string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func insertGeo(ctx context.Context, in GeocodeRequest, latStr, lonStr string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var lat, lon sql.NullFloat64
-	if v, err := strconv.ParseFloat(latStr, 64); err == nil {
-		lat = sql.NullFloat64{Float64: v, Valid: true}
-	}
-	if v, err := strconv.ParseFloat(lonStr, 64); err == nil {
-		lon = sql.NullFloat64{Float64: v, Valid: true}
+	enc, err := sealAddress(in, latStr, lonStr)
+	if err != nil {
+		return err
 	}
 
 	// Check if a primary address already exists for this user
 	var existingGeoID string
-	err := db.QueryRowContext(ctx, "SELECT geo_id FROM Geo WHERE user_id = ? AND is_primary = 1", in.UserID).Scan(&existingGeoID)
+	err = db.QueryRowContext(ctx, "SELECT geo_id FROM Geo WHERE user_id = ? AND is_primary = 1", in.UserID).Scan(&existingGeoID)
 
 	isPrimary := 0
 	if err == sql.ErrNoRows {
@@ -392,13 +415,13 @@ INSERT INTO Geo (
 	_, err = db.ExecContext(ctx, q,
 		in.UserID,
 		strings.ToUpper(strings.TrimSpace(in.CountryCode)),
-		in.PostalCode,
+		enc.PostalCode,
 		in.Prefecture,
 		in.City,
-		in.Town,
-		in.BuildingName,
-		in.RoomNumber,
-		lat, lon,
+		enc.Town,
+		enc.BuildingName,
+		enc.RoomNumber,
+		enc.Latitude, enc.Longitude,
 		isPrimary,
 	)
 	return err
@@ -408,13 +431,12 @@ func geocodeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		userID := r.URL.Query().Get("user_id")
 		if userID == "" {
-			http.Error(w, "user_id required", http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("user_id required").Field("user_id", "is required"))
 			return
 		}
-		attrs, err := getUserProfile(userID)
+		attrs, err := getUserProfile(r.Context(), userID)
 		if err != nil {
-			log.Printf("Get profile error: %v", err)
-			http.Error(w, "internal error", http.StatusInternalServerError)
+			apierr.Write(w, r, apierr.Internal("failed to load profile", err))
 			return
 		}
 		if attrs == nil {
@@ -426,13 +448,13 @@ func geocodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierr.Write(w, r, apierr.MethodNotAllowed())
 		return
 	}
 
 	var reqBody GeocodeRequest
 	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apierr.Write(w, r, apierr.Validation("Invalid request"))
 		return
 	}
 
@@ -445,15 +467,15 @@ func geocodeHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if token != "" {
-			if uid, err := getUserIDFromTokenString(token); err == nil && uid != "" {
+			if uid, err := getUserIDFromTokenString(r.Context(), token); err == nil && uid != "" {
 				reqBody.UserID = uid
 			}
 		}
 	}
 
 	if reqBody.PhoneNumber != "" || reqBody.CountryCode != "" {
-		if err := updateUserPhoneNumber(reqBody.UserID, reqBody.PhoneNumber, reqBody.CountryCode); err != nil {
-			log.Printf("Phone update error: %v", err)
+		if err := updateUserPhoneNumber(r.Context(), reqBody.UserID, reqBody.PhoneNumber, reqBody.CountryCode); err != nil {
+			logging.From(r.Context()).Error("Phone update error", zap.Error(err))
 		}
 	}
 
@@ -464,31 +486,28 @@ func geocodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	params := buildParams(reqBody)
-	lat, lon, found, err := geocodeOnce(params)
+	lat, lon, found, err := geocodeOnce(r.Context(), params)
 	if err != nil {
-		log.Printf("Geocode error: %v", err)
+		logging.From(r.Context()).Error("Geocode error", zap.Error(err))
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 	if !found && strings.ToLower(reqBody.CountryCode) == "jp" && strings.TrimSpace(reqBody.PostalCode) != "" {
 		params.Set("postalcode", strings.TrimSpace(reqBody.PostalCode))
 		time.Sleep(1100 * time.Millisecond)
-		lat, lon, found, err = geocodeOnce(params)
+		lat, lon, found, err = geocodeOnce(r.Context(), params)
 		if err != nil {
-			log.Printf("Geocode fallback error: %v", err)
+			logging.From(r.Context()).Warn("Geocode fallback error", zap.Error(err))
 			w.WriteHeader(http.StatusOK)
 			return
 		}
 	}
-	if found {
-		url := fmt.Sprintf("https://www.google.com/maps?q=%s,%s", lat, lon)
-		fmt.Println("Geocode Result:", url)
-	} else {
-		fmt.Println("Geocode Result: NOT_FOUND")
+	if !found {
+		logf(r.Context(), "Geocode result: not found (country=%s)", reqBody.CountryCode)
 	}
 
-	if err := insertGeo(reqBody, lat, lon); err != nil {
-		log.Printf("DB insert error: %v", err)
+	if err := insertGeo(r.Context(), reqBody, lat, lon); err != nil {
+		logging.From(r.Context()).Error("DB insert error", zap.Error(err))
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -496,102 +515,32 @@ func geocodeHandler(w http.ResponseWriter, r *http.Request) {
 
 // ===== Auth helpers =====
 
-func buildJWKSURL() (string, error) {
-	if v := strings.TrimSpace(os.Getenv("KEYCLOAK_JWKS_URL")); v != "" {
-		return v, nil
-	}
-
-	base := strings.TrimSpace(os.Getenv("KEYCLOAK_BASE_URL"))
-	if base == "" {
-		base = "http://uam-service.default.svc.cluster.local"
-	}
-	realm := strings.TrimSpace(os.Getenv("KEYCLOAK_REALM"))
-	if realm == "" {
-		realm = "mockten-realm-dev"
-	}
-
-	base = strings.TrimRight(base, "/")
-	if realm == "" {
-		return "", errors.New("KEYCLOAK_REALM is empty")
-	}
-
-	return base + "/realms/" + realm + "/protocol/openid-connect/certs", nil
-}
-
-func initJWKS(jwksURL string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	jwksCancel = cancel
-
-	k, err := keyfunc.NewDefaultCtx(ctx, []string{jwksURL})
-	if err != nil {
-		return err
-	}
-	jwks = k
-	return nil
-}
-
-func jwtHeaderInfo(tokenStr string) (string, string) {
-	p := strings.Split(tokenStr, ".")
-	if len(p) < 2 {
-		return "", ""
-	}
-
-	b, err := base64.RawURLEncoding.DecodeString(p[0])
+// getUserIDFromTokenString verifies tokenStr with authn (signature, issuer,
+// audience and the shared denylist) and returns its email, preferred_username
+// or sub, adding it to the request's log lines. Impersonation tokens are
+// refused: the token may come from a query string, which cannot be audited.
+func getUserIDFromTokenString(ctx context.Context, tokenStr string) (string, error) {
+	uid, err := authn.UserIDFromTokenCtx(ctx, tokenStr)
 	if err != nil {
-		return "", ""
-	}
-
-	var m map[string]any
-	if err := json.Unmarshal(b, &m); err != nil {
-		return "", ""
+		logging.From(ctx).Warn("JWT rejected", zap.Error(err))
+		return "", err
 	}
-
-	kid, _ := m["kid"].(string)
-	alg, _ := m["alg"].(string)
-	return strings.TrimSpace(kid), strings.TrimSpace(alg)
+	logging.SetUser(ctx, uid)
+	return uid, nil
 }
 
-func getUserIDFromTokenString(tokenStr string) (string, error) {
-	kid, alg := jwtHeaderInfo(tokenStr)
-
-	parser := jwt.NewParser(
-		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
-		jwt.WithLeeway(30*time.Second),
-	)
-
-	var claims jwt.MapClaims
-	tok, err := parser.ParseWithClaims(tokenStr, &claims, jwks.Keyfunc)
-	if err != nil {
-		log.Printf("JWT parse failed kid=%s alg=%s err=%v", kid, alg, err)
-		return "", err
-	}
-	if !tok.Valid {
-		log.Printf("JWT invalid kid=%s alg=%s", kid, alg)
-		return "", errors.New("invalid token")
-	}
+// ===== Shipping helpers (DB) =====
 
-	if v, ok := claims["email"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
+// lookupError is a failed Product / Geo lookup: not found when there is no
+// such row, an internal error otherwise.
+func lookupError(message string, err error) *apierr.Error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return apierr.NotFound(message)
 	}
-	if v, ok := claims["preferred_username"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
-	}
-	if v, ok := claims["sub"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
-	}
-
-	return "", errors.New("no usable user identifier in token claims")
+	return apierr.Internal(message, err)
 }
 
-// ===== Shipping helpers (DB) =====
-
-func getProductLocation(productID string) (*Product, error) {
+func getProductLocation(ctx context.Context, productID string) (*Product, error) {
 	q := `
 SELECT p.geo_id, g.country_code, g.latitude, g.longitude
 FROM Product p
@@ -599,35 +548,42 @@ JOIN Geo g ON p.geo_id = g.geo_id
 WHERE p.product_id = ?
 `
 	var p Product
-	err := db.QueryRow(q, productID).Scan(&p.GeoID, &p.Country, &p.Latitude, &p.Longitude)
+	var lat, lon sql.NullString
+	err := db.QueryRowContext(ctx, q, productID).Scan(&p.GeoID, &p.Country, &lat, &lon)
 	if err != nil {
 		return nil, err
 	}
+	if p.Latitude, _, err = openCoord("latitude", lat); err != nil {
+		return nil, err
+	}
+	if p.Longitude, _, err = openCoord("longitude", lon); err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
 
-func getUserLocation(userID string) (*UserGeo, error) {
+func getUserLocation(ctx context.Context, userID string) (*UserGeo, error) {
 	q := `
 SELECT country_code, latitude, longitude
 FROM Geo
 WHERE user_id = ? AND is_primary = 1
 `
 	var g UserGeo
-	var lat, lon sql.NullFloat64
-	err := db.QueryRow(q, userID).Scan(&g.Country, &lat, &lon)
+	var lat, lon sql.NullString
+	err := db.QueryRowContext(ctx, q, userID).Scan(&g.Country, &lat, &lon)
 	if err != nil {
 		return nil, err
 	}
-	if lat.Valid {
-		g.Latitude = lat.Float64
+	if g.Latitude, _, err = openCoord("latitude", lat); err != nil {
+		return nil, err
 	}
-	if lon.Valid {
-		g.Longitude = lon.Float64
+	if g.Longitude, _, err = openCoord("longitude", lon); err != nil {
+		return nil, err
 	}
 	return &g, nil
 }
 
-func getGeoLocationByID(geoID string) (*UserGeo, error) {
+func getGeoLocationByID(ctx context.Context, geoID string) (*UserGeo, error) {
 	q := `
 SELECT user_id, country_code, latitude, longitude
 FROM Geo
@@ -635,20 +591,28 @@ WHERE geo_id = ?
 `
 	var g UserGeo
 	var userID string
-	var lat, lon sql.NullFloat64
-	err := db.QueryRow(q, geoID).Scan(&userID, &g.Country, &lat, &lon)
+	var latCT, lonCT sql.NullString
+	err := db.QueryRowContext(ctx, q, geoID).Scan(&userID, &g.Country, &latCT, &lonCT)
+	if err != nil {
+		return nil, err
+	}
+	lat, latOK, err := openCoord("latitude", latCT)
+	if err != nil {
+		return nil, err
+	}
+	lon, lonOK, err := openCoord("longitude", lonCT)
 	if err != nil {
 		return nil, err
 	}
 
-	if lat.Valid && lon.Valid {
-		g.Latitude = lat.Float64
-		g.Longitude = lon.Float64
+	if latOK && lonOK {
+		g.Latitude = lat
+		g.Longitude = lon
 		return &g, nil
 	}
 
 	// Fallback to Primary Address coordinates if explicit node lacked valid geolocation resolving
-	primaryNode, pErr := getUserLocation(userID)
+	primaryNode, pErr := getUserLocation(ctx, userID)
 	if pErr == nil {
 		g.Latitude = primaryNode.Latitude
 		g.Longitude = primaryNode.Longitude
@@ -657,19 +621,16 @@ WHERE geo_id = ?
 	return &g, nil
 }
 
-// feeScale uniformly scales every shipping fee (road legs + air/sea freight) so
-// the customer-facing quotes stay in a realistic range for a demo storefront.
-// Applied at the source of each fee so the cheaper-route comparisons and the
-// derived delivery-day estimates remain consistent.
-const feeScale = 0.1
+// feeScale is Config.FeeScale, which can be changed without a restart.
+func feeScale() float64 { return conf().FeeScale }
 
-func getShippingRates(country string, distance float64) (float64, float64, error) {
+func getShippingRates(ctx context.Context, country string, distance float64) (float64, float64, error) {
 	q := `
 SELECT shipping_type, rate_per_10km
 FROM ShippingRate
 WHERE country_code = ?
 `
-	rows, err := db.Query(q, strings.ToUpper(country))
+	rows, err := db.QueryContext(ctx, q, strings.ToUpper(country))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -702,17 +663,17 @@ WHERE country_code = ?
 	if express > capExpress {
 		express = capExpress
 	}
-	return round2(standard * feeScale), round2(express * feeScale), nil
+	return round2(standard * feeScale()), round2(express * feeScale()), nil
 }
 
-func getClosestAirport(country string, lat, lon float64) (Node, float64, error) {
+func getClosestAirport(ctx context.Context, country string, lat, lon float64) (Node, float64, error) {
 	q := `
 SELECT user_id, latitude, longitude
 FROM Geo
 WHERE UPPER(country_code) = ?
-  AND building_name LIKE '%Airport%'
+  AND node_type = 'airport'
 `
-	rows, err := db.Query(q, strings.ToUpper(country))
+	rows, err := db.QueryContext(ctx, q, strings.ToUpper(country))
 	if err != nil {
 		return Node{}, 0, err
 	}
@@ -721,8 +682,8 @@ WHERE UPPER(country_code) = ?
 	var closest Node
 	min := math.MaxFloat64
 	for rows.Next() {
-		var n Node
-		if err := rows.Scan(&n.ID, &n.Latitude, &n.Longitude); err != nil {
+		n, err := scanNode(rows)
+		if err != nil {
 			continue
 		}
 		d := distanceKM(lat, lon, n.Latitude, n.Longitude)
@@ -734,14 +695,14 @@ WHERE UPPER(country_code) = ?
 	return closest, min, nil
 }
 
-func getClosestPort(country string, lat, lon float64) (Node, float64, error) {
+func getClosestPort(ctx context.Context, country string, lat, lon float64) (Node, float64, error) {
 	q := `
 SELECT user_id, latitude, longitude
 FROM Geo
 WHERE UPPER(country_code) = ?
-  AND (building_name LIKE '%Port%' OR building_name LIKE '%Terminal%')
+  AND node_type = 'port'
 `
-	rows, err := db.Query(q, strings.ToUpper(country))
+	rows, err := db.QueryContext(ctx, q, strings.ToUpper(country))
 	if err != nil {
 		return Node{}, 0, err
 	}
@@ -750,8 +711,8 @@ WHERE UPPER(country_code) = ?
 	var closest Node
 	min := math.MaxFloat64
 	for rows.Next() {
-		var n Node
-		if err := rows.Scan(&n.ID, &n.Latitude, &n.Longitude); err != nil {
+		n, err := scanNode(rows)
+		if err != nil {
 			continue
 		}
 		d := distanceKM(lat, lon, n.Latitude, n.Longitude)
@@ -763,46 +724,60 @@ WHERE UPPER(country_code) = ?
 	return closest, min, nil
 }
 
-func getDomesticFlightFee(originCode, destCode string) (float64, error) {
+func scanNode(rows *sql.Rows) (Node, error) {
+	var n Node
+	var lat, lon sql.NullString
+	if err := rows.Scan(&n.ID, &lat, &lon); err != nil {
+		return n, err
+	}
+	var err error
+	if n.Latitude, _, err = openCoord("latitude", lat); err != nil {
+		return n, err
+	}
+	n.Longitude, _, err = openCoord("longitude", lon)
+	return n, err
+}
+
+func getDomesticFlightFee(ctx context.Context, originCode, destCode string) (float64, error) {
 	q := `
 SELECT cost_usd
 FROM DomesticAirCost
 WHERE origin = ? AND destination = ?
 `
 	var fee float64
-	err := db.QueryRow(q, originCode, destCode).Scan(&fee)
+	err := db.QueryRowContext(ctx, q, originCode, destCode).Scan(&fee)
 	if err != nil {
 		return 0, err
 	}
-	return round2(fee * feeScale), nil
+	return round2(fee * feeScale()), nil
 }
 
-func getInternationalAirFee(originAirport, destAirport string) (float64, error) {
+func getInternationalAirFee(ctx context.Context, originAirport, destAirport string) (float64, error) {
 	q := `
 SELECT cost_usd
 FROM AirCost
 WHERE origin = ? AND destination = ?
 `
 	var fee float64
-	err := db.QueryRow(q, strings.ToUpper(originAirport), strings.ToUpper(destAirport)).Scan(&fee)
+	err := db.QueryRowContext(ctx, q, strings.ToUpper(originAirport), strings.ToUpper(destAirport)).Scan(&fee)
 	if err != nil {
 		return 0, err
 	}
-	return round2(fee * feeScale), nil
+	return round2(fee * feeScale()), nil
 }
 
-func getSeaFreightFee(originCountry, destCountry string) (float64, error) {
+func getSeaFreightFee(ctx context.Context, originCountry, destCountry string) (float64, error) {
 	q := `
 SELECT cost_usd
 FROM SeaCost
 WHERE origin_country_code = ? AND destination_country_code = ?
 `
 	var fee float64
-	err := db.QueryRow(q, strings.ToUpper(originCountry), strings.ToUpper(destCountry)).Scan(&fee)
+	err := db.QueryRowContext(ctx, q, strings.ToUpper(originCountry), strings.ToUpper(destCountry)).Scan(&fee)
 	if err != nil {
 		return 0, err
 	}
-	return round2(fee * feeScale), nil
+	return round2(fee * feeScale()), nil
 }
 
 // ===== Shipping math / utils =====
@@ -818,6 +793,14 @@ func distanceKM(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
+// fee formats a route fee for the quote log; +Inf marks an unavailable route.
+func fee(v float64) string {
+	if math.IsInf(v, 1) {
+		return "n/a"
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
 func round2(v float64) float64 {
 	r, _ := strconv.ParseFloat(fmt.Sprintf("%.2f", v), 64)
 	return r
@@ -825,6 +808,13 @@ func round2(v float64) float64 {
 
 // ===== Shipping handlers =====
 
+// logf logs on the request's logger, whose lines carry the request and trace
+// ids, so a quote in the log can be matched to its trace (and the other
+// services' logs for the same request).
+func logf(ctx context.Context, format string, args ...any) {
+	logging.From(ctx).Info(fmt.Sprintf(format, args...))
+}
+
 func shippingHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user_id")
 	geoID := r.URL.Query().Get("geo_id")
@@ -840,95 +830,83 @@ func shippingHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Fallback to token if userID is missing
 	if userID == "" && token != "" {
-		if uid, err := getUserIDFromTokenString(token); err == nil && uid != "" {
+		if uid, err := getUserIDFromTokenString(r.Context(), token); err == nil && uid != "" {
 			userID = uid
 		} else {
-			log.Printf("Failed to derive user_id from token: %v", err)
+			logging.From(r.Context()).Warn("Failed to derive user_id from token", zap.Error(err))
 		}
 	}
 
 	if (userID == "" && geoID == "") || productID == "" {
-		http.Error(w, "Missing identifier or product_id", http.StatusBadRequest)
+		apierr.Write(w, r, apierr.Validation("Missing identifier or product_id"))
 		return
 	}
 
-	product, err := getProductLocation(productID)
+	product, err := getProductLocation(r.Context(), productID)
 	if err != nil {
-		http.Error(w, "Product not found", http.StatusInternalServerError)
+		apierr.Write(w, r, lookupError("Product not found", err))
 		return
 	}
 
 	var user *UserGeo
 	if geoID != "" {
-		user, err = getGeoLocationByID(geoID)
+		user, err = getGeoLocationByID(r.Context(), geoID)
 		if err != nil {
-			http.Error(w, "Geo location not found", http.StatusInternalServerError)
+			apierr.Write(w, r, lookupError("Geo location not found", err))
 			return
 		}
 	} else {
-		user, err = getUserLocation(userID)
+		user, err = getUserLocation(r.Context(), userID)
 		if err != nil {
-			http.Error(w, "User location not found", http.StatusInternalServerError)
+			apierr.Write(w, r, lookupError("User location not found", err))
 			return
 		}
 	}
 
 	if !strings.EqualFold(product.Country, user.Country) {
-		handleInternational(w, product, user)
+		handleInternational(r.Context(), w, product, user)
 		return
 	}
-	handleDomestic(w, product, user)
+	handleDomestic(r.Context(), w, product, user)
 }
 
-func handleDomestic(w http.ResponseWriter, product *Product, user *UserGeo) {
-	fmt.Println("Domestic delivery")
-
+func handleDomestic(ctx context.Context, w http.ResponseWriter, product *Product, user *UserGeo) {
 	direct := distanceKM(product.Latitude, product.Longitude, user.Latitude, user.Longitude)
-	origA, dOrigA, _ := getClosestAirport(product.Country, product.Latitude, product.Longitude)
-	destA, dDestA, _ := getClosestAirport(user.Country, user.Latitude, user.Longitude)
-
-	fmt.Printf("Direct distance: %.2f km\n", round2(direct))
-	fmt.Printf("Origin nearest airport: %s (%.2f km)\n", origA.ID, round2(dOrigA))
-	fmt.Printf("Destination nearest airport: %s (%.2f km)\n", destA.ID, round2(dDestA))
+	origA, dOrigA, _ := getClosestAirport(ctx, product.Country, product.Latitude, product.Longitude)
+	destA, dDestA, _ := getClosestAirport(ctx, user.Country, user.Latitude, user.Longitude)
 
 	// Road-only fees
-	roadStd, roadExp, _ := getShippingRates(dbCountry(product.Country), direct)
-	fmt.Printf("Road only standard fee: %.2f USD\n", roadStd)
-	fmt.Printf("Road only express fee: %.2f USD\n", roadExp)
+	roadStd, roadExp, _ := getShippingRates(ctx, dbCountry(product.Country), direct)
 
 	// Domestic air: disable if same airport (no domestic flight)
 	hasDomestic := true
 	var dAir float64
 	if strings.EqualFold(origA.ID, destA.ID) {
 		hasDomestic = false
-		fmt.Printf("Domestic air not applicable (same airport: %s)\n", origA.ID)
 	} else {
-		df, err := getDomesticFlightFee(origA.ID, destA.ID)
+		df, err := getDomesticFlightFee(ctx, origA.ID, destA.ID)
 		if err != nil {
 			hasDomestic = false
-			fmt.Printf("Domestic flight cost not found for %s -> %s\n", origA.ID, destA.ID)
+			logf(ctx, "Domestic flight cost not found for %s -> %s", origA.ID, destA.ID)
 		} else {
 			dAir = df
-			fmt.Printf("Domestic Flight Fee (%s-%s): %.2f USD\n", origA.ID, destA.ID, round2(dAir))
 		}
 	}
 
 	// First/last-mile road legs to/from airport
-	origStd, origExp, _ := getShippingRates(dbCountry(product.Country), dOrigA)
-	destStd, destExp, _ := getShippingRates(dbCountry(user.Country), dDestA)
+	origStd, origExp, _ := getShippingRates(ctx, dbCountry(product.Country), dOrigA)
+	destStd, destExp, _ := getShippingRates(ctx, dbCountry(user.Country), dDestA)
 
 	var airStd, airExp float64
 	if hasDomestic {
 		airStd = round2(origStd + dAir + destStd)
 		airExp = round2(origExp + dAir + destExp)
-		fmt.Printf("Air route standard fee: %.2f USD\n", airStd)
-		fmt.Printf("Air route express fee: %.2f USD\n", airExp)
 	} else {
 		airStd = math.Inf(1)
 		airExp = math.Inf(1)
-		fmt.Println("Air route standard fee: n/a")
-		fmt.Println("Air route express fee: n/a")
 	}
+	logf(ctx, "Domestic quote: direct %.2f km, airports %s (%.2f km) -> %s (%.2f km), road %s/%s USD, air %s/%s USD",
+		direct, origA.ID, dOrigA, destA.ID, dDestA, fee(roadStd), fee(roadExp), fee(airStd), fee(airExp))
 
 	finalStd := math.Min(roadStd, airStd)
 	finalExp := math.Min(roadExp, airExp)
@@ -957,66 +935,46 @@ func handleDomestic(w http.ResponseWriter, product *Product, user *UserGeo) {
 
 func dbCountry(cc string) string { return strings.ToUpper(cc) }
 
-func handleInternational(w http.ResponseWriter, product *Product, user *UserGeo) {
-	fmt.Println("Cross-border delivery")
-
+func handleInternational(ctx context.Context, w http.ResponseWriter, product *Product, user *UserGeo) {
 	direct := distanceKM(product.Latitude, product.Longitude, user.Latitude, user.Longitude)
-	fmt.Printf("Direct distance: %.2f km\n", round2(direct))
 
 	// Sea leg
-	origPort, dOrigP, _ := getClosestPort(product.Country, product.Latitude, product.Longitude)
-	destPort, dDestP, _ := getClosestPort(user.Country, user.Latitude, user.Longitude)
-	fmt.Printf("Origin nearest port: %s (%.2f km)\n", origPort.ID, round2(dOrigP))
-	fmt.Printf("Destination nearest port: %s (%.2f km)\n", destPort.ID, round2(dDestP))
+	origPort, dOrigP, _ := getClosestPort(ctx, product.Country, product.Latitude, product.Longitude)
+	destPort, dDestP, _ := getClosestPort(ctx, user.Country, user.Latitude, user.Longitude)
 
-	seaCost, seaErr := getSeaFreightFee(product.Country, user.Country)
+	seaCost, seaErr := getSeaFreightFee(ctx, product.Country, user.Country)
 	if seaErr != nil {
-		fmt.Printf("Sea freight cost not found for %s -> %s\n", strings.ToUpper(product.Country), strings.ToUpper(user.Country))
+		logf(ctx, "Sea freight cost not found for %s -> %s", strings.ToUpper(product.Country), strings.ToUpper(user.Country))
 	}
-	if seaErr == nil {
-		fmt.Printf("Sea Freight Fee (%s->%s): %.2f USD\n", strings.ToUpper(product.Country), strings.ToUpper(user.Country), round2(seaCost))
-	}
-	origPortStd, origPortExp, _ := getShippingRates(product.Country, dOrigP)
-	destPortStd, destPortExp, _ := getShippingRates(user.Country, dDestP)
+	origPortStd, origPortExp, _ := getShippingRates(ctx, product.Country, dOrigP)
+	destPortStd, destPortExp, _ := getShippingRates(ctx, user.Country, dDestP)
 
 	seaStd := math.Inf(1)
 	seaExp := math.Inf(1)
 	if seaErr == nil {
 		seaStd = round2(origPortStd + seaCost + destPortStd)
 		seaExp = round2(origPortExp + seaCost + destPortExp)
-		fmt.Printf("Sea route standard fee: %.2f USD\n", seaStd)
-		fmt.Printf("Sea route express fee: %.2f USD\n", seaExp)
-	} else {
-		fmt.Println("Sea route standard fee: n/a")
-		fmt.Println("Sea route express fee: n/a")
 	}
 
 	// Air leg
-	origA, dOrigA, _ := getClosestAirport(product.Country, product.Latitude, product.Longitude)
-	destA, dDestA, _ := getClosestAirport(user.Country, user.Latitude, user.Longitude)
-	fmt.Printf("Origin nearest airport: %s (%.2f km)\n", origA.ID, round2(dOrigA))
-	fmt.Printf("Destination nearest airport: %s (%.2f km)\n", destA.ID, round2(dDestA))
+	origA, dOrigA, _ := getClosestAirport(ctx, product.Country, product.Latitude, product.Longitude)
+	destA, dDestA, _ := getClosestAirport(ctx, user.Country, user.Latitude, user.Longitude)
 
-	airCost, airErr := getInternationalAirFee(origA.ID, destA.ID)
+	airCost, airErr := getInternationalAirFee(ctx, origA.ID, destA.ID)
 	if airErr != nil {
-		fmt.Printf("International air cost not found for %s -> %s\n", origA.ID, destA.ID)
-	} else {
-		fmt.Printf("International Air Fee (%s-%s): %.2f USD\n", origA.ID, destA.ID, round2(airCost))
+		logf(ctx, "International air cost not found for %s -> %s", origA.ID, destA.ID)
 	}
-	origAirStd, origAirExp, _ := getShippingRates(product.Country, dOrigA)
-	destAirStd, destAirExp, _ := getShippingRates(user.Country, dDestA)
+	origAirStd, origAirExp, _ := getShippingRates(ctx, product.Country, dOrigA)
+	destAirStd, destAirExp, _ := getShippingRates(ctx, user.Country, dDestA)
 
 	airStd := math.Inf(1)
 	airExp := math.Inf(1)
 	if airErr == nil {
 		airStd = round2(origAirStd + airCost + destAirStd)
 		airExp = round2(origAirExp + airCost + destAirExp)
-		fmt.Printf("Air route standard fee: %.2f USD\n", airStd)
-		fmt.Printf("Air route express fee: %.2f USD\n", airExp)
-	} else {
-		fmt.Println("Air route standard fee: n/a")
-		fmt.Println("Air route express fee: n/a")
 	}
+	logf(ctx, "Cross-border quote: direct %.2f km, ports %s (%.2f km) -> %s (%.2f km), airports %s (%.2f km) -> %s (%.2f km), sea %s/%s USD, air %s/%s USD",
+		direct, origPort.ID, dOrigP, destPort.ID, dDestP, origA.ID, dOrigA, destA.ID, dDestA, fee(seaStd), fee(seaExp), fee(airStd), fee(airExp))
 
 	resp := ShippingInternationalResponse{}
 	if !math.IsInf(airStd, 1) {
@@ -1045,7 +1003,7 @@ func getGeoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPut {
 		var reqBody GeocodeRequest
 		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("Invalid request"))
 			return
 		}
 
@@ -1058,26 +1016,25 @@ func getGeoHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 			if token != "" {
-				if uid, err := getUserIDFromTokenString(token); err == nil && uid != "" {
+				if uid, err := getUserIDFromTokenString(r.Context(), token); err == nil && uid != "" {
 					reqBody.UserID = uid
 				}
 			}
 		}
 
 		if reqBody.GeoID == "" {
-			http.Error(w, "Missing geo_id for update", http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("Missing geo_id for update").Field("geo_id", "is required"))
 			return
 		}
 
 		params := buildParams(reqBody)
-		lat, lon, _, err := geocodeOnce(params)
+		lat, lon, _, err := geocodeOnce(r.Context(), params)
 		if err != nil {
-			log.Printf("Geocode error: %v", err)
+			logging.From(r.Context()).Error("Geocode error", zap.Error(err))
 		}
 
-		if err := updateGeo(reqBody, lat, lon); err != nil {
-			log.Printf("DB update error: %v", err)
-			http.Error(w, "Database error", http.StatusInternalServerError)
+		if err := updateGeo(r.Context(), reqBody, lat, lon); err != nil {
+			apierr.Write(w, r, apierr.Internal("Database error", err))
 			return
 		}
 
@@ -1097,29 +1054,27 @@ func getGeoHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Fallback to token if userID is missing
 	if userID == "" && token != "" {
-		if uid, err := getUserIDFromTokenString(token); err == nil && uid != "" {
+		if uid, err := getUserIDFromTokenString(r.Context(), token); err == nil && uid != "" {
 			userID = uid
 		} else {
-			log.Printf("Failed to derive user_id from token: %v", err)
+			logging.From(r.Context()).Warn("Failed to derive user_id from token", zap.Error(err))
 		}
 	}
 
 	if userID == "" {
-		http.Error(w, "Missing user_id", http.StatusBadRequest)
+		apierr.Write(w, r, apierr.Validation("Missing user_id").Field("user_id", "is required"))
 		return
 	}
 
 	q := `
-SELECT TRIM(CONCAT(COALESCE(ue.FIRST_NAME, ''), ' ', COALESCE(ue.LAST_NAME, ''))) as user_name, g.geo_id, g.is_primary, COALESCE(g.country_code, '') as country_code, COALESCE(g.postal_code, '') as postal_code, COALESCE(g.prefecture, '') as prefecture, COALESCE(g.city, '') as city, COALESCE(g.town, '') as town, COALESCE(g.building_name, '') as building_name, COALESCE(g.room_number, '') as room_number
+SELECT g.geo_id, g.is_primary, COALESCE(g.country_code, '') as country_code, g.postal_code, COALESCE(g.prefecture, '') as prefecture, COALESCE(g.city, '') as city, g.town, g.building_name, g.room_number
 FROM Geo g
-LEFT JOIN USER_ENTITY ue ON g.user_id = ue.EMAIL
 WHERE g.user_id = ?
 ORDER BY g.is_primary DESC, g.geo_id ASC
 `
-	rows, err := db.Query(q, userID)
+	rows, err := db.QueryContext(r.Context(), q, userID)
 	if err != nil {
-		log.Printf("DB query error: %v", err)
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		apierr.Write(w, r, apierr.Internal("Database error", err))
 		return
 	}
 	defer rows.Close()
@@ -1127,26 +1082,41 @@ ORDER BY g.is_primary DESC, g.geo_id ASC
 	var responses []GeoResponse
 	for rows.Next() {
 		var g GeoResponse
+		var postal, town, building, room sql.NullString
 		err := rows.Scan(
-			&g.UserName, &g.GeoID, &g.IsPrimary, &g.CountryCode, &g.PostalCode, &g.Prefecture,
-			&g.City, &g.Town, &g.BuildingName, &g.RoomNumber,
+			&g.GeoID, &g.IsPrimary, &g.CountryCode, &postal, &g.Prefecture,
+			&g.City, &town, &building, &room,
 		)
 		if err != nil {
-			log.Printf("Row scan error: %v", err)
+			logging.From(r.Context()).Error("Row scan error", zap.Error(err))
+			continue
+		}
+		if err := openAddress(&g, postal, town, building, room); err != nil {
+			logging.From(r.Context()).Warn("Geo decryption error", zap.String("geo_id", g.GeoID), zap.Error(err))
 			continue
 		}
 		responses = append(responses, g)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Rows error: %v", err)
+		logging.From(r.Context()).Error("Rows error", zap.Error(err))
 	}
 
 	if len(responses) == 0 {
-		http.Error(w, "User geo data not found", http.StatusNotFound)
+		apierr.Write(w, r, apierr.NotFound("User geo data not found"))
 		return
 	}
 
+	var userName string
+	if p, err := profiles.Profile(r.Context(), userID); err == nil {
+		userName = p.FullName()
+	} else if !errors.Is(err, keycloak.ErrNotFound) {
+		logging.From(r.Context()).Warn("Keycloak profile error", zap.Error(err))
+	}
+	for i := range responses {
+		responses[i].UserName = userName
+	}
+
 	writeJSON(w, http.StatusOK, responses)
 }
 
@@ -1159,29 +1129,71 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 }
 
 func main() {
+	logger := logging.Init("geocoding", logging.Options{})
+	defer func() { _ = logger.Sync() }()
 	loadConfig("config.json")
 
-	jwksURL, err := buildJWKSURL()
+	shutdownTracing, err := tracing.Init(context.Background(), "geocoding")
 	if err != nil {
-		log.Fatalf("failed to build jwks url: %v", err)
+		log.Fatalf("failed to set up tracing: %v", err)
 	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	jwksURL := conf().Keycloak.JWKSURL()
 	log.Printf("JWKS URL: %s", jwksURL)
 
-	if err := initJWKS(jwksURL); err != nil {
-		log.Fatalf("failed to init jwks: %v", err)
+	initDBWait()
+
+	rc := conf().Redis
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         rc.Addr,
+		Password:     rc.Password.Value(),
+		DB:           rc.DB,
+		PoolSize:     2,
+		MinIdleConns: 1,
+	})
+	defer rdb.Close()
+	tracing.InstrumentRedis(rdb)
+
+	authn, err = commonauth.NewAuthenticatorFromEnv(commonauth.Options{
+		Logger:   logger,
+		JWKSURL:  jwksURL,
+		Denylist: commonauth.NewRedisDenylist(rdb),
+	})
+	if err != nil {
+		log.Fatalf("failed to init authenticator: %v", err)
 	}
-	defer func() {
-		if jwksCancel != nil {
-			jwksCancel()
-		}
-	}()
+	defer authn.Close()
 
-	initDBWait()
+	keyring, err = fieldcrypt.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load field encryption keys: %v", err)
+	}
+	startGeoRotation()
+
+	kc, err := keycloak.NewClientFromEnv()
+	if err != nil {
+		log.Printf("keycloak admin client disabled; phone numbers cannot be saved: %v", err)
+	} else {
+		profiles = keycloak.NewProfileCache(kc, 5*time.Minute)
+	}
 
 	http.HandleFunc("/profile", geocodeHandler)
 	http.HandleFunc("/shipping", shippingHandler)
 	http.HandleFunc("/geo", getGeoHandler)
+	health.New("geocoding").
+		Critical("mysql", health.SQL(db)).
+		Optional("nominatim", health.Cached(checkNominatim, time.Minute)).
+		Optional("redis", health.Redis(rdb)).
+		Optional("jwks", authn.CheckJWKS).
+		Mount(http.DefaultServeMux)
+
+	go func() {
+		if err := metrics.Serve(":9100"); err != nil {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
 
-	fmt.Println("Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Println("Server started at :8080")
+	log.Fatal(http.ListenAndServe(":8080", tracing.Handler(logging.Handler(metrics.Handler(http.DefaultServeMux, "geocoding"), logger), "geocoding")))
 }
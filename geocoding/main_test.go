@@ -1,21 +1,105 @@
 package main
 
 import (
-	"regexp"
+	"context"
+	"database/sql"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/fieldcrypt"
+	"github.com/mockten/mockten/common/keycloak"
+	"github.com/mockten/mockten/common/keycloak/keycloaktest"
 )
 
-func TestGenerateUUID(t *testing.T) {
-	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
-	seen := map[string]bool{}
-	for i := 0; i < 100; i++ {
-		u := generateUUID()
-		if !re.MatchString(u) {
-			t.Fatalf("generateUUID() = %q, not a UUID-shaped string", u)
-		}
-		if seen[u] {
-			t.Fatalf("generateUUID() produced a duplicate: %q", u)
-		}
-		seen[u] = true
+func testKeyring(t *testing.T) {
+	t.Helper()
+	k, err := fieldcrypt.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)}, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring = k
+	t.Cleanup(func() { keyring = nil })
+}
+
+func TestUserPhoneNumberAttributes(t *testing.T) {
+	testKeyring(t)
+	srv := keycloaktest.NewServer(t)
+	id := srv.AddUser(keycloak.User{Email: "a@x.io", Attributes: map[string][]string{"storeName": {"A"}}})
+	profiles = keycloak.NewProfileCache(srv.Client(t), time.Minute)
+	t.Cleanup(func() { profiles = nil })
+
+	if err := updateUserPhoneNumber(context.Background(), "a@x.io", "090-0000-0000", "+81"); err != nil {
+		t.Fatalf("updateUserPhoneNumber: %v", err)
+	}
+	u, _ := srv.User(id)
+	if !fieldcrypt.IsEncrypted(u.Attribute("phoneNumber")) || u.Attribute("countryCode") != "+81" || u.Attribute("storeName") != "A" {
+		t.Fatalf("attributes = %v", u.Attributes)
+	}
+	if u.Attribute("phoneNumberIndex") != keyring.PhoneIndex("09000000000") {
+		t.Fatalf("phoneNumberIndex = %q", u.Attribute("phoneNumberIndex"))
+	}
+
+	attrs, err := getUserProfile(context.Background(), "a@x.io")
+	if err != nil || attrs["phoneNumber"] != "090-0000-0000" || attrs["phoneNumberIndex"] != "" {
+		t.Fatalf("getUserProfile = (%v, %v)", attrs, err)
+	}
+	if attrs, err := getUserProfile(context.Background(), "nobody@x.io"); err != nil || attrs != nil {
+		t.Fatalf("getUserProfile(unknown) = (%v, %v), want (nil, nil)", attrs, err)
+	}
+	if err := updateUserPhoneNumber(context.Background(), "nobody@x.io", "1", ""); err != nil {
+		t.Fatalf("updateUserPhoneNumber(unknown) = %v, want nil", err)
+	}
+}
+
+func TestLegacyPhoneIsReencrypted(t *testing.T) {
+	testKeyring(t)
+	srv := keycloaktest.NewServer(t)
+	id := srv.AddUser(keycloak.User{Email: "b@x.io", Attributes: map[string][]string{"phoneNumber": {"03-1111-2222"}}})
+	profiles = keycloak.NewProfileCache(srv.Client(t), time.Minute)
+	t.Cleanup(func() { profiles = nil })
+
+	attrs, err := getUserProfile(context.Background(), "b@x.io")
+	if err != nil || attrs["phoneNumber"] != "03-1111-2222" {
+		t.Fatalf("getUserProfile = (%v, %v)", attrs, err)
+	}
+	if u, _ := srv.User(id); !fieldcrypt.IsEncrypted(u.Attribute("phoneNumber")) {
+		t.Fatalf("legacy phone left in plaintext: %v", u.Attributes)
+	}
+}
+
+func TestSealAddress(t *testing.T) {
+	testKeyring(t)
+	enc, err := sealAddress(GeocodeRequest{PostalCode: "106-0032", Town: "Roppongi"}, "35.6627", "not a number")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(enc.PostalCode+enc.Town+enc.Latitude.String, "106") || enc.BuildingName != "" || enc.Longitude.Valid {
+		t.Fatalf("sealAddress = %+v", enc)
+	}
+	var g GeoResponse
+	ns := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	if err := openAddress(&g, ns(enc.PostalCode), ns(enc.Town), sql.NullString{}, ns("")); err != nil || g.PostalCode != "106-0032" || g.Town != "Roppongi" {
+		t.Fatalf("openAddress = (%+v, %v)", g, err)
+	}
+	if lat, ok, err := openCoord("latitude", enc.Latitude); err != nil || !ok || lat != 35.6627 {
+		t.Fatalf("openCoord = (%v, %v, %v)", lat, ok, err)
+	}
+	// Seed rows are plaintext until the rotation job reaches them.
+	if lon, ok, err := openCoord("longitude", ns("139.7310")); err != nil || !ok || lon != 139.731 {
+		t.Fatalf("openCoord(plaintext) = (%v, %v, %v)", lon, ok, err)
+	}
+}
+
+func TestShippedConfig(t *testing.T) {
+	l, err := config.NewLive[Config](config.Options{File: "config.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	live = l
+	t.Cleanup(func() { live = nil })
+	if feeScale() != 0.1 || conf().CountryMapping["jp"] != "Japan" || conf().MySQL.Pass.String() == "mocktenpassword" {
+		t.Fatalf("config.json loaded as %s", config.Dump(conf()))
 	}
 }
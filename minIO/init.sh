@@ -13,5 +13,9 @@ mc cp --recursive /photos/ local/photos/
 
 mc anonymous set download local/photos
 
+# Review photos uploaded by buyers (written by product)
+mc mb --ignore-existing local/review-photos
+mc anonymous set download local/review-photos
+
 # Wait indefinitely
 wait
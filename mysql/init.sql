@@ -1,3 +1,8 @@
+-- Schema changes go in common/migrate/migrations, not here. The CREATE TABLEs
+-- below are migration 0001 (the baseline) and must stay identical to it
+-- (TestBaselineMatchesInitSQL checks); they are kept so a fresh container can
+-- load the seed data before any service has run the migrations.
+
 -- Airflow metadata DB (uses same MySQL instance, separate DB)
 CREATE DATABASE IF NOT EXISTS airflow CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;
 GRANT ALL PRIVILEGES ON airflow.* TO 'mocktenusr'@'%';
@@ -34,6 +39,25 @@ CREATE TABLE IF NOT EXISTS Seller (
   last_update DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 );
 
+-- Seller API keys for programmatic access. Only the SHA-256 of the key is
+-- stored; key_prefix is the first few characters, shown so sellers can tell
+-- keys apart. scopes is a comma-separated subset of products:read,
+-- products:write, orders:read, stock:write.
+CREATE TABLE IF NOT EXISTS SellerApiKey (
+  key_id VARCHAR(36) PRIMARY KEY,
+  seller_id VARCHAR(64) NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  key_prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL,
+  scopes VARCHAR(255) NOT NULL,
+  rate_limit_per_min INT NOT NULL DEFAULT 60,
+  last_used_at DATETIME NULL,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  revoked_at DATETIME NULL,
+  UNIQUE KEY uq_apikey_hash (key_hash),
+  INDEX idx_apikey_seller (seller_id)
+);
+
 CREATE TABLE IF NOT EXISTS AuditLog (
   id BIGINT AUTO_INCREMENT PRIMARY KEY,
   action VARCHAR(128) NOT NULL,
@@ -132,20 +156,29 @@ CREATE TABLE IF NOT EXISTS AirCost (
   PRIMARY KEY (origin, destination)
 );
 
+-- postal_code, town, building_name, room_number, latitude and longitude hold
+-- envelope-encrypted values (common/fieldcrypt, "ev1:..."), hence the width and
+-- the string type for coordinates. Seed rows start as plaintext; geocoding
+-- encrypts them in the background on startup. country_code, prefecture and city
+-- stay plaintext because queries filter and group on them.
+-- node_type marks logistics nodes ('airport', 'port') so shipping can find them
+-- without matching on the encrypted building_name.
 CREATE TABLE IF NOT EXISTS Geo (
   geo_id VARCHAR(36) PRIMARY KEY,
   user_id VARCHAR(255) NOT NULL,
   country_code VARCHAR(2),
-  postal_code VARCHAR(36),
+  postal_code VARCHAR(512),
   prefecture VARCHAR(50),
   city VARCHAR(100),
-  town VARCHAR(100),
-  building_name VARCHAR(100),
-  room_number VARCHAR(20),
-  latitude DECIMAL(10,7),
-  longitude DECIMAL(10,7),
+  town VARCHAR(512),
+  building_name VARCHAR(512),
+  room_number VARCHAR(512),
+  latitude VARCHAR(512),
+  longitude VARCHAR(512),
   is_primary TINYINT(1) NOT NULL DEFAULT 0,
-  KEY idx_geo_user_primary (user_id, is_primary)
+  node_type VARCHAR(16) NULL,
+  KEY idx_geo_user_primary (user_id, is_primary),
+  KEY idx_geo_node (country_code, node_type)
 );
 
 CREATE TABLE IF NOT EXISTS `Order` (
@@ -245,6 +278,20 @@ CREATE TABLE IF NOT EXISTS DashboardMetrics (
   KEY idx_dm_created (created_at)
 );
 
+-- Transactional outbox (common/events): domain events written in the same
+-- transaction as the change they describe, published to Redis Streams by the
+-- services' relays.
+CREATE TABLE IF NOT EXISTS Outbox (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  event_type    VARCHAR(64)  NOT NULL,
+  aggregate_id  VARCHAR(255) NOT NULL,
+  payload       JSON         NOT NULL,
+  trace_context VARCHAR(255) NULL,
+  created_at    DATETIME(3)  NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  published_at  DATETIME(3)  NULL,
+  KEY idx_outbox_pending (published_at, id)
+);
+
 INSERT INTO Category (category_id, category_name, category_image)
 VALUES
 ('01', 'Books', '3cd51e99-b274-4d73-85d9-ccd2f5f1b1b7'),
@@ -1667,6 +1714,8 @@ CREATE TABLE IF NOT EXISTS ApiSLA (
   path        VARCHAR(255) NOT NULL,
   sla_ms      INT          NOT NULL COMMENT 'Max acceptable avg response time in ms',
   description VARCHAR(255),
+  service     VARCHAR(32)  NULL COMMENT 'Go service serving the route (RED metrics "service" label); NULL when not measured',
+  route       VARCHAR(255) NULL COMMENT 'Route template inside that service (RED metrics "route" label)',
   PRIMARY KEY (method, path)
 );
 
@@ -1714,6 +1763,33 @@ INSERT INTO ApiSLA (method, path, sla_ms, description) VALUES
 ('GET',  '/api/stats',                         200, 'API gateway telemetry from Kong logs')
 ON DUPLICATE KEY UPDATE sla_ms = VALUES(sla_ms), description = VALUES(description);
 
+-- Where each public route is measured: the service's RED metrics label it with
+-- the route template it registered, which differs from the Kong path. Routes
+-- not served by a Go service (Keycloak, MinIO, recommendation, stats) and
+-- /api/order/history (no such endpoint yet) stay NULL and report "no data".
+-- Checkout is POST /api/payment on ecpay.
+UPDATE ApiSLA SET service = 'searchitem', route = '/v1/search' WHERE method = 'GET' AND path = '/api/search';
+UPDATE ApiSLA SET service = 'searchitem', route = '/v1/categories' WHERE method = 'GET' AND path = '/api/categories';
+UPDATE ApiSLA SET service = 'product', route = '/v1/item/detail/:productId' WHERE method = 'GET' AND path = '/api/item/detail/:id';
+UPDATE ApiSLA SET service = 'cart', route = '/v1/cart/' WHERE method = 'GET' AND path = '/api/cart';
+UPDATE ApiSLA SET service = 'cart', route = '/v1/cart/items' WHERE method = 'POST' AND path = '/api/cart/items';
+UPDATE ApiSLA SET service = 'cart', route = '/v1/cart/items/:productId' WHERE method = 'PUT' AND path = '/api/cart/items/:id';
+UPDATE ApiSLA SET service = 'cart', route = '/v1/cart/items/:productId' WHERE method = 'DELETE' AND path = '/api/cart/items/:id';
+UPDATE ApiSLA SET service = 'product', route = '/v1/fav' WHERE method = 'GET' AND path = '/api/fav';
+UPDATE ApiSLA SET service = 'product', route = '/v1/fav/:productId' WHERE method = 'POST' AND path = '/api/fav/:id';
+UPDATE ApiSLA SET service = 'product', route = '/v1/fav/:productId' WHERE method = 'DELETE' AND path = '/api/fav/:id';
+UPDATE ApiSLA SET service = 'ecpay', route = '/api/payment-method' WHERE method = 'POST' AND path = '/api/payment-method';
+UPDATE ApiSLA SET service = 'ecpay', route = '/api/payment-method' WHERE method = 'GET' AND path = '/api/payment-method';
+UPDATE ApiSLA SET service = 'ecpay', route = '/api/payment' WHERE method = 'POST' AND path = '/api/checkout';
+UPDATE ApiSLA SET service = 'product', route = '/v1/co-purchase' WHERE method = 'GET' AND path = '/api/co-purchase';
+UPDATE ApiSLA SET service = 'geocoding', route = '/shipping' WHERE method = 'GET' AND path = '/api/shipping';
+UPDATE ApiSLA SET service = 'geocoding', route = '/geo' WHERE method = 'GET' AND path = '/api/geo';
+UPDATE ApiSLA SET service = 'shipment', route = '/v1/shipment' WHERE method = 'GET' AND path = '/api/shipment';
+UPDATE ApiSLA SET service = 'sale', route = '/api/sale/active' WHERE method = 'GET' AND path = '/api/sale';
+UPDATE ApiSLA SET service = 'sale', route = '/api/sale/products/random' WHERE method = 'GET' AND path = '/api/sale/products/random';
+UPDATE ApiSLA SET service = 'geocoding', route = '/profile' WHERE method = 'GET' AND path = '/api/profile';
+UPDATE ApiSLA SET service = 'geocoding', route = '/profile' WHERE method = 'POST' AND path = '/api/profile';
+
 UPDATE Product p
 JOIN (
   SELECT product_id, ROUND(AVG(rating), 1) AS avg_review, COUNT(*) AS review_count
@@ -1762,3 +1838,7 @@ INSERT INTO `Order` (order_id, user_id, currency, subtotal_amount, shipping_amou
   ('flag-rapid-0001', 'rapid_buyer@example.com', 'USD', 8.00, 2.00, 10.00, 1, 'paid', '[]', NOW() - INTERVAL 12 MINUTE),
   ('flag-rapid-0002', 'rapid_buyer@example.com', 'USD', 9.00, 2.00, 11.00, 1, 'paid', '[]', NOW() - INTERVAL 10 MINUTE),
   ('flag-rapid-0003', 'rapid_buyer@example.com', 'USD', 7.00, 2.00, 9.00, 1, 'paid', '[]', NOW() - INTERVAL 8 MINUTE);
+
+-- Logistics nodes, tagged while building_name is still plaintext (see Geo).
+UPDATE Geo SET node_type = 'airport' WHERE building_name LIKE '%Airport%';
+UPDATE Geo SET node_type = 'port' WHERE node_type IS NULL AND (building_name LIKE '%Port%' OR building_name LIKE '%Terminal%');
@@ -2,24 +2,45 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	"github.com/MicahParks/keyfunc/v3"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/events"
+	"github.com/mockten/mockten/common/fieldcrypt"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/httpclient"
+	"github.com/mockten/mockten/common/keycloak"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/ratelimit"
+	"github.com/mockten/mockten/common/ratings"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -27,10 +48,49 @@ const (
 	port = ":50052"
 )
 
+// Config is read from the environment by common/config.
+type Config struct {
+	Environment     string          `json:"environment" env:"MOCKTEN_ENV" default:"development"`
+	MySQL           config.MySQL    `json:"mysql"`
+	Redis           config.Redis    `json:"redis"`
+	MinIO           config.MinIO    `json:"minio"`
+	Keycloak        config.Keycloak `json:"keycloak"`
+	RateLimitConfig string          `json:"rate_limit_config" env:"RATE_LIMIT_CONFIG"`
+	// Reviews need a paid order for the product unless
+	// REVIEWS_REQUIRE_PURCHASE=false; with REVIEWS_DELIVERED_ONLY only a
+	// delivered one counts, for posting and for the verified badge alike.
+	ReviewsRequirePurchase bool `json:"reviews_require_purchase" env:"REVIEWS_REQUIRE_PURCHASE" default:"true"`
+	ReviewsDeliveredOnly   bool `json:"reviews_delivered_only" env:"REVIEWS_DELIVERED_ONLY" default:"false"`
+	// Review photos: at most ReviewImagesMax per review, each at most
+	// ReviewImageMaxBytes.
+	ReviewImagesMax     int `json:"review_images_max" env:"REVIEW_IMAGES_MAX" default:"4" validate:"min=1,max=10"`
+	ReviewImageMaxBytes int `json:"review_image_max_bytes" env:"REVIEW_IMAGE_MAX_BYTES" default:"5242880" validate:"min=1024"`
+	// RatingReconcileInterval is how often avg_review / review_count are
+	// recomputed from the reviews; 0 turns the job off.
+	RatingReconcileInterval time.Duration `json:"rating_reconcile_interval" env:"RATING_RECONCILE_INTERVAL" default:"1h" validate:"min=0"`
+	// Price-drop / back-in-stock alerts: wishlisted items alert at
+	// AlertDefaultDropPct off unless the user chose a threshold, a user gets
+	// at most AlertsPerDay alerts in 24 hours, and AlertSweepInterval rechecks
+	// watched products for time sales starting or ending (0 turns it off).
+	AlertDefaultDropPct int           `json:"alert_default_drop_pct" env:"ALERT_DEFAULT_DROP_PCT" default:"10" validate:"min=1,max=90"`
+	AlertsPerDay        int           `json:"alerts_per_day" env:"ALERTS_PER_DAY" default:"10" validate:"min=1"`
+	AlertSweepInterval  time.Duration `json:"alert_sweep_interval" env:"ALERT_SWEEP_INTERVAL" default:"5m" validate:"min=0"`
+}
+
 var (
-	logger     *zap.Logger
-	jwks       keyfunc.Keyfunc
-	jwksCancel context.CancelFunc
+	cfg       *Config
+	logger    *zap.Logger
+	authn     *commonauth.Authenticator
+	profiles  *keycloak.ProfileCache
+	fieldKeys *fieldcrypt.Keyring
+
+	// minioClient stores review photos in reviewImageBucket.
+	minioClient *minio.Client
+
+	// minioHTTP checks product images; traced so the HEAD shows up under the
+	// item detail request. No retries: while MinIO is down the breaker opens
+	// and items get the placeholder image at once instead of after a timeout.
+	minioHTTP = httpclient.New(httpclient.Options{Target: "minio", Timeout: 2 * time.Second, Retries: -1})
 )
 
 type GeoResponse struct {
@@ -47,12 +107,30 @@ type GeoResponse struct {
 }
 
 type ReviewResponse struct {
-	ReviewID string    `json:"reviewId"`
-	UserID   string    `json:"userId"`
-	UserName string    `json:"userName"`
-	Rating   int       `json:"rating"`
-	Comment  string    `json:"comment"`
-	Created  time.Time `json:"createdAt"`
+	ReviewID         string                `json:"reviewId"`
+	UserID           string                `json:"userId"`
+	UserName         string                `json:"userName"`
+	Rating           int                   `json:"rating"`
+	Comment          string                `json:"comment"`
+	VerifiedPurchase bool                  `json:"verifiedPurchase"`
+	HelpfulCount     int                   `json:"helpfulCount"`
+	UnhelpfulCount   int                   `json:"unhelpfulCount"`
+	Images           []ReviewImageResponse `json:"images"`
+	SellerReply      *ReviewReplyResponse  `json:"sellerReply,omitempty"`
+	Created          time.Time             `json:"createdAt"`
+}
+
+// ReviewReplyResponse is the seller's public reply to a review, written
+// through sale's seller API.
+type ReviewReplyResponse struct {
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ReviewImageResponse struct {
+	ImageID string `json:"imageId"`
+	URL     string `json:"url"`
 }
 
 type ItemDetailResponse struct {
@@ -73,6 +151,7 @@ type ItemDetailResponse struct {
 	VendorUserName    string           `json:"vendorUserName"`
 	VendorDescription string           `json:"vendorDescription"`
 	Reviews           []ReviewResponse `json:"reviews,omitempty"`
+	RatingHistogram   map[int]int      `json:"ratingHistogram"`
 	ImageURL          string           `json:"imageUrl"`
 	SaleFlag          bool             `json:"saleFlag"`
 	SaleID            string           `json:"saleId"`
@@ -84,6 +163,8 @@ type ItemReviewsResponse struct {
 	Total     int              `json:"total"`
 	Limit     int              `json:"limit"`
 	Offset    int              `json:"offset"`
+	Sort      string           `json:"sort"`
+	Histogram map[int]int      `json:"histogram"`
 	Reviews   []ReviewResponse `json:"reviews"`
 }
 
@@ -94,30 +175,34 @@ type CreateReviewRequest struct {
 }
 
 type CreateReviewResponse struct {
-	ProductID   string    `json:"productId"`
-	ReviewID    string    `json:"reviewId"`
-	UserID      string    `json:"userId"`
-	UserName    string    `json:"userName"`
-	Rating      int       `json:"rating"`
-	Comment     string    `json:"comment"`
-	CreatedAt   time.Time `json:"createdAt"`
-	AvgReview   float64   `json:"avgReview"`
-	ReviewCount int       `json:"reviewCount"`
+	ProductID        string    `json:"productId"`
+	ReviewID         string    `json:"reviewId"`
+	UserID           string    `json:"userId"`
+	UserName         string    `json:"userName"`
+	Rating           int       `json:"rating"`
+	Comment          string    `json:"comment"`
+	VerifiedPurchase bool      `json:"verifiedPurchase"`
+	CreatedAt        time.Time `json:"createdAt"`
+	AvgReview        float64   `json:"avgReview"`
+	ReviewCount      int       `json:"reviewCount"`
 }
 
 type FavoriteItemResponse struct {
-	ProductID         string           `json:"productId"`
-	ProductName       string           `json:"productName"`
-	SellerName        string           `json:"sellerName"`
-	Price             int              `json:"price"`
-	Summary           string           `json:"summary"`
-	ProductCondition  string           `json:"productCondition"`
-	Stocks            int              `json:"stocks"`
-	AvgReview         float64          `json:"avgReview"`
-	ReviewCount       int              `json:"reviewCount"`
-	SaleFlag          bool             `json:"saleFlag"`
-	SaleID            string           `json:"saleId"`
-	DiscountRate      float64          `json:"discountRate"`
+	ProductID        string    `json:"productId"`
+	ProductName      string    `json:"productName"`
+	SellerName       string    `json:"sellerName"`
+	Price            int       `json:"price"`
+	Summary          string    `json:"summary"`
+	ProductCondition string    `json:"productCondition"`
+	Stocks           int       `json:"stocks"`
+	AvgReview        float64   `json:"avgReview"`
+	ReviewCount      int       `json:"reviewCount"`
+	SaleFlag         bool      `json:"saleFlag"`
+	SaleID           string    `json:"saleId"`
+	DiscountRate     float64   `json:"discountRate"`
+	Note             string    `json:"note"`
+	Quantity         int       `json:"quantity"`
+	AddedAt          time.Time `json:"addedAt"`
 }
 
 func waitForMySQL(db *sql.DB, logger *zap.Logger) {
@@ -134,132 +219,104 @@ func waitForMySQL(db *sql.DB, logger *zap.Logger) {
 	logger.Fatal("MySQL did not become ready in time.")
 }
 
-func buildJWKSURL() (string, error) {
-	if v := strings.TrimSpace(os.Getenv("KEYCLOAK_JWKS_URL")); v != "" {
-		return v, nil
-	}
-
-	base := strings.TrimSpace(os.Getenv("KEYCLOAK_BASE_URL"))
-	if base == "" {
-		base = "http://uam-service.default.svc.cluster.local"
-	}
-	realm := strings.TrimSpace(os.Getenv("KEYCLOAK_REALM"))
-	if realm == "" {
-		realm = "mockten-realm-dev"
-	}
-
-	base = strings.TrimRight(base, "/")
-	if realm == "" {
-		return "", errors.New("KEYCLOAK_REALM is empty")
-	}
-
-	return base + "/realms/" + realm + "/protocol/openid-connect/certs", nil
+// getUserIDFromAccessToken verifies the caller's Bearer JWT with authn and
+// returns their user id (email, else preferred_username, else sub).
+func getUserIDFromAccessToken(c *gin.Context) (string, error) {
+	return authn.UserIDFromGinContext(c)
 }
 
-func initJWKS(jwksURL string) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	jwksCancel = cancel
+// reviewSorts are the ?sort= orders of the reviews endpoint; newest is the
+// default.
+var reviewSorts = map[string]string{
+	"newest":  "r.created_at DESC",
+	"helpful": "r.helpful_count DESC, r.created_at DESC",
+	"lowest":  "r.rating ASC, r.created_at DESC",
+	"highest": "r.rating DESC, r.created_at DESC",
+}
 
-	k, err := keyfunc.NewDefaultCtx(ctx, []string{jwksURL})
-	if err != nil {
-		return err
-	}
-	jwks = k
-	return nil
+// reviewQuery picks a page of a product's active reviews.
+type reviewQuery struct {
+	Limit        int
+	Offset       int
+	Sort         string // a reviewSorts key
+	Stars        []int  // only these ratings; empty means all
+	VerifiedOnly bool
 }
 
-func bearerTokenFromHeader(h string) (string, bool) {
-	v := strings.TrimSpace(h)
-	if v == "" {
-		return "", false
+// parseReviewQuery reads limit, offset, sort, stars (repeatable or
+// comma-separated, 1-5) and verified. Out-of-range limit and offset fall back
+// to the defaults as they always have; an unknown sort or star is an error.
+func parseReviewQuery(c *gin.Context) (reviewQuery, error) {
+	q := reviewQuery{Limit: 20, Sort: "newest"}
+	if v := c.Query("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 200 {
+			q.Limit = n
+		}
 	}
-	parts := strings.SplitN(v, " ", 2)
-	if len(parts) != 2 {
-		return "", false
+	if v := c.Query("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			q.Offset = n
+		}
 	}
-	if !strings.EqualFold(parts[0], "Bearer") {
-		return "", false
+	if v := c.Query("sort"); v != "" {
+		if _, ok := reviewSorts[v]; !ok {
+			return q, apierr.Validation("sort must be one of newest, helpful, lowest, highest").Field("sort", "is invalid")
+		}
+		q.Sort = v
 	}
-	t := strings.TrimSpace(parts[1])
-	if t == "" {
-		return "", false
+	for _, v := range c.QueryArray("stars") {
+		for _, part := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 1 || n > 5 {
+				return q, apierr.Validation("stars must be between 1 and 5").Field("stars", "is invalid")
+			}
+			if !slices.Contains(q.Stars, n) {
+				q.Stars = append(q.Stars, n)
+			}
+		}
 	}
-	return t, true
-}
-
-func jwtHeaderInfo(tokenStr string) (string, string) {
-	p := strings.Split(tokenStr, ".")
-	if len(p) < 2 {
-		return "", ""
+	if v := c.Query("verified"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, apierr.Validation("verified must be true or false").Field("verified", "is invalid")
+		}
+		q.VerifiedOnly = b
 	}
+	return q, nil
+}
 
-	b, err := base64.RawURLEncoding.DecodeString(p[0])
-	if err != nil {
-		return "", ""
+// filter is the SQL after "WHERE r.product_id = ? AND r.status = 'active'"
+// for q, with its arguments.
+func (q reviewQuery) filter() (string, []any) {
+	var b strings.Builder
+	var args []any
+	if q.VerifiedOnly {
+		b.WriteString("\n  AND r.verified_purchase = 1")
 	}
-
-	var m map[string]any
-	if err := json.Unmarshal(b, &m); err != nil {
-		return "", ""
+	if len(q.Stars) > 0 {
+		b.WriteString("\n  AND r.rating IN (?" + strings.Repeat(", ?", len(q.Stars)-1) + ")")
+		for _, n := range q.Stars {
+			args = append(args, n)
+		}
 	}
-
-	kid, _ := m["kid"].(string)
-	alg, _ := m["alg"].(string)
-	return strings.TrimSpace(kid), strings.TrimSpace(alg)
+	return b.String(), args
 }
 
-func getUserIDFromAccessToken(c *gin.Context) (string, error) {
-	tokenStr, ok := bearerTokenFromHeader(c.GetHeader("Authorization"))
+func fetchReviews(ctx context.Context, db *sql.DB, productID string, q reviewQuery) ([]ReviewResponse, int, error) {
+	filter, filterArgs := q.filter()
+	order, ok := reviewSorts[q.Sort]
 	if !ok {
-		return "", errors.New("missing bearer token")
-	}
-
-	kid, alg := jwtHeaderInfo(tokenStr)
-
-	parser := jwt.NewParser(
-		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
-		jwt.WithLeeway(30*time.Second),
-	)
-
-	var claims jwt.MapClaims
-	tok, err := parser.ParseWithClaims(tokenStr, &claims, jwks.Keyfunc)
-	if err != nil {
-		logger.Warn("JWT parse failed", zap.String("kid", kid), zap.String("alg", alg), zap.Error(err))
-		return "", err
-	}
-	if !tok.Valid {
-		logger.Warn("JWT invalid", zap.String("kid", kid), zap.String("alg", alg))
-		return "", errors.New("invalid token")
-	}
-
-	if v, ok := claims["email"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
-	}
-	if v, ok := claims["preferred_username"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
-	}
-	if v, ok := claims["sub"]; ok {
-		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-			return strings.TrimSpace(s), nil
-		}
+		order = reviewSorts["newest"]
 	}
+	args := append([]any{productID}, filterArgs...)
 
-	return "", errors.New("no usable user identifier in token claims")
-}
-
-func fetchReviews(db *sql.DB, productID string, limit int, offset int) ([]ReviewResponse, int, error) {
 	countQuery := `
 SELECT COUNT(*)
 FROM Review r
 WHERE r.product_id = ?
-  AND r.status = 'active'
-`
+  AND r.status = 'active'` + filter
 	var total int
-	if err := db.QueryRow(countQuery, productID).Scan(&total); err != nil {
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
@@ -267,72 +324,141 @@ WHERE r.product_id = ?
 SELECT
   r.review_id,
   r.user_id,
-  COALESCE(NULLIF(ue.FIRST_NAME, ''), 'Anonymous') AS user_name,
   r.rating,
   COALESCE(r.comment, '') AS comment,
-  r.created_at
+  r.verified_purchase,
+  r.helpful_count,
+  r.unhelpful_count,
+  r.created_at,
+  rp.body,
+  rp.created_at,
+  rp.updated_at
 FROM Review r
-LEFT JOIN USER_ENTITY ue ON r.user_id = ue.EMAIL
+LEFT JOIN ReviewReply rp ON rp.review_id = r.review_id
 WHERE r.product_id = ?
-  AND r.status = 'active'
-ORDER BY r.created_at DESC
+  AND r.status = 'active'` + filter + `
+ORDER BY ` + order + `
 LIMIT ? OFFSET ?
 `
-	rows, err := db.Query(listQuery, productID, limit, offset)
+	rows, err := db.QueryContext(ctx, listQuery, append(args, q.Limit, q.Offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
 	defer rows.Close()
 
-	reviews := make([]ReviewResponse, 0, limit)
+	reviews := make([]ReviewResponse, 0, q.Limit)
 	for rows.Next() {
 		var rr ReviewResponse
-		if err := rows.Scan(&rr.ReviewID, &rr.UserID, &rr.UserName, &rr.Rating, &rr.Comment, &rr.Created); err != nil {
+		var replyBody sql.NullString
+		var replyCreated, replyUpdated sql.NullTime
+		if err := rows.Scan(&rr.ReviewID, &rr.UserID, &rr.Rating, &rr.Comment, &rr.VerifiedPurchase, &rr.HelpfulCount, &rr.UnhelpfulCount, &rr.Created,
+			&replyBody, &replyCreated, &replyUpdated); err != nil {
 			return nil, 0, err
 		}
+		if replyBody.Valid {
+			rr.SellerReply = &ReviewReplyResponse{Body: replyBody.String, CreatedAt: replyCreated.Time, UpdatedAt: replyUpdated.Time}
+		}
 		reviews = append(reviews, rr)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, 0, err
 	}
+	fillReviewerNames(reviews)
+	if err := fillReviewImages(ctx, db, reviews); err != nil {
+		return nil, 0, err
+	}
 
 	return reviews, total, nil
 }
 
+// fetchHistogram returns the product's active reviews per star, 1 to 5, all
+// keys present.
+func fetchHistogram(ctx context.Context, db *sql.DB, productID string) (map[int]int, error) {
+	var h [5]int
+	err := db.QueryRowContext(ctx, `SELECT stars_1, stars_2, stars_3, stars_4, stars_5 FROM ReviewHistogram WHERE product_id = ?`, productID).
+		Scan(&h[0], &h[1], &h[2], &h[3], &h[4])
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	out := make(map[int]int, 5)
+	for i, n := range h {
+		out[i+1] = n
+	}
+	return out, nil
+}
+
+// histogramDeltas is how posting a review moves the star counts, in step with
+// updateProductRatingIncremental: a new or re-activated review adds its
+// rating, an edit moves one count from the old rating to the new.
+func histogramDeltas(newRating int, prevRating int, wasActive bool, isNew bool) map[int]int {
+	d := map[int]int{newRating: 1}
+	if !isNew && wasActive {
+		d[prevRating]--
+	}
+	return d
+}
+
+// updateReviewHistogram applies deltas (star -> change) to the product's
+// ReviewHistogram row, creating it if needed. Counts never go below zero.
+func updateReviewHistogram(ctx context.Context, tx *sql.Tx, productID string, deltas map[int]int) error {
+	var cols, vals, upd []string
+	args := []any{productID}
+	var updArgs []any
+	for star := 1; star <= 5; star++ {
+		d := deltas[star]
+		if d == 0 {
+			continue
+		}
+		col := fmt.Sprintf("stars_%d", star)
+		cols = append(cols, col)
+		vals = append(vals, "GREATEST(?, 0)")
+		upd = append(upd, col+" = GREATEST("+col+" + ?, 0)")
+		args = append(args, d)
+		updArgs = append(updArgs, d)
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	q := `INSERT INTO ReviewHistogram (product_id, ` + strings.Join(cols, ", ") + `) VALUES (?, ` + strings.Join(vals, ", ") + `)
+ON DUPLICATE KEY UPDATE ` + strings.Join(upd, ", ")
+	_, err := tx.ExecContext(ctx, q, append(args, updArgs...)...)
+	return err
+}
+
 func getItemReviewsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		productID := c.Param("productId")
 		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId path parameter"})
+			apierr.Abort(c, apierr.Validation("Missing productId path parameter"))
 			return
 		}
 
-		limit := 20
-		offset := 0
-
-		if v := c.Query("limit"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 200 {
-				limit = n
-			}
-		}
-		if v := c.Query("offset"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
-				offset = n
-			}
+		q, err := parseReviewQuery(c)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
 		}
 
-		reviews, total, err := fetchReviews(db, productID, limit, offset)
+		reviews, total, err := fetchReviews(c.Request.Context(), db, productID, q)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB query failed (reviews)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		histogram, err := fetchHistogram(c.Request.Context(), db, productID)
 		if err != nil {
-			logger.Error("DB query failed (reviews)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB query failed (review histogram)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
 		c.JSON(http.StatusOK, ItemReviewsResponse{
 			ProductID: productID,
 			Total:     total,
-			Limit:     limit,
-			Offset:    offset,
+			Limit:     q.Limit,
+			Offset:    q.Offset,
+			Sort:      q.Sort,
+			Histogram: histogram,
 			Reviews:   reviews,
 		})
 	}
@@ -343,10 +469,18 @@ func getItemReviewsHandler(db *sql.DB) gin.HandlerFunc {
 // HEAD-checking MinIO on every request is acceptable at PoC scale.
 // For production, cache the list of existing images in memory at startup and
 // refresh it periodically, or invalidate the cache when a new image is uploaded.
-func getImageURL(productID string, categoryID string) string {
+func getImageURL(ctx context.Context, productID string, categoryID string) string {
 	imageURL := fmt.Sprintf("/api/storage/%s.png", productID)
-	resp, err := http.Head(fmt.Sprintf("http://minio-service.default.svc.cluster.local:9000/photos/%s.png", productID))
-	if err != nil || resp.StatusCode != 200 {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fmt.Sprintf("%s/photos/%s.png", cfg.MinIO.URL(), productID), nil)
+	if err != nil {
+		return "/api/storage/placeholder.png"
+	}
+	resp, err := minioHTTP.Do(req)
+	if err != nil {
+		return "/api/storage/placeholder.png"
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
 		return "/api/storage/placeholder.png"
 	}
 	return imageURL
@@ -356,7 +490,7 @@ func getItemDetailHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		productID := c.Param("productId")
 		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId path parameter"})
+			apierr.Abort(c, apierr.Validation("Missing productId path parameter"))
 			return
 		}
 
@@ -364,7 +498,7 @@ func getItemDetailHandler(db *sql.DB) gin.HandlerFunc {
 SELECT
   p.product_id,
   p.product_name,
-  COALESCE(NULLIF(s.seller_name, ''), ua_store.VALUE, ue.USERNAME) AS seller_name,
+  COALESCE(s.seller_name, '') AS seller_name,
   p.price,
   c.category_name,
   p.product_condition,
@@ -384,18 +518,17 @@ SELECT
   g.longitude,
   p.avg_review,
   p.review_count,
-  COALESCE(NULLIF(s.seller_name, ''), ua_store.VALUE, ue.USERNAME) AS vendor_username,
+  COALESCE(s.seller_name, '') AS vendor_username,
   COALESCE(s.description, '') AS vendor_description,
   p.category_id,
   p.sale_flag,
   COALESCE(p.sale_id, '') AS sale_id,
-  COALESCE(ts.discount_rate, 0.0) AS discount_rate
+  COALESCE(ts.discount_rate, 0.0) AS discount_rate,
+  p.seller_id
 FROM Product p
 JOIN Category c ON p.category_id = c.category_id
 LEFT JOIN Stock t ON p.product_id = t.product_id
 LEFT JOIN Seller s ON p.seller_id = s.seller_id
-LEFT JOIN USER_ENTITY ue ON p.seller_id = ue.EMAIL
-LEFT JOIN USER_ATTRIBUTE ua_store ON ue.ID = ua_store.USER_ID AND ua_store.NAME = 'storeName'
 LEFT JOIN Geo g ON p.geo_id = g.geo_id
 LEFT JOIN TimeSale ts ON p.sale_id = ts.id
 -- A retired product must not be reachable, even by direct link.
@@ -415,17 +548,18 @@ LIMIT 1
 			town         sql.NullString
 			buildingName sql.NullString
 			roomNumber   sql.NullString
-			latitude     sql.NullFloat64
-			longitude    sql.NullFloat64
+			latitude     sql.NullString
+			longitude    sql.NullString
 
 			avgReview         sql.NullFloat64
 			reviewCount       sql.NullInt64
 			vendorUserName    sql.NullString
 			vendorDescription sql.NullString
 			categoryID        string
+			sellerID          string
 		)
 
-		err := db.QueryRow(query, productID).Scan(
+		err := db.QueryRowContext(c.Request.Context(), query, productID).Scan(
 			&resp.ProductID,
 			&resp.ProductName,
 			&resp.SellerName,
@@ -454,15 +588,16 @@ LIMIT 1
 			&resp.SaleFlag,
 			&resp.SaleID,
 			&resp.DiscountRate,
+			&sellerID,
 		)
 
 		if err != nil {
 			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+				apierr.Abort(c, apierr.NotFound("Product not found"))
 				return
 			}
-			logger.Error("DB query failed (detail)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB query failed (detail)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
@@ -484,31 +619,16 @@ LIMIT 1
 		if countryCode.Valid {
 			resp.Geo.CountryCode = countryCode.String
 		}
-		if postalCode.Valid {
-			resp.Geo.PostalCode = postalCode.String
-		}
 		if prefecture.Valid {
 			resp.Geo.Prefecture = prefecture.String
 		}
 		if city.Valid {
 			resp.Geo.City = city.String
 		}
-		if town.Valid {
-			resp.Geo.Town = town.String
-		}
-		if buildingName.Valid {
-			resp.Geo.BuildingName = buildingName.String
-		}
-		if roomNumber.Valid {
-			resp.Geo.RoomNumber = roomNumber.String
-		}
-		if latitude.Valid {
-			v := latitude.Float64
-			resp.Geo.Latitude = &v
-		}
-		if longitude.Valid {
-			v := longitude.Float64
-			resp.Geo.Longitude = &v
+		// The address and coordinates are stored encrypted; a value that
+		// fails to decrypt is left empty rather than failing the page.
+		if err := openGeo(&resp.Geo, postalCode, town, buildingName, roomNumber, latitude, longitude); err != nil {
+			logging.From(c.Request.Context()).Warn("Geo decryption failed", zap.String("geo_id", geoID), zap.Error(err))
 		}
 
 		resp.AvgReview = 0.0
@@ -528,62 +648,195 @@ LIMIT 1
 		if vendorDescription.Valid {
 			resp.VendorDescription = vendorDescription.String
 		}
+		if resp.SellerName == "" {
+			resp.SellerName = sellerDisplayName(c.Request.Context(), sellerID)
+			resp.VendorUserName = resp.SellerName
+		}
 
-		reviewsPreview, _, err := fetchReviews(db, productID, 2, 0)
+		reviewsPreview, _, err := fetchReviews(c.Request.Context(), db, productID, reviewQuery{Limit: 2, Sort: "newest"})
 		if err != nil {
-			logger.Error("DB query failed (reviews preview)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB query failed (reviews preview)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 		if len(reviewsPreview) > 0 {
 			resp.Reviews = reviewsPreview
 		}
+		if resp.RatingHistogram, err = fetchHistogram(c.Request.Context(), db, productID); err != nil {
+			logging.From(c.Request.Context()).Error("DB query failed (review histogram)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
 
-		resp.ImageURL = getImageURL(resp.ProductID, categoryID)
+		resp.ImageURL = getImageURL(c.Request.Context(), resp.ProductID, categoryID)
 
 		c.JSON(http.StatusOK, resp)
 	}
 }
 
-func fetchUserDisplayName(tx *sql.Tx, userID string) (string, error) {
-	q := `SELECT COALESCE(NULLIF(FIRST_NAME, ''), 'Anonymous') FROM USER_ENTITY WHERE EMAIL = ? LIMIT 1`
-	var name string
-	err := tx.QueryRow(q, userID).Scan(&name)
+// openGeo decrypts the encrypted Geo columns (see common/fieldcrypt) into g.
+// NULL columns stay empty / nil.
+func openGeo(g *GeoResponse, postal, town, building, room, lat, lon sql.NullString) error {
+	open := func(column string, v sql.NullString) (string, error) {
+		if !v.Valid {
+			return "", nil
+		}
+		return fieldKeys.Decrypt(v.String, fieldcrypt.GeoAAD(column))
+	}
+	var err error
+	for _, f := range []struct {
+		column string
+		in     sql.NullString
+		out    *string
+	}{
+		{"postal_code", postal, &g.PostalCode},
+		{"town", town, &g.Town},
+		{"building_name", building, &g.BuildingName},
+		{"room_number", room, &g.RoomNumber},
+	} {
+		if *f.out, err = open(f.column, f.in); err != nil {
+			return err
+		}
+	}
+	for _, f := range []struct {
+		column string
+		in     sql.NullString
+		out    **float64
+	}{
+		{"latitude", lat, &g.Latitude},
+		{"longitude", lon, &g.Longitude},
+	} {
+		pt, err := open(f.column, f.in)
+		if err != nil {
+			return err
+		}
+		if pt == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(pt, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.column, err)
+		}
+		*f.out = &v
+	}
+	return nil
+}
+
+// fetchUserDisplayName is the reviewer name shown next to a review: the
+// Keycloak first name, or "Anonymous" when it is unset or Keycloak is
+// unreachable. A missing name never fails the request.
+func fetchUserDisplayName(ctx context.Context, userID string) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	p, err := profiles.Profile(ctx, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return "Anonymous", nil
+		if !errors.Is(err, keycloak.ErrNotFound) {
+			logging.From(ctx).Warn("keycloak profile lookup failed", zap.String("user_id", userID), zap.Error(err))
 		}
-		return "", err
+		return "Anonymous"
+	}
+	if name := strings.TrimSpace(p.FirstName); name != "" {
+		return name
+	}
+	return "Anonymous"
+}
+
+func fillReviewerNames(reviews []ReviewResponse) {
+	ids := make([]string, 0, len(reviews))
+	for _, r := range reviews {
+		ids = append(ids, r.UserID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	byID, err := profiles.Profiles(ctx, ids)
+	if err != nil {
+		logger.Warn("keycloak profile lookup failed", zap.Error(err))
+	}
+	for i := range reviews {
+		reviews[i].UserName = "Anonymous"
+		if p := byID[reviews[i].UserID]; p != nil && strings.TrimSpace(p.FirstName) != "" {
+			reviews[i].UserName = strings.TrimSpace(p.FirstName)
+		}
+	}
+}
+
+// sellerDisplayName is the fallback for a seller without a Seller.seller_name:
+// the storeName they gave at sign-up, else their Keycloak username.
+func sellerDisplayName(ctx context.Context, sellerID string) string {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	p, err := profiles.Profile(ctx, sellerID)
+	if err != nil {
+		if !errors.Is(err, keycloak.ErrNotFound) {
+			logging.From(ctx).Warn("keycloak profile lookup failed", zap.String("seller_id", sellerID), zap.Error(err))
+		}
+		return ""
+	}
+	if v := p.Attribute("storeName"); v != "" {
+		return v
 	}
-	if strings.TrimSpace(name) == "" {
-		return "Anonymous", nil
+	return p.Username
+}
+
+// errReviewModerated is a new post over a review an admin hid or deleted.
+var errReviewModerated = errors.New("review removed by a moderator")
+
+// purchaseStatuses are the Order statuses that count as having bought the
+// product: paid onwards, or delivered only.
+func purchaseStatuses(deliveredOnly bool) []any {
+	if deliveredOnly {
+		return []any{"delivered"}
 	}
-	return name, nil
+	return []any{"paid", "picking", "shipped", "delivered"}
+}
+
+// hasPurchased reports whether userID bought productID: a Transaction for it
+// on one of the user's Geo addresses, in an Order with a purchaseStatuses
+// status.
+func hasPurchased(ctx context.Context, tx *sql.Tx, userID, productID string, deliveredOnly bool) (bool, error) {
+	statuses := purchaseStatuses(deliveredOnly)
+	q := `
+SELECT EXISTS (
+  SELECT 1
+  FROM ` + "`Transaction`" + ` t
+  JOIN Geo g ON g.geo_id = t.geo_id
+  JOIN ` + "`Order`" + ` o ON JSON_CONTAINS(o.transactions_json, JSON_QUOTE(t.transaction_id))
+  WHERE g.user_id = ?
+    AND t.product_id = ?
+    AND o.status IN (?` + strings.Repeat(", ?", len(statuses)-1) + `)
+)`
+	var ok bool
+	err := tx.QueryRowContext(ctx, q, append([]any{userID, productID}, statuses...)...).Scan(&ok)
+	return ok, err
 }
 
-func upsertReview(tx *sql.Tx, reviewID string, productID string, userID string, rating int, comment string) (string, time.Time, int, bool, bool, error) {
+func upsertReview(ctx context.Context, tx *sql.Tx, reviewID string, productID string, userID string, rating int, comment string, verified bool) (string, time.Time, int, bool, bool, error) {
 	var existingID string
 	var createdAt time.Time
 	var prevRating int
 	var prevStatus string
+	var moderatedBy sql.NullString
 
-	sel := `SELECT review_id, created_at, rating, status FROM Review WHERE product_id = ? AND user_id = ? LIMIT 1 FOR UPDATE`
-	err := tx.QueryRow(sel, productID, userID).Scan(&existingID, &createdAt, &prevRating, &prevStatus)
+	sel := `SELECT review_id, created_at, rating, status, moderated_by FROM Review WHERE product_id = ? AND user_id = ? LIMIT 1 FOR UPDATE`
+	err := tx.QueryRowContext(ctx, sel, productID, userID).Scan(&existingID, &createdAt, &prevRating, &prevStatus, &moderatedBy)
 	if err != nil && err != sql.ErrNoRows {
 		return "", time.Time{}, 0, false, false, err
 	}
+	if err == nil && prevStatus != "active" && moderatedBy.Valid {
+		return "", time.Time{}, 0, false, false, errReviewModerated
+	}
 
 	if err == sql.ErrNoRows {
 		ins := `
-INSERT INTO Review (review_id, product_id, user_id, rating, comment, status)
-VALUES (?, ?, ?, ?, ?, 'active')
+INSERT INTO Review (review_id, product_id, user_id, rating, comment, verified_purchase, status)
+VALUES (?, ?, ?, ?, ?, ?, 'active')
 `
-		_, err := tx.Exec(ins, reviewID, productID, userID, rating, comment)
+		_, err := tx.ExecContext(ctx, ins, reviewID, productID, userID, rating, comment, verified)
 		if err != nil {
 			return "", time.Time{}, 0, false, false, err
 		}
 		sel2 := `SELECT created_at FROM Review WHERE review_id = ? LIMIT 1`
-		if err := tx.QueryRow(sel2, reviewID).Scan(&createdAt); err != nil {
+		if err := tx.QueryRowContext(ctx, sel2, reviewID).Scan(&createdAt); err != nil {
 			return "", time.Time{}, 0, false, false, err
 		}
 		return reviewID, createdAt, 0, false, true, nil
@@ -591,10 +844,10 @@ VALUES (?, ?, ?, ?, ?, 'active')
 
 	upd := `
 UPDATE Review
-SET rating = ?, comment = ?, status = 'active'
+SET rating = ?, comment = ?, verified_purchase = ?, status = 'active'
 WHERE review_id = ?
 `
-	_, err = tx.Exec(upd, rating, comment, existingID)
+	_, err = tx.ExecContext(ctx, upd, rating, comment, verified, existingID)
 	if err != nil {
 		return "", time.Time{}, 0, false, false, err
 	}
@@ -603,12 +856,12 @@ WHERE review_id = ?
 	return existingID, createdAt, prevRating, wasActive, false, nil
 }
 
-func updateProductRatingIncremental(tx *sql.Tx, productID string, newRating int, prevRating int, wasActive bool, isNew bool) (float64, int, error) {
+func updateProductRatingIncremental(ctx context.Context, tx *sql.Tx, productID string, newRating int, prevRating int, wasActive bool, isNew bool) (float64, int, error) {
 	var oldAvg float64
 	var oldCnt int
 
 	sel := `SELECT CAST(avg_review AS DECIMAL(10,4)) + 0.0, review_count FROM Product WHERE product_id = ? LIMIT 1 FOR UPDATE`
-	if err := tx.QueryRow(sel, productID).Scan(&oldAvg, &oldCnt); err != nil {
+	if err := tx.QueryRowContext(ctx, sel, productID).Scan(&oldAvg, &oldCnt); err != nil {
 		return 0.0, 0, err
 	}
 
@@ -629,10 +882,10 @@ func updateProductRatingIncremental(tx *sql.Tx, productID string, newRating int,
 		newAvg = (oldAvg*float64(oldCnt) - float64(prevRating) + float64(newRating)) / float64(newCnt)
 	}
 
-	newAvg = math.Round(newAvg*10.0) / 10.0
+	newAvg = ratings.Round(newAvg)
 
 	upd := `UPDATE Product SET avg_review = ?, review_count = ? WHERE product_id = ?`
-	if _, err := tx.Exec(upd, newAvg, newCnt, productID); err != nil {
+	if _, err := tx.ExecContext(ctx, upd, newAvg, newCnt, productID); err != nil {
 		return 0.0, 0, err
 	}
 
@@ -643,284 +896,2162 @@ func postItemReviewHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := getUserIDFromAccessToken(c)
 		if err != nil {
-			logger.Warn("Unauthorized request", zap.Error(err))
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			logging.From(c.Request.Context()).Warn("Unauthorized request", zap.Error(err))
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 			return
 		}
 
 		var req CreateReviewRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
 			return
 		}
 		req.ProductID = strings.TrimSpace(req.ProductID)
 		req.Comment = strings.TrimSpace(req.Comment)
 
 		if req.ProductID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId"})
+			apierr.Abort(c, apierr.Validation("Missing productId"))
 			return
 		}
 		if req.Rating < 1 || req.Rating > 5 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be between 1 and 5"})
+			apierr.Abort(c, apierr.Validation("rating must be between 1 and 5"))
 			return
 		}
 		if len(req.Comment) > 4000 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "comment too long"})
+			apierr.Abort(c, apierr.Validation("comment too long"))
 			return
 		}
 
-		tx, err := db.Begin()
+		tx, err := db.BeginTx(c.Request.Context(), nil)
 		if err != nil {
-			logger.Error("DB begin failed", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB begin failed", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 		defer func() { _ = tx.Rollback() }()
 
 		var exists int
-		if err := tx.QueryRow(`SELECT 1 FROM Product WHERE product_id = ? LIMIT 1`, req.ProductID).Scan(&exists); err != nil {
+		if err := tx.QueryRowContext(c.Request.Context(), `SELECT 1 FROM Product WHERE product_id = ? LIMIT 1`, req.ProductID).Scan(&exists); err != nil {
 			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+				apierr.Abort(c, apierr.NotFound("Product not found"))
 				return
 			}
-			logger.Error("DB query failed (product exists)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB query failed (product exists)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+
+		verified, err := hasPurchased(c.Request.Context(), tx, userID, req.ProductID, cfg.ReviewsDeliveredOnly)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB query failed (purchase history)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		if !verified && cfg.ReviewsRequirePurchase {
+			msg := "Only customers who bought this product can review it"
+			if cfg.ReviewsDeliveredOnly {
+				msg = "You can review this product once your order has been delivered"
+			}
+			apierr.Abort(c, apierr.Forbidden(msg))
 			return
 		}
 
 		newReviewID := uuid.NewString()
-		reviewID, createdAt, prevRating, wasActive, isNew, err := upsertReview(tx, newReviewID, req.ProductID, userID, req.Rating, req.Comment)
+		reviewID, createdAt, prevRating, wasActive, isNew, err := upsertReview(c.Request.Context(), tx, newReviewID, req.ProductID, userID, req.Rating, req.Comment, verified)
+		if errors.Is(err, errReviewModerated) {
+			apierr.Abort(c, apierr.Forbidden("Your review of this product was removed by a moderator"))
+			return
+		}
 		if err != nil {
-			logger.Error("DB upsert failed (review)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB upsert failed (review)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
-		avg, cnt, err := updateProductRatingIncremental(tx, req.ProductID, req.Rating, prevRating, wasActive, isNew)
+		avg, cnt, err := updateProductRatingIncremental(c.Request.Context(), tx, req.ProductID, req.Rating, prevRating, wasActive, isNew)
 		if err != nil {
-			logger.Error("DB update failed (product rating incremental)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB update failed (product rating incremental)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		if err := updateReviewHistogram(c.Request.Context(), tx, req.ProductID, histogramDeltas(req.Rating, prevRating, wasActive, isNew)); err != nil {
+			logging.From(c.Request.Context()).Error("DB update failed (review histogram)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
-		userName, err := fetchUserDisplayName(tx, userID)
+		err = events.Enqueue(c.Request.Context(), tx, events.ReviewPosted, req.ProductID, events.ReviewPostedPayload{
+			ReviewID: reviewID, ProductID: req.ProductID, UserID: userID, Rating: req.Rating, AvgReview: avg, ReviewCount: cnt,
+		})
 		if err != nil {
-			logger.Error("DB query failed (user display name)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB insert failed (outbox)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
 		if err := tx.Commit(); err != nil {
-			logger.Error("DB commit failed", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB commit failed", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
 		c.JSON(http.StatusOK, CreateReviewResponse{
-			ProductID:   req.ProductID,
-			ReviewID:    reviewID,
-			UserID:      userID,
-			UserName:    userName,
-			Rating:      req.Rating,
-			Comment:     req.Comment,
-			CreatedAt:   createdAt,
-			AvgReview:   avg,
-			ReviewCount: cnt,
+			ProductID:        req.ProductID,
+			ReviewID:         reviewID,
+			UserID:           userID,
+			UserName:         fetchUserDisplayName(c.Request.Context(), userID),
+			Rating:           req.Rating,
+			Comment:          req.Comment,
+			VerifiedPurchase: verified,
+			CreatedAt:        createdAt,
+			AvgReview:        avg,
+			ReviewCount:      cnt,
 		})
 	}
 }
 
-func getFavoriteListHandler(db *sql.DB) gin.HandlerFunc {
+type VoteReviewRequest struct {
+	Helpful *bool `json:"helpful"`
+}
+
+// voteDeltas is the change to a review's helpful and unhelpful counts when a
+// user's vote goes from prev to next; nil is no vote.
+func voteDeltas(prev, next *bool) (helpful, unhelpful int) {
+	count := func(v *bool, sign int) {
+		switch {
+		case v == nil:
+		case *v:
+			helpful += sign
+		default:
+			unhelpful += sign
+		}
+	}
+	count(prev, -1)
+	count(next, 1)
+	return helpful, unhelpful
+}
+
+// voteReviewHandler records the caller's helpful / unhelpful vote on someone
+// else's active review (POST {"helpful": bool}), or withdraws it (DELETE).
+// Voting again replaces the vote; the counts on Review move in the same
+// transaction.
+func voteReviewHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := getUserIDFromAccessToken(c)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 			return
 		}
+		reviewID := strings.TrimSpace(c.Param("reviewId"))
 
-		c.Header("Cache-Control", "no-store")
-		var productIDsJSON string
-		err = db.QueryRow(`SELECT product_ids FROM Wishlist WHERE user_id = ? LIMIT 1`, userID).Scan(&productIDsJSON)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				c.JSON(http.StatusOK, []FavoriteItemResponse{})
+		var next *bool
+		if c.Request.Method != http.MethodDelete {
+			var req VoteReviewRequest
+			if err := c.ShouldBindJSON(&req); err != nil || req.Helpful == nil {
+				apierr.Abort(c, apierr.Validation("helpful must be true or false").Field("helpful", "is required"))
 				return
 			}
-			logger.Error("DB query failed (Wishlist)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
+			next = req.Helpful
 		}
 
-		var productIDs []string
-		if err := json.Unmarshal([]byte(productIDsJSON), &productIDs); err != nil {
-			logger.Error("JSON unmarshal failed", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		ctx := c.Request.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
 			return
 		}
+		defer func() { _ = tx.Rollback() }()
 
-		if len(productIDs) == 0 {
-			c.JSON(http.StatusOK, []FavoriteItemResponse{})
+		var author string
+		err = tx.QueryRowContext(ctx, `SELECT user_id FROM Review WHERE review_id = ? AND status = 'active' FOR UPDATE`, reviewID).Scan(&author)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Review not found"))
 			return
 		}
-
-		query := `
-SELECT
-  p.product_id,
-  p.product_name,
-  COALESCE(s.seller_name, ue.USERNAME) AS seller_name,
-  p.price,
-  p.product_condition,
-  COALESCE(t.stocks, 0) AS stocks,
-  p.summary,
-  p.avg_review,
-  p.review_count,
-  p.sale_flag,
-  COALESCE(p.sale_id, '') AS sale_id,
-  COALESCE(ts.discount_rate, 0.0) AS discount_rate
-FROM Product p
-LEFT JOIN Stock t ON p.product_id = t.product_id
-LEFT JOIN Seller s ON p.seller_id = s.seller_id
-LEFT JOIN USER_ENTITY ue ON p.seller_id = ue.EMAIL
-LEFT JOIN TimeSale ts ON p.sale_id = ts.id
-WHERE p.product_id IN (?` + strings.Repeat(",?", len(productIDs)-1) + `)
-`
-		args := make([]interface{}, len(productIDs))
-		for i, id := range productIDs {
-			args[i] = id
-		}
-
-		rows, err := db.Query(query, args...)
 		if err != nil {
-			logger.Error("DB query failed (Fav products)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
 			return
 		}
-		defer rows.Close()
-
-		var results []FavoriteItemResponse
-		for rows.Next() {
-			var resp FavoriteItemResponse
-			var avgReview sql.NullFloat64
-			var reviewCount sql.NullInt64
-
-			err := rows.Scan(
-				&resp.ProductID,
-				&resp.ProductName,
-				&resp.SellerName,
-				&resp.Price,
-				&resp.ProductCondition,
-				&resp.Stocks,
-				&resp.Summary,
-				&avgReview,
-				&reviewCount,
-				&resp.SaleFlag,
-				&resp.SaleID,
-				&resp.DiscountRate,
-			)
-			if err != nil {
-				continue
-			}
-			if avgReview.Valid {
-				resp.AvgReview = avgReview.Float64
-			}
-			if reviewCount.Valid {
-				resp.ReviewCount = int(reviewCount.Int64)
-			}
-			results = append(results, resp)
-		}
-
-		c.JSON(http.StatusOK, results)
-	}
-}
-
-func addFavoriteItemHandler(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID, err := getUserIDFromAccessToken(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		if author == userID {
+			apierr.Abort(c, apierr.Validation("You cannot vote on your own review"))
 			return
 		}
 
-		productID := c.Param("productId")
-		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId"})
+		var prev *bool
+		var was bool
+		err = tx.QueryRowContext(ctx, `SELECT helpful FROM ReviewVote WHERE review_id = ? AND user_id = ? FOR UPDATE`, reviewID, userID).Scan(&was)
+		if err == nil {
+			prev = &was
+		} else if err != sql.ErrNoRows {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
 			return
 		}
 
-		var exists int
-		err = db.QueryRow(`SELECT 1 FROM Product WHERE product_id = ? LIMIT 1`, productID).Scan(&exists)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
+		if next == nil {
+			_, err = tx.ExecContext(ctx, `DELETE FROM ReviewVote WHERE review_id = ? AND user_id = ?`, reviewID, userID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+INSERT INTO ReviewVote (review_id, user_id, helpful) VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE helpful = VALUES(helpful)
+`, reviewID, userID, *next)
+		}
+		if dh, du := voteDeltas(prev, next); err == nil && (dh != 0 || du != 0) {
+			_, err = tx.ExecContext(ctx, `
+UPDATE Review
+SET helpful_count = GREATEST(helpful_count + ?, 0), unhelpful_count = GREATEST(unhelpful_count + ?, 0)
+WHERE review_id = ?
+`, dh, du, reviewID)
+		}
+		var helpfulCount, unhelpfulCount int
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `SELECT helpful_count, unhelpful_count FROM Review WHERE review_id = ?`, reviewID).Scan(&helpfulCount, &unhelpfulCount)
+		}
+		if err == nil {
+			err = tx.Commit()
 		}
-
-		upsertQuery := `
-INSERT INTO Wishlist (user_id, product_ids, updated)
-VALUES (?, JSON_ARRAY(?), NOW())
-ON DUPLICATE KEY UPDATE 
-  product_ids = IF(JSON_CONTAINS(product_ids, JSON_QUOTE(?)), product_ids, JSON_ARRAY_APPEND(product_ids, '$', ?)),
-  updated = NOW()
-`
-		_, err = db.Exec(upsertQuery, userID, productID, productID, productID)
 		if err != nil {
-			logger.Error("DB upsert failed (Wishlist add)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		c.JSON(http.StatusOK, gin.H{
+			"reviewId":       reviewID,
+			"helpful":        next,
+			"helpfulCount":   helpfulCount,
+			"unhelpfulCount": unhelpfulCount,
+		})
 	}
 }
 
-func removeFavoriteItemHandler(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID, err := getUserIDFromAccessToken(c)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
+// reviewImageBucket holds review photos, keyed "<review_id>/<image_id><ext>";
+// Kong serves it read-only under /api/review-photos.
+const reviewImageBucket = "review-photos"
 
-		productID := c.Param("productId")
-		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId"})
-			return
-		}
+// reviewImageTypes are the accepted photo types, by sniffed content type, and
+// the extension each is stored with.
+var reviewImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
 
-		updateQuery := `
-UPDATE Wishlist
-SET product_ids = JSON_REMOVE(product_ids, JSON_UNQUOTE(JSON_SEARCH(product_ids, 'one', ?))),
-    updated = NOW()
-WHERE user_id = ? AND JSON_CONTAINS(product_ids, JSON_QUOTE(?))
-`
-		_, err = db.Exec(updateQuery, productID, userID, productID)
-		if err != nil {
-			logger.Error("DB update failed (Wishlist remove)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
-			return
+func reviewImageURL(key string) string { return "/api/review-photos/" + key }
+
+// fillReviewImages sets Images on each review, oldest photo first.
+func fillReviewImages(ctx context.Context, db *sql.DB, reviews []ReviewResponse) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+	byID := make(map[string]*ReviewResponse, len(reviews))
+	args := make([]any, 0, len(reviews))
+	for i := range reviews {
+		reviews[i].Images = []ReviewImageResponse{}
+		byID[reviews[i].ReviewID] = &reviews[i]
+		args = append(args, reviews[i].ReviewID)
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT review_id, image_id, object_key
+FROM ReviewImage
+WHERE review_id IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+ORDER BY created_at, image_id
+`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reviewID, imageID, key string
+		if err := rows.Scan(&reviewID, &imageID, &key); err != nil {
+			return err
 		}
+		if r := byID[reviewID]; r != nil {
+			r.Images = append(r.Images, ReviewImageResponse{ImageID: imageID, URL: reviewImageURL(key)})
+		}
+	}
+	return rows.Err()
+}
 
-		c.JSON(http.StatusOK, gin.H{"status": "success"})
+// sniffReviewImage checks an uploaded photo's size and, from its first bytes
+// rather than the client's Content-Type, its type. It returns the content
+// type and extension.
+func sniffReviewImage(fh *multipart.FileHeader, maxBytes int64) (string, string, error) {
+	if fh.Size <= 0 || fh.Size > maxBytes {
+		return "", "", fmt.Errorf("%s: must be at most %d bytes", fh.Filename, maxBytes)
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", fh.Filename, err)
 	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := reviewImageTypes[contentType]
+	if !ok {
+		return "", "", fmt.Errorf("%s: must be a JPEG, PNG or WebP image", fh.Filename)
+	}
+	return contentType, ext, nil
 }
 
-func recordBrowsingHistoryHandler(db *sql.DB) gin.HandlerFunc {
+// uploadReviewImagesHandler adds photos (multipart "images[]") to the caller's
+// own active review, up to REVIEW_IMAGES_MAX in all. Every file is checked
+// before any is stored; if one fails to store, the ones already written are
+// removed again. 201 returns all of the review's photos.
+func uploadReviewImagesHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := getUserIDFromAccessToken(c)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 			return
 		}
+		reviewID := strings.TrimSpace(c.Param("reviewId"))
+		maxBytes := int64(cfg.ReviewImageMaxBytes)
 
-		productID := c.Param("productId")
-		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing productId"})
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(cfg.ReviewImagesMax)*maxBytes+1<<20)
+		form, err := c.MultipartForm()
+		if err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid multipart form"))
 			return
 		}
-
-		_, err = db.Exec(`INSERT INTO BrowsingHistory (user_id, product_id) VALUES (?, ?)`, userID, productID)
-		if err != nil {
-			logger.Error("DB insert failed (BrowsingHistory)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		files := form.File["images[]"]
+		if len(files) == 0 {
+			apierr.Abort(c, apierr.Validation("No images uploaded").Field("images[]", "is required"))
+			return
+		}
+		types := make([]string, len(files))
+		exts := make([]string, len(files))
+		for i, fh := range files {
+			if types[i], exts[i], err = sniffReviewImage(fh, maxBytes); err != nil {
+				apierr.Abort(c, apierr.Validation(err.Error()).Field("images[]", "is invalid"))
+				return
+			}
+		}
+
+		ctx := c.Request.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		var author string
+		err = tx.QueryRowContext(ctx, `SELECT user_id FROM Review WHERE review_id = ? AND status = 'active' FOR UPDATE`, reviewID).Scan(&author)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Review not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if author != userID {
+			apierr.Abort(c, apierr.Forbidden("You can only add photos to your own review"))
+			return
+		}
+		var existing int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM ReviewImage WHERE review_id = ?`, reviewID).Scan(&existing); err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if existing+len(files) > cfg.ReviewImagesMax {
+			apierr.Abort(c, apierr.Validation(fmt.Sprintf("A review can have at most %d photos", cfg.ReviewImagesMax)).Field("images[]", "too many"))
+			return
+		}
+
+		var stored []string
+		err = func() error {
+			for i, fh := range files {
+				imageID := uuid.NewString()
+				key := reviewID + "/" + imageID + exts[i]
+				f, err := fh.Open()
+				if err != nil {
+					return err
+				}
+				_, err = minioClient.PutObject(ctx, reviewImageBucket, key, f, fh.Size, minio.PutObjectOptions{ContentType: types[i]})
+				f.Close()
+				if err != nil {
+					return fmt.Errorf("put %s: %w", key, err)
+				}
+				stored = append(stored, key)
+				if _, err := tx.ExecContext(ctx, `
+INSERT INTO ReviewImage (image_id, review_id, object_key, content_type, size_bytes)
+VALUES (?, ?, ?, ?, ?)
+`, imageID, reviewID, key, types[i], fh.Size); err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		}()
+		if err != nil {
+			for _, key := range stored {
+				_ = minioClient.RemoveObject(context.WithoutCancel(ctx), reviewImageBucket, key, minio.RemoveObjectOptions{})
+			}
+			logging.From(ctx).Error("review photo upload failed", zap.String("review_id", reviewID), zap.Error(err))
+			apierr.Abort(c, apierr.Unavailable("Could not store the photos, please try again", nil))
+			return
+		}
+
+		images := []ReviewResponse{{ReviewID: reviewID}}
+		if err := fillReviewImages(ctx, db, images); err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"reviewId": reviewID, "images": images[0].Images})
+	}
+}
+
+// deleteReviewImageHandler removes one photo from the caller's own review.
+func deleteReviewImageHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		reviewID := strings.TrimSpace(c.Param("reviewId"))
+		imageID := strings.TrimSpace(c.Param("imageId"))
+
+		ctx := c.Request.Context()
+		var key, author string
+		err = db.QueryRowContext(ctx, `
+SELECT i.object_key, r.user_id
+FROM ReviewImage i
+JOIN Review r ON r.review_id = i.review_id
+WHERE i.image_id = ? AND i.review_id = ?
+`, imageID, reviewID).Scan(&key, &author)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Photo not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if author != userID {
+			apierr.Abort(c, apierr.Forbidden("You can only remove photos from your own review"))
+			return
+		}
+		if err := minioClient.RemoveObject(ctx, reviewImageBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			logging.From(ctx).Error("review photo delete failed", zap.String("key", key), zap.Error(err))
+			apierr.Abort(c, apierr.Unavailable("Could not remove the photo, please try again", nil))
+			return
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM ReviewImage WHERE image_id = ?`, imageID); err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// removeReviewImages deletes every photo of a review, objects first, so a
+// failure leaves the rows to retry with. Removing a missing object is not
+// an error, which makes it safe to run twice.
+func removeReviewImages(ctx context.Context, db *sql.DB, reviewID string) error {
+	rows, err := db.QueryContext(ctx, `SELECT object_key FROM ReviewImage WHERE review_id = ?`, reviewID)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := minioClient.RemoveObject(ctx, reviewImageBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("remove %s: %w", key, err)
+		}
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM ReviewImage WHERE review_id = ?`, reviewID)
+	return err
+}
+
+// handleReviewDeleted drops the photos of a review its author deleted.
+func handleReviewDeleted(db *sql.DB) events.Handler {
+	return func(ctx context.Context, e events.Event) error {
+		var p events.ReviewDeletedPayload
+		if err := e.Decode(&p); err != nil {
+			return err
+		}
+		return removeReviewImages(ctx, db, p.ReviewID)
+	}
+}
+
+// handleReviewModerated drops the photos of a review an admin hid or deleted
+// (sale's moderation queue). A restored review comes back without them.
+func handleReviewModerated(db *sql.DB) events.Handler {
+	return func(ctx context.Context, e events.Event) error {
+		var p events.ReviewModeratedPayload
+		if err := e.Decode(&p); err != nil {
+			return err
+		}
+		if p.Action != "hide" && p.Action != "delete" {
+			return nil
+		}
+		return removeReviewImages(ctx, db, p.ReviewID)
+	}
+}
+
+// ensureReviewImageBucket creates the review photo bucket with anonymous read
+// if it is missing; minIO/init.sh does the same for a fresh MinIO.
+func ensureReviewImageBucket(ctx context.Context) error {
+	ok, err := minioClient.BucketExists(ctx, reviewImageBucket)
+	if err != nil || ok {
+		return err
+	}
+	if err := minioClient.MakeBucket(ctx, reviewImageBucket, minio.MakeBucketOptions{}); err != nil {
+		return err
+	}
+	policy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::` + reviewImageBucket + `/*"]}]}`
+	return minioClient.SetBucketPolicy(ctx, reviewImageBucket, policy)
+}
+
+// deleteItemReviewHandler withdraws the caller's own active review of the
+// product: the review becomes 'deleted' (moderated_by stays empty, so the
+// author may post again later), and the product's rating and histogram drop
+// it in the same transaction. A ReviewDeleted event refreshes the search
+// index and removes the review's photos.
+func deleteItemReviewHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		productID := strings.TrimSpace(c.Param("reviewId")) // see the route in main
+
+		ctx := c.Request.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		var reviewID string
+		var rating int
+		err = tx.QueryRowContext(ctx, `
+SELECT review_id, rating FROM Review
+WHERE product_id = ? AND user_id = ? AND status = 'active'
+LIMIT 1 FOR UPDATE
+`, productID, userID).Scan(&reviewID, &rating)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Review not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+
+		var avg float64
+		var cnt int
+		_, err = tx.ExecContext(ctx, `UPDATE Review SET status = 'deleted' WHERE review_id = ?`, reviewID)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `SELECT CAST(avg_review AS DECIMAL(10,4)) + 0.0, review_count FROM Product WHERE product_id = ? LIMIT 1 FOR UPDATE`, productID).Scan(&avg, &cnt)
+		}
+		if err == nil {
+			avg, cnt = ratings.After(avg, cnt, rating, -1)
+			_, err = tx.ExecContext(ctx, `UPDATE Product SET avg_review = ?, review_count = ? WHERE product_id = ?`, avg, cnt, productID)
+		}
+		if err == nil {
+			err = updateReviewHistogram(ctx, tx, productID, map[int]int{rating: -1})
+		}
+		if err == nil {
+			err = events.Enqueue(ctx, tx, events.ReviewDeleted, productID, events.ReviewDeletedPayload{
+				ReviewID: reviewID, ProductID: productID, UserID: userID, AvgReview: avg, ReviewCount: cnt,
+			})
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB update failed (review delete)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"productId":   productID,
+			"reviewId":    reviewID,
+			"status":      "deleted",
+			"avgReview":   avg,
+			"reviewCount": cnt,
+		})
+	}
+}
+
+// reportReasons are the ReviewReport.reason values a buyer can choose.
+var reportReasons = map[string]bool{
+	"spam": true, "offensive": true, "off_topic": true, "fake": true, "personal_info": true, "other": true,
+}
+
+type ReportReviewRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+// reportReviewHandler lets a signed-in buyer flag someone else's review for
+// the admin moderation queue (sale's /v1/admin/reviews). Reporting again
+// updates the reason while the report is still open; 201 is a new report.
+func reportReviewHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		reviewID := strings.TrimSpace(c.Param("reviewId"))
+
+		var req ReportReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
+			return
+		}
+		req.Reason = strings.ToLower(strings.TrimSpace(req.Reason))
+		req.Details = strings.TrimSpace(req.Details)
+		if !reportReasons[req.Reason] {
+			apierr.Abort(c, apierr.Validation("reason must be one of spam, offensive, off_topic, fake, personal_info, other").Field("reason", "is invalid"))
+			return
+		}
+		if len(req.Details) > 500 {
+			apierr.Abort(c, apierr.Validation("details too long").Field("details", "must be at most 500 characters"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		var author string
+		err = db.QueryRowContext(ctx, `SELECT user_id FROM Review WHERE review_id = ? AND status = 'active'`, reviewID).Scan(&author)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Review not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if author == userID {
+			apierr.Abort(c, apierr.Validation("You cannot report your own review"))
+			return
+		}
+
+		res, err := db.ExecContext(ctx, `
+INSERT INTO ReviewReport (review_id, reporter_id, reason, details)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  reason = IF(status = 'open', VALUES(reason), reason),
+  details = IF(status = 'open', VALUES(details), details)
+`, reviewID, userID, req.Reason, req.Details)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		status := http.StatusOK
+		if n, _ := res.RowsAffected(); n == 1 {
+			status = http.StatusCreated
+			_, _ = db.ExecContext(ctx, "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'customer', ?, 'success')",
+				"Review Reported ("+req.Reason+")", userID, reviewID)
+		}
+		c.JSON(status, gin.H{"reviewId": reviewID, "reason": req.Reason, "status": "reported"})
+	}
+}
+
+// ---- Wishlists ----
+
+const (
+	defaultWishlistName = "My Favorites"
+	wishlistsPerUserMax = 20
+	wishlistItemsMax    = 500
+)
+
+// favoriteSorts maps the sort query of a wishlist page to its ORDER BY.
+var favoriteSorts = map[string]string{
+	"newest":     "i.added_at DESC, i.product_id",
+	"oldest":     "i.added_at ASC, i.product_id",
+	"price_asc":  "p.price ASC, i.added_at DESC",
+	"price_desc": "p.price DESC, i.added_at DESC",
+	"name":       "p.product_name ASC, i.added_at DESC",
+}
+
+type favoriteQuery struct {
+	Limit  int
+	Offset int
+	Sort   string
+}
+
+func parseFavoriteQuery(c *gin.Context) (favoriteQuery, error) {
+	q := favoriteQuery{Limit: 20, Sort: "newest"}
+	if v := c.Query("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 100 {
+			q.Limit = n
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			q.Offset = n
+		}
+	}
+	if v := c.Query("sort"); v != "" {
+		if _, ok := favoriteSorts[v]; !ok {
+			return q, apierr.Validation("sort must be one of newest, oldest, price_asc, price_desc, name").Field("sort", "is invalid")
+		}
+		q.Sort = v
+	}
+	return q, nil
+}
+
+// wishlistName trims a list name and checks it fits Wishlist.name, counted in
+// characters as MySQL counts a VARCHAR.
+func wishlistName(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || utf8.RuneCountInString(s) > 100 {
+		return "", apierr.Validation("name must be 1 to 100 characters").Field("name", "is invalid")
+	}
+	return s, nil
+}
+
+// newShareToken is the secret in a wishlist's public link: 24 URL-safe
+// characters from 18 random bytes.
+func newShareToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type WishlistResponse struct {
+	WishlistID string    `json:"wishlistId"`
+	Name       string    `json:"name"`
+	IsDefault  bool      `json:"isDefault"`
+	ItemCount  int       `json:"itemCount"`
+	ShareToken string    `json:"shareToken,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type FavoriteListResponse struct {
+	WishlistID string                 `json:"wishlistId,omitempty"`
+	Name       string                 `json:"name"`
+	IsDefault  bool                   `json:"isDefault,omitempty"`
+	ShareToken string                 `json:"shareToken,omitempty"`
+	Total      int                    `json:"total"`
+	Limit      int                    `json:"limit"`
+	Offset     int                    `json:"offset"`
+	Sort       string                 `json:"sort"`
+	Items      []FavoriteItemResponse `json:"items"`
+}
+
+type FavoriteItemRequest struct {
+	Note     *string `json:"note"`
+	Quantity *int    `json:"quantity"`
+}
+
+type WishlistRequest struct {
+	Name string `json:"name"`
+}
+
+// userWishlist loads one of the user's lists; sql.ErrNoRows when it does not
+// exist or belongs to someone else. An empty listID is the default list.
+func userWishlist(ctx context.Context, db *sql.DB, userID, listID string) (WishlistResponse, error) {
+	var w WishlistResponse
+	var token sql.NullString
+	query := `
+SELECT w.wishlist_id, w.name, w.is_default, w.share_token, w.created_at, w.updated_at,
+       (SELECT COUNT(*) FROM WishlistItem i WHERE i.wishlist_id = w.wishlist_id)
+FROM Wishlist w
+WHERE w.user_id = ? AND `
+	args := []any{userID}
+	if listID == "" {
+		query += `w.is_default = 1`
+	} else {
+		query += `w.wishlist_id = ?`
+		args = append(args, listID)
+	}
+	err := db.QueryRowContext(ctx, query, args...).Scan(&w.WishlistID, &w.Name, &w.IsDefault, &token, &w.CreatedAt, &w.UpdatedAt, &w.ItemCount)
+	w.ShareToken = token.String
+	return w, err
+}
+
+// defaultWishlistID returns the user's default list, creating it on first use.
+func defaultWishlistID(ctx context.Context, db *sql.DB, userID string) (string, error) {
+	_, err := db.ExecContext(ctx, `INSERT IGNORE INTO Wishlist (wishlist_id, user_id, name, is_default) VALUES (?, ?, ?, 1)`,
+		uuid.NewString(), userID, defaultWishlistName)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = db.QueryRowContext(ctx, `SELECT wishlist_id FROM Wishlist WHERE default_owner = ?`, userID).Scan(&id)
+	return id, err
+}
+
+// fetchFavoriteItems returns one page of a list's items and the list's total.
+func fetchFavoriteItems(ctx context.Context, db *sql.DB, wishlistID string, q favoriteQuery) ([]FavoriteItemResponse, int, error) {
+	var total int
+	err := db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM WishlistItem i JOIN Product p ON p.product_id = i.product_id WHERE i.wishlist_id = ?
+`, wishlistID).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+SELECT
+  p.product_id,
+  p.product_name,
+  COALESCE(s.seller_name, '') AS seller_name,
+  p.price,
+  p.product_condition,
+  COALESCE(t.stocks, 0) AS stocks,
+  p.summary,
+  p.avg_review,
+  p.review_count,
+  p.sale_flag,
+  COALESCE(p.sale_id, '') AS sale_id,
+  COALESCE(ts.discount_rate, 0.0) AS discount_rate,
+  p.seller_id,
+  i.note,
+  i.desired_quantity,
+  i.added_at
+FROM WishlistItem i
+JOIN Product p ON p.product_id = i.product_id
+LEFT JOIN Stock t ON p.product_id = t.product_id
+LEFT JOIN Seller s ON p.seller_id = s.seller_id
+LEFT JOIN TimeSale ts ON p.sale_id = ts.id
+WHERE i.wishlist_id = ?
+ORDER BY `+favoriteSorts[q.Sort]+`
+LIMIT ? OFFSET ?
+`, wishlistID, q.Limit, q.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []FavoriteItemResponse{}
+	for rows.Next() {
+		var resp FavoriteItemResponse
+		var avgReview sql.NullFloat64
+		var reviewCount sql.NullInt64
+		var sellerID string
+
+		err := rows.Scan(
+			&resp.ProductID,
+			&resp.ProductName,
+			&resp.SellerName,
+			&resp.Price,
+			&resp.ProductCondition,
+			&resp.Stocks,
+			&resp.Summary,
+			&avgReview,
+			&reviewCount,
+			&resp.SaleFlag,
+			&resp.SaleID,
+			&resp.DiscountRate,
+			&sellerID,
+			&resp.Note,
+			&resp.Quantity,
+			&resp.AddedAt,
+		)
+		if err != nil {
+			continue
+		}
+		if resp.SellerName == "" {
+			resp.SellerName = sellerDisplayName(ctx, sellerID)
+		}
+		if avgReview.Valid {
+			resp.AvgReview = avgReview.Float64
+		}
+		if reviewCount.Valid {
+			resp.ReviewCount = int(reviewCount.Int64)
+		}
+		results = append(results, resp)
+	}
+	return results, total, rows.Err()
+}
+
+// getFavoriteListHandler pages through one of the caller's lists (?list=,
+// default list when absent).
+func getFavoriteListHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		q, err := parseFavoriteQuery(c)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		ctx := c.Request.Context()
+		listID := strings.TrimSpace(c.Query("list"))
+		w, err := userWishlist(ctx, db, userID, listID)
+		if err == sql.ErrNoRows {
+			if listID != "" {
+				apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+				return
+			}
+			c.JSON(http.StatusOK, FavoriteListResponse{Name: defaultWishlistName, IsDefault: true, Limit: q.Limit, Offset: q.Offset, Sort: q.Sort, Items: []FavoriteItemResponse{}})
+			return
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Wishlist)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+
+		items, total, err := fetchFavoriteItems(ctx, db, w.WishlistID, q)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Fav products)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		c.JSON(http.StatusOK, FavoriteListResponse{
+			WishlistID: w.WishlistID,
+			Name:       w.Name,
+			IsDefault:  w.IsDefault,
+			ShareToken: w.ShareToken,
+			Total:      total,
+			Limit:      q.Limit,
+			Offset:     q.Offset,
+			Sort:       q.Sort,
+			Items:      items,
+		})
+	}
+}
+
+// getFavoriteIDsHandler lists every product on any of the caller's lists, for
+// the heart toggles on product cards.
+func getFavoriteIDsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		ctx := c.Request.Context()
+		rows, err := db.QueryContext(ctx, `
+SELECT DISTINCT i.product_id FROM WishlistItem i JOIN Wishlist w ON w.wishlist_id = i.wishlist_id WHERE w.user_id = ?
+`, userID)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Wishlist ids)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		defer rows.Close()
+		ids := []string{}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"productIds": ids})
+	}
+}
+
+// addFavoriteItemHandler puts a product on one of the caller's lists (?list=,
+// default list when absent). Adding it again keeps added_at and updates the
+// note and quantity given in the optional body.
+func addFavoriteItemHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		productID := c.Param("productId")
+		if productID == "" {
+			apierr.Abort(c, apierr.Validation("Missing productId"))
+			return
+		}
+
+		var req FavoriteItemRequest
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierr.Abort(c, apierr.Validation("Invalid request body"))
+				return
+			}
+		}
+		note, quantity := "", 1
+		if req.Note != nil {
+			note = strings.TrimSpace(*req.Note)
+			if len(note) > 500 {
+				apierr.Abort(c, apierr.Validation("note too long").Field("note", "must be at most 500 characters"))
+				return
+			}
+		}
+		if req.Quantity != nil {
+			quantity = *req.Quantity
+			if quantity < 1 || quantity > 99 {
+				apierr.Abort(c, apierr.Validation("quantity must be between 1 and 99").Field("quantity", "is invalid"))
+				return
+			}
+		}
+
+		ctx := c.Request.Context()
+		cur, err := currentProductSnapshot(ctx, db, productID)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Product not found"))
+			return
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Product snapshot)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+
+		var w WishlistResponse
+		if listID := strings.TrimSpace(c.Query("list")); listID != "" {
+			w, err = userWishlist(ctx, db, userID, listID)
+		} else if w.WishlistID, err = defaultWishlistID(ctx, db, userID); err == nil {
+			w, err = userWishlist(ctx, db, userID, w.WishlistID)
+		}
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+			return
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Wishlist)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		if w.ItemCount >= wishlistItemsMax {
+			var on int
+			err := db.QueryRowContext(ctx, `SELECT 1 FROM WishlistItem WHERE wishlist_id = ? AND product_id = ?`, w.WishlistID, productID).Scan(&on)
+			if err == sql.ErrNoRows {
+				apierr.Abort(c, apierr.Conflict(fmt.Sprintf("A wishlist holds at most %d items", wishlistItemsMax)))
+				return
+			}
+			if err != nil {
+				logging.From(ctx).Error("DB query failed (WishlistItem)", zap.Error(err))
+				apierr.Abort(c, apierr.Internal("Internal server error", nil))
+				return
+			}
+		}
+
+		_, err = db.ExecContext(ctx, `
+INSERT INTO WishlistItem (wishlist_id, product_id, note, desired_quantity, added_price)
+VALUES (?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  note = IF(?, VALUES(note), note),
+  desired_quantity = IF(?, VALUES(desired_quantity), desired_quantity)
+`, w.WishlistID, productID, note, quantity, cur.Price, req.Note != nil, req.Quantity != nil)
+		if err == nil {
+			_, err = db.ExecContext(ctx, `UPDATE Wishlist SET updated_at = NOW() WHERE wishlist_id = ?`, w.WishlistID)
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB upsert failed (Wishlist add)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		snapshotProduct(ctx, db, productID, cur)
+
+		c.JSON(http.StatusOK, gin.H{"status": "success", "wishlistId": w.WishlistID})
+	}
+}
+
+// removeFavoriteItemHandler takes a product off one of the caller's lists
+// (?list=), or off all of them when no list is given.
+func removeFavoriteItemHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		productID := c.Param("productId")
+		if productID == "" {
+			apierr.Abort(c, apierr.Validation("Missing productId"))
+			return
+		}
+
+		query := `
+DELETE i FROM WishlistItem i
+JOIN Wishlist w ON w.wishlist_id = i.wishlist_id
+WHERE w.user_id = ? AND i.product_id = ?`
+		args := []any{userID, productID}
+		if listID := strings.TrimSpace(c.Query("list")); listID != "" {
+			query += ` AND w.wishlist_id = ?`
+			args = append(args, listID)
+		}
+		_, err = db.ExecContext(c.Request.Context(), query, args...)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB update failed (Wishlist remove)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	}
+}
+
+// listWishlistsHandler returns the caller's lists, default first.
+func listWishlistsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		ctx := c.Request.Context()
+		rows, err := db.QueryContext(ctx, `
+SELECT w.wishlist_id, w.name, w.is_default, w.share_token, w.created_at, w.updated_at, COUNT(i.product_id)
+FROM Wishlist w
+LEFT JOIN WishlistItem i ON i.wishlist_id = w.wishlist_id
+WHERE w.user_id = ?
+GROUP BY w.wishlist_id
+ORDER BY w.is_default DESC, w.created_at, w.name
+`, userID)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Wishlist lists)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		defer rows.Close()
+		lists := []WishlistResponse{}
+		for rows.Next() {
+			var w WishlistResponse
+			var token sql.NullString
+			if err := rows.Scan(&w.WishlistID, &w.Name, &w.IsDefault, &token, &w.CreatedAt, &w.UpdatedAt, &w.ItemCount); err != nil {
+				continue
+			}
+			w.ShareToken = token.String
+			lists = append(lists, w)
+		}
+		c.JSON(http.StatusOK, gin.H{"wishlists": lists})
+	}
+}
+
+// createWishlistHandler adds a named list. Names are unique per user, and a
+// user has at most wishlistsPerUserMax lists.
+func createWishlistHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		var req WishlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
+			return
+		}
+		name, err := wishlistName(req.Name)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		if _, err := defaultWishlistID(ctx, db, userID); err != nil {
+			logging.From(ctx).Error("DB upsert failed (Wishlist default)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		// Locking the caller's lists serializes their creates, so the count
+		// and the name check hold until commit.
+		rows, err := tx.QueryContext(ctx, `SELECT name FROM Wishlist WHERE user_id = ? FOR UPDATE`, userID)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		count, taken := 0, false
+		for rows.Next() {
+			var existing string
+			if rows.Scan(&existing) == nil && strings.EqualFold(existing, name) {
+				taken = true
+			}
+			count++
+		}
+		rows.Close()
+		if taken {
+			apierr.Abort(c, apierr.Conflict("You already have a wishlist with that name").Field("name", "is taken"))
+			return
+		}
+		if count >= wishlistsPerUserMax {
+			apierr.Abort(c, apierr.Conflict(fmt.Sprintf("You can have at most %d wishlists", wishlistsPerUserMax)))
+			return
+		}
+
+		id := uuid.NewString()
+		_, err = tx.ExecContext(ctx, `INSERT INTO Wishlist (wishlist_id, user_id, name) VALUES (?, ?, ?)`, id, userID, name)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB insert failed (Wishlist create)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		w, err := userWishlist(ctx, db, userID, id)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.JSON(http.StatusCreated, w)
+	}
+}
+
+// renameWishlistHandler renames one of the caller's lists.
+func renameWishlistHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		var req WishlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
+			return
+		}
+		name, err := wishlistName(req.Name)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+
+		ctx := c.Request.Context()
+		listID := c.Param("listId")
+		var clash int
+		err = db.QueryRowContext(ctx, `SELECT 1 FROM Wishlist WHERE user_id = ? AND name = ? AND wishlist_id <> ?`, userID, name, listID).Scan(&clash)
+		if err == nil {
+			apierr.Abort(c, apierr.Conflict("You already have a wishlist with that name").Field("name", "is taken"))
+			return
+		}
+		if err != sql.ErrNoRows {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		_, err = db.ExecContext(ctx, `UPDATE Wishlist SET name = ? WHERE wishlist_id = ? AND user_id = ?`, name, listID, userID)
+		if err != nil {
+			logging.From(ctx).Error("DB update failed (Wishlist rename)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		w, err := userWishlist(ctx, db, userID, listID)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// deleteWishlistHandler deletes one of the caller's lists with its items; the
+// default list stays.
+func deleteWishlistHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		w, err := userWishlist(ctx, db, userID, c.Param("listId"))
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if w.IsDefault {
+			apierr.Abort(c, apierr.Conflict("The default wishlist cannot be deleted"))
+			return
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM Wishlist WHERE wishlist_id = ? AND user_id = ?`, w.WishlistID, userID); err != nil {
+			logging.From(ctx).Error("DB delete failed (Wishlist)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "wishlistId": w.WishlistID})
+	}
+}
+
+// shareWishlistHandler turns on the list's public link (POST), keeping an
+// existing token, or revokes it (DELETE) so old links stop working.
+func shareWishlistHandler(db *sql.DB, share bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		w, err := userWishlist(ctx, db, userID, c.Param("listId"))
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+
+		if !share {
+			_, err = db.ExecContext(ctx, `UPDATE Wishlist SET share_token = NULL WHERE wishlist_id = ?`, w.WishlistID)
+			if err != nil {
+				logging.From(ctx).Error("DB update failed (Wishlist unshare)", zap.Error(err))
+				apierr.Abort(c, apierr.Internal("Internal server error", nil))
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"wishlistId": w.WishlistID, "shared": false})
+			return
+		}
+
+		token := w.ShareToken
+		if token == "" {
+			if token, err = newShareToken(); err == nil {
+				_, err = db.ExecContext(ctx, `UPDATE Wishlist SET share_token = ? WHERE wishlist_id = ? AND share_token IS NULL`, token, w.WishlistID)
+			}
+			if err == nil {
+				// Re-read in case a concurrent request shared it first.
+				w, err = userWishlist(ctx, db, userID, w.WishlistID)
+				token = w.ShareToken
+			}
+			if err != nil {
+				logging.From(ctx).Error("DB update failed (Wishlist share)", zap.Error(err))
+				apierr.Abort(c, apierr.Internal("Internal server error", nil))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"wishlistId": w.WishlistID, "shared": true, "shareToken": token, "shareUrl": "/fav/shared/" + token})
+	}
+}
+
+// sharedWishlistHandler is the public, read-only view of a shared list. It
+// needs no login; the token is the only key.
+func sharedWishlistHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q, err := parseFavoriteQuery(c)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+		token := strings.TrimSpace(c.Param("token"))
+
+		ctx := c.Request.Context()
+		var id, name string
+		err = db.QueryRowContext(ctx, `SELECT wishlist_id, name FROM Wishlist WHERE share_token = ?`, token).Scan(&id, &name)
+		if err == sql.ErrNoRows || token == "" {
+			apierr.Abort(c, apierr.NotFound("Wishlist not found"))
+			return
+		}
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Wishlist shared)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		items, total, err := fetchFavoriteItems(ctx, db, id, q)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (Fav products)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, FavoriteListResponse{Name: name, Total: total, Limit: q.Limit, Offset: q.Offset, Sort: q.Sort, Items: items})
+	}
+}
+
+// ---- Price-drop and back-in-stock alerts ----
+
+var (
+	productAlertsRaised = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "product_alerts_raised_total",
+		Help: "Price-drop and back-in-stock alerts raised for users, by kind.",
+	}, []string{"kind"})
+	productAlertsRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "product_alerts_rate_limited_total",
+		Help: "Alerts dropped because the user had reached ALERTS_PER_DAY.",
+	})
+	productAlertsLimiterErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "product_alerts_limiter_errors_total",
+		Help: "ALERTS_PER_DAY checks that failed; the alert was delivered anyway.",
+	})
+)
+
+const (
+	alertPriceDrop   = "price_drop"
+	alertBackInStock = "back_in_stock"
+)
+
+// effectivePriceSQL is what a buyer pays for p now: the list price less the
+// discount of a time sale inside its window (sale_flag alone is not enough).
+// The query must join TimeSale ts ON ts.id = p.sale_id.
+const effectivePriceSQL = `ROUND(COALESCE(p.price, 0) * (1 - IF(p.sale_flag = 1 AND NOW() BETWEEN ts.start_date AND ts.end_date, ts.discount_rate, 0)), 2)`
+
+// productSnapshot is a product's effective price and stock. Known is false
+// until the watcher has seen the product once.
+type productSnapshot struct {
+	Price float64
+	Stock int
+	Known bool
+}
+
+// alertWatch is one user watching a product through their wishlist or a
+// subscription.
+type alertWatch struct {
+	UserID      string
+	Source      string    // "wishlist" or "subscription"
+	Reference   float64   // the price when they started watching
+	DropPct     int       // 0: no price alerts
+	BackInStock bool      // alert on restock
+	Since       time.Time // when Reference was taken
+	LastAlerted float64   // lowest price already alerted since Since; 0: none
+}
+
+// dropPercent is how far cur is below ref, in percent to two decimals.
+func dropPercent(ref, cur float64) float64 {
+	if ref <= 0 {
+		return 0
+	}
+	return math.Round((ref-cur)/ref*10000) / 100
+}
+
+// alertsFor decides which alerts w gets when the product moves from prev to
+// cur. A price alert needs the price to be at least DropPct below the
+// reference, lower than any price already alerted, and the product to be
+// buyable, however it got there (a drop while out of stock counts once the
+// stock returns); a restock alert needs the stock to go from none to some.
+// Seeing the same state twice is left to alertDedupeKey.
+func alertsFor(w alertWatch, prev, cur productSnapshot) []string {
+	var kinds []string
+	if w.DropPct > 0 && cur.Stock > 0 &&
+		dropPercent(w.Reference, cur.Price) >= float64(w.DropPct) &&
+		(w.LastAlerted == 0 || cur.Price < w.LastAlerted) {
+		kinds = append(kinds, alertPriceDrop)
+	}
+	if w.BackInStock && prev.Known && prev.Stock <= 0 && cur.Stock > 0 {
+		kinds = append(kinds, alertBackInStock)
+	}
+	return kinds
+}
+
+// alertDedupeKey identifies an alert so that evaluating the same change twice
+// raises it once: per reference and price for price drops, so a user who
+// re-watches at a new reference can be alerted at a price seen before, and
+// per UTC day for restocks.
+func alertDedupeKey(kind, userID, productID string, reference, price float64, at time.Time) string {
+	if kind == alertPriceDrop {
+		return fmt.Sprintf("%s:%s:%s:%d:%d", kind, userID, productID,
+			int64(math.Round(reference*100)), int64(math.Round(price*100)))
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", kind, userID, productID, at.UTC().Format("2006-01-02"))
+}
+
+// currentProductSnapshot reads p's effective price and stock; sql.ErrNoRows
+// when the product is gone or switched off.
+func currentProductSnapshot(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}, productID string) (productSnapshot, error) {
+	s := productSnapshot{Known: true}
+	err := q.QueryRowContext(ctx, `
+SELECT `+effectivePriceSQL+`, COALESCE(st.stocks, 0)
+FROM Product p
+LEFT JOIN Stock st ON st.product_id = p.product_id
+LEFT JOIN TimeSale ts ON ts.id = p.sale_id
+WHERE p.product_id = ? AND p.is_active = 1 AND p.deleted_at IS NULL
+`, productID).Scan(&s.Price, &s.Stock)
+	return s, err
+}
+
+// snapshotProduct records p's price and stock when someone starts watching it,
+// so the first change after that has something to compare with.
+func snapshotProduct(ctx context.Context, db *sql.DB, productID string, s productSnapshot) {
+	_, err := db.ExecContext(ctx, `INSERT IGNORE INTO ProductAlertSnapshot (product_id, price, stock) VALUES (?, ?, ?)`, productID, s.Price, s.Stock)
+	if err != nil {
+		logging.From(ctx).Warn("alert snapshot failed", zap.String("product_id", productID), zap.Error(err))
+	}
+}
+
+// alertWatcher turns price, stock and time sale changes of watched products
+// into per-user alerts (ProductAlert rows and ProductAlertRaised events).
+type alertWatcher struct {
+	db             *sql.DB
+	limiter        ratelimit.Limiter
+	policy         ratelimit.Policy // per user
+	defaultDropPct int
+}
+
+// handleEvent evaluates the product of a StockChanged or ProductUpdated event.
+func (a *alertWatcher) handleEvent(ctx context.Context, e events.Event) error {
+	var p struct {
+		ProductID string `json:"productId"`
+	}
+	if err := e.Decode(&p); err != nil {
+		return err
+	}
+	if p.ProductID == "" {
+		return nil
+	}
+	_, err := a.evaluate(ctx, p.ProductID)
+	return err
+}
+
+// evaluate compares a watched product with its snapshot, records the alerts
+// the change calls for and moves the snapshot on, in one transaction with the
+// snapshot row locked, then delivers the recorded alerts. It returns the number
+// of alerts delivered.
+func (a *alertWatcher) evaluate(ctx context.Context, productID string) (int, error) {
+	var watched bool
+	err := a.db.QueryRowContext(ctx, `
+SELECT EXISTS (SELECT 1 FROM WishlistItem WHERE product_id = ?) OR EXISTS (SELECT 1 FROM ProductSubscription WHERE product_id = ?)
+`, productID, productID).Scan(&watched)
+	if err != nil || !watched {
+		return 0, err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `INSERT IGNORE INTO ProductAlertSnapshot (product_id) VALUES (?)`, productID); err != nil {
+		return 0, err
+	}
+	var price sql.NullFloat64
+	var stock sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT price, stock FROM ProductAlertSnapshot WHERE product_id = ? FOR UPDATE`, productID).Scan(&price, &stock)
+	if err != nil {
+		return 0, err
+	}
+	prev := productSnapshot{Price: price.Float64, Stock: int(stock.Int64), Known: price.Valid && stock.Valid}
+
+	cur, err := currentProductSnapshot(ctx, tx, productID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var recorded []events.ProductAlertRaisedPayload
+	if !prev.Known || cur.Price != prev.Price || cur.Stock != prev.Stock {
+		watches, err := a.watches(ctx, tx, productID, prev)
+		if err != nil {
+			return 0, err
+		}
+		now := time.Now()
+		for _, w := range watches {
+			for _, kind := range alertsFor(w, prev, cur) {
+				alert, ok, err := a.record(ctx, tx, productID, w, kind, cur, now)
+				if err != nil {
+					return 0, err
+				}
+				if ok {
+					recorded = append(recorded, alert)
+				}
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE ProductAlertSnapshot SET price = ?, stock = ? WHERE product_id = ?`, cur.Price, cur.Stock, productID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return a.deliver(ctx, recorded), nil
+}
+
+// wishlistReference is the price a wishlist watch measures drops against: the
+// price when the item was added, else (items from before added_price was
+// recorded) the last price the watcher saw.
+func wishlistReference(added sql.NullFloat64, prev productSnapshot) float64 {
+	if added.Valid {
+		return added.Float64
+	}
+	return prev.Price
+}
+
+// watches lists who watches the product: wishlist owners with alerts on, on
+// their own or the default threshold, and subscribers, whose subscription
+// wins over their wishlist. prev is the product's snapshot.
+func (a *alertWatcher) watches(ctx context.Context, tx *sql.Tx, productID string, prev productSnapshot) ([]alertWatch, error) {
+	byUser := map[string]alertWatch{}
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT w.user_id, MAX(i.added_price), MIN(i.added_at),
+       COALESCE(ap.price_drop_pct, ?), COALESCE(ap.back_in_stock, 1)
+FROM WishlistItem i
+JOIN Wishlist w ON w.wishlist_id = i.wishlist_id
+LEFT JOIN AlertPreference ap ON ap.user_id = w.user_id
+WHERE i.product_id = ? AND COALESCE(ap.wishlist_alerts, 1) = 1
+GROUP BY w.user_id, ap.price_drop_pct, ap.back_in_stock
+`, a.defaultDropPct, productID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		w := alertWatch{Source: "wishlist"}
+		var added sql.NullFloat64
+		if err := rows.Scan(&w.UserID, &added, &w.Since, &w.DropPct, &w.BackInStock); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		w.Reference = wishlistReference(added, prev)
+		byUser[w.UserID] = w
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `
+SELECT user_id, reference_price, updated_at, COALESCE(price_drop_pct, 0), back_in_stock
+FROM ProductSubscription WHERE product_id = ?
+`, productID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		w := alertWatch{Source: "subscription"}
+		if err := rows.Scan(&w.UserID, &w.Reference, &w.Since, &w.DropPct, &w.BackInStock); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byUser[w.UserID] = w
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT user_id, new_price, created_at FROM ProductAlert WHERE product_id = ? AND kind = 'price_drop'`, productID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID string
+		var alerted float64
+		var at time.Time
+		if err := rows.Scan(&userID, &alerted, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if w, ok := byUser[userID]; ok && !at.Before(w.Since) && (w.LastAlerted == 0 || alerted < w.LastAlerted) {
+			w.LastAlerted = alerted
+			byUser[userID] = w
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	watches := make([]alertWatch, 0, len(byUser))
+	for _, w := range byUser {
+		watches = append(watches, w)
+	}
+	return watches, nil
+}
+
+// record writes one ProductAlert row unless the alert was recorded before,
+// and reports whether it did.
+func (a *alertWatcher) record(ctx context.Context, tx *sql.Tx, productID string, w alertWatch, kind string, cur productSnapshot, now time.Time) (events.ProductAlertRaisedPayload, bool, error) {
+	alert := events.ProductAlertRaisedPayload{
+		AlertID:   uuid.NewString(),
+		UserID:    w.UserID,
+		ProductID: productID,
+		Kind:      kind,
+		OldPrice:  w.Reference,
+		NewPrice:  cur.Price,
+		Stock:     cur.Stock,
+	}
+	key := alertDedupeKey(kind, w.UserID, productID, w.Reference, cur.Price, now)
+	ins, err := tx.ExecContext(ctx, `
+INSERT IGNORE INTO ProductAlert (alert_id, user_id, product_id, kind, source, old_price, new_price, stock, dedupe_key)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, alert.AlertID, w.UserID, productID, kind, w.Source, w.Reference, cur.Price, cur.Stock, key)
+	if err != nil {
+		return alert, false, err
+	}
+	n, _ := ins.RowsAffected()
+	return alert, n > 0, nil
+}
+
+// deliver charges each committed alert to its user's daily allowance and
+// enqueues its ProductAlertRaised event. The allowance is only charged here,
+// after the commit, so a rolled back or redelivered evaluation does not use it
+// up. An alert over the allowance is deleted again; a failed check lets the
+// alert through, like every other limiter. It returns the number delivered.
+func (a *alertWatcher) deliver(ctx context.Context, alerts []events.ProductAlertRaisedPayload) int {
+	delivered := 0
+	for _, alert := range alerts {
+		l := logging.From(ctx).With(zap.String("user_id", alert.UserID), zap.String("product_id", alert.ProductID), zap.String("kind", alert.Kind))
+		res, err := a.limiter.Allow(ctx, "product-alerts:"+alert.UserID, a.policy)
+		if err != nil {
+			productAlertsLimiterErrors.Inc()
+			l.Warn("product alert rate limit check failed", zap.Error(err))
+		} else if !res.Allowed {
+			productAlertsRateLimited.Inc()
+			l.Info("product alert rate limited")
+			if _, err := a.db.ExecContext(ctx, `DELETE FROM ProductAlert WHERE alert_id = ?`, alert.AlertID); err != nil {
+				l.Error("failed to drop rate limited product alert", zap.Error(err))
+			}
+			continue
+		}
+		// The row is already committed, so a failed enqueue leaves the alert
+		// in the user's list without a notification rather than retrying the
+		// whole evaluation, which the dedupe key would turn into a no-op.
+		if err := events.Enqueue(ctx, a.db, events.ProductAlertRaised, alert.UserID, alert); err != nil {
+			l.Error("failed to enqueue product alert", zap.Error(err))
+			continue
+		}
+		productAlertsRaised.WithLabelValues(alert.Kind).Inc()
+		delivered++
+	}
+	return delivered
+}
+
+// sweep re-evaluates the watched products whose price or stock no longer
+// matches their snapshot. Time sales start and end without an event, so this
+// is what notices them, along with changes made behind the services' backs.
+// Snapshots of products nobody watches any more are dropped first.
+func (a *alertWatcher) sweep(ctx context.Context) (int, error) {
+	_, err := a.db.ExecContext(ctx, `
+DELETE sn FROM ProductAlertSnapshot sn
+WHERE NOT EXISTS (SELECT 1 FROM WishlistItem i WHERE i.product_id = sn.product_id)
+  AND NOT EXISTS (SELECT 1 FROM ProductSubscription ps WHERE ps.product_id = sn.product_id)
+`)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+SELECT sn.product_id
+FROM ProductAlertSnapshot sn
+JOIN Product p ON p.product_id = sn.product_id
+LEFT JOIN Stock st ON st.product_id = p.product_id
+LEFT JOIN TimeSale ts ON ts.id = p.sale_id
+WHERE p.is_active = 1 AND p.deleted_at IS NULL
+  AND (sn.price IS NULL OR sn.stock IS NULL OR sn.price <> `+effectivePriceSQL+` OR sn.stock <> COALESCE(st.stocks, 0))
+`)
+	if err != nil {
+		return 0, err
+	}
+	var changed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			changed = append(changed, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	raised := 0
+	for _, id := range changed {
+		n, err := a.evaluate(ctx, id)
+		if err != nil {
+			return raised, fmt.Errorf("product %s: %w", id, err)
+		}
+		raised += n
+	}
+	return raised, nil
+}
+
+// run sweeps every interval until ctx ends.
+func (a *alertWatcher) run(ctx context.Context, every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := a.sweep(ctx)
+		if err != nil {
+			logger.Error("product alert sweep failed", zap.Int("raised", n), zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			logger.Info("product alert sweep done", zap.Int("raised", n))
+		}
+	}
+}
+
+type WatchRequest struct {
+	PriceDropPct *int  `json:"priceDropPct"`
+	BackInStock  *bool `json:"backInStock"`
+}
+
+type WatchResponse struct {
+	ProductID      string    `json:"productId"`
+	PriceDropPct   *int      `json:"priceDropPct"`
+	BackInStock    bool      `json:"backInStock"`
+	ReferencePrice float64   `json:"referencePrice"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+type AlertPreferences struct {
+	WishlistAlerts bool `json:"wishlistAlerts"`
+	PriceDropPct   int  `json:"priceDropPct"`
+	BackInStock    bool `json:"backInStock"`
+}
+
+type AlertResponse struct {
+	AlertID     string     `json:"alertId"`
+	ProductID   string     `json:"productId"`
+	ProductName string     `json:"productName"`
+	Kind        string     `json:"kind"`
+	Source      string     `json:"source"`
+	OldPrice    float64    `json:"oldPrice"`
+	NewPrice    float64    `json:"newPrice"`
+	DropPct     float64    `json:"dropPct"`
+	Stock       int        `json:"stock"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ReadAt      *time.Time `json:"readAt"`
+}
+
+// validDropPct checks a price-drop threshold in percent.
+func validDropPct(pct int) error {
+	if pct < 1 || pct > 90 {
+		return apierr.Validation("priceDropPct must be between 1 and 90").Field("priceDropPct", "is invalid")
+	}
+	return nil
+}
+
+func userWatch(ctx context.Context, db *sql.DB, userID, productID string) (WatchResponse, error) {
+	w := WatchResponse{ProductID: productID}
+	var pct sql.NullInt64
+	err := db.QueryRowContext(ctx, `
+SELECT price_drop_pct, back_in_stock, reference_price, created_at, updated_at
+FROM ProductSubscription WHERE user_id = ? AND product_id = ?
+`, userID, productID).Scan(&pct, &w.BackInStock, &w.ReferencePrice, &w.CreatedAt, &w.UpdatedAt)
+	if pct.Valid {
+		n := int(pct.Int64)
+		w.PriceDropPct = &n
+	}
+	return w, err
+}
+
+// getWatchHandler returns the caller's "notify me" subscription to a product.
+func getWatchHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		w, err := userWatch(c.Request.Context(), db, userID, c.Param("productId"))
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Not watching this product"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, w)
+	}
+}
+
+// putWatchHandler subscribes the caller to price drops of priceDropPct or
+// more and/or restocks of a product. The reference price is taken on the
+// first subscribe and kept when the settings change.
+func putWatchHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		var req WatchRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
+			return
+		}
+		if req.PriceDropPct != nil {
+			if err := validDropPct(*req.PriceDropPct); err != nil {
+				apierr.Abort(c, err)
+				return
+			}
+		}
+		backInStock := req.BackInStock == nil || *req.BackInStock
+		if req.PriceDropPct == nil && !backInStock {
+			apierr.Abort(c, apierr.Validation("Turn on price-drop or back-in-stock alerts"))
+			return
+		}
+
+		ctx := c.Request.Context()
+		productID := c.Param("productId")
+		cur, err := currentProductSnapshot(ctx, db, productID)
+		if err == sql.ErrNoRows {
+			apierr.Abort(c, apierr.NotFound("Product not found"))
+			return
+		}
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+
+		res, err := db.ExecContext(ctx, `
+INSERT INTO ProductSubscription (user_id, product_id, price_drop_pct, back_in_stock, reference_price)
+VALUES (?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE price_drop_pct = VALUES(price_drop_pct), back_in_stock = VALUES(back_in_stock)
+`, userID, productID, req.PriceDropPct, backInStock, cur.Price)
+		if err != nil {
+			logging.From(ctx).Error("DB upsert failed (ProductSubscription)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		snapshotProduct(ctx, db, productID, cur)
+
+		status := http.StatusOK
+		if n, _ := res.RowsAffected(); n == 1 {
+			status = http.StatusCreated
+		}
+		w, err := userWatch(ctx, db, userID, productID)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		c.JSON(status, w)
+	}
+}
+
+// deleteWatchHandler ends the caller's subscription to a product. Wishlist
+// alerts for it, if any, carry on.
+func deleteWatchHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		_, err = db.ExecContext(c.Request.Context(), `DELETE FROM ProductSubscription WHERE user_id = ? AND product_id = ?`, userID, c.Param("productId"))
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB delete failed (ProductSubscription)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+	}
+}
+
+// getAlertPreferencesHandler returns the caller's wishlist alert settings,
+// the defaults when they have none.
+func getAlertPreferencesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		p := AlertPreferences{WishlistAlerts: true, PriceDropPct: cfg.AlertDefaultDropPct, BackInStock: true}
+		var pct sql.NullInt64
+		err = db.QueryRowContext(c.Request.Context(), `SELECT wishlist_alerts, price_drop_pct, back_in_stock FROM AlertPreference WHERE user_id = ?`, userID).
+			Scan(&p.WishlistAlerts, &pct, &p.BackInStock)
+		if err != nil && err != sql.ErrNoRows {
+			apierr.Abort(c, apierr.Internal("Internal server error", err))
+			return
+		}
+		if pct.Valid {
+			p.PriceDropPct = int(pct.Int64)
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// putAlertPreferencesHandler replaces the caller's wishlist alert settings.
+func putAlertPreferencesHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		var p AlertPreferences
+		if err := c.ShouldBindJSON(&p); err != nil {
+			apierr.Abort(c, apierr.Validation("Invalid request body"))
+			return
+		}
+		if err := validDropPct(p.PriceDropPct); err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+		_, err = db.ExecContext(c.Request.Context(), `
+INSERT INTO AlertPreference (user_id, wishlist_alerts, price_drop_pct, back_in_stock) VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE wishlist_alerts = VALUES(wishlist_alerts), price_drop_pct = VALUES(price_drop_pct), back_in_stock = VALUES(back_in_stock)
+`, userID, p.WishlistAlerts, p.PriceDropPct, p.BackInStock)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB upsert failed (AlertPreference)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		c.JSON(http.StatusOK, p)
+	}
+}
+
+// listAlertsHandler pages through the caller's alerts, newest first
+// (limit / offset, unread=true for unread only), with the unread count.
+func listAlertsHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		limit, offset := 20, 0
+		if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+		if n, err := strconv.Atoi(c.Query("offset")); err == nil && n >= 0 {
+			offset = n
+		}
+		filter := ""
+		if c.Query("unread") == "true" {
+			filter = " AND a.read_at IS NULL"
+		}
+
+		ctx := c.Request.Context()
+		var total, unread int
+		err = db.QueryRowContext(ctx, `
+SELECT COUNT(*), COALESCE(SUM(a.read_at IS NULL), 0) FROM ProductAlert a WHERE a.user_id = ?`+filter, userID).Scan(&total, &unread)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (ProductAlert count)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		rows, err := db.QueryContext(ctx, `
+SELECT a.alert_id, a.product_id, COALESCE(p.product_name, ''), a.kind, a.source, a.old_price, a.new_price, a.stock, a.created_at, a.read_at
+FROM ProductAlert a
+LEFT JOIN Product p ON p.product_id = a.product_id
+WHERE a.user_id = ?`+filter+`
+ORDER BY a.created_at DESC, a.alert_id
+LIMIT ? OFFSET ?
+`, userID, limit, offset)
+		if err != nil {
+			logging.From(ctx).Error("DB query failed (ProductAlert)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		defer rows.Close()
+		alerts := []AlertResponse{}
+		for rows.Next() {
+			var a AlertResponse
+			var readAt sql.NullTime
+			if err := rows.Scan(&a.AlertID, &a.ProductID, &a.ProductName, &a.Kind, &a.Source, &a.OldPrice, &a.NewPrice, &a.Stock, &a.CreatedAt, &readAt); err != nil {
+				continue
+			}
+			if readAt.Valid {
+				a.ReadAt = &readAt.Time
+			}
+			if a.Kind == alertPriceDrop {
+				a.DropPct = dropPercent(a.OldPrice, a.NewPrice)
+			}
+			alerts = append(alerts, a)
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"alerts": alerts, "total": total, "unread": unread, "limit": limit, "offset": offset})
+	}
+}
+
+// markAlertsReadHandler marks the given alerts read ({"alertIds": [...]}),
+// or all of the caller's alerts when none are given.
+func markAlertsReadHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+		var req struct {
+			AlertIDs []string `json:"alertIds"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				apierr.Abort(c, apierr.Validation("Invalid request body"))
+				return
+			}
+		}
+		if len(req.AlertIDs) > 100 {
+			apierr.Abort(c, apierr.Validation("at most 100 alertIds").Field("alertIds", "too many"))
+			return
+		}
+
+		query := `UPDATE ProductAlert SET read_at = NOW() WHERE user_id = ? AND read_at IS NULL`
+		args := []any{userID}
+		if len(req.AlertIDs) > 0 {
+			query += ` AND alert_id IN (?` + strings.Repeat(",?", len(req.AlertIDs)-1) + `)`
+			for _, id := range req.AlertIDs {
+				args = append(args, id)
+			}
+		}
+		res, err := db.ExecContext(c.Request.Context(), query, args...)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB update failed (ProductAlert read)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
+			return
+		}
+		n, _ := res.RowsAffected()
+		c.JSON(http.StatusOK, gin.H{"updated": n})
+	}
+}
+
+func recordBrowsingHistoryHandler(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserIDFromAccessToken(c)
+		if err != nil {
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
+			return
+		}
+
+		productID := c.Param("productId")
+		if productID == "" {
+			apierr.Abort(c, apierr.Validation("Missing productId"))
+			return
+		}
+
+		_, err = db.ExecContext(c.Request.Context(), `INSERT INTO BrowsingHistory (user_id, product_id) VALUES (?, ?)`, userID, productID)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("DB insert failed (BrowsingHistory)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 
@@ -932,7 +3063,7 @@ func getBrowsingHistoryRecommendationsHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, err := getUserIDFromAccessToken(c)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			apierr.Abort(c, apierr.Unauthorized("Unauthorized"))
 			return
 		}
 
@@ -943,7 +3074,7 @@ func getBrowsingHistoryRecommendationsHandler(db *sql.DB) gin.HandlerFunc {
 			}
 		}
 
-		rows, err := db.Query(`
+		rows, err := db.QueryContext(c.Request.Context(), `
 			SELECT p.product_id, p.product_name, p.category_id, p.price, p.avg_review
 			FROM Product p
 			WHERE p.category_id IN (
@@ -964,8 +3095,8 @@ func getBrowsingHistoryRecommendationsHandler(db *sql.DB) gin.HandlerFunc {
 			LIMIT ?
 		`, userID, userID, limit)
 		if err != nil {
-			logger.Error("DB query failed (BrowsingHistory recommendations)", zap.Error(err))
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			logging.From(c.Request.Context()).Error("DB query failed (BrowsingHistory recommendations)", zap.Error(err))
+			apierr.Abort(c, apierr.Internal("Internal server error", nil))
 			return
 		}
 		defer rows.Close()
@@ -997,7 +3128,7 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		productID := c.Query("product_id")
 		if productID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "product_id is required"})
+			apierr.Abort(c, apierr.Validation("product_id is required"))
 			return
 		}
 
@@ -1020,7 +3151,7 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 		results := []Product{}
 
 		// Step 1: "users who bought X also bought Y" via Transaction→Geo→user_id
-		rows, err := db.Query(`
+		rows, err := db.QueryContext(c.Request.Context(), `
 			SELECT p.product_id, p.product_name, p.category_id, p.price, p.avg_review,
 			       COUNT(DISTINCT g.user_id) AS co_buy_count
 			FROM `+"`Transaction`"+` t
@@ -1038,7 +3169,7 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 			LIMIT ?
 		`, productID, productID, limit)
 		if err != nil {
-			logger.Error("co-purchase query failed", zap.Error(err))
+			logging.From(c.Request.Context()).Error("co-purchase query failed", zap.Error(err))
 		} else {
 			defer rows.Close()
 			for rows.Next() {
@@ -1054,7 +3185,7 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 		// Step 2: fallback with same-category popular products
 		if len(results) < limit {
 			var categoryID string
-			_ = db.QueryRow(`SELECT category_id FROM Product WHERE product_id = ?`, productID).Scan(&categoryID)
+			_ = db.QueryRowContext(c.Request.Context(), `SELECT category_id FROM Product WHERE product_id = ?`, productID).Scan(&categoryID)
 
 			if categoryID != "" {
 				excludeIDs := make([]interface{}, 0, len(results)+1)
@@ -1067,7 +3198,7 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 				remaining := limit - len(results)
 				excludeIDs = append(excludeIDs, categoryID, remaining)
 
-				fallbackRows, err2 := db.Query(fmt.Sprintf(`
+				fallbackRows, err2 := db.QueryContext(c.Request.Context(), fmt.Sprintf(`
 					SELECT product_id, product_name, category_id, price, avg_review
 					FROM Product
 					WHERE product_id NOT IN (%s)
@@ -1092,49 +3223,234 @@ func getCoPurchaseHandler(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-func main() {
-	var err error
+var ratingDriftFixed = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "review_rating_drift_fixed_total",
+	Help: "Products whose avg_review, review_count or star histogram the reconciliation job corrected.",
+})
+
+// productRating is a product's review aggregates, as stored or as recomputed
+// from its active reviews.
+type productRating struct {
+	Avg   float64
+	Count int
+	Stars [5]int
+}
 
-	environment := os.Getenv("MOCKTEN_ENV")
+// drifted reports whether the stored aggregates disagree with the actual
+// ones. The average is compared at the one decimal it is stored with, so
+// only real drift counts, not rounding.
+func (stored productRating) drifted(actual productRating) bool {
+	return stored.Count != actual.Count || stored.Stars != actual.Stars ||
+		math.Abs(stored.Avg-ratings.Round(actual.Avg)) >= 0.05
+}
 
-	if environment == "development" || environment == "" {
-		config := zap.NewDevelopmentConfig()
-		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-		logger, err = config.Build()
-	} else {
-		config := zap.NewProductionConfig()
-		config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-		logger, err = config.Build()
+const actualRatingSelect = `
+SELECT COUNT(*), COALESCE(AVG(rating), 0),
+       COALESCE(SUM(rating = 1), 0), COALESCE(SUM(rating = 2), 0), COALESCE(SUM(rating = 3), 0),
+       COALESCE(SUM(rating = 4), 0), COALESCE(SUM(rating = 5), 0)
+FROM Review`
+
+// reconcileRatings recomputes every product's avg_review, review_count and
+// ReviewHistogram from its active reviews and corrects the ones that drifted
+// (updateProductRatingIncremental rounds on every write, so repeated edits
+// wander off the true average). Each fix is its own transaction with a
+// ProductUpdated event, which re-indexes the product for search. One replica
+// runs at a time, under a MySQL named lock; the others skip the round. It
+// returns the number of products fixed.
+func reconcileRatings(ctx context.Context, db *sql.DB) (int, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
 	}
+	defer conn.Close()
+	var got sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(CONCAT(DATABASE(), '.rating_reconcile'), 0)`).Scan(&got); err != nil {
+		return 0, fmt.Errorf("reconcile lock: %w", err)
+	}
+	if got.Int64 != 1 {
+		return 0, nil
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(CONCAT(DATABASE(), '.rating_reconcile'))`)
+	}()
 
+	rows, err := conn.QueryContext(ctx, `
+SELECT p.product_id, CAST(p.avg_review AS DECIMAL(10,4)) + 0.0, p.review_count,
+       COALESCE(h.stars_1, 0), COALESCE(h.stars_2, 0), COALESCE(h.stars_3, 0), COALESCE(h.stars_4, 0), COALESCE(h.stars_5, 0),
+       COALESCE(a.cnt, 0), COALESCE(a.avg, 0),
+       COALESCE(a.s1, 0), COALESCE(a.s2, 0), COALESCE(a.s3, 0), COALESCE(a.s4, 0), COALESCE(a.s5, 0)
+FROM Product p
+LEFT JOIN ReviewHistogram h ON h.product_id = p.product_id
+LEFT JOIN (
+  SELECT product_id, COUNT(*) AS cnt, AVG(rating) AS avg,
+         SUM(rating = 1) AS s1, SUM(rating = 2) AS s2, SUM(rating = 3) AS s3, SUM(rating = 4) AS s4, SUM(rating = 5) AS s5
+  FROM Review WHERE status = 'active' GROUP BY product_id
+) a ON a.product_id = p.product_id
+`)
 	if err != nil {
-		log.Println("failed to set up zap logger.")
-		panic(err)
+		return 0, err
+	}
+	var drifted []string
+	for rows.Next() {
+		var id string
+		var stored, actual productRating
+		if err := rows.Scan(&id, &stored.Avg, &stored.Count,
+			&stored.Stars[0], &stored.Stars[1], &stored.Stars[2], &stored.Stars[3], &stored.Stars[4],
+			&actual.Count, &actual.Avg,
+			&actual.Stars[0], &actual.Stars[1], &actual.Stars[2], &actual.Stars[3], &actual.Stars[4]); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if stored.drifted(actual) {
+			drifted = append(drifted, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
 	}
 
-	defer func() { _ = logger.Sync() }()
+	fixed := 0
+	for _, id := range drifted {
+		ok, err := fixProductRating(ctx, db, id)
+		if err != nil {
+			return fixed, fmt.Errorf("product %s: %w", id, err)
+		}
+		if ok {
+			fixed++
+		}
+	}
+	return fixed, nil
+}
 
-	jwksURL, err := buildJWKSURL()
+// fixProductRating recomputes one product's aggregates with the product row
+// locked, as review writes lock it, and stores them if they still disagree.
+func fixProductRating(ctx context.Context, db *sql.DB, productID string) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Fatal("failed to build jwks url", zap.Error(err))
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var stored, actual productRating
+	var active bool
+	err = tx.QueryRowContext(ctx, `
+SELECT CAST(avg_review AS DECIMAL(10,4)) + 0.0, review_count, is_active = 1 AND deleted_at IS NULL
+FROM Product WHERE product_id = ? FOR UPDATE
+`, productID).Scan(&stored.Avg, &stored.Count, &active)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = tx.QueryRowContext(ctx, `SELECT stars_1, stars_2, stars_3, stars_4, stars_5 FROM ReviewHistogram WHERE product_id = ? FOR UPDATE`, productID).
+		Scan(&stored.Stars[0], &stored.Stars[1], &stored.Stars[2], &stored.Stars[3], &stored.Stars[4])
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	err = tx.QueryRowContext(ctx, actualRatingSelect+` WHERE product_id = ? AND status = 'active'`, productID).
+		Scan(&actual.Count, &actual.Avg, &actual.Stars[0], &actual.Stars[1], &actual.Stars[2], &actual.Stars[3], &actual.Stars[4])
+	if err != nil {
+		return false, err
+	}
+	if !stored.drifted(actual) {
+		return false, nil
 	}
-	logger.Info("JWKS URL", zap.String("jwks_url", jwksURL))
 
-	if err := initJWKS(jwksURL); err != nil {
-		logger.Fatal("failed to init jwks", zap.String("jwks_url", jwksURL), zap.Error(err))
+	avg := ratings.Round(actual.Avg)
+	_, err = tx.ExecContext(ctx, `UPDATE Product SET avg_review = ?, review_count = ? WHERE product_id = ?`, avg, actual.Count, productID)
+	if err == nil {
+		s := actual.Stars
+		_, err = tx.ExecContext(ctx, `
+INSERT INTO ReviewHistogram (product_id, stars_1, stars_2, stars_3, stars_4, stars_5) VALUES (?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE stars_1 = VALUES(stars_1), stars_2 = VALUES(stars_2), stars_3 = VALUES(stars_3),
+  stars_4 = VALUES(stars_4), stars_5 = VALUES(stars_5)
+`, productID, s[0], s[1], s[2], s[3], s[4])
 	}
-	defer func() {
-		if jwksCancel != nil {
-			jwksCancel()
+	if err == nil {
+		err = events.Enqueue(ctx, tx, events.ProductUpdated, productID, events.ProductUpdatedPayload{ProductID: productID, Active: active})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return false, err
+	}
+	logger.Warn("review rating drift corrected",
+		zap.String("product_id", productID),
+		zap.Float64("stored_avg", stored.Avg), zap.Int("stored_count", stored.Count), zap.Ints("stored_stars", stored.Stars[:]),
+		zap.Float64("actual_avg", avg), zap.Int("actual_count", actual.Count), zap.Ints("actual_stars", actual.Stars[:]))
+	ratingDriftFixed.Inc()
+	return true, nil
+}
+
+// runRatingReconciler runs reconcileRatings at startup and then every
+// interval until ctx ends, so drift is not left in place for a whole interval
+// after each deploy.
+func runRatingReconciler(ctx context.Context, db *sql.DB, every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	reconcileOnce := func() {
+		start := time.Now()
+		n, err := reconcileRatings(ctx, db)
+		if err != nil {
+			logger.Error("review rating reconciliation failed", zap.Int("fixed", n), zap.Error(err))
+			return
 		}
-	}()
+		logger.Info("review rating reconciliation done", zap.Int("fixed", n), zap.Duration("took", time.Since(start)))
+	}
+	reconcileOnce()
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			reconcileOnce()
+		}
+	}
+}
+
+// defaultRateLimits apply unless RATE_LIMIT_CONFIG overrides them. Reviews are
+// rare and deliberate; browsing history fires on every product page view.
+var defaultRateLimits = map[string]ratelimit.Policy{
+	"review":           {Algorithm: ratelimit.SlidingWindow, Limit: 5, Window: time.Minute, KeyBy: []string{"user", "ip"}},
+	"review-report":    {Algorithm: ratelimit.SlidingWindow, Limit: 10, Window: time.Hour, KeyBy: []string{"user", "ip"}},
+	"review-vote":      {Algorithm: ratelimit.TokenBucket, Limit: 60, Window: time.Minute, KeyBy: []string{"user", "ip"}},
+	"review-images":    {Algorithm: ratelimit.SlidingWindow, Limit: 20, Window: time.Hour, KeyBy: []string{"user", "ip"}},
+	"browsing-history": {Algorithm: ratelimit.TokenBucket, Limit: 120, Window: time.Minute, KeyBy: []string{"user", "ip"}},
+	"wishlist-shared":  {Algorithm: ratelimit.TokenBucket, Limit: 60, Window: time.Minute, KeyBy: []string{"ip"}},
+}
 
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		dsn = "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
+// exportMetrics serves Prometheus metrics (rate-limit rejections, rating drift fixes) on :9100.
+func exportMetrics() {
+	http.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(":9100", nil); err != nil {
+		logger.Error("metrics server stopped", zap.Error(err))
 	}
+}
+
+func main() {
+	var err error
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		log.Fatal(err)
+	}
+
+	logger = logging.Init("product", logging.Options{Development: cfg.Environment == "development"})
+
+	defer func() { _ = logger.Sync() }()
+	logger.Info("config loaded", zap.String("config", config.Dump(cfg)))
 
-	db, err := sql.Open("mysql", dsn)
+	shutdownTracing, err := tracing.Init(context.Background(), "product")
+	if err != nil {
+		logger.Fatal("failed to init tracing", zap.Error(err))
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	db, err := tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 	if err != nil {
 		log.Fatalf("DB open error: %v", err)
 	}
@@ -1144,21 +3460,143 @@ func main() {
 
 	waitForMySQL(db, logger)
 	defer db.Close()
+	if err := migrate.Ensure(context.Background(), db, logger); err != nil {
+		logger.Fatal("MySQL schema not ready", zap.Error(err))
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password.Value(),
+		DB:           cfg.Redis.DB,
+		PoolSize:     3,
+		MinIdleConns: 1,
+	})
+	defer rdb.Close()
+	tracing.InstrumentRedis(rdb)
+
+	// The denylist is shared through Redis, so an admin revoke reaches product
+	// before the user's tokens expire.
+	jwksURL := cfg.Keycloak.JWKSURL()
+	logger.Info("JWKS URL", zap.String("jwks_url", jwksURL))
+	authn, err = commonauth.NewAuthenticatorFromEnv(commonauth.Options{
+		Logger:   logger,
+		JWKSURL:  jwksURL,
+		Denylist: commonauth.NewRedisDenylist(rdb),
+	})
+	if err != nil {
+		logger.Fatal("failed to init authenticator", zap.String("jwks_url", jwksURL), zap.Error(err))
+	}
+	defer authn.Close()
+	go events.NewRelay(db, rdb, events.RelayOptions{Logger: logger}).Run(context.Background())
+
+	minioClient, err = minio.New(cfg.MinIO.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.MinIO.AccessKey.Value(), cfg.MinIO.SecretKey.Value(), ""),
+		Secure:    cfg.MinIO.Secure,
+		Transport: tracing.Transport(nil),
+	})
+	if err != nil {
+		logger.Fatal("failed to create minio client", zap.Error(err))
+	}
+	if err := ensureReviewImageBucket(context.Background()); err != nil {
+		logger.Warn("review photo bucket not ready; uploads fail until MinIO is", zap.Error(err))
+	}
+	go runRatingReconciler(context.Background(), db, cfg.RatingReconcileInterval)
+	go events.NewConsumer(rdb, "review-images", events.ConsumerOptions{Logger: logger}).
+		Handle(events.ReviewModerated, handleReviewModerated(db)).
+		Handle(events.ReviewDeleted, handleReviewDeleted(db)).
+		Run(context.Background())
+	alerts := &alertWatcher{
+		db:             db,
+		limiter:        ratelimit.NewRedisLimiter(rdb),
+		policy:         ratelimit.Policy{Algorithm: ratelimit.SlidingWindow, Limit: cfg.AlertsPerDay, Window: 24 * time.Hour},
+		defaultDropPct: cfg.AlertDefaultDropPct,
+	}
+	go events.NewConsumer(rdb, "product-alerts", events.ConsumerOptions{Logger: logger}).
+		Handle(events.StockChanged, alerts.handleEvent).
+		Handle(events.ProductUpdated, alerts.handleEvent).
+		Run(context.Background())
+	go alerts.run(context.Background(), cfg.AlertSweepInterval)
+
+	policies, err := ratelimit.LoadPolicies(cfg.RateLimitConfig, defaultRateLimits)
+	if err != nil {
+		logger.Fatal("failed to load rate limit config", zap.Error(err))
+	}
+	limits := ratelimit.New(ratelimit.NewRedisLimiter(rdb), ratelimit.Options{
+		Logger:   logger,
+		Policies: policies,
+		Keys: map[string]ratelimit.KeyFunc{
+			"user": func(c *gin.Context) (string, bool) {
+				uid, err := getUserIDFromAccessToken(c)
+				return uid, err == nil
+			},
+			"ip": ratelimit.ByIP(),
+		},
+	})
+	go limits.Watch(context.Background(), cfg.RateLimitConfig, defaultRateLimits)
+
+	kc, err := keycloak.NewClientFromEnv()
+	if err != nil {
+		logger.Warn("keycloak admin client disabled; reviewer and seller names fall back", zap.Error(err))
+	} else {
+		profiles = keycloak.NewProfileCache(kc, 5*time.Minute)
+	}
+
+	fieldKeys, err = fieldcrypt.LoadFromEnv()
+	if err != nil {
+		logger.Fatal("failed to load field encryption keys", zap.Error(err))
+	}
+
+	go exportMetrics()
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), tracing.Gin("product"), logging.Gin(logger), metrics.Gin("product"))
 
 	router.GET("/v1/item/detail/:productId", getItemDetailHandler(db))
 	router.GET("/v1/item/reviews/:productId", getItemReviewsHandler(db))
-	router.POST("/v1/item/review", postItemReviewHandler(db))
+	router.POST("/v1/item/review", limits.For("review"), postItemReviewHandler(db))
+	// DELETE /v1/item/review/:productId. gin allows one wildcard name per
+	// segment, so the product id arrives under the :reviewId name the review
+	// sub-routes use.
+	router.DELETE("/v1/item/review/:reviewId", limits.For("review"), deleteItemReviewHandler(db))
+	router.POST("/v1/item/review/:reviewId/report", limits.For("review-report"), reportReviewHandler(db))
+	router.POST("/v1/item/review/:reviewId/vote", limits.For("review-vote"), voteReviewHandler(db))
+	router.DELETE("/v1/item/review/:reviewId/vote", limits.For("review-vote"), voteReviewHandler(db))
+	router.POST("/v1/item/review/:reviewId/images", limits.For("review-images"), uploadReviewImagesHandler(db))
+	router.DELETE("/v1/item/review/:reviewId/images/:imageId", deleteReviewImageHandler(db))
 
 	router.GET("/v1/fav", getFavoriteListHandler(db))
+	router.GET("/v1/fav/ids", getFavoriteIDsHandler(db))
 	router.POST("/v1/fav/:productId", addFavoriteItemHandler(db))
 	router.DELETE("/v1/fav/:productId", removeFavoriteItemHandler(db))
-
-	router.POST("/v1/browsing-history/:productId", recordBrowsingHistoryHandler(db))
+	router.GET("/v1/fav/lists", listWishlistsHandler(db))
+	router.POST("/v1/fav/lists", createWishlistHandler(db))
+	router.PATCH("/v1/fav/lists/:listId", renameWishlistHandler(db))
+	router.DELETE("/v1/fav/lists/:listId", deleteWishlistHandler(db))
+	router.POST("/v1/fav/lists/:listId/share", shareWishlistHandler(db, true))
+	router.DELETE("/v1/fav/lists/:listId/share", shareWishlistHandler(db, false))
+	router.GET("/v1/fav/shared/:token", limits.For("wishlist-shared"), sharedWishlistHandler(db))
+	router.GET("/v1/item/watch/:productId", getWatchHandler(db))
+	router.PUT("/v1/item/watch/:productId", putWatchHandler(db))
+	router.DELETE("/v1/item/watch/:productId", deleteWatchHandler(db))
+	router.GET("/v1/alerts", listAlertsHandler(db))
+	router.POST("/v1/alerts/read", markAlertsReadHandler(db))
+	router.GET("/v1/alerts/preferences", getAlertPreferencesHandler(db))
+	router.PUT("/v1/alerts/preferences", putAlertPreferencesHandler(db))
+
+	router.POST("/v1/browsing-history/:productId", limits.For("browsing-history"), recordBrowsingHistoryHandler(db))
 	router.GET("/v1/browsing-history/recommendations", getBrowsingHistoryRecommendationsHandler(db))
 
 	router.GET("/v1/co-purchase", getCoPurchaseHandler(db))
 
+	// MinIO only backs image checks and review photos, and rate limits fail
+	// open without Redis; both degrade the service without taking it out of
+	// rotation.
+	health.New("product").
+		Critical("mysql", health.SQL(db)).
+		Optional("redis", health.Redis(rdb)).
+		Optional("minio", health.HTTP(nil, cfg.MinIO.URL()+"/minio/health/live")).
+		Optional("jwks", health.HTTP(nil, jwksURL)).
+		Gin(router)
+
 	_ = router.Run(port)
 }
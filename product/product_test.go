@@ -1,25 +1,342 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
 
-func TestBearerTokenFromHeader(t *testing.T) {
-	cases := []struct {
-		header    string
-		wantToken string
-		wantOK    bool
+	"github.com/gin-gonic/gin"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/auth/authtest"
+	"github.com/mockten/mockten/common/fieldcrypt"
+	"github.com/mockten/mockten/common/keycloak"
+	"github.com/mockten/mockten/common/keycloak/keycloaktest"
+	"go.uber.org/zap"
+)
+
+func TestPutWatchHandlerAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	iss := authtest.NewIssuer(t)
+	authn = iss.Authenticator(t, commonauth.Options{})
+	t.Cleanup(func() { authn = nil })
+
+	r := gin.New()
+	r.PUT("/v1/item/watch/:productId", putWatchHandler(nil))
+	for _, tc := range []struct {
+		name   string
+		header string
+		want   int
 	}{
-		{"Bearer abc.def.ghi", "abc.def.ghi", true},
-		{"bearer xyz", "xyz", true},
-		{"BEARER   spaced", "spaced", true},
-		{"", "", false},
-		{"Basic abc", "", false},
-		{"Bearer ", "", false},
-		{"justtoken", "", false},
-	}
-	for _, c := range cases {
-		tok, ok := bearerTokenFromHeader(c.header)
-		if tok != c.wantToken || ok != c.wantOK {
-			t.Errorf("bearerTokenFromHeader(%q) = (%q,%v), want (%q,%v)", c.header, tok, ok, c.wantToken, c.wantOK)
+		{"no token", "", http.StatusUnauthorized},
+		{"basic auth", "Basic abc", http.StatusUnauthorized},
+		{"empty bearer", "Bearer ", http.StatusUnauthorized},
+		{"expired", iss.Bearer(t, authtest.WithExpiry(-time.Hour)), http.StatusUnauthorized},
+		// a verified caller gets as far as body validation, which needs no DB
+		{"verified", iss.Bearer(t, authtest.WithEmail("a@x.io")), http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/v1/item/watch/p1", strings.NewReader(`{"priceDropPct": 0}`))
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Errorf("%s: status = %d, want %d (%s)", tc.name, w.Code, tc.want, w.Body.String())
+		}
+	}
+}
+
+func TestKeycloakDisplayNames(t *testing.T) {
+	logger = zap.NewNop()
+	srv := keycloaktest.NewServer(t)
+	srv.AddUser(keycloak.User{Email: "shop@example.com", Attributes: map[string][]string{"storeName": {"Shop"}}})
+	srv.AddUser(keycloak.User{Email: "plain@example.com", Username: "plain"})
+	srv.AddUser(keycloak.User{Email: "ann@example.com", FirstName: "Ann"})
+	profiles = keycloak.NewProfileCache(srv.Client(t), time.Minute)
+	t.Cleanup(func() { profiles = nil })
+	ctx := context.Background()
+
+	if got := sellerDisplayName(ctx, "shop@example.com"); got != "Shop" {
+		t.Errorf("sellerDisplayName(storeName) = %q", got)
+	}
+	if got := sellerDisplayName(ctx, "plain@example.com"); got != "plain" {
+		t.Errorf("sellerDisplayName(username) = %q", got)
+	}
+
+	reviews := []ReviewResponse{{UserID: "ann@example.com"}, {UserID: "plain@example.com"}, {UserID: "gone@example.com"}}
+	fillReviewerNames(reviews)
+	for i, want := range []string{"Ann", "Anonymous", "Anonymous"} {
+		if reviews[i].UserName != want {
+			t.Errorf("review %d name = %q, want %q", i, reviews[i].UserName, want)
 		}
 	}
+
+	profiles = nil
+	if got := fetchUserDisplayName(ctx, "ann@example.com"); got != "Anonymous" {
+		t.Errorf("without keycloak = %q, want Anonymous", got)
+	}
+}
+
+func TestOpenGeo(t *testing.T) {
+	k, err := fieldcrypt.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)}, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fieldKeys = k
+	t.Cleanup(func() { fieldKeys = nil })
+
+	seal := func(column, v string) sql.NullString {
+		ct, err := k.Encrypt(v, fieldcrypt.GeoAAD(column))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sql.NullString{String: ct, Valid: true}
+	}
+	var g GeoResponse
+	err = openGeo(&g, seal("postal_code", "106-0032"), seal("town", "Roppongi"), sql.NullString{},
+		sql.NullString{String: "101", Valid: true}, seal("latitude", "35.6627000"), sql.NullString{})
+	if err != nil || g.PostalCode != "106-0032" || g.Town != "Roppongi" || g.RoomNumber != "101" {
+		t.Fatalf("openGeo = (%+v, %v)", g, err)
+	}
+	if g.Latitude == nil || *g.Latitude != 35.6627 || g.Longitude != nil {
+		t.Fatalf("coordinates = %v, %v", g.Latitude, g.Longitude)
+	}
+	if err := openGeo(&g, seal("town", "x"), sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}, sql.NullString{}); err == nil {
+		t.Fatal("town ciphertext decrypted as postal_code")
+	}
+}
+
+func TestParseReviewQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	parse := func(query string) (reviewQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/v1/item/reviews/p1?"+query, nil)
+		return parseReviewQuery(c)
+	}
+
+	q, err := parse("sort=helpful&stars=1,2&stars=2&verified=true&limit=500&offset=10")
+	if err != nil || q.Sort != "helpful" || !slices.Equal(q.Stars, []int{1, 2}) || !q.VerifiedOnly || q.Limit != 20 || q.Offset != 10 {
+		t.Fatalf("parse = %+v, %v", q, err)
+	}
+	filter, args := q.filter()
+	if filter != "\n  AND r.verified_purchase = 1\n  AND r.rating IN (?, ?)" || len(args) != 2 {
+		t.Errorf("filter = %q %v", filter, args)
+	}
+	if q, _ := parse(""); q.Sort != "newest" || q.Stars != nil {
+		t.Errorf("defaults = %+v", q)
+	}
+	for _, bad := range []string{"sort=random", "stars=0", "stars=6", "stars=x", "verified=maybe"} {
+		if _, err := parse(bad); err == nil {
+			t.Errorf("%s: want error", bad)
+		}
+	}
+}
+
+func TestHistogramDeltas(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		rating, prev     int
+		wasActive, isNew bool
+		want             map[int]int
+	}{
+		{"new", 4, 0, false, true, map[int]int{4: 1}},
+		{"edit", 2, 5, true, false, map[int]int{2: 1, 5: -1}},
+		{"edit same rating", 3, 3, true, false, map[int]int{3: 0}},
+		{"re-post after delete", 5, 1, false, false, map[int]int{5: 1}},
+	} {
+		got := histogramDeltas(tc.rating, tc.prev, tc.wasActive, tc.isNew)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestVoteDeltas(t *testing.T) {
+	yes, no := true, false
+	for _, tc := range []struct {
+		prev, next *bool
+		h, u       int
+	}{
+		{nil, &yes, 1, 0},
+		{&yes, &yes, 0, 0},
+		{&yes, &no, -1, 1},
+		{&no, nil, 0, -1},
+		{nil, nil, 0, 0},
+	} {
+		if h, u := voteDeltas(tc.prev, tc.next); h != tc.h || u != tc.u {
+			t.Errorf("voteDeltas(%v, %v) = (%d, %d), want (%d, %d)", tc.prev, tc.next, h, u, tc.h, tc.u)
+		}
+	}
+}
+
+func TestProductRatingDrifted(t *testing.T) {
+	stored := productRating{Avg: 3.7, Count: 3, Stars: [5]int{0, 0, 1, 2, 0}}
+	for _, tc := range []struct {
+		name   string
+		actual productRating
+		want   bool
+	}{
+		{"in sync", productRating{Avg: 3.6667, Count: 3, Stars: [5]int{0, 0, 1, 2, 0}}, false},
+		{"average off", productRating{Avg: 3.3333, Count: 3, Stars: [5]int{0, 0, 1, 2, 0}}, true},
+		{"count off", productRating{Avg: 3.6667, Count: 4, Stars: [5]int{0, 0, 1, 2, 0}}, true},
+		{"histogram off", productRating{Avg: 3.6667, Count: 3, Stars: [5]int{0, 1, 0, 1, 1}}, true},
+	} {
+		if got := stored.drifted(tc.actual); got != tc.want {
+			t.Errorf("%s: drifted = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSniffReviewImage(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	files := map[string][]byte{
+		"ok.png":   png,
+		"fake.jpg": []byte("<html><body>not an image</body></html>"),
+		"big.png":  append(png, make([]byte, 2048)...),
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, data := range files {
+		part, _ := w.CreateFormFile("images[]", name)
+		_, _ = part.Write(data)
+	}
+	_ = w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fh := range form.File["images[]"] {
+		ct, ext, err := sniffReviewImage(fh, 1024)
+		switch fh.Filename {
+		case "ok.png":
+			if err != nil || ct != "image/png" || ext != ".png" {
+				t.Errorf("ok.png = (%q, %q, %v)", ct, ext, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("%s: want error, got %q", fh.Filename, ct)
+			}
+		}
+	}
+}
+
+func TestParseFavoriteQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	parse := func(query string) (favoriteQuery, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/v1/fav?"+query, nil)
+		return parseFavoriteQuery(c)
+	}
+
+	if q, err := parse("sort=price_desc&limit=50&offset=40"); err != nil || q != (favoriteQuery{Limit: 50, Offset: 40, Sort: "price_desc"}) {
+		t.Fatalf("parse = %+v, %v", q, err)
+	}
+	if q, _ := parse("limit=1000&offset=-1"); q != (favoriteQuery{Limit: 20, Sort: "newest"}) {
+		t.Errorf("defaults = %+v", q)
+	}
+	if _, err := parse("sort=p.price"); err == nil {
+		t.Error("unknown sort: want error")
+	}
+}
+
+func TestWishlistName(t *testing.T) {
+	if name, err := wishlistName("  Birthday  "); err != nil || name != "Birthday" {
+		t.Errorf("wishlistName = %q, %v", name, err)
+	}
+	// 100 characters of Japanese is 300 bytes and still fits
+	if _, err := wishlistName(strings.Repeat("誕生日", 33) + "の"); err != nil {
+		t.Errorf("100 Japanese characters: %v", err)
+	}
+	for _, bad := range []string{"", "   ", strings.Repeat("x", 101), strings.Repeat("欲", 101)} {
+		if _, err := wishlistName(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestNewShareToken(t *testing.T) {
+	a, err := newShareToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newShareToken()
+	if len(a) != 24 || a == b || strings.ContainsAny(a, "+/=") {
+		t.Errorf("tokens %q, %q", a, b)
+	}
+}
+
+func TestAlertsFor(t *testing.T) {
+	if got := dropPercent(80, 68); got != 15 {
+		t.Errorf("dropPercent(80, 68) = %v", got)
+	}
+	watch := alertWatch{Reference: 100, DropPct: 10, BackInStock: true}
+	before := productSnapshot{Price: 100, Stock: 5, Known: true}
+	for _, tc := range []struct {
+		name      string
+		w         alertWatch
+		prev, cur productSnapshot
+		want      []string
+	}{
+		{"small drop", watch, before, productSnapshot{Price: 95, Stock: 5, Known: true}, nil},
+		{"threshold met", watch, before, productSnapshot{Price: 90, Stock: 5, Known: true}, []string{alertPriceDrop}},
+		{"price went up after an alert", alertWatch{Reference: 100, DropPct: 10, LastAlerted: 80}, productSnapshot{Price: 80, Stock: 5, Known: true}, productSnapshot{Price: 85, Stock: 5, Known: true}, nil},
+		{"price went up, never alerted", watch, productSnapshot{Price: 80, Stock: 5, Known: true}, productSnapshot{Price: 85, Stock: 5, Known: true}, []string{alertPriceDrop}},
+		{"dropped while out of stock", watch, productSnapshot{Price: 70, Known: true}, productSnapshot{Price: 70, Stock: 2, Known: true}, []string{alertPriceDrop, alertBackInStock}},
+		{"dropped between sweeps", watch, productSnapshot{Price: 85, Stock: 5, Known: true}, productSnapshot{Price: 88, Stock: 4, Known: true}, []string{alertPriceDrop}},
+		{"already alerted lower", alertWatch{Reference: 100, DropPct: 10, LastAlerted: 80}, before, productSnapshot{Price: 85, Stock: 5, Known: true}, nil},
+		{"out of stock", watch, before, productSnapshot{Price: 50, Known: true}, nil},
+		{"restocked on sale", watch, productSnapshot{Price: 100, Known: true}, productSnapshot{Price: 70, Stock: 3, Known: true}, []string{alertPriceDrop, alertBackInStock}},
+		{"restock without price alerts", alertWatch{Reference: 100, BackInStock: true}, productSnapshot{Price: 100, Known: true}, productSnapshot{Price: 70, Stock: 3, Known: true}, []string{alertBackInStock}},
+		{"first sight", watch, productSnapshot{}, productSnapshot{Price: 100, Stock: 3, Known: true}, nil},
+	} {
+		if got := alertsFor(tc.w, tc.prev, tc.cur); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: alertsFor = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWishlistReferenceLegacyItem(t *testing.T) {
+	seen := productSnapshot{Price: 100, Stock: 5, Known: true}
+	if got := wishlistReference(sql.NullFloat64{Float64: 120, Valid: true}, seen); got != 120 {
+		t.Errorf("added price: reference = %v, want 120", got)
+	}
+	// Items migrated by 0009 have no added_price.
+	ref := wishlistReference(sql.NullFloat64{}, seen)
+	if ref != 100 {
+		t.Fatalf("NULL added price: reference = %v, want the snapshot price 100", ref)
+	}
+	w := alertWatch{Source: "wishlist", Reference: ref, DropPct: 10}
+	if got := alertsFor(w, seen, productSnapshot{Price: 85, Stock: 5, Known: true}); !slices.Equal(got, []string{alertPriceDrop}) {
+		t.Errorf("legacy item: alertsFor = %v, want a price drop", got)
+	}
+}
+
+func TestAlertDedupeKey(t *testing.T) {
+	day := time.Date(2026, 3, 1, 23, 30, 0, 0, time.UTC)
+	if got := alertDedupeKey(alertPriceDrop, "u", "p", 25, 19.99, day); got != "price_drop:u:p:2500:1999" {
+		t.Errorf("price key = %q", got)
+	}
+	if alertDedupeKey(alertPriceDrop, "u", "p", 25, 19.99, day) == alertDedupeKey(alertPriceDrop, "u", "p", 25, 18.99, day) {
+		t.Error("different prices share a key")
+	}
+	if alertDedupeKey(alertPriceDrop, "u", "p", 25, 19.99, day) == alertDedupeKey(alertPriceDrop, "u", "p", 22, 19.99, day) {
+		t.Error("different references share a key")
+	}
+	if got := alertDedupeKey(alertBackInStock, "u", "p", 25, 19.99, day.Add(time.Hour)); got != "back_in_stock:u:p:2026-03-02" {
+		t.Errorf("restock key = %q", got)
+	}
 }
@@ -1,6 +1,15 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/mockten/mockten/common/events"
+)
 
 func TestRankingZSetKey(t *testing.T) {
 	cases := []struct {
@@ -20,3 +29,27 @@ func TestRankingZSetKey(t *testing.T) {
 		}
 	}
 }
+
+func TestOrderPlacedCountedOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	payload, _ := json.Marshal(events.OrderPlacedPayload{OrderID: "o1", Items: []events.OrderItem{
+		{ProductID: "p1", CategoryID: 3, Quantity: 2},
+		{ProductID: "p2", CategoryID: 7, Quantity: 1},
+	}})
+	e := events.Event{ID: "42", Type: events.OrderPlaced, Payload: payload, OccurredAt: time.Date(2025, 3, 31, 23, 0, 0, 0, time.UTC)}
+	for range 2 { // redelivered
+		if err := handleOrderPlaced(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	if s := rdb.ZScore(ctx, "ranking:2025-03:3", "p1").Val(); s != 2 {
+		t.Fatalf("category score = %v, want 2", s)
+	}
+	if all := rdb.ZRangeWithScores(ctx, "ranking:2025-03:all", 0, -1).Val(); len(all) != 2 || all[0].Score+all[1].Score != 3 {
+		t.Fatalf("all = %v", all)
+	}
+}
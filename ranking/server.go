@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
@@ -14,14 +13,35 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/mockten/mockten/common/apierr"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/events"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/mockten/mockten/common/tracing/redisv8"
+	redisv9 "github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
 var (
-	ctx = context.Background()
 	rdb *redis.Client
 	db  *sql.DB
 )
 
+// Config is read from the environment by common/config. Ranking names its
+// Redis address REDIS_HOST, unlike the other services.
+type Config struct {
+	MySQL config.MySQL `json:"mysql"`
+	Redis struct {
+		Host     string        `json:"host" env:"REDIS_HOST" default:"localhost:6379" validate:"required,hostname_port"`
+		Password config.Secret `json:"password" env:"REDIS_PASSWORD"`
+	} `json:"redis"`
+	Port int `json:"port" env:"PORT" default:"8080" validate:"min=1,max=65535"`
+}
+
 type RankingItem struct {
 	ProductID   string  `json:"product_id"`
 	Score       int     `json:"score"`
@@ -45,31 +65,32 @@ type UpdateRankingRequest struct {
 }
 
 func main() {
-	var err error
+	logger := logging.Init("ranking", logging.Options{})
+	defer func() { _ = logger.Sync() }()
 
-	// Redis setup
-	redisHost := os.Getenv("REDIS_HOST")
-	if redisHost == "" {
-		redisHost = "localhost:6379"
+	cfg, err := config.Load[Config](config.Options{})
+	if err != nil {
+		log.Fatal(err)
 	}
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	if redisPassword == "" {
-		redisPassword = "mocktenpass"
+	log.Printf("config: %s", config.Dump(cfg))
+
+	shutdownTracing, err := tracing.Init(context.Background(), "ranking")
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
 	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	// Redis setup
 	rdb = redis.NewClient(&redis.Options{
-		Addr:         redisHost,
-		Password:     redisPassword,
+		Addr:         cfg.Redis.Host,
+		Password:     cfg.Redis.Password.Value(),
 		PoolSize:     3,
 		MinIdleConns: 1,
 	})
+	redisv8.Instrument(rdb)
 
 	// MySQL setup
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		// Mock default for development
-		dsn = "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
-	}
-	db, err = sql.Open("mysql", dsn)
+	db, err = tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 	if err != nil {
 		log.Fatalf("failed to connect to MySQL: %v", err)
 	}
@@ -77,25 +98,46 @@ func main() {
 	db.SetMaxIdleConns(2)
 	db.SetConnMaxLifetime(5 * time.Minute)
 	defer db.Close()
+	for i := 0; i < 30 && db.Ping() != nil; i++ {
+		log.Println("Waiting for MySQL...")
+		time.Sleep(time.Second)
+	}
+	if err := migrate.Ensure(context.Background(), db, nil); err != nil {
+		log.Fatalf("MySQL schema not ready: %v", err)
+	}
 
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Recovery(), tracing.Gin("ranking"), logging.Gin(logger), metrics.Gin("ranking"))
 
 	// CORS config
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	r.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
+	r.Use(cors.New(corsConfig))
+
+	// Sales reach the ranking as OrderPlaced events from ecpay's outbox; the
+	// update endpoint stays for manual corrections.
+	eventsRedis := redisv9.NewClient(&redisv9.Options{Addr: cfg.Redis.Host, Password: cfg.Redis.Password.Value(), PoolSize: 2})
+	tracing.InstrumentRedis(eventsRedis)
+	consumer := events.NewConsumer(eventsRedis, "ranking", events.ConsumerOptions{Logger: logger}).
+		Handle(events.OrderPlaced, handleOrderPlaced)
+	go consumer.Run(context.Background())
 
 	r.GET("/api/ranking", handleGetRanking)
 	r.POST("/api/ranking/update", handleUpdateRanking)
+	health.New("ranking").
+		Critical("redis", func(ctx context.Context) error { return rdb.Ping(ctx).Err() }).
+		Critical("mysql", health.SQL(db)).
+		Gin(r)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	go func() {
+		if err := metrics.Serve(":9100"); err != nil {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
 
-	log.Printf("Starting Ranking service on :%s", port)
-	if err := r.Run(":" + port); err != nil {
+	log.Printf("Starting Ranking service on :%d", cfg.Port)
+	if err := r.Run(fmt.Sprintf(":%d", cfg.Port)); err != nil {
 		log.Fatalf("failed to run server: %v", err)
 	}
 }
@@ -118,10 +160,10 @@ func handleGetRanking(c *gin.Context) {
 	zsetKey, categoryID := rankingZSetKey(month, categoryStr)
 
 	// Get top 10 products from Redis
-	res, err := rdb.ZRevRangeWithScores(ctx, zsetKey, 0, 9).Result()
+	res, err := rdb.ZRevRangeWithScores(c.Request.Context(), zsetKey, 0, 9).Result()
 	if err != nil {
-		log.Printf("failed to fetch ranking from redis: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch ranking"})
+		logging.From(c.Request.Context()).Error("failed to fetch ranking from redis", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("failed to fetch ranking", nil))
 		return
 	}
 
@@ -134,9 +176,9 @@ func handleGetRanking(c *gin.Context) {
 		var name, summary string
 		var price float64
 		var rating float64
-		err := db.QueryRow("SELECT product_name, summary, price, avg_review FROM Product WHERE product_id = ?", productID).Scan(&name, &summary, &price, &rating)
+		err := db.QueryRowContext(c.Request.Context(), "SELECT product_name, summary, price, avg_review FROM Product WHERE product_id = ?", productID).Scan(&name, &summary, &price, &rating)
 		if err != nil {
-			log.Printf("failed to fetch product info for %s: %v", productID, err)
+			logging.From(c.Request.Context()).Warn("failed to fetch product info", zap.String("product_id", productID), zap.Error(err))
 			continue
 		}
 
@@ -164,16 +206,51 @@ func handleGetRanking(c *gin.Context) {
 func handleUpdateRanking(c *gin.Context) {
 	var req UpdateRankingRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Bind(err))
 		return
 	}
 
 	month := time.Now().Format("2006-01")
-	zsetKey := fmt.Sprintf("ranking:%s:%d", month, req.CategoryID)
-	allKey := fmt.Sprintf("ranking:%s:all", month)
-
-	rdb.ZIncrBy(ctx, zsetKey, float64(req.Quantity), req.ProductID)
-	rdb.ZIncrBy(ctx, allKey, float64(req.Quantity), req.ProductID)
+	if err := countSale(c.Request.Context(), month, req.CategoryID, req.ProductID, req.Quantity); err != nil {
+		logging.From(c.Request.Context()).Error("failed to update ranking", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("failed to update ranking", nil))
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
+
+// countSale adds a sale to the month's category and all-category rankings.
+func countSale(ctx context.Context, month string, categoryID int, productID string, quantity int) error {
+	zsetKey := fmt.Sprintf("ranking:%s:%d", month, categoryID)
+	allKey := fmt.Sprintf("ranking:%s:all", month)
+	_, err := rdb.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZIncrBy(ctx, zsetKey, float64(quantity), productID)
+		p.ZIncrBy(ctx, allKey, float64(quantity), productID)
+		return nil
+	})
+	return err
+}
+
+// handleOrderPlaced counts an order's items into the ranking of the month it
+// was placed in. Events can be delivered twice, so each is counted once: the
+// marker is set before counting and removed again if counting fails.
+func handleOrderPlaced(ctx context.Context, e events.Event) error {
+	var order events.OrderPlacedPayload
+	if err := e.Decode(&order); err != nil {
+		return err
+	}
+	marker := "ranking:event:" + e.ID
+	first, err := rdb.SetNX(ctx, marker, 1, 40*24*time.Hour).Result()
+	if err != nil || !first {
+		return err
+	}
+	month := e.OccurredAt.Format("2006-01")
+	for _, item := range order.Items {
+		if err := countSale(ctx, month, item.CategoryID, item.ProductID, item.Quantity); err != nil {
+			rdb.Del(ctx, marker)
+			return err
+		}
+	}
+	return nil
+}
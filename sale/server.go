@@ -5,14 +5,16 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,13 +23,99 @@ import (
 	meilisearch "github.com/meilisearch/meilisearch-go"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/events"
+	"github.com/mockten/mockten/common/fieldcrypt"
+	"github.com/mockten/mockten/common/flags"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/keycloak"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/ratelimit"
+	"github.com/mockten/mockten/common/ratings"
+	"github.com/mockten/mockten/common/text"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
+// Config is read from the environment by common/config.
+type Config struct {
+	MySQL config.MySQL `json:"mysql"`
+	Redis config.Redis `json:"redis"`
+	Meili config.Meili `json:"meili"`
+	MinIO config.MinIO `json:"minio"`
+	// empty means defaultSLATargets / defaultHealthTargets
+	SLATargets    string   `json:"sla_metrics_targets" env:"SLA_METRICS_TARGETS"`
+	HealthTargets string   `json:"health_targets" env:"HEALTH_TARGETS"`
+	AdminUsers    []string `json:"admin_users" env:"ADMIN_USERS" default:"superadmin@example.com"`
+	Port          int      `json:"port" env:"PORT" default:"8080" validate:"min=1,max=65535"`
+}
+
 var (
+	cfg         *Config
 	db          *sql.DB
 	meiliclient *meilisearch.Client
+	minioClient *minio.Client
+	rdb         *redis.Client
+	denylist    *commonauth.RedisDenylist
+	apiKeys     commonauth.APIKeyStore
+	limiter     ratelimit.Limiter
+
+	// authn verifies tokens where sale must not trust unverified claims
+	// (minting impersonation tokens); impersonator is nil when
+	// AUTH_IMPERSONATION_KEY is unset.
+	authn        *commonauth.Authenticator
+	impersonator *commonauth.ImpersonationSigner
+
+	// profiles reads seller usernames and store names from Keycloak; nil when
+	// no admin client secret is configured. kc is the uncached client behind it.
+	profiles *keycloak.ProfileCache
+	kc       *keycloak.Client
+
+	// flagStore is the FeatureFlag table behind the admin flag endpoints;
+	// flagClient is the cached view they evaluate and invalidate.
+	flagStore  *flags.SQLStore
+	flagClient *flags.Client
+
+	// fieldKeys decrypts Geo addresses and phone numbers for admin views.
+	fieldKeys *fieldcrypt.Keyring
+
+	// slaEval scores the services' RED metrics against ApiSLA for the admin
+	// health view.
+	slaEval *metrics.SLAEvaluator
+
+	// healthMon polls every service's /readyz and keeps the last hour of
+	// results for the admin health view.
+	healthMon *health.Monitor
 )
 
+// defaultSLATargets are the /metrics endpoints the SLA evaluator reads, unless
+// SLA_METRICS_TARGETS overrides them. sale reads its own registry directly.
+const defaultSLATargets = "sale=," +
+	"searchitem=http://searchitem-service.default.svc.cluster.local:9100/metrics," +
+	"product=http://product-service.default.svc.cluster.local:9100/metrics," +
+	"cart=http://cart-service.default.svc.cluster.local:9100/metrics," +
+	"geocoding=http://geocoding-service.default.svc.cluster.local:9100/metrics," +
+	"ecpay=http://ecpay-service.default.svc.cluster.local:9100/metrics," +
+	"ranking=http://ranking-service.default.svc.cluster.local:9100/metrics," +
+	"shipment=http://shipment-service.default.svc.cluster.local:9100/metrics"
+
+// defaultHealthTargets are the readiness endpoints the admin health view
+// polls, unless HEALTH_TARGETS overrides them. sale runs its own checks
+// in-process.
+const defaultHealthTargets = "sale=," +
+	"searchitem=http://searchitem-service.default.svc.cluster.local:50051/readyz," +
+	"product=http://product-service.default.svc.cluster.local:50052/readyz," +
+	"cart=http://cart-service.default.svc.cluster.local:50053/readyz," +
+	"geocoding=http://geocoding-service.default.svc.cluster.local:8080/readyz," +
+	"ecpay=http://ecpay-service.default.svc.cluster.local:8080/readyz," +
+	"ranking=http://ranking-service.default.svc.cluster.local:8080/readyz," +
+	"shipment=http://shipment-service.default.svc.cluster.local:8080/readyz"
+
 type TimeSale struct {
 	ID           string    `json:"id"`
 	Name         string    `json:"name"`
@@ -53,13 +141,22 @@ type ProductItem struct {
 
 func main() {
 	var err error
+	logger := logging.Init("sale", logging.Options{})
+	defer func() { _ = logger.Sync() }()
 
-	// Database setup
-	dsn := os.Getenv("MYSQL_DSN")
-	if dsn == "" {
-		dsn = "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		log.Fatal(err)
 	}
-	db, err = sql.Open("mysql", dsn)
+	log.Printf("config: %s", config.Dump(cfg))
+
+	shutdownTracing, err := tracing.Init(context.Background(), "sale")
+	if err != nil {
+		log.Fatalf("failed to init tracing: %v", err)
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
+	// Database setup
+	db, err = tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 	if err != nil {
 		log.Fatalf("failed to connect to MySQL: %v", err)
 	}
@@ -77,23 +174,113 @@ func main() {
 		log.Println("Waiting for MySQL...")
 		time.Sleep(1 * time.Second)
 	}
+	if err := migrate.Ensure(context.Background(), db, nil); err != nil {
+		log.Fatalf("MySQL schema not ready: %v", err)
+	}
 
 	// MeiliSearch setup
-	meiliHost := os.Getenv("MEILI_SVC")
-	if meiliHost == "" {
-		meiliHost = "meilisearch-service.default.svc.cluster.local"
-	}
 	meiliclient = meilisearch.NewClient(meilisearch.ClientConfig{
-		Host: "http://" + meiliHost + ":7700",
+		Host: cfg.Meili.URL(),
+	})
+
+	// Product images live in MinIO's photos bucket.
+	minioClient, err = minio.New(cfg.MinIO.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.MinIO.AccessKey.Value(), cfg.MinIO.SecretKey.Value(), ""),
+		Secure:    cfg.MinIO.Secure,
+		Transport: tracing.Transport(nil),
+	})
+	if err != nil {
+		log.Fatalf("failed to create minio client: %v", err)
+	}
+
+	apiKeys = commonauth.NewSQLAPIKeyStore(db)
+
+	// Redis setup (shared token denylist, API key rate limits)
+	rdb = redis.NewClient(&redis.Options{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password.Value(),
+		DB:           cfg.Redis.DB,
+		PoolSize:     3,
+		MinIdleConns: 1,
 	})
+	defer rdb.Close()
+	tracing.InstrumentRedis(rdb)
+	denylist = commonauth.NewRedisDenylist(rdb)
+	limiter = ratelimit.NewRedisLimiter(rdb)
+	flagStore = flags.NewSQLStore(db)
+	flagClient = flags.New(flagStore, flags.Options{Redis: rdb, Logger: logger})
+
+	authn, err = commonauth.NewAuthenticatorFromEnv(commonauth.Options{Denylist: denylist})
+	if err != nil {
+		log.Fatalf("failed to init authenticator: %v", err)
+	}
+	defer authn.Close()
+	impersonator = commonauth.NewImpersonationSigner(commonauth.ImpersonationKeyFromEnv())
+
+	if kc, err = keycloak.NewClientFromEnv(); err != nil {
+		log.Printf("keycloak admin client disabled: %v", err)
+		kc = nil
+	} else {
+		profiles = keycloak.NewProfileCache(kc, 5*time.Minute)
+	}
+	fieldKeys, err = fieldcrypt.LoadFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load field encryption keys: %v", err)
+	}
+
+	slaTargets := cfg.SLATargets
+	if slaTargets == "" {
+		slaTargets = defaultSLATargets
+	}
+	slaSources, err := metrics.ParseTargets(slaTargets, &http.Client{Timeout: 3 * time.Second})
+	if err != nil {
+		log.Fatalf("invalid SLA_METRICS_TARGETS: %v", err)
+	}
+	slaEval = metrics.NewSLAEvaluator(metrics.SLAOptions{Sources: slaSources})
+	go slaEval.Run(context.Background(), db)
+	go func() {
+		if err := metrics.Serve(":9100"); err != nil {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
+
+	// Search indexing, image uploads, the token denylist (which fails open)
+	// and key rotation are side paths; only MySQL is needed to serve.
+	checker := health.New("sale").
+		Critical("mysql", health.SQL(db)).
+		Optional("redis", health.Redis(rdb)).
+		Optional("meilisearch", health.HTTP(nil, cfg.Meili.URL()+"/health")).
+		Optional("minio", health.HTTP(nil, cfg.MinIO.URL()+"/minio/health/live")).
+		Optional("jwks", authn.CheckJWKS)
+	healthTargets := cfg.HealthTargets
+	if healthTargets == "" {
+		healthTargets = defaultHealthTargets
+	}
+	probes, err := health.ParseTargets(healthTargets, &http.Client{Timeout: 5 * time.Second}, checker)
+	if err != nil {
+		log.Fatalf("invalid HEALTH_TARGETS: %v", err)
+	}
+	healthMon = health.NewMonitor(health.MonitorOptions{Probes: probes})
+	go healthMon.Run(context.Background())
 
-	r := gin.Default()
+	go events.NewRelay(db, rdb, events.RelayOptions{Logger: logger}).Run(context.Background())
+	indexer := events.NewConsumer(rdb, "search-indexer", events.ConsumerOptions{Logger: logger}).
+		Handle(events.ProductUpdated, handleIndexEvent).
+		Handle(events.StockChanged, handleIndexEvent).
+		Handle(events.ReviewPosted, handleIndexEvent).
+		Handle(events.ReviewModerated, handleIndexEvent).
+		Handle(events.ReviewDeleted, handleIndexEvent)
+	go indexer.Run(context.Background())
+
+	r := gin.New()
+	r.Use(gin.Recovery(), tracing.Gin("sale"), logging.Gin(logger), metrics.Gin("sale"))
+	checker.Gin(r)
 
 	// CORS config
-	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	r.Use(cors.New(config))
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowAllOrigins = true
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-API-Key"}
+	r.Use(cors.New(corsConfig))
 
 	r.GET("/api/sale/active", handleGetActiveSales)
 	r.GET("/api/sale/products/random", handleGetRandomProducts)
@@ -108,9 +295,16 @@ func main() {
 	r.GET("/v1/seller/products", handleSellerProducts)
 	r.PUT("/v1/seller/products/:id", handleUpdateProduct)
 	r.PUT("/v1/seller/products/:id/status", handleToggleProductStatus)
+	r.PUT("/v1/seller/products/:id/stock", handleUpdateStock)
 	r.DELETE("/v1/seller/products/:id", handleDeleteProduct)
 	r.GET("/v1/seller/profile", handleGetProfile)
 	r.PUT("/v1/seller/profile", handleUpdateProfile)
+	r.GET("/v1/seller/api-keys", handleListAPIKeys)
+	r.POST("/v1/seller/api-keys", handleCreateAPIKey)
+	r.DELETE("/v1/seller/api-keys/:id", handleRevokeAPIKey)
+	r.GET("/v1/seller/reviews", handleSellerReviews)
+	r.PUT("/v1/seller/reviews/:reviewId/reply", handleSellerPutReply)
+	r.DELETE("/v1/seller/reviews/:reviewId/reply", handleSellerDeleteReply)
 
 	// Admin portal endpoints (all data is real; audit log is persisted).
 	r.GET("/v1/admin/orders", handleAdminOrders)
@@ -119,57 +313,105 @@ func main() {
 	r.GET("/v1/admin/health", handleAdminHealth)
 	r.GET("/v1/admin/seller", handleAdminGetSeller)
 	r.PUT("/v1/admin/seller", handleAdminPutSeller)
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
-	log.Printf("Starting Sale service on :%s", port)
-	if err := r.Run(":" + port); err != nil {
+	r.POST("/v1/admin/sessions/revoke", handleAdminRevokeSessions)
+	r.POST("/v1/admin/sessions/reinstate", handleAdminReinstateUser)
+	r.POST("/v1/admin/impersonate", handleAdminImpersonate)
+	r.GET("/v1/admin/customers/lookup", handleAdminCustomerLookup)
+	r.GET("/v1/admin/flags", handleAdminListFlags)
+	r.PUT("/v1/admin/flags/:key", handleAdminPutFlag)
+	r.DELETE("/v1/admin/flags/:key", handleAdminDeleteFlag)
+	r.GET("/v1/admin/flags/:key/evaluate", handleAdminEvaluateFlag)
+	r.GET("/v1/admin/reviews/reports", handleAdminReviewReports)
+	r.GET("/v1/admin/reviews/:reviewId", handleAdminReviewDetail)
+	r.POST("/v1/admin/reviews/:reviewId/hide", handleModerateReview(moderateHide))
+	r.POST("/v1/admin/reviews/:reviewId/restore", handleModerateReview(moderateRestore))
+	r.POST("/v1/admin/reviews/:reviewId/dismiss", handleModerateReview(moderateDismiss))
+	r.DELETE("/v1/admin/reviews/:reviewId", handleModerateReview(moderateDelete))
+
+	log.Printf("Starting Sale service on :%d", cfg.Port)
+	if err := r.Run(fmt.Sprintf(":%d", cfg.Port)); err != nil {
 		log.Fatalf("failed to run server: %v", err)
 	}
 }
 
-// extractEmailFromJWT extracts the email claim from a JWT token without signature verification
-func extractEmailFromJWT(c *gin.Context) (string, error) {
-	authHeader := c.GetHeader("Authorization")
-	if authHeader == "" {
-		return "", fmt.Errorf("missing Authorization header")
+// verifiedEmail verifies the caller's Bearer JWT with authn (signature,
+// issuer, audience and the shared denylist) and returns its email claim.
+// Impersonation tokens are refused: sale has no read-only mode for them.
+func verifiedEmail(c *gin.Context) (string, error) {
+	claims, err := verifiedClaims(c)
+	if err != nil {
+		return "", err
 	}
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-		return "", fmt.Errorf("invalid Authorization header format")
+	if _, ok := commonauth.ImpersonationFromClaims(claims); ok {
+		return "", fmt.Errorf("impersonation tokens are not accepted here")
 	}
-	tokenParts := strings.Split(parts[1], ".")
-	if len(tokenParts) < 2 {
-		return "", fmt.Errorf("invalid JWT format")
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", fmt.Errorf("email claim not found in JWT")
 	}
-	// Add padding if needed
-	payload := tokenParts[1]
-	switch len(payload) % 4 {
-	case 2:
-		payload += "=="
-	case 3:
-		payload += "="
+	logging.SetUser(c.Request.Context(), email)
+	return email, nil
+}
+
+// verifiedClaims returns the claims of the caller's Bearer JWT once authn has
+// verified it.
+func verifiedClaims(c *gin.Context) (map[string]interface{}, error) {
+	tok := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+	if tok == "" {
+		return nil, fmt.Errorf("missing Authorization header")
 	}
-	decoded, err := base64.URLEncoding.DecodeString(payload)
+	claims, err := authn.ParseTokenCtx(c.Request.Context(), tok)
 	if err != nil {
-		// Try StdEncoding as fallback
-		decoded, err = base64.RawURLEncoding.DecodeString(tokenParts[1])
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// sellerFromRequest resolves the calling seller from either an API key
+// (X-API-Key / "Authorization: ApiKey") or, failing that, the Keycloak JWT.
+// API keys must carry scope and stay within their per-minute limit; JWT
+// callers are the seller themselves and may do anything. Errors are
+// *apierr.Error, ready to be written as they are.
+func sellerFromRequest(c *gin.Context, scope string) (string, error) {
+	key, ok := commonauth.APIKeyFromHeader(c.Request.Header)
+	if !ok {
+		email, err := verifiedEmail(c)
 		if err != nil {
-			return "", fmt.Errorf("failed to decode JWT payload: %v", err)
+			return "", apierr.Unauthorized(err.Error())
 		}
+		return email, nil
 	}
-	var claims map[string]interface{}
-	if err := json.Unmarshal(decoded, &claims); err != nil {
-		return "", fmt.Errorf("failed to parse JWT claims: %v", err)
+
+	k, err := commonauth.ResolveAPIKey(c.Request.Context(), apiKeys, key)
+	if errors.Is(err, commonauth.ErrAPIKeyInvalid) {
+		return "", apierr.Unauthorized(err.Error())
 	}
-	email, ok := claims["email"].(string)
-	if !ok || email == "" {
-		return "", fmt.Errorf("email claim not found in JWT")
+	if err != nil {
+		return "", apierr.Internal("database error", fmt.Errorf("api key lookup: %w", err))
 	}
-	return email, nil
+	if !k.HasScope(scope) {
+		return "", apierr.Forbidden("api key lacks scope " + scope)
+	}
+	if !apiKeyLimit(c, k) {
+		return "", apierr.RateLimited("rate limit exceeded")
+	}
+	return k.Owner, nil
+}
+
+// apiKeyLimit is a key's own per-minute budget, enforced on the shared
+// limiter so it holds across replicas. Limiter errors let the request through.
+func apiKeyLimit(c *gin.Context, k *commonauth.APIKey) bool {
+	if k.RateLimitPerMinute <= 0 {
+		return true
+	}
+	p := ratelimit.Policy{Algorithm: ratelimit.SlidingWindow, Limit: k.RateLimitPerMinute, Window: time.Minute}
+	res, err := limiter.Allow(c.Request.Context(), "apikey:"+k.ID, p)
+	if err != nil {
+		logging.From(c.Request.Context()).Warn("api key rate limit", zap.Error(err))
+		return true
+	}
+	ratelimit.SetHeaders(c.Writer.Header(), p, res)
+	return res.Allowed
 }
 
 // actorTypeFromJWT classifies the caller as admin, seller or customer based on
@@ -229,9 +471,9 @@ func actorTypeFromJWT(c *gin.Context) string {
 }
 
 func handleSellerStats(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeOrdersRead)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -262,21 +504,21 @@ func handleSellerStats(c *gin.Context) {
 			WHERE p.seller_id = ?
 			  AND o.created_at >= ?
 			  AND o.created_at < ?`
-		row := db.QueryRow(query, sellerID, start, end)
+		row := db.QueryRowContext(c.Request.Context(), query, sellerID, start, end)
 		err := row.Scan(&ps.Revenue, &ps.Orders, &ps.Products, &ps.Customers)
 		return ps, err
 	}
 
 	curStats, err := getStats(curStart, now.Add(24*time.Hour))
 	if err != nil {
-		log.Printf("failed to get current stats: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to get current stats", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	prevStats, err := getStats(prevStart, prevEnd)
 	if err != nil {
-		log.Printf("failed to get prev stats: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to get prev stats", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 
@@ -310,9 +552,9 @@ func handleSellerStats(c *gin.Context) {
 }
 
 func handleSellerOrders(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeOrdersRead)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -381,9 +623,9 @@ func handleSellerOrders(c *gin.Context) {
 	// Count total
 	countQuery := "SELECT COUNT(*) FROM (" + baseQuery + ") AS sub"
 	var total int
-	if err := db.QueryRow(countQuery, args...).Scan(&total); err != nil {
-		log.Printf("failed to count orders: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+	if err := db.QueryRowContext(c.Request.Context(), countQuery, args...).Scan(&total); err != nil {
+		logging.From(c.Request.Context()).Error("failed to count orders", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 
@@ -395,10 +637,10 @@ func handleSellerOrders(c *gin.Context) {
 	pagedQuery := baseQuery + " ORDER BY o.created_at " + sortDir + " LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
 
-	rows, err := db.Query(pagedQuery, args...)
+	rows, err := db.QueryContext(c.Request.Context(), pagedQuery, args...)
 	if err != nil {
-		log.Printf("failed to query orders: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to query orders", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	defer rows.Close()
@@ -469,8 +711,8 @@ func handleSellerOrders(c *gin.Context) {
 			"JOIN Product p ON t.product_id = p.product_id " +
 			"WHERE p.seller_id = ? AND o.order_id IN (" + strings.Join(placeholders, ",") + ") " +
 			"GROUP BY o.order_id, p.product_id, p.product_name ORDER BY p.product_name"
-		if itemRows, ierr := db.Query(itemQuery, itemArgs...); ierr != nil {
-			log.Printf("failed to query order items: %v", ierr)
+		if itemRows, ierr := db.QueryContext(c.Request.Context(), itemQuery, itemArgs...); ierr != nil {
+			logging.From(c.Request.Context()).Error("failed to query order items", zap.Error(ierr))
 		} else {
 			defer itemRows.Close()
 			for itemRows.Next() {
@@ -495,9 +737,9 @@ func handleSellerOrders(c *gin.Context) {
 }
 
 func handleSellerProducts(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeProductsRead)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -511,10 +753,10 @@ func handleSellerProducts(c *gin.Context) {
 	}
 	offset := (page - 1) * limit
 
-	countRow := db.QueryRow("SELECT COUNT(*) FROM Product WHERE seller_id = ? AND deleted_at IS NULL", sellerID)
+	countRow := db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM Product WHERE seller_id = ? AND deleted_at IS NULL", sellerID)
 	var total int
 	if err := countRow.Scan(&total); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		apierr.Abort(c, apierr.Internal("database error", err))
 		return
 	}
 
@@ -528,10 +770,10 @@ func handleSellerProducts(c *gin.Context) {
 		ORDER BY p.product_name
 		LIMIT ? OFFSET ?`
 
-	rows, err := db.Query(query, sellerID, limit, offset)
+	rows, err := db.QueryContext(c.Request.Context(), query, sellerID, limit, offset)
 	if err != nil {
-		log.Printf("failed to query products: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to query products", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	defer rows.Close()
@@ -578,9 +820,9 @@ func handleSellerProducts(c *gin.Context) {
 }
 
 func handleUpdateProduct(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeProductsWrite)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -595,23 +837,49 @@ func handleUpdateProduct(c *gin.Context) {
 		IsActive         *int   `json:"is_active"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		apierr.Abort(c, apierr.Validation("invalid request body"))
 		return
 	}
 
-	_, err = db.Exec(
-		"UPDATE Product SET product_name=?, price=?, summary=?, category_id=COALESCE(NULLIF(?,''), category_id), product_condition=COALESCE(NULLIF(?,''), product_condition) WHERE product_id=? AND seller_id=?",
-		body.ProductName, body.Price, body.Summary, body.CategoryID, body.ProductCondition, productID, sellerID,
-	)
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var active bool
+	err = tx.QueryRowContext(ctx, "SELECT is_active = 1 FROM Product WHERE product_id=? AND seller_id=? AND deleted_at IS NULL FOR UPDATE", productID, sellerID).Scan(&active)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierr.Abort(c, apierr.NotFound("product not found"))
+		return
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE Product SET product_name=?, price=?, summary=?, category_id=COALESCE(NULLIF(?,''), category_id), product_condition=COALESCE(NULLIF(?,''), product_condition) WHERE product_id=? AND seller_id=?",
+			body.ProductName, body.Price, body.Summary, body.CategoryID, body.ProductCondition, productID, sellerID,
+		)
+	}
 	if err == nil && body.Stock >= 0 {
-		_, err = db.Exec("INSERT INTO Stock (product_id, stocks) VALUES (?, ?) ON DUPLICATE KEY UPDATE stocks=?", productID, body.Stock, body.Stock)
+		_, err = tx.ExecContext(ctx, "INSERT INTO Stock (product_id, stocks) VALUES (?, ?) ON DUPLICATE KEY UPDATE stocks=?", productID, body.Stock, body.Stock)
+		if err == nil {
+			err = events.Enqueue(ctx, tx, events.StockChanged, productID, events.StockChangedPayload{ProductID: productID, Stock: body.Stock})
+		}
 	}
 	if err == nil && body.IsActive != nil {
-		_, err = db.Exec("UPDATE Product SET is_active=? WHERE product_id=? AND seller_id=?", *body.IsActive, productID, sellerID)
+		active = *body.IsActive == 1
+		_, err = tx.ExecContext(ctx, "UPDATE Product SET is_active=? WHERE product_id=? AND seller_id=?", *body.IsActive, productID, sellerID)
+	}
+	if err == nil {
+		err = events.Enqueue(ctx, tx, events.ProductUpdated, productID, events.ProductUpdatedPayload{ProductID: productID, Active: active})
+	}
+	if err == nil {
+		err = tx.Commit()
 	}
 	if err != nil {
-		log.Printf("failed to update product: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to update product", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 
@@ -619,9 +887,9 @@ func handleUpdateProduct(c *gin.Context) {
 }
 
 func handleDeleteProduct(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeProductsWrite)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -638,16 +906,33 @@ func handleDeleteProduct(c *gin.Context) {
 	// the index for us (last_update bumps via ON UPDATE, so it gets picked up), and
 	// deleted_at is what hides it from the seller's list — without it, Delete would
 	// merely be a second Deactivate button.
-	res, err := db.Exec(
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx,
 		"UPDATE Product SET deleted_at = NOW(), is_active = 0 WHERE product_id=? AND seller_id=? AND deleted_at IS NULL",
 		productID, sellerID)
 	if err != nil {
-		log.Printf("failed to delete product: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to delete product", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	if n, _ := res.RowsAffected(); n == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		apierr.Abort(c, apierr.NotFound("product not found"))
+		return
+	}
+	err = events.Enqueue(ctx, tx, events.ProductUpdated, productID, events.ProductUpdatedPayload{ProductID: productID})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to delete product", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 
@@ -655,9 +940,9 @@ func handleDeleteProduct(c *gin.Context) {
 }
 
 func handleToggleProductStatus(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeProductsWrite)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -666,47 +951,105 @@ func handleToggleProductStatus(c *gin.Context) {
 		IsActive int `json:"is_active"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+		apierr.Abort(c, apierr.Validation("invalid body"))
 		return
 	}
 
-	_, err = db.Exec("UPDATE Product SET is_active=? WHERE product_id=? AND seller_id=?", body.IsActive, productID, sellerID)
+	// The search index follows through the ProductUpdated event.
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("failed to toggle product status: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		apierr.Abort(c, apierr.Internal("database error", err))
 		return
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	go syncProductMeili(productID, body.IsActive == 1)
+	res, err := tx.ExecContext(ctx, "UPDATE Product SET is_active=? WHERE product_id=? AND seller_id=? AND deleted_at IS NULL", body.IsActive, productID, sellerID)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			err = events.Enqueue(ctx, tx, events.ProductUpdated, productID, events.ProductUpdatedPayload{ProductID: productID, Active: body.IsActive == 1})
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to toggle product status", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-// syncProductMeili removes or re-adds a product in MeiliSearch based on is_active.
-func syncProductMeili(productID string, active bool) {
-	if !active {
-		taskInfo, err := meiliclient.Index("products").DeleteDocument(productID)
-		if err != nil {
-			log.Printf("meili delete %s: %v", productID, err)
-			return
-		}
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-		if _, err = meiliclient.WaitForTask(taskInfo.TaskUID, meilisearch.WaitParams{Context: ctx, Interval: 200 * time.Millisecond}); err != nil {
-			log.Printf("meili delete wait %s: %v", productID, err)
-		}
+// handleUpdateStock sets a product's stock level only. It is the narrow
+// endpoint inventory integrations use with a stock:write API key.
+func handleUpdateStock(c *gin.Context) {
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeStockWrite)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+
+	productID := c.Param("id")
+	var body struct {
+		Stock *int `json:"stock"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Stock == nil || *body.Stock < 0 {
+		apierr.Abort(c, apierr.Validation("stock must be a non-negative integer"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
 		return
 	}
-	// Re-add: fetch all fields needed for the index document
+	defer func() { _ = tx.Rollback() }()
+
+	var owned int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM Product WHERE product_id=? AND seller_id=? AND deleted_at IS NULL", productID, sellerID).Scan(&owned)
+	if err == nil && owned == 0 {
+		apierr.Abort(c, apierr.NotFound("product not found"))
+		return
+	}
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO Stock (product_id, stocks) VALUES (?, ?) ON DUPLICATE KEY UPDATE stocks=?", productID, *body.Stock, *body.Stock)
+	}
+	if err == nil {
+		err = events.Enqueue(ctx, tx, events.StockChanged, productID, events.StockChangedPayload{ProductID: productID, Stock: *body.Stock})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to update stock", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// indexProduct brings a product's MeiliSearch document in line with MySQL:
+// removed while the product is inactive, deleted or gone, re-added otherwise.
+// It runs from the search-indexer consumer, which retries on error, so it
+// reads the current row instead of trusting the event.
+func indexProduct(ctx context.Context, productID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
 	var item ProductItem
 	var saleFlag int
-	err := db.QueryRow(`
-		SELECT p.product_id, p.product_name, ue.USERNAME, p.price,
+	var active bool
+	err := db.QueryRowContext(ctx, `
+		SELECT p.product_id, p.product_name, p.seller_id, p.price,
 		       c.category_name, p.product_condition, COALESCE(s.stocks,0),
 		       p.avg_review, p.review_count, p.sale_flag,
-		       COALESCE(p.sale_id,''), COALESCE(ts.discount_rate,0)
+		       COALESCE(p.sale_id,''), COALESCE(ts.discount_rate,0),
+		       p.is_active = 1 AND p.deleted_at IS NULL
 		FROM Product p
-		JOIN USER_ENTITY ue ON p.seller_id = ue.EMAIL
 		JOIN Category c ON p.category_id = c.category_id
 		LEFT JOIN Stock s ON p.product_id = s.product_id
 		LEFT JOIN TimeSale ts ON p.sale_id = ts.id
@@ -714,48 +1057,82 @@ func syncProductMeili(productID string, active bool) {
 	).Scan(&item.ProductID, &item.ProductName, &item.SellerName, &item.Price,
 		&item.CategoryName, &item.Condition, &item.Stocks,
 		&item.AvgReview, &item.ReviewCount, &saleFlag,
-		&item.SaleID, &item.DiscountRate)
+		&item.SaleID, &item.DiscountRate, &active)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read product %s: %w", productID, err)
+	}
+
+	if !active {
+		var taskInfo *meilisearch.TaskInfo
+		err := tracing.Call(ctx, "meilisearch", "delete document", func() (err error) {
+			taskInfo, err = meiliclient.Index("products").DeleteDocument(productID)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("meili delete %s: %w", productID, err)
+		}
+		return waitMeiliTask(ctx, taskInfo)
+	}
+	// The index shows the seller's Keycloak username, never their email.
+	if profiles == nil {
+		return errors.New("keycloak admin client is not configured")
+	}
+	seller, err := profiles.Profile(ctx, item.SellerName)
 	if err != nil {
-		log.Printf("meili re-add fetch %s: %v", productID, err)
-		return
+		return fmt.Errorf("seller of %s: %w", productID, err)
 	}
+	item.SellerName = seller.Username
 	item.SaleFlag = saleFlag == 1
-	taskInfo, err2 := meiliclient.Index("products").AddDocuments([]ProductItem{item}, "product_id")
-	if err2 != nil {
-		log.Printf("meili add %s: %v", productID, err2)
-		return
+	var taskInfo *meilisearch.TaskInfo
+	err = tracing.Call(ctx, "meilisearch", "add documents", func() (err error) {
+		taskInfo, err = meiliclient.Index("products").AddDocuments([]ProductItem{item}, "product_id")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("meili add %s: %w", productID, err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-	defer cancel()
-	if _, err2 = meiliclient.WaitForTask(taskInfo.TaskUID, meilisearch.WaitParams{Context: ctx, Interval: 200 * time.Millisecond}); err2 != nil {
-		log.Printf("meili add wait %s: %v", productID, err2)
+	return waitMeiliTask(ctx, taskInfo)
+}
+
+// handleIndexEvent re-indexes the product a ProductUpdated, StockChanged,
+// ReviewPosted, ReviewModerated or ReviewDeleted event is about; every one of
+// those payloads names it productId.
+func handleIndexEvent(ctx context.Context, e events.Event) error {
+	var p struct {
+		ProductID string `json:"productId"`
+	}
+	if err := e.Decode(&p); err != nil {
+		return err
 	}
+	return indexProduct(ctx, p.ProductID)
+}
+
+func waitMeiliTask(ctx context.Context, taskInfo *meilisearch.TaskInfo) error {
+	return tracing.Call(ctx, "meilisearch", "wait for task", func() error {
+		_, err := meiliclient.WaitForTask(taskInfo.TaskUID, meilisearch.WaitParams{Context: ctx, Interval: 200 * time.Millisecond})
+		return err
+	})
 }
 
 func handleGetProfile(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := verifiedEmail(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 
 	var sellerName sql.NullString
 	var description sql.NullString
-	_ = db.QueryRow("SELECT seller_name, description FROM Seller WHERE seller_id = ?", sellerID).Scan(&sellerName, &description)
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT seller_name, description FROM Seller WHERE seller_id = ?", sellerID).Scan(&sellerName, &description)
 
 	// Fall back to the storeName supplied at sign-up (stored as a Keycloak user
 	// attribute) so a new seller's store name is pre-filled without re-entry.
 	storeName := sellerName.String
 	if strings.TrimSpace(storeName) == "" {
-		var attrStore sql.NullString
-		_ = db.QueryRow(`
-			SELECT ua.VALUE
-			FROM USER_ENTITY ue
-			JOIN USER_ATTRIBUTE ua ON ue.ID = ua.USER_ID AND ua.NAME = 'storeName'
-			WHERE ue.EMAIL = ?
-			LIMIT 1`, sellerID).Scan(&attrStore)
-		if attrStore.Valid {
-			storeName = attrStore.String
+		if p, err := profiles.Profile(c.Request.Context(), sellerID); err == nil {
+			storeName = p.Attribute("storeName")
+		} else if !errors.Is(err, keycloak.ErrNotFound) {
+			logging.From(c.Request.Context()).Warn("keycloak profile", zap.Error(err))
 		}
 	}
 
@@ -763,9 +1140,9 @@ func handleGetProfile(c *gin.Context) {
 }
 
 func handleUpdateProfile(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := verifiedEmail(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 
@@ -774,35 +1151,209 @@ func handleUpdateProfile(c *gin.Context) {
 		Description *string `json:"description"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		apierr.Abort(c, apierr.Validation("invalid request body"))
 		return
 	}
 
 	if body.Description != nil {
-		_, err = db.Exec(
+		_, err = db.ExecContext(c.Request.Context(), 
 			"INSERT INTO Seller (seller_id, seller_name, description) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE seller_name = VALUES(seller_name), description = VALUES(description)",
 			sellerID, body.SellerName, *body.Description,
 		)
 	} else {
-		_, err = db.Exec(
+		_, err = db.ExecContext(c.Request.Context(), 
 			"INSERT INTO Seller (seller_id, seller_name) VALUES (?, ?) ON DUPLICATE KEY UPDATE seller_name = VALUES(seller_name)",
 			sellerID, body.SellerName,
 		)
 	}
 	if err != nil {
-		log.Printf("failed to update profile: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to update profile", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// maxAPIKeysPerSeller caps active keys so a leaked portal session can't mint
+// an unbounded number of them.
+const maxAPIKeysPerSeller = 10
+
+// normalizeScopes validates requested scopes against the known set and returns
+// them de-duplicated in canonical order.
+func normalizeScopes(requested []string) ([]string, error) {
+	want := map[string]bool{}
+	for _, s := range requested {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(commonauth.APIKeyScopes, s) {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		want[s] = true
+	}
+	var out []string
+	for _, s := range commonauth.APIKeyScopes {
+		if want[s] {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return out, nil
+}
+
+// API keys are managed from the Seller Portal only: these endpoints take the
+// seller's JWT and never an API key, so a key cannot mint further keys.
+func handleListAPIKeys(c *gin.Context) {
+	sellerID, err := verifiedEmail(c)
+	if err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
+		return
+	}
+
+	// Kong's proxy-cache keys on the path, not the caller; keep one seller's
+	// keys from being served to another.
+	c.Header("Cache-Control", "no-store")
+	rows, err := db.QueryContext(c.Request.Context(), 
+		`SELECT key_id, name, key_prefix, scopes, rate_limit_per_min, last_used_at, created_at
+		 FROM SellerApiKey WHERE seller_id = ? AND revoked_at IS NULL ORDER BY created_at DESC`, sellerID)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to query api keys", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	defer rows.Close()
+
+	type APIKeyRow struct {
+		ID                 string     `json:"id"`
+		Name               string     `json:"name"`
+		Prefix             string     `json:"prefix"`
+		Scopes             []string   `json:"scopes"`
+		RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+		LastUsedAt         *time.Time `json:"last_used_at"`
+		CreatedAt          time.Time  `json:"created_at"`
+	}
+	keys := []APIKeyRow{}
+	for rows.Next() {
+		var k APIKeyRow
+		var scopes string
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.RateLimitPerMinute, &lastUsed, &k.CreatedAt); err != nil {
+			continue
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		if lastUsed.Valid {
+			k.LastUsedAt = &lastUsed.Time
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+func handleCreateAPIKey(c *gin.Context) {
+	sellerID, err := verifiedEmail(c)
+	if err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
+		return
+	}
+
+	var body struct {
+		Name               string   `json:"name"`
+		Scopes             []string `json:"scopes"`
+		RateLimitPerMinute int      `json:"rate_limit_per_minute"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.Validation("invalid request body"))
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 100 {
+		apierr.Abort(c, apierr.Validation("name is required (max 100 characters)"))
+		return
+	}
+	scopes, err := normalizeScopes(body.Scopes)
+	if err != nil {
+		apierr.Abort(c, apierr.Validation(err.Error()))
+		return
+	}
+	if body.RateLimitPerMinute <= 0 {
+		body.RateLimitPerMinute = 60
+	}
+	if body.RateLimitPerMinute > 600 {
+		apierr.Abort(c, apierr.Validation("rate_limit_per_minute must be at most 600"))
+		return
+	}
+
+	var active int
+	if err := db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM SellerApiKey WHERE seller_id = ? AND revoked_at IS NULL", sellerID).Scan(&active); err != nil {
+		logging.From(c.Request.Context()).Error("failed to count api keys", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	if active >= maxAPIKeysPerSeller {
+		apierr.Abort(c, apierr.Conflict(fmt.Sprintf("at most %d active keys; revoke one first", maxAPIKeysPerSeller)))
+		return
+	}
+
+	key, prefix, hash, err := commonauth.GenerateAPIKey()
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to generate api key", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("key generation failed", nil))
+		return
+	}
+	keyID := uuid.New().String()
+	_, err = db.ExecContext(c.Request.Context(), 
+		`INSERT INTO SellerApiKey (key_id, seller_id, name, key_prefix, key_hash, scopes, rate_limit_per_min)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		keyID, sellerID, body.Name, prefix, hash, strings.Join(scopes, ","), body.RateLimitPerMinute)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to insert api key", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'seller', ?, 'success')",
+		"API Key Created", sellerID, body.Name+" ("+prefix+")")
+
+	// The plaintext key is returned exactly once; only its hash is stored.
+	c.JSON(http.StatusCreated, gin.H{
+		"id":                    keyID,
+		"name":                  body.Name,
+		"key":                   key,
+		"prefix":                prefix,
+		"scopes":                scopes,
+		"rate_limit_per_minute": body.RateLimitPerMinute,
+	})
+}
+
+func handleRevokeAPIKey(c *gin.Context) {
+	sellerID, err := verifiedEmail(c)
+	if err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
+		return
+	}
+
+	keyID := c.Param("id")
+	res, err := db.ExecContext(c.Request.Context(), "UPDATE SellerApiKey SET revoked_at = NOW() WHERE key_id = ? AND seller_id = ? AND revoked_at IS NULL", keyID, sellerID)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to revoke api key", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		apierr.Abort(c, apierr.NotFound("api key not found"))
+		return
+	}
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'seller', ?, 'success')",
+		"API Key Revoked", sellerID, keyID)
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func handleSellerCategories(c *gin.Context) {
-	rows, err := db.Query("SELECT category_id, category_name FROM Category ORDER BY category_name")
+	rows, err := db.QueryContext(c.Request.Context(), "SELECT category_id, category_name FROM Category ORDER BY category_name")
 	if err != nil {
-		log.Printf("failed to query categories: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to query categories", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	defer rows.Close()
@@ -826,9 +1377,9 @@ func handleSellerCategories(c *gin.Context) {
 }
 
 func handleCreateProduct(c *gin.Context) {
-	sellerID, err := extractEmailFromJWT(c)
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeProductsWrite)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, err)
 		return
 	}
 
@@ -843,7 +1394,7 @@ func handleCreateProduct(c *gin.Context) {
 		Status           bool    `json:"status"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		apierr.Abort(c, apierr.Validation("invalid request body"))
 		return
 	}
 
@@ -851,7 +1402,7 @@ func handleCreateProduct(c *gin.Context) {
 
 	// Get geo_id for seller
 	var geoID string
-	err = db.QueryRow("SELECT geo_id FROM Geo WHERE user_id = ? LIMIT 1", sellerID).Scan(&geoID)
+	err = db.QueryRowContext(c.Request.Context(), "SELECT geo_id FROM Geo WHERE user_id = ? LIMIT 1", sellerID).Scan(&geoID)
 	if err != nil {
 		geoID = "40e1eeca-7db5-4df3-8ab0-8addd3ec9103"
 	}
@@ -870,20 +1421,38 @@ func handleCreateProduct(c *gin.Context) {
 		condition = "new"
 	}
 
-	_, err = db.Exec(
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx,
 		`INSERT INTO Product (product_id, product_name, seller_id, price, category_id, summary, product_condition, geo_id, sale_flag, sale_id)
 		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		productID, body.Name, sellerID, int(body.Price), body.CategoryID, body.Description, condition, geoID, saleFlag, saleID,
 	)
 	if err != nil {
-		log.Printf("failed to insert product: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("failed to insert product", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO Stock (product_id, stocks) VALUES (?, ?)", productID, body.Stock)
+	_, err = tx.ExecContext(ctx, "INSERT INTO Stock (product_id, stocks) VALUES (?, ?)", productID, body.Stock)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("failed to insert stock", zap.Error(err))
+	}
+
+	err = events.Enqueue(ctx, tx, events.ProductUpdated, productID, events.ProductUpdatedPayload{ProductID: productID, Active: true})
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		log.Printf("failed to insert stock: %v", err)
+		logging.From(c.Request.Context()).Error("failed to insert product", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"product_id": productID})
@@ -892,20 +1461,9 @@ func handleCreateProduct(c *gin.Context) {
 func handleUploadProductImages(c *gin.Context) {
 	productID := c.Param("id")
 
-	minioEndpoint := "minio-service.default.svc.cluster.local:9000"
-	minioClient, err := minio.New(minioEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4("minioadmin", "minioadmin", ""),
-		Secure: false,
-	})
-	if err != nil {
-		log.Printf("failed to create minio client: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
-		return
-	}
-
 	form, err := c.MultipartForm()
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form"})
+		apierr.Abort(c, apierr.Validation("invalid multipart form"))
 		return
 	}
 
@@ -920,17 +1478,17 @@ func handleUploadProductImages(c *gin.Context) {
 	if slotStr := c.Query("slot"); slotStr != "" {
 		slot, err2 := strconv.Atoi(slotStr)
 		if err2 != nil || slot < 0 || slot > 2 || len(files) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid slot"})
+			apierr.Abort(c, apierr.Validation("invalid slot"))
 			return
 		}
 		file, err2 := files[0].Open()
 		if err2 != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "open failed"})
+			apierr.Abort(c, apierr.Internal("open failed", nil))
 			return
 		}
 		defer file.Close()
 		if _, err2 = minioClient.PutObject(c.Request.Context(), "photos", allPaths[slot], file, files[0].Size, minio.PutObjectOptions{ContentType: "image/png"}); err2 != nil {
-			log.Printf("failed to upload image slot %d: %v", slot, err2)
+			logging.From(c.Request.Context()).Error("failed to upload image", zap.Int("slot", slot), zap.Error(err2))
 		}
 		c.JSON(http.StatusOK, gin.H{"success": true})
 		return
@@ -942,7 +1500,7 @@ func handleUploadProductImages(c *gin.Context) {
 		}
 		file, err := fileHeader.Open()
 		if err != nil {
-			log.Printf("failed to open file: %v", err)
+			logging.From(c.Request.Context()).Error("failed to open file", zap.Error(err))
 			continue
 		}
 		defer file.Close()
@@ -956,7 +1514,7 @@ func handleUploadProductImages(c *gin.Context) {
 			minio.PutObjectOptions{ContentType: "image/png"},
 		)
 		if err != nil {
-			log.Printf("failed to upload image %d: %v", i, err)
+			logging.From(c.Request.Context()).Error("failed to upload image", zap.Int("image", i), zap.Error(err))
 		}
 	}
 
@@ -968,30 +1526,23 @@ func handleDeleteProductImage(c *gin.Context) {
 	slotStr := c.Param("slot")
 	slot, err := strconv.Atoi(slotStr)
 	if err != nil || slot < 0 || slot > 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid slot"})
+		apierr.Abort(c, apierr.Validation("invalid slot"))
 		return
 	}
 	paths := []string{productID + ".png", productID + "/1.png", productID + "/2.png"}
 
-	minioClient, err := minio.New("minio-service.default.svc.cluster.local:9000", &minio.Options{
-		Creds: credentials.NewStaticV4("minioadmin", "minioadmin", ""), Secure: false,
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "storage error"})
-		return
-	}
 	if err = minioClient.RemoveObject(c.Request.Context(), "photos", paths[slot], minio.RemoveObjectOptions{}); err != nil {
-		log.Printf("failed to delete image slot %d: %v", slot, err)
+		logging.From(c.Request.Context()).Error("failed to delete image", zap.Int("slot", slot), zap.Error(err))
 	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func handleGetActiveSales(c *gin.Context) {
 	query := `SELECT id, name, start_date, end_date, discount_rate FROM TimeSale WHERE start_date <= NOW() AND end_date >= NOW()`
-	rows, err := db.Query(query)
+	rows, err := db.QueryContext(c.Request.Context(), query)
 	if err != nil {
-		log.Printf("failed to query active sales: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query database"})
+		logging.From(c.Request.Context()).Error("failed to query active sales", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to query database", nil))
 		return
 	}
 	defer rows.Close()
@@ -1000,7 +1551,7 @@ func handleGetActiveSales(c *gin.Context) {
 	for rows.Next() {
 		var ts TimeSale
 		if err := rows.Scan(&ts.ID, &ts.Name, &ts.StartDate, &ts.EndDate, &ts.DiscountRate); err != nil {
-			log.Printf("failed to scan sale: %v", err)
+			logging.From(c.Request.Context()).Error("failed to scan sale", zap.Error(err))
 			continue
 		}
 		sales = append(sales, ts)
@@ -1020,15 +1571,19 @@ func handleGetRandomProducts(c *gin.Context) {
 	}
 
 	// Fetch from MeiliSearch
-	searchRes, err := meiliclient.Index("products").Search(
-		"*",
-		&meilisearch.SearchRequest{
-			Limit:  1000,
-			Filter: filter,
-		},
-	)
+	var searchRes *meilisearch.SearchResponse
+	err := tracing.Call(c.Request.Context(), "meilisearch", "search", func() (err error) {
+		searchRes, err = meiliclient.Index("products").Search(
+			"*",
+			&meilisearch.SearchRequest{
+				Limit:  1000,
+				Filter: filter,
+			},
+		)
+		return err
+	})
 	if err != nil {
-		log.Printf("failed to query MeiliSearch: %v", err)
+		logging.From(c.Request.Context()).Error("failed to query MeiliSearch", zap.Error(err))
 		c.JSON(http.StatusOK, gin.H{"items": []ProductItem{}, "total": 0})
 		return
 	}
@@ -1036,21 +1591,21 @@ func handleGetRandomProducts(c *gin.Context) {
 	var items []ProductItem
 	hitsJson, err := json.Marshal(searchRes.Hits)
 	if err != nil {
-		log.Printf("failed to marshal hits: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal JSON error"})
+		logging.From(c.Request.Context()).Error("failed to marshal hits", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("internal JSON error", nil))
 		return
 	}
 	if err := json.Unmarshal(hitsJson, &items); err != nil {
-		log.Printf("failed to unmarshal items: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal unmarshal error"})
+		logging.From(c.Request.Context()).Error("failed to unmarshal items", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("internal unmarshal error", nil))
 		return
 	}
 
 	// Retrieve discount rates from MySQL to build lookup map
-	rows, err := db.Query("SELECT id, discount_rate FROM TimeSale")
+	rows, err := db.QueryContext(c.Request.Context(), "SELECT id, discount_rate FROM TimeSale")
 	if err != nil {
-		log.Printf("failed to query sale rates: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to query database"})
+		logging.From(c.Request.Context()).Error("failed to query sale rates", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to query database", nil))
 		return
 	}
 	defer rows.Close()
@@ -1104,8 +1659,8 @@ var euCountries = map[string]bool{
 //   - "Unusual location (EU)"    — shipping address country is in the EU
 //   - "Multiple rapid orders"    — same customer placed >= 3 orders within 15 min
 func handleAdminOrders(c *gin.Context) {
-	if _, err := extractEmailFromJWT(c); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	if _, err := verifiedEmail(c); err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 
@@ -1119,7 +1674,7 @@ func handleAdminOrders(c *gin.Context) {
 	}
 
 	// Scan a recent window; flag + paginate in-process.
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(c.Request.Context(), `
 		SELECT o.order_id, o.user_id, o.total_amount, o.status, o.created_at,
 			COALESCE((
 				SELECT g.country_code FROM ` + "`Transaction`" + ` t
@@ -1135,8 +1690,8 @@ func handleAdminOrders(c *gin.Context) {
 		ORDER BY o.created_at DESC
 		LIMIT 1000`)
 	if err != nil {
-		log.Printf("admin orders query: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("admin orders query", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	defer rows.Close()
@@ -1199,8 +1754,8 @@ func handleAdminOrders(c *gin.Context) {
 
 // handleGetAudit returns the most recent audit-log entries.
 func handleGetAudit(c *gin.Context) {
-	if _, err := extractEmailFromJWT(c); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	if _, err := verifiedEmail(c); err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
@@ -1223,15 +1778,15 @@ func handleGetAudit(c *gin.Context) {
 	}
 
 	var total int
-	_ = db.QueryRow("SELECT COUNT(*) FROM AuditLog "+where, args...).Scan(&total)
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM AuditLog "+where, args...).Scan(&total)
 
 	pagedArgs := append(append([]interface{}{}, args...), limit, offset)
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(c.Request.Context(), `
 		SELECT id, action, actor, COALESCE(actor_type,'system'), target, status, created_at
 		FROM AuditLog `+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, pagedArgs...)
 	if err != nil {
-		log.Printf("audit query: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("audit query", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	defer rows.Close()
@@ -1265,9 +1820,9 @@ func handleGetAudit(c *gin.Context) {
 
 // handlePostAudit records an audit-log entry (admin actions, logins, etc).
 func handlePostAudit(c *gin.Context) {
-	actor, err := extractEmailFromJWT(c)
+	actor, err := verifiedEmail(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 	var body struct {
@@ -1277,7 +1832,7 @@ func handlePostAudit(c *gin.Context) {
 		ActorType string `json:"actor_type"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Action) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "action is required"})
+		apierr.Abort(c, apierr.Validation("action is required"))
 		return
 	}
 	status := body.Status
@@ -1292,23 +1847,23 @@ func handlePostAudit(c *gin.Context) {
 	if t := strings.ToLower(strings.TrimSpace(body.ActorType)); t == "admin" || t == "seller" || t == "customer" {
 		actorType = t
 	}
-	if _, err := db.Exec(
+	if _, err := db.ExecContext(c.Request.Context(), 
 		"INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, ?, ?, ?)",
 		body.Action, actor, actorType, body.Target, status,
 	); err != nil {
-		log.Printf("audit insert: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("audit insert", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 // handleAdminHealth reports real system-component health derived from live
-// signals (DB reachability + row counts), plus colloquial alerts when a
-// component is degraded.
+// signals (DB reachability + row counts, every service's readiness probes and
+// their recent history), plus colloquial alerts when a component is degraded.
 func handleAdminHealth(c *gin.Context) {
-	if _, err := extractEmailFromJWT(c); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	if _, err := verifiedEmail(c); err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 
@@ -1328,9 +1883,9 @@ func handleAdminHealth(c *gin.Context) {
 
 	// Counts as lightweight metrics.
 	var productCount, orderCount, stockOut int
-	_ = db.QueryRow("SELECT COUNT(*) FROM Product").Scan(&productCount)
-	_ = db.QueryRow("SELECT COUNT(*) FROM `Order`").Scan(&orderCount)
-	_ = db.QueryRow("SELECT COUNT(*) FROM Stock WHERE stocks = 0").Scan(&stockOut)
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM Product").Scan(&productCount)
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM `Order`").Scan(&orderCount)
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT COUNT(*) FROM Stock WHERE stocks = 0").Scan(&stockOut)
 
 	apiStatus, apiDetail := "operational", "All endpoints healthy"
 	if pingMs > 500 {
@@ -1352,9 +1907,16 @@ func handleAdminHealth(c *gin.Context) {
 		catalogDetail = fmt.Sprintf("%d%% of catalog out of stock (%d/%d) — over the %d%% threshold", outOfStockPct, stockOut, productCount, outOfStockThresholdPct)
 	}
 
+	slaReports := slaEval.Report()
+	slaStatus, slaDetail := slaSummary(slaReports)
+	services := healthMon.Services()
+	servicesStatus, servicesDetail := servicesSummary(services)
+
 	components := []Component{
 		{"Database", dbStatus, dbDetail},
 		{"API Server", apiStatus, apiDetail},
+		{"Services", servicesStatus, servicesDetail},
+		{"API SLA", slaStatus, slaDetail},
 		{"Catalog / Inventory", catalogStatus, catalogDetail},
 	}
 
@@ -1372,6 +1934,8 @@ func handleAdminHealth(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"components": components,
 		"alerts":     alerts,
+		"services":   services,
+		"sla":        slaReports,
 		"metrics": gin.H{
 			"products":    productCount,
 			"orders":      orderCount,
@@ -1381,31 +1945,95 @@ func handleAdminHealth(c *gin.Context) {
 	})
 }
 
-// handleAdminGetSeller returns a seller's store profile (store name +
-// description) by email, from the same `Seller` table the storefront and Seller
-// Portal read. Lets the Admin Portal show/prefill the real store name instead of
-// only the Keycloak attribute (which is empty for pre-seeded sellers).
-func handleAdminGetSeller(c *gin.Context) {
-	if _, err := extractEmailFromJWT(c); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
+// servicesSummary turns the latest readiness probes into the "Services"
+// component. It is degraded while any service is down or has a failing
+// optional dependency, naming the failing checks.
+func servicesSummary(services []health.ServiceStatus) (string, string) {
+	var polled int
+	var problems []string
+	for _, s := range services {
+		if s.Status == health.StatusUnknown {
+			continue
+		}
+		polled++
+		if s.Status == health.StatusOK {
+			continue
+		}
+		var failing []string
+		for _, c := range s.Latest.Checks {
+			if c.Status != health.StatusOK {
+				failing = append(failing, c.Name)
+			}
+		}
+		problems = append(problems, fmt.Sprintf("%s %s (%s)", s.Service, s.Status, strings.Join(failing, ", ")))
 	}
-	email := strings.TrimSpace(c.Query("email"))
-	if email == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
-		return
+	if polled == 0 {
+		return "operational", "Service readiness not probed yet"
 	}
-	var name, desc sql.NullString
-	_ = db.QueryRow("SELECT seller_name, description FROM Seller WHERE seller_id = ?", email).Scan(&name, &desc)
-	c.JSON(http.StatusOK, gin.H{"email": email, "seller_name": name.String, "description": desc.String})
+	if len(problems) > 0 {
+		return "degraded", fmt.Sprintf("%d of %d services not fully ready: %s", len(problems), polled, strings.Join(problems, "; "))
+	}
+	return "operational", fmt.Sprintf("All %d services ready", polled)
+}
+
+// slaSummary turns the per-route SLA reports into the "API SLA" component. It
+// is degraded while any route is breaching (burning error budget in every
+// window, or slower than its sla_ms on average); routes that are only at risk
+// are named but do not degrade it.
+func slaSummary(reports []metrics.RouteReport) (string, string) {
+	var measured int
+	var breaching, atRisk []string
+	for _, r := range reports {
+		if r.Status == metrics.StatusNoData {
+			continue
+		}
+		measured++
+		w := r.Windows[len(r.Windows)-1]
+		desc := fmt.Sprintf("%s %s %.1f%% within %dms over %s (burn %.1fx)", r.Method, r.Path, w.Compliance*100, r.BudgetMs, w.Covered, w.BurnRate)
+		switch r.Status {
+		case metrics.StatusBreaching:
+			breaching = append(breaching, desc)
+		case metrics.StatusAtRisk:
+			atRisk = append(atRisk, desc)
+		}
+	}
+	if measured == 0 {
+		return "operational", "No API traffic measured yet"
+	}
+	if len(breaching) > 0 {
+		return "degraded", fmt.Sprintf("%d of %d routes breaching SLA: %s", len(breaching), measured, strings.Join(breaching, "; "))
+	}
+	if len(atRisk) > 0 {
+		return "operational", fmt.Sprintf("%d routes within SLA; at risk: %s", measured, strings.Join(atRisk, "; "))
+	}
+	return "operational", fmt.Sprintf("All %d measured routes within SLA", measured)
+}
+
+// handleAdminGetSeller returns a seller's store profile (store name +
+// description) by email, from the same `Seller` table the storefront and Seller
+// Portal read. Lets the Admin Portal show/prefill the real store name instead of
+// only the Keycloak attribute (which is empty for pre-seeded sellers).
+func handleAdminGetSeller(c *gin.Context) {
+	if _, err := verifiedEmail(c); err != nil {
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
+		return
+	}
+	email := strings.TrimSpace(c.Query("email"))
+	if email == "" {
+		apierr.Abort(c, apierr.Validation("email is required"))
+		return
+	}
+	var name, desc sql.NullString
+	_ = db.QueryRowContext(c.Request.Context(), "SELECT seller_name, description FROM Seller WHERE seller_id = ?", email).Scan(&name, &desc)
+	c.JSON(http.StatusOK, gin.H{"email": email, "seller_name": name.String, "description": desc.String})
 }
 
 // handleAdminPutSeller upserts a seller's store name (the buyer-facing "vendor"
 // name) in the `Seller` table so an admin edit actually changes what buyers see.
 func handleAdminPutSeller(c *gin.Context) {
-	actor, err := extractEmailFromJWT(c)
+	actor, err := verifiedEmail(c)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		apierr.Abort(c, apierr.Unauthorized(err.Error()))
 		return
 	}
 	var body struct {
@@ -1413,17 +2041,1018 @@ func handleAdminPutSeller(c *gin.Context) {
 		SellerName string `json:"seller_name"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Email) == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		apierr.Abort(c, apierr.Validation("email is required"))
 		return
 	}
-	if _, err := db.Exec(
+	if _, err := db.ExecContext(c.Request.Context(), 
 		"INSERT INTO Seller (seller_id, seller_name) VALUES (?, ?) ON DUPLICATE KEY UPDATE seller_name = VALUES(seller_name)",
 		body.Email, body.SellerName,
 	); err != nil {
-		log.Printf("admin update seller: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		logging.From(c.Request.Context()).Error("admin update seller", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
 		return
 	}
-	_, _ = db.Exec("INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')", "Store Name Updated", actor, body.Email)
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')", "Store Name Updated", actor, body.Email)
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+// defaultRevokeTTL bounds how long a denylist entry lives. It only has to
+// outlast the access tokens it can match, so it comfortably covers Keycloak's
+// access-token lifespan.
+const defaultRevokeTTL = time.Hour
+
+// handleAdminRevokeSessions cuts a user off before their tokens expire. Any
+// combination of user_id (every token issued so far — suspension), sid (one
+// Keycloak session) and jti (one token) may be given.
+func handleAdminRevokeSessions(c *gin.Context) {
+	actor, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	var body struct {
+		UserID     string `json:"user_id"`
+		SID        string `json:"sid"`
+		JTI        string `json:"jti"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.Validation("invalid request body"))
+		return
+	}
+	body.UserID = strings.TrimSpace(body.UserID)
+	body.SID = strings.TrimSpace(body.SID)
+	body.JTI = strings.TrimSpace(body.JTI)
+	if body.UserID == "" && body.SID == "" && body.JTI == "" {
+		apierr.Abort(c, apierr.Validation("one of user_id, sid or jti is required"))
+		return
+	}
+	ttl := defaultRevokeTTL
+	if body.TTLSeconds > 0 {
+		ttl = time.Duration(body.TTLSeconds) * time.Second
+	}
+
+	ctx := c.Request.Context()
+	var targets []string
+	if body.UserID != "" {
+		err = denylist.RevokeUser(ctx, body.UserID, ttl)
+		targets = append(targets, body.UserID)
+	}
+	if err == nil && body.SID != "" {
+		err = denylist.RevokeSession(ctx, body.SID, ttl)
+		targets = append(targets, "sid:"+body.SID)
+	}
+	if err == nil && body.JTI != "" {
+		err = denylist.RevokeToken(ctx, body.JTI, ttl)
+		targets = append(targets, "jti:"+body.JTI)
+	}
+	status := "success"
+	if err != nil {
+		status = "failed"
+	}
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, ?)",
+		"Sessions Revoked", actor, strings.Join(targets, ","), status)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("admin revoke sessions", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("denylist unavailable", nil))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "expires_in": int(ttl.Seconds())})
+}
+
+// handleAdminReinstateUser lifts a user-level revoke early.
+func handleAdminReinstateUser(c *gin.Context) {
+	actor, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	var body struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.UserID) == "" {
+		apierr.Abort(c, apierr.Validation("user_id is required"))
+		return
+	}
+	if err := denylist.ReinstateUser(c.Request.Context(), body.UserID); err != nil {
+		logging.From(c.Request.Context()).Error("admin reinstate user", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("denylist unavailable", nil))
+		return
+	}
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')",
+		"User Reinstated", actor, body.UserID)
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// verifiedAdmin verifies the caller's JWT like verifiedEmail and checks that
+// they are an administrator: the "admin" realm role, membership of
+// admin-group when a groups mapper is configured, or an email listed in
+// ADMIN_USERS (comma separated, default superadmin@example.com — the realm's
+// admins are a group without a role, so the role alone is not enough today).
+// Impersonation tokens never count as admin, whoever their subject is.
+// Errors are *apierr.Error, ready to be written as they are: unauthorized when
+// the token does not verify, forbidden when it does but is not an admin's.
+func verifiedAdmin(c *gin.Context) (string, error) {
+	claims, err := verifiedClaims(c)
+	if err != nil {
+		return "", apierr.Unauthorized(err.Error())
+	}
+	if _, ok := commonauth.ImpersonationFromClaims(claims); ok {
+		return "", apierr.Forbidden("impersonation tokens cannot perform admin actions")
+	}
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return "", apierr.Unauthorized("email claim not found in JWT")
+	}
+	if isAdminClaims(claims, email) {
+		logging.SetUser(c.Request.Context(), email)
+		return email, nil
+	}
+	return "", apierr.Forbidden("admin privileges required")
+}
+
+func isAdminClaims(claims map[string]interface{}, email string) bool {
+	if ra, ok := claims["realm_access"].(map[string]interface{}); ok {
+		if roles, ok := ra["roles"].([]interface{}); ok {
+			for _, r := range roles {
+				if s, _ := r.(string); strings.EqualFold(s, "admin") {
+					return true
+				}
+			}
+		}
+	}
+	if groups, ok := claims["groups"].([]interface{}); ok {
+		for _, g := range groups {
+			if s, _ := g.(string); strings.TrimPrefix(s, "/") == "admin-group" {
+				return true
+			}
+		}
+	}
+	for _, a := range cfg.AdminUsers {
+		if strings.EqualFold(a, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleAdminImpersonate mints a short-lived token letting support staff see
+// the storefront as a given user. Tokens are read-only unless "write" is set,
+// last at most 15 minutes, and every request made with one is audited by the
+// receiving service. Revoke early with /v1/admin/sessions/revoke and the jti.
+func handleAdminImpersonate(c *gin.Context) {
+	if impersonator == nil {
+		apierr.Abort(c, apierr.Unavailable("impersonation is not configured", nil))
+		return
+	}
+	admin, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	var body struct {
+		UserID     string `json:"user_id"`
+		Reason     string `json:"reason"`
+		Write      bool   `json:"write"`
+		TTLSeconds int    `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.Validation("invalid request body"))
+		return
+	}
+	body.UserID = strings.TrimSpace(body.UserID)
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.UserID == "" || body.Reason == "" {
+		apierr.Abort(c, apierr.Validation("user_id and reason are required"))
+		return
+	}
+	if strings.EqualFold(body.UserID, admin) {
+		apierr.Abort(c, apierr.Validation("cannot impersonate yourself"))
+		return
+	}
+
+	tok, imp, err := impersonator.Issue(admin, body.UserID, body.Write, time.Duration(body.TTLSeconds)*time.Second, body.Reason)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("impersonation issue failed", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("could not issue token", nil))
+		return
+	}
+	mode := "read-only"
+	if body.Write {
+		mode = "read-write"
+	}
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'warning')",
+		"Impersonation Started ("+mode+")", admin, body.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         tok,
+		"jti":           imp.ID,
+		"subject":       imp.Subject,
+		"impersonator":  imp.Impersonator,
+		"read_only":     imp.ReadOnly,
+		"expires_at":    imp.ExpiresAt,
+		"impersonation": true,
+	})
+}
+
+type CustomerAddress struct {
+	GeoID        string `json:"geo_id"`
+	IsPrimary    bool   `json:"is_primary"`
+	CountryCode  string `json:"country_code"`
+	PostalCode   string `json:"postal_code"`
+	Prefecture   string `json:"prefecture"`
+	City         string `json:"city"`
+	Town         string `json:"town"`
+	BuildingName string `json:"building_name"`
+	RoomNumber   string `json:"room_number"`
+}
+
+type CustomerRecord struct {
+	UserID      string            `json:"user_id"`
+	Username    string            `json:"username"`
+	Email       string            `json:"email"`
+	FirstName   string            `json:"first_name"`
+	LastName    string            `json:"last_name"`
+	PhoneNumber string            `json:"phone_number"`
+	CountryCode string            `json:"country_code"`
+	Addresses   []CustomerAddress `json:"addresses"`
+}
+
+// handleAdminCustomerLookup shows support staff a customer's contact details
+// and addresses, decrypted. Look up by ?user_id= (email or username) or by
+// ?phone= in any formatting; phone lookups go through the blind index
+// (phoneNumberIndex) since the number itself is only stored encrypted. Every
+// lookup is audited, without the phone number.
+func handleAdminCustomerLookup(c *gin.Context) {
+	admin, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	if kc == nil {
+		apierr.Abort(c, apierr.Unavailable("keycloak admin client is not configured", nil))
+		return
+	}
+	userID := strings.TrimSpace(c.Query("user_id"))
+	phone := strings.TrimSpace(c.Query("phone"))
+	if (userID == "") == (phone == "") {
+		apierr.Abort(c, apierr.Validation("exactly one of user_id or phone is required"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	var users []keycloak.User
+	target := userID
+	if phone != "" {
+		target = "phone lookup"
+		idx := fieldKeys.PhoneIndex(phone)
+		if idx == "" {
+			apierr.Abort(c, apierr.Validation("invalid phone number"))
+			return
+		}
+		users, err = kc.FindUsersByAttribute(ctx, fieldcrypt.PhoneIndexAttr, idx)
+	} else {
+		var u *keycloak.User
+		if u, err = kc.LookupUser(ctx, userID); err == nil {
+			users = []keycloak.User{*u}
+		} else if errors.Is(err, keycloak.ErrNotFound) {
+			err = nil
+		}
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("customer lookup: keycloak error", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("user directory unavailable", nil))
+		return
+	}
+
+	customers := make([]CustomerRecord, 0, len(users))
+	for _, u := range users {
+		rec, err := customerRecord(ctx, u)
+		if err != nil {
+			logging.From(c.Request.Context()).Error("customer lookup", zap.String("customer_id", u.ID), zap.Error(err))
+			apierr.Abort(c, apierr.Internal("failed to load customer", nil))
+			return
+		}
+		customers = append(customers, rec)
+		if phone != "" {
+			target += " " + rec.Email
+		}
+	}
+
+	_, _ = db.ExecContext(c.Request.Context(), "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')",
+		"Customer Lookup", admin, target)
+	if len(customers) == 0 {
+		apierr.Abort(c, apierr.NotFound("customer not found"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"customers": customers})
+}
+
+// customerRecord decrypts u's phone number and loads their Geo rows. Geo is
+// keyed by email for customers, by username for older rows.
+func customerRecord(ctx context.Context, u keycloak.User) (CustomerRecord, error) {
+	rec := CustomerRecord{
+		UserID:      u.ID,
+		Username:    u.Username,
+		Email:       u.Email,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		CountryCode: u.Attribute("countryCode"),
+		Addresses:   []CustomerAddress{},
+	}
+	var err error
+	if rec.PhoneNumber, err = fieldKeys.DecryptPhone(u.Attribute(fieldcrypt.PhoneAttr)); err != nil {
+		return rec, fmt.Errorf("phone: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+SELECT geo_id, is_primary, COALESCE(country_code, ''), postal_code, COALESCE(prefecture, ''), COALESCE(city, ''), town, building_name, room_number
+FROM Geo
+WHERE user_id IN (?, ?)
+ORDER BY is_primary DESC, geo_id ASC`, u.Email, u.Username)
+	if err != nil {
+		return rec, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a CustomerAddress
+		var enc [4]sql.NullString
+		if err := rows.Scan(&a.GeoID, &a.IsPrimary, &a.CountryCode, &enc[0], &a.Prefecture, &a.City, &enc[1], &enc[2], &enc[3]); err != nil {
+			return rec, err
+		}
+		for i, f := range []struct {
+			column string
+			out    *string
+		}{
+			{"postal_code", &a.PostalCode},
+			{"town", &a.Town},
+			{"building_name", &a.BuildingName},
+			{"room_number", &a.RoomNumber},
+		} {
+			if !enc[i].Valid {
+				continue
+			}
+			if *f.out, err = fieldKeys.Decrypt(enc[i].String, fieldcrypt.GeoAAD(f.column)); err != nil {
+				return rec, fmt.Errorf("geo %s %s: %w", a.GeoID, f.column, err)
+			}
+		}
+		rec.Addresses = append(rec.Addresses, a)
+	}
+	return rec, rows.Err()
+}
+
+// handleAdminListFlags returns every feature flag (common/flags), straight
+// from MySQL rather than the cache.
+func handleAdminListFlags(c *gin.Context) {
+	if _, err := verifiedAdmin(c); err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	list, err := flagStore.Load(c.Request.Context())
+	if err != nil {
+		logging.From(c.Request.Context()).Error("list feature flags", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to load flags", nil))
+		return
+	}
+	if list == nil {
+		list = []flags.Flag{}
+	}
+	c.JSON(http.StatusOK, gin.H{"flags": list})
+}
+
+// handleAdminPutFlag creates or replaces the flag named in the path. A boolean
+// flag is rollout_percent 100 (the default); targeting lists narrow it down.
+// Services see the change within a few seconds, once their local copy expires.
+func handleAdminPutFlag(c *gin.Context) {
+	admin, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	var body struct {
+		Description string   `json:"description"`
+		Enabled     bool     `json:"enabled"`
+		Rollout     *int     `json:"rollout_percent"`
+		Users       []string `json:"target_users"`
+		Roles       []string `json:"target_roles"`
+		Countries   []string `json:"target_countries"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.Validation("invalid request body"))
+		return
+	}
+	f := flags.Flag{
+		Key:         c.Param("key"),
+		Description: strings.TrimSpace(body.Description),
+		Enabled:     body.Enabled,
+		Rollout:     100,
+		Users:       body.Users,
+		Roles:       body.Roles,
+		Countries:   body.Countries,
+		UpdatedBy:   admin,
+	}
+	if body.Rollout != nil {
+		f.Rollout = *body.Rollout
+	}
+
+	ctx := c.Request.Context()
+	if err := flagStore.Put(ctx, f); err != nil {
+		if errors.Is(err, flags.ErrInvalid) {
+			apierr.Abort(c, apierr.Validation(err.Error()))
+			return
+		}
+		logging.From(c.Request.Context()).Error("put feature flag", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to save flag", nil))
+		return
+	}
+	if err := flagClient.Invalidate(ctx); err != nil {
+		logging.From(c.Request.Context()).Warn("feature flag cache not cleared", zap.Error(err))
+	}
+	_, _ = db.ExecContext(ctx, "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')",
+		fmt.Sprintf("Feature Flag Updated (enabled=%v, rollout=%d%%)", f.Enabled, f.Rollout), admin, f.Key)
+
+	saved, _, err := flagStore.Get(ctx, f.Key)
+	if err != nil {
+		saved = f
+	}
+	c.JSON(http.StatusOK, saved)
+}
+
+func handleAdminDeleteFlag(c *gin.Context) {
+	admin, err := verifiedAdmin(c)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	key := c.Param("key")
+	found, err := flagStore.Delete(ctx, key)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("delete feature flag", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to delete flag", nil))
+		return
+	}
+	if !found {
+		apierr.Abort(c, apierr.NotFound("flag not found"))
+		return
+	}
+	if err := flagClient.Invalidate(ctx); err != nil {
+		logging.From(c.Request.Context()).Warn("feature flag cache not cleared", zap.Error(err))
+	}
+	_, _ = db.ExecContext(ctx, "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')",
+		"Feature Flag Deleted", admin, key)
+	c.Status(http.StatusNoContent)
+}
+
+// handleAdminEvaluateFlag answers "would this user get the flag?" for
+// ?user_id=, ?role= (repeatable) and ?country=, with the deciding rule.
+func handleAdminEvaluateFlag(c *gin.Context) {
+	if _, err := verifiedAdmin(c); err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	f, ok, err := flagStore.Get(c.Request.Context(), c.Param("key"))
+	if err != nil {
+		logging.From(c.Request.Context()).Error("get feature flag", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("failed to load flag", nil))
+		return
+	}
+	if !ok {
+		apierr.Abort(c, apierr.NotFound("flag not found"))
+		return
+	}
+	s := flags.Subject{UserID: c.Query("user_id"), Roles: c.QueryArray("role"), Country: c.Query("country")}
+	on, reason := f.Evaluate(s)
+	c.JSON(http.StatusOK, gin.H{"flag": f.Key, "enabled": on, "reason": reason})
+}
+
+// adjustProductRating applies ratings.After to the product inside tx, with the
+// row locked so concurrent reviews and moderation do not lose updates, and
+// moves the rating's ReviewHistogram count the same way. delta 0 only reads
+// the current rating.
+func adjustProductRating(ctx context.Context, tx *sql.Tx, productID string, rating, delta int) (float64, int, error) {
+	var avg float64
+	var cnt int
+	err := tx.QueryRowContext(ctx, "SELECT avg_review, review_count FROM Product WHERE product_id=? FOR UPDATE", productID).Scan(&avg, &cnt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil || delta == 0 {
+		return avg, cnt, err
+	}
+	avg, cnt = ratings.After(avg, cnt, rating, delta)
+	_, err = tx.ExecContext(ctx, "UPDATE Product SET avg_review=?, review_count=? WHERE product_id=?", avg, cnt, productID)
+	if err == nil && rating >= 1 && rating <= 5 {
+		col := fmt.Sprintf("stars_%d", rating)
+		_, err = tx.ExecContext(ctx, "INSERT INTO ReviewHistogram (product_id, "+col+") VALUES (?, GREATEST(?, 0)) ON DUPLICATE KEY UPDATE "+col+" = GREATEST("+col+" + ?, 0)",
+			productID, delta, delta)
+	}
+	return avg, cnt, err
+}
+
+// handleAdminReviewReports is the moderation queue: reviews with reports in
+// ?status= (open by default, or actioned, dismissed, all), most reported first.
+func handleAdminReviewReports(c *gin.Context) {
+	if _, err := verifiedAdmin(c); err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	status := c.DefaultQuery("status", "open")
+	switch status {
+	case "open", "actioned", "dismissed", "all":
+	default:
+		apierr.Abort(c, apierr.Validation("status must be open, actioned, dismissed or all"))
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	where, args := "", []any{}
+	if status != "all" {
+		where, args = "WHERE rr.status = ?", append(args, status)
+	}
+	ctx := c.Request.Context()
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT rr.review_id) FROM ReviewReport rr "+where, args...).Scan(&total); err != nil {
+		logging.From(c.Request.Context()).Error("review report count", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.review_id, r.product_id, COALESCE(p.product_name, ''), r.user_id,
+		       r.rating, COALESCE(r.comment, ''), r.status,
+		       COUNT(*), GROUP_CONCAT(rr.reason), MIN(rr.created_at), MAX(rr.created_at)
+		FROM ReviewReport rr
+		JOIN Review r ON r.review_id = rr.review_id
+		LEFT JOIN Product p ON p.product_id = r.product_id
+		`+where+`
+		GROUP BY r.review_id, r.product_id, p.product_name, r.user_id, r.rating, r.comment, r.status
+		ORDER BY COUNT(*) DESC, MAX(rr.created_at) DESC
+		LIMIT ? OFFSET ?`, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("review report queue", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	defer rows.Close()
+
+	type QueueItem struct {
+		ReviewID      string         `json:"review_id"`
+		ProductID     string         `json:"product_id"`
+		ProductName   string         `json:"product_name"`
+		UserID        string         `json:"user_id"`
+		Rating        int            `json:"rating"`
+		Comment       string         `json:"comment"`
+		ReviewStatus  string         `json:"review_status"`
+		ReportCount   int            `json:"report_count"`
+		Reasons       map[string]int `json:"reasons"`
+		FirstReported string         `json:"first_reported_at"`
+		LastReported  string         `json:"last_reported_at"`
+	}
+	items := []QueueItem{}
+	for rows.Next() {
+		var it QueueItem
+		var reasons string
+		var first, last time.Time
+		if err := rows.Scan(&it.ReviewID, &it.ProductID, &it.ProductName, &it.UserID,
+			&it.Rating, &it.Comment, &it.ReviewStatus,
+			&it.ReportCount, &reasons, &first, &last); err != nil {
+			logging.From(c.Request.Context()).Error("review report row", zap.Error(err))
+			continue
+		}
+		it.Reasons = map[string]int{}
+		for _, r := range strings.Split(reasons, ",") {
+			it.Reasons[r]++
+		}
+		it.FirstReported = first.Format("2006-01-02 15:04:05")
+		it.LastReported = last.Format("2006-01-02 15:04:05")
+		items = append(items, it)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// handleAdminReviewDetail returns one review with every report against it,
+// details included, for the admin deciding what to do with it.
+func handleAdminReviewDetail(c *gin.Context) {
+	if _, err := verifiedAdmin(c); err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	reviewID := c.Param("reviewId")
+
+	var rv struct {
+		ReviewID    string `json:"review_id"`
+		ProductID   string `json:"product_id"`
+		UserID      string `json:"user_id"`
+		Rating      int    `json:"rating"`
+		Comment     string `json:"comment"`
+		Status      string `json:"status"`
+		ModeratedBy string `json:"moderated_by,omitempty"`
+	}
+	err := db.QueryRowContext(ctx,
+		"SELECT review_id, product_id, user_id, rating, COALESCE(comment, ''), status, COALESCE(moderated_by, '') FROM Review WHERE review_id=?",
+		reviewID).Scan(&rv.ReviewID, &rv.ProductID, &rv.UserID, &rv.Rating, &rv.Comment, &rv.Status, &rv.ModeratedBy)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierr.Abort(c, apierr.NotFound("review not found"))
+		return
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("admin review detail", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT report_id, reporter_id, reason, COALESCE(details, ''), status, created_at, COALESCE(resolved_by, '')
+		FROM ReviewReport WHERE review_id=? ORDER BY created_at DESC`, reviewID)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("admin review reports", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	defer rows.Close()
+
+	type Report struct {
+		ReportID   int64  `json:"report_id"`
+		ReporterID string `json:"reporter_id"`
+		Reason     string `json:"reason"`
+		Details    string `json:"details"`
+		Status     string `json:"status"`
+		CreatedAt  string `json:"created_at"`
+		ResolvedBy string `json:"resolved_by,omitempty"`
+	}
+	reports := []Report{}
+	for rows.Next() {
+		var r Report
+		var createdAt time.Time
+		if err := rows.Scan(&r.ReportID, &r.ReporterID, &r.Reason, &r.Details, &r.Status, &createdAt, &r.ResolvedBy); err != nil {
+			logging.From(c.Request.Context()).Error("admin review report row", zap.Error(err))
+			continue
+		}
+		r.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		reports = append(reports, r)
+	}
+	c.JSON(http.StatusOK, gin.H{"review": rv, "reports": reports})
+}
+
+// reviewModeration is one admin action on a review: the statuses it applies
+// to, where it leaves the review, and what it does to the open reports.
+type reviewModeration struct {
+	action  string // ReviewModeratedPayload.Action; "" for dismiss
+	audit   string
+	from    []string
+	to      string // "" leaves the review as it is
+	reports string // what open reports become
+}
+
+var (
+	moderateHide    = reviewModeration{action: "hide", audit: "Review Hidden", from: []string{"active"}, to: "hidden", reports: "actioned"}
+	moderateRestore = reviewModeration{action: "restore", audit: "Review Restored", from: []string{"hidden"}, to: "active", reports: "dismissed"}
+	moderateDelete  = reviewModeration{action: "delete", audit: "Review Deleted", from: []string{"active", "hidden"}, to: "deleted", reports: "actioned"}
+	moderateDismiss = reviewModeration{audit: "Review Reports Dismissed", reports: "dismissed"}
+)
+
+// ratingDelta is what moving a review from one status to another does to the
+// product's review count: only active reviews are counted.
+func ratingDelta(from, to string) int {
+	switch {
+	case from == to || to == "":
+		return 0
+	case to == "active":
+		return 1
+	case from == "active":
+		return -1
+	}
+	return 0
+}
+
+// handleModerateReview runs m on the review in the path. The review, the
+// product rating, the reports, the outbox event and the audit row change in
+// one transaction. An optional JSON {"reason": "..."} goes into the audit row.
+//
+// Hiding or deleting sets moderated_by, which stops the author from simply
+// posting the review again; restoring clears it.
+func handleModerateReview(m reviewModeration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, err := verifiedAdmin(c)
+		if err != nil {
+			apierr.Abort(c, err)
+			return
+		}
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&body); err != nil {
+				apierr.Abort(c, apierr.Validation("invalid request body"))
+				return
+			}
+		}
+		auditAction := m.audit
+		if reason := strings.TrimSpace(body.Reason); reason != "" {
+			auditAction = text.TruncateRunes(auditAction+" ("+reason+")", 128)
+		}
+
+		ctx := c.Request.Context()
+		reviewID := c.Param("reviewId")
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			apierr.Abort(c, apierr.Internal("database error", err))
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		var productID, userID, status string
+		var rating int
+		err = tx.QueryRowContext(ctx, "SELECT product_id, user_id, rating, status FROM Review WHERE review_id=? FOR UPDATE", reviewID).
+			Scan(&productID, &userID, &rating, &status)
+		if errors.Is(err, sql.ErrNoRows) {
+			apierr.Abort(c, apierr.NotFound("review not found"))
+			return
+		}
+		if err != nil {
+			logging.From(c.Request.Context()).Error("moderate review", zap.String("review_id", reviewID), zap.Error(err))
+			apierr.Abort(c, apierr.Internal("database error", nil))
+			return
+		}
+		if m.to != "" && !slices.Contains(m.from, status) {
+			apierr.Abort(c, apierr.Conflict(fmt.Sprintf("cannot %s a review that is %s", m.action, status)))
+			return
+		}
+
+		newStatus := status
+		if m.to != "" {
+			newStatus = m.to
+			if m.to == "active" {
+				_, err = tx.ExecContext(ctx, "UPDATE Review SET status=?, moderated_by=NULL, moderated_at=NULL WHERE review_id=?", m.to, reviewID)
+			} else {
+				_, err = tx.ExecContext(ctx, "UPDATE Review SET status=?, moderated_by=?, moderated_at=NOW() WHERE review_id=?", m.to, admin, reviewID)
+			}
+		}
+		var avg float64
+		var cnt int
+		if err == nil {
+			avg, cnt, err = adjustProductRating(ctx, tx, productID, rating, ratingDelta(status, newStatus))
+		}
+		var resolved int64
+		if err == nil {
+			var res sql.Result
+			res, err = tx.ExecContext(ctx,
+				"UPDATE ReviewReport SET status=?, resolved_at=NOW(), resolved_by=? WHERE review_id=? AND status='open'",
+				m.reports, admin, reviewID)
+			if err == nil {
+				resolved, _ = res.RowsAffected()
+			}
+		}
+		if err == nil && m.to == "" && resolved == 0 {
+			apierr.Abort(c, apierr.Conflict("review has no open reports"))
+			return
+		}
+		if err == nil && m.action != "" {
+			err = events.Enqueue(ctx, tx, events.ReviewModerated, productID, events.ReviewModeratedPayload{
+				ReviewID: reviewID, ProductID: productID, UserID: userID, Action: m.action,
+				Status: newStatus, Moderator: admin, AvgReview: avg, ReviewCount: cnt,
+			})
+		}
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO AuditLog (action, actor, actor_type, target, status) VALUES (?, ?, 'admin', ?, 'success')",
+				auditAction, admin, reviewID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			logging.From(c.Request.Context()).Error("moderate review", zap.String("review_id", reviewID), zap.Error(err))
+			apierr.Abort(c, apierr.Internal("database error", nil))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"review_id":        reviewID,
+			"status":           newStatus,
+			"reports_resolved": resolved,
+			"avg_review":       avg,
+			"review_count":     cnt,
+		})
+	}
+}
+
+// handleSellerReviews lists active reviews of the seller's products, newest
+// first, with the seller's reply if any. ?product_id= narrows it to one
+// product, ?unreplied=true to reviews still waiting for a reply. Reviewer ids
+// are not included.
+func handleSellerReviews(c *gin.Context) {
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeReviewsRead)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	where := "p.seller_id = ? AND r.status = 'active'"
+	args := []any{sellerID}
+	if productID := c.Query("product_id"); productID != "" {
+		where += " AND r.product_id = ?"
+		args = append(args, productID)
+	}
+	if c.Query("unreplied") == "true" {
+		where += " AND rp.review_id IS NULL"
+	}
+	from := `
+		FROM Review r
+		JOIN Product p ON p.product_id = r.product_id
+		LEFT JOIN ReviewReply rp ON rp.review_id = r.review_id
+		WHERE ` + where
+
+	ctx := c.Request.Context()
+	var total int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		logging.From(c.Request.Context()).Error("seller reviews count", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.review_id, r.product_id, COALESCE(p.product_name, ''), r.rating,
+		       COALESCE(r.comment, ''), r.created_at, rp.body, rp.updated_at`+from+`
+		ORDER BY r.created_at DESC
+		LIMIT ? OFFSET ?`, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		logging.From(c.Request.Context()).Error("seller reviews query", zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	defer rows.Close()
+
+	type SellerReview struct {
+		ReviewID    string  `json:"review_id"`
+		ProductID   string  `json:"product_id"`
+		ProductName string  `json:"product_name"`
+		Rating      int     `json:"rating"`
+		Comment     string  `json:"comment"`
+		CreatedAt   string  `json:"created_at"`
+		Reply       *string `json:"reply"`
+		RepliedAt   string  `json:"replied_at,omitempty"`
+	}
+	items := []SellerReview{}
+	for rows.Next() {
+		var it SellerReview
+		var createdAt time.Time
+		var reply sql.NullString
+		var repliedAt sql.NullTime
+		if err := rows.Scan(&it.ReviewID, &it.ProductID, &it.ProductName, &it.Rating, &it.Comment, &createdAt, &reply, &repliedAt); err != nil {
+			logging.From(c.Request.Context()).Error("seller review row", zap.Error(err))
+			continue
+		}
+		it.CreatedAt = createdAt.Format("2006-01-02 15:04:05")
+		if reply.Valid {
+			it.Reply = &reply.String
+			it.RepliedAt = repliedAt.Time.Format("2006-01-02 15:04:05")
+		}
+		items = append(items, it)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "limit": limit})
+}
+
+// sellerReview locks the review in tx and checks it is of one of the seller's
+// products; another seller's review is reported as not found.
+func sellerReview(ctx context.Context, tx *sql.Tx, reviewID, sellerID string) (productID, reviewer, status string, err error) {
+	var owner string
+	err = tx.QueryRowContext(ctx, `
+		SELECT r.product_id, r.user_id, r.status, COALESCE(p.seller_id, '')
+		FROM Review r JOIN Product p ON p.product_id = r.product_id
+		WHERE r.review_id = ? FOR UPDATE`, reviewID).Scan(&productID, &reviewer, &status, &owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != sellerID) {
+		return "", "", "", apierr.NotFound("review not found")
+	}
+	return productID, reviewer, status, err
+}
+
+// handleSellerPutReply writes the seller's public reply to a review of one of
+// their products, {"body": "..."} up to 2000 characters: 201 for a new reply,
+// 200 for an edit. A new or changed reply writes ReviewReplied to the outbox,
+// keyed by product like the other review events, for a future notification
+// consumer; nothing delivers it to the reviewer yet.
+func handleSellerPutReply(c *gin.Context) {
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeReviewsWrite)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	var body struct {
+		Body string `json:"body"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		apierr.Abort(c, apierr.Validation("invalid request body"))
+		return
+	}
+	body.Body = strings.TrimSpace(body.Body)
+	if body.Body == "" || utf8.RuneCountInString(body.Body) > 2000 {
+		apierr.Abort(c, apierr.Validation("body must be 1 to 2000 characters").Field("body", "is invalid"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	reviewID := c.Param("reviewId")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	productID, reviewer, status, err := sellerReview(ctx, tx, reviewID, sellerID)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	if status != "active" {
+		apierr.Abort(c, apierr.Conflict("cannot reply to a "+status+" review"))
+		return
+	}
+
+	// 1 row affected: inserted; 2: updated; 0: same text as before.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO ReviewReply (review_id, seller_id, body) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE body = VALUES(body)`, reviewID, sellerID, body.Body)
+	var affected int64
+	if err == nil {
+		affected, _ = res.RowsAffected()
+		if affected > 0 {
+			err = events.Enqueue(ctx, tx, events.ReviewReplied, productID, events.ReviewRepliedPayload{
+				ReviewID: reviewID, ProductID: productID, UserID: reviewer, SellerID: sellerID, Edited: affected == 2,
+			})
+		}
+	}
+	var createdAt, updatedAt time.Time
+	if err == nil {
+		err = tx.QueryRowContext(ctx, "SELECT created_at, updated_at FROM ReviewReply WHERE review_id = ?", reviewID).Scan(&createdAt, &updatedAt)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("seller reply to review", zap.String("review_id", reviewID), zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+
+	code := http.StatusOK
+	if affected == 1 {
+		code = http.StatusCreated
+	}
+	c.JSON(code, gin.H{
+		"review_id":  reviewID,
+		"body":       body.Body,
+		"created_at": createdAt.Format("2006-01-02 15:04:05"),
+		"updated_at": updatedAt.Format("2006-01-02 15:04:05"),
+	})
+}
+
+func handleSellerDeleteReply(c *gin.Context) {
+	sellerID, err := sellerFromRequest(c, commonauth.ScopeReviewsWrite)
+	if err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	reviewID := c.Param("reviewId")
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		apierr.Abort(c, apierr.Internal("database error", err))
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, _, _, err := sellerReview(ctx, tx, reviewID, sellerID); err != nil {
+		apierr.Abort(c, err)
+		return
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM ReviewReply WHERE review_id = ?", reviewID)
+	if err == nil {
+		if n, _ := res.RowsAffected(); n == 0 {
+			apierr.Abort(c, apierr.NotFound("reply not found"))
+			return
+		}
+		err = tx.Commit()
+	}
+	if err != nil {
+		logging.From(c.Request.Context()).Error("delete seller reply", zap.String("review_id", reviewID), zap.Error(err))
+		apierr.Abort(c, apierr.Internal("database error", nil))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
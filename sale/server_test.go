@@ -1,6 +1,20 @@
 package main
 
-import "testing"
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/auth/authtest"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/metrics"
+)
 
 func TestEUCountries(t *testing.T) {
 	inEU := []string{"DE", "FR", "IT", "ES", "NL", "SE"}
@@ -16,3 +30,188 @@ func TestEUCountries(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeScopes(t *testing.T) {
+	got, err := normalizeScopes([]string{"stock:write", " Products:Read ", "stock:write"})
+	if err != nil || len(got) != 2 || got[0] != "products:read" || got[1] != "stock:write" {
+		t.Errorf("normalizeScopes = (%v, %v), want [products:read stock:write]", got, err)
+	}
+	got, err = normalizeScopes([]string{"reviews:write", "reviews:read"})
+	if err != nil || len(got) != 2 || got[0] != "reviews:read" || got[1] != "reviews:write" {
+		t.Errorf("normalizeScopes = (%v, %v), want [reviews:read reviews:write]", got, err)
+	}
+	for _, bad := range [][]string{nil, {}, {"admin"}, {"orders:read", "orders:write"}} {
+		if got, err := normalizeScopes(bad); err == nil {
+			t.Errorf("normalizeScopes(%v) = %v, want error", bad, got)
+		}
+	}
+}
+
+func TestIsAdminClaims(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "ops@x.io, root@x.io")
+	t.Setenv("MYSQL_DSN", "u:p@tcp(db:3306)/mocktendb")
+	t.Setenv("MINIO_ACCESS_KEY", "test")
+	t.Setenv("MINIO_SECRET_KEY", "test")
+	var err error
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		claims map[string]interface{}
+		email  string
+		want   bool
+	}{
+		{"listed email", map[string]interface{}{}, "root@x.io", true},
+		{"admin role", map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"Admin"}}}, "a@x.io", true},
+		{"admin group", map[string]interface{}{"groups": []interface{}{"/admin-group"}}, "a@x.io", true},
+		{"seller", map[string]interface{}{"realm_access": map[string]interface{}{"roles": []interface{}{"seller"}}}, "a@x.io", false},
+	}
+	for _, c := range cases {
+		if got := isAdminClaims(c.claims, c.email); got != c.want {
+			t.Errorf("%s: isAdminClaims = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSLASummary(t *testing.T) {
+	if status, detail := slaSummary(nil); status != "operational" || detail != "No API traffic measured yet" {
+		t.Errorf("slaSummary(nil) = (%q, %q)", status, detail)
+	}
+	window := []metrics.WindowReport{{Window: "1h", Covered: "1h", Requests: 100, Compliance: 0.9, BurnRate: 10}}
+	reports := []metrics.RouteReport{
+		{Method: "GET", Path: "/api/search", BudgetMs: 800, Status: metrics.StatusBreaching, Windows: window},
+		{Method: "GET", Path: "/api/categories", BudgetMs: 200, Status: metrics.StatusOK, Windows: window},
+		{Method: "GET", Path: "/api/storage/:id.png", BudgetMs: 500, Status: metrics.StatusNoData},
+	}
+	status, detail := slaSummary(reports)
+	if status != "degraded" || !strings.HasPrefix(detail, "1 of 2 routes breaching SLA: GET /api/search 90.0% within 800ms over 1h (burn 10.0x)") {
+		t.Errorf("slaSummary = (%q, %q)", status, detail)
+	}
+}
+
+func TestServicesSummary(t *testing.T) {
+	services := []health.ServiceStatus{
+		{Service: "cart", Status: health.StatusUnknown},
+		{Service: "ecpay", Status: health.StatusDegraded, Latest: health.Report{Checks: []health.CheckResult{
+			{Name: "mysql", Status: health.StatusOK},
+			{Name: "stripe", Status: health.StatusDown},
+		}}},
+		{Service: "shipment", Status: health.StatusOK},
+	}
+	status, detail := servicesSummary(services)
+	if status != "degraded" || detail != "1 of 2 services not fully ready: ecpay degraded (stripe)" {
+		t.Errorf("servicesSummary = (%q, %q)", status, detail)
+	}
+	if status, detail := servicesSummary(services[2:]); status != "operational" || detail != "All 1 services ready" {
+		t.Errorf("servicesSummary(ok) = (%q, %q)", status, detail)
+	}
+	if status, _ := servicesSummary(services[:1]); status != "operational" {
+		t.Errorf("servicesSummary(unpolled) = %q", status)
+	}
+}
+
+func TestRatingDelta(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		want     int
+	}{
+		{"active", "hidden", -1},
+		{"hidden", "active", 1},
+		{"active", "deleted", -1},
+		{"hidden", "deleted", 0},
+		{"active", "", 0},
+	} {
+		if got := ratingDelta(tc.from, tc.to); got != tc.want {
+			t.Errorf("ratingDelta(%q, %q) = %d, want %d", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg = &Config{AdminUsers: []string{"root@x.io"}}
+	key := []byte("impersonation-test-key")
+	iss := authtest.NewIssuer(t)
+	authn = iss.Authenticator(t, commonauth.Options{ImpersonationKey: key})
+	impersonator = commonauth.NewImpersonationSigner(key)
+	t.Cleanup(func() { cfg, authn, impersonator = nil, nil, nil })
+
+	// a token letting the listed admin act as themselves must not count as admin
+	impTok, _, err := impersonator.Issue("ops@x.io", "root@x.io", true, time.Minute, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/v1/admin/impersonate", handleAdminImpersonate)
+	r.POST("/v1/admin/sessions/revoke", handleAdminRevokeSessions)
+	r.POST("/v1/admin/sessions/reinstate", handleAdminReinstateUser)
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"customer", iss.Bearer(t, authtest.WithEmail("a@x.io")), http.StatusForbidden},
+		{"expired admin", iss.Bearer(t, authtest.WithEmail("root@x.io"), authtest.WithExpiry(-time.Hour)), http.StatusUnauthorized},
+		{"no email", iss.Bearer(t, authtest.WithClaim("email", nil)), http.StatusUnauthorized},
+		{"impersonation token", "Bearer " + impTok, http.StatusForbidden},
+		// admins get as far as body validation
+		{"listed admin", iss.Bearer(t, authtest.WithEmail("root@x.io")), http.StatusBadRequest},
+		{"admin role", iss.Bearer(t, authtest.WithEmail("b@x.io"), authtest.WithRoles("admin")), http.StatusBadRequest},
+	}
+	for _, path := range []string{"/v1/admin/impersonate", "/v1/admin/sessions/revoke", "/v1/admin/sessions/reinstate"} {
+		for _, tc := range cases {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Errorf("%s %s: status = %d, want %d (%s)", path, tc.name, w.Code, tc.want, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestVerifiedEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key := []byte("impersonation-test-key")
+	iss := authtest.NewIssuer(t)
+	authn = iss.Authenticator(t, commonauth.Options{ImpersonationKey: key})
+	t.Cleanup(func() { authn = nil })
+
+	impTok, _, err := commonauth.NewImpersonationSigner(key).Issue("ops@x.io", "s@x.io", true, time.Minute, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the old decoder trusted any payload; an unsigned token must now fail
+	unsigned := "Bearer " + base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"email":"s@x.io"}`)) + "."
+
+	cases := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"no token", "", ""},
+		{"unsigned", unsigned, ""},
+		{"expired", iss.Bearer(t, authtest.WithEmail("s@x.io"), authtest.WithExpiry(-time.Hour)), ""},
+		{"impersonation token", "Bearer " + impTok, ""},
+		{"no email", iss.Bearer(t, authtest.WithClaim("email", nil)), ""},
+		{"seller", iss.Bearer(t, authtest.WithEmail("s@x.io")), "s@x.io"},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/v1/seller/profile", nil)
+		if tc.header != "" {
+			c.Request.Header.Set("Authorization", tc.header)
+		}
+		got, err := verifiedEmail(c)
+		if got != tc.want || (tc.want == "") != (err != nil) {
+			t.Errorf("%s: verifiedEmail = %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
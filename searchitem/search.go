@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -13,10 +13,20 @@ import (
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	meilisearch "github.com/meilisearch/meilisearch-go"
+	"github.com/mockten/mockten/common/apierr"
+	commonauth "github.com/mockten/mockten/common/auth"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/ratelimit"
+	"github.com/mockten/mockten/common/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/valyala/fasthttp"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -80,7 +90,7 @@ func searchHandler(c *gin.Context) {
 		pageStr = "1"
 	}
 
-	logger.Debug(
+	logging.From(c.Request.Context()).Debug(
 		"Request log",
 		zap.String("query", query),
 		zap.String("page", pageStr),
@@ -182,17 +192,22 @@ func searchHandler(c *gin.Context) {
 		finalFilter = filters
 	}
 
-	searchRes, err := meiliclient.Index("products").Search(
-		query,
-		&meilisearch.SearchRequest{
-			Limit:  int64(limit),
-			Offset: int64(offset),
-			Filter: finalFilter,
-		},
-	)
+	var searchRes *meilisearch.SearchResponse
+	err = tracing.Call(c.Request.Context(), "meilisearch", "search", func() error {
+		var err error
+		searchRes, err = meiliclient.Index("products").Search(
+			query,
+			&meilisearch.SearchRequest{
+				Limit:  int64(limit),
+				Offset: int64(offset),
+				Filter: finalFilter,
+			},
+		)
+		return err
+	})
 	if err != nil {
-		logger.Error("failed to search in MeiliSearch.", zap.Error(err))
-		c.JSON(http.StatusNoContent, gin.H{"message": "There is no items"})
+		logging.From(c.Request.Context()).Error("failed to search in MeiliSearch.", zap.Error(err))
+		apierr.Abort(c, apierr.Unavailable("search is temporarily unavailable", nil))
 		return
 	}
 
@@ -218,13 +233,13 @@ type Category struct {
 
 func getCategoryListHandler(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rows, err := db.Query(`
+		rows, err := db.QueryContext(c.Request.Context(), `
 			SELECT category_id, category_name, category_image
 			FROM Category
 			ORDER BY category_id
 		`)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+			apierr.Abort(c, apierr.Internal("Failed to fetch categories", err))
 			return
 		}
 		defer rows.Close()
@@ -233,7 +248,7 @@ func getCategoryListHandler(db *sql.DB) gin.HandlerFunc {
 		for rows.Next() {
 			var cat Category
 			if err := rows.Scan(&cat.CategoryID, &cat.CategoryName, &cat.CategoryImage); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan category"})
+				apierr.Abort(c, apierr.Internal("Failed to scan category", err))
 				return
 			}
 			categories = append(categories, cat)
@@ -257,32 +272,39 @@ func waitForMySQL(db *sql.DB, logger *zap.Logger) {
 	logger.Fatal("MySQL did not become ready in time.")
 }
 
-func main() {
-	var err error
-
-	environment := os.Getenv("MOCKTEN_ENV")
+// defaultRateLimits apply unless RATE_LIMIT_CONFIG overrides them. Search is
+// anonymous, so it is keyed on the client address; the bucket absorbs the
+// bursts of as-you-type queries.
+var defaultRateLimits = map[string]ratelimit.Policy{
+	"search": {Algorithm: ratelimit.TokenBucket, Limit: 120, Window: time.Minute, KeyBy: []string{"apikey", "ip"}},
+}
 
-	if environment == "development" || environment == "" {
-		config := zap.NewDevelopmentConfig()
-		config.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-		logger, err = config.Build()
-	} else {
-		config := zap.NewProductionConfig()
-		config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-		logger, err = config.Build()
-	}
+// Config is read from the environment by common/config.
+type Config struct {
+	Environment     string       `json:"environment" env:"MOCKTEN_ENV" default:"development"`
+	MySQL           config.MySQL `json:"mysql"`
+	Redis           config.Redis `json:"redis"`
+	Meili           config.Meili `json:"meili"`
+	RateLimitConfig string       `json:"rate_limit_config" env:"RATE_LIMIT_CONFIG"`
+}
 
+func main() {
+	var err error
+	cfg, err := config.Load[Config](config.Options{})
 	if err != nil {
-		log.Println("failed to set-up zap log in searchitem.")
-		panic(err)
+		log.Fatal(err)
 	}
 
+	logger = logging.Init("searchitem", logging.Options{Development: cfg.Environment == "development"})
+
 	defer logger.Sync()
+	logger.Info("config loaded", zap.String("config", config.Dump(cfg)))
 
-	meiliBackend := os.Getenv("MEILI_SVC")
-	if meiliBackend == "" {
-		meiliBackend = "meilisearch-service.default.svc.cluster.local"
+	shutdownTracing, err := tracing.Init(context.Background(), "searchitem")
+	if err != nil {
+		logger.Fatal("failed to set up tracing", zap.Error(err))
 	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
 
 	fasthttpClient := &fasthttp.Client{
 		MaxIdleConnDuration: 300 * time.Second,
@@ -290,7 +312,7 @@ func main() {
 		WriteTimeout:        10 * time.Second,
 	}
 	meiliclient = meilisearch.NewFastHTTPCustomClient(meilisearch.ClientConfig{
-		Host:    "http://" + meiliBackend + ":7700",
+		Host:    cfg.Meili.URL(),
 		Timeout: 10 * time.Second,
 	}, fasthttpClient)
 
@@ -304,9 +326,7 @@ func main() {
 		}
 	}()
 
-	dsn := "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
-
-	db, err := sql.Open("mysql", dsn)
+	db, err := tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 	if err != nil {
 		log.Fatalf("DB open error: %v", err)
 	}
@@ -314,13 +334,45 @@ func main() {
 	db.SetMaxIdleConns(2)
 	waitForMySQL(db, logger)
 	defer db.Close()
+	if err := migrate.Ensure(context.Background(), db, logger); err != nil {
+		logger.Fatal("MySQL schema not ready", zap.Error(err))
+	}
 
 	go exportMetrics()
 
-	router := gin.Default()
-	router.GET("v1/search", searchHandler)
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         cfg.Redis.Addr,
+		Password:     cfg.Redis.Password.Value(),
+		DB:           cfg.Redis.DB,
+		PoolSize:     3,
+		MinIdleConns: 1,
+	})
+	tracing.InstrumentRedis(rdb)
+	defer rdb.Close()
+
+	policies, err := ratelimit.LoadPolicies(cfg.RateLimitConfig, defaultRateLimits)
+	if err != nil {
+		logger.Fatal("failed to load rate limit config", zap.Error(err))
+	}
+	limits := ratelimit.New(ratelimit.NewRedisLimiter(rdb), ratelimit.Options{
+		Logger:   logger,
+		Policies: policies,
+		Keys:     map[string]ratelimit.KeyFunc{"apikey": ratelimit.ByAPIKey(commonauth.NewSQLAPIKeyStore(db)), "ip": ratelimit.ByIP()},
+	})
+	go limits.Watch(context.Background(), cfg.RateLimitConfig, defaultRateLimits)
+
+	router := gin.New()
+	router.Use(gin.Recovery(), tracing.Gin("searchitem"), logging.Gin(logger), metrics.Gin("searchitem"))
+	router.GET("v1/search", limits.For("search"), searchHandler)
 	router.GET("v1/categories", getCategoryListHandler(db))
 
+	// MySQL only serves the category list; search itself needs MeiliSearch.
+	health.New("searchitem").
+		Critical("meilisearch", health.HTTP(nil, cfg.Meili.URL()+"/health")).
+		Optional("mysql", health.SQL(db)).
+		Optional("redis", health.Redis(rdb)).
+		Gin(router)
+
 	router.Run(port)
 }
 
@@ -1,19 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
+	"github.com/mockten/mockten/common/apierr"
+	"github.com/mockten/mockten/common/config"
+	"github.com/mockten/mockten/common/events"
+	"github.com/mockten/mockten/common/flags"
+	"github.com/mockten/mockten/common/health"
+	"github.com/mockten/mockten/common/logging"
+	"github.com/mockten/mockten/common/metrics"
+	"github.com/mockten/mockten/common/migrate"
+	"github.com/mockten/mockten/common/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
-var db *sql.DB
+var (
+	cfg *Config
+	db  *sql.DB
+	// flagClient is nil until main has Redis; flags then read as off.
+	flagClient *flags.Client
+)
+
+// flagIgnoreSchedule does what TEST_MODE does, but can be switched on from
+// sale's admin API without a redeploy.
+const flagIgnoreSchedule = "shipment.ignore-schedule"
+
+// Config is read from the environment by common/config.
+type Config struct {
+	MySQL config.MySQL `json:"mysql"`
+	Redis config.Redis `json:"redis"`
+	// TestMode ignores a request's scheduled_start, so the worker advances
+	// the shipment on its next tick.
+	TestMode            bool `json:"test_mode" env:"TEST_MODE" default:"false"`
+	TickIntervalSeconds int  `json:"tick_interval_seconds" env:"TICK_INTERVAL_SECONDS" default:"200" validate:"min=1"`
+	Port                int  `json:"port" env:"PORT" default:"8080" validate:"min=1,max=65535"`
+}
 
 type ShipmentRequest struct {
 	ProductID      string `json:"product_id"`
@@ -33,13 +64,8 @@ type ShipmentResponse struct {
 
 func initDB() {
 	var err error
-	dsn := "mocktenusr:mocktenpassword@tcp(mysql-service.default.svc.cluster.local:3306)/mocktendb?parseTime=true"
-	if envDSN := os.Getenv("MYSQL_DSN"); envDSN != "" {
-		dsn = envDSN
-	}
-
 	for i := 0; i < 30; i++ {
-		db, err = sql.Open("mysql", dsn)
+		db, err = tracing.OpenDB("mysql", cfg.MySQL.DSN.Value())
 		if err == nil {
 			err = db.Ping()
 			if err == nil {
@@ -57,70 +83,94 @@ func initDB() {
 	db.SetMaxIdleConns(2)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	// Auto-migrate: add scheduled_start column if not exists using information_schema
-	var exists bool
-	err = db.QueryRow(`
-		SELECT COUNT(*) > 0 
-		FROM information_schema.columns 
-		WHERE table_schema = DATABASE() 
-		  AND table_name = 'Transaction' 
-		  AND column_name = 'scheduled_start'
-	`).Scan(&exists)
-	if err != nil {
-		log.Printf("Migration warning: failed to query information_schema: %v", err)
-	} else if !exists {
-		_, err = db.Exec(`ALTER TABLE Transaction ADD COLUMN scheduled_start DATETIME NULL`)
-		if err != nil {
-			log.Printf("Migration error (adding scheduled_start): %v", err)
-		} else {
-			log.Println("Migration: scheduled_start column added successfully")
-		}
-	} else {
-		log.Println("Migration: scheduled_start column already exists")
+	if err := migrate.Ensure(context.Background(), db, nil); err != nil {
+		log.Fatalf("MySQL schema not ready: %v", err)
 	}
 }
 
 func isTestMode() bool {
-	return os.Getenv("TEST_MODE") == "true"
+	return cfg.TestMode
 }
 
-func startBackgroundWorker() {
-	intervalStr := os.Getenv("TICK_INTERVAL_SECONDS")
-	interval := 200 // default 200 seconds
-	if intervalStr != "" {
-		if parsed, err := strconv.Atoi(intervalStr); err == nil && parsed > 0 {
-			interval = parsed
-		}
-	}
+// ignoresSchedule is isTestMode or the shipment.ignore-schedule flag.
+func ignoresSchedule(ctx context.Context) bool {
+	return isTestMode() || (flagClient != nil && flagClient.Enabled(ctx, flagIgnoreSchedule, flags.Subject{}))
+}
 
-	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+func startBackgroundWorker() {
+	ticker := time.NewTicker(time.Duration(cfg.TickIntervalSeconds) * time.Second)
 	go func() {
 		for range ticker.C {
 			log.Println("Running status progression worker...")
-			// Only advance statuses where scheduled_start has been reached (or is NULL for legacy rows)
-			query := `
-				UPDATE Transaction 
-				SET status = CASE 
-					WHEN status = 'in_transit' THEN 'delivered' 
-					WHEN status = 'picked_up' THEN 'in_transit' 
-					WHEN status = 'booked' THEN 'picked_up' 
-					ELSE status 
-				END,
-				updated_at = NOW()
-				WHERE status IN ('booked', 'picked_up', 'in_transit')
-				  AND (scheduled_start IS NULL OR scheduled_start <= NOW())
-			`
-			res, err := db.Exec(query)
+			n, err := advanceShipments(context.Background())
 			if err != nil {
 				log.Printf("Error updating transaction statuses: %v", err)
 			} else {
-				rowsAffected, _ := res.RowsAffected()
-				log.Printf("Status progression complete. Rows affected: %d", rowsAffected)
+				log.Printf("Status progression complete. Rows affected: %d", n)
 			}
 		}
 	}()
 }
 
+// nextStatus is the status a shipment leg advances to on the next tick, or ""
+// if it does not advance on its own.
+func nextStatus(status string) string {
+	switch status {
+	case "booked":
+		return "picked_up"
+	case "picked_up":
+		return "in_transit"
+	case "in_transit":
+		return "delivered"
+	}
+	return ""
+}
+
+// advanceShipments moves every due leg one status forward and records a
+// ShipmentStatusChanged event for each, in one transaction.
+func advanceShipments(ctx context.Context) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Only advance statuses where scheduled_start has been reached (or is NULL for legacy rows)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT transaction_id, product_id, status
+		FROM Transaction
+		WHERE status IN ('booked', 'picked_up', 'in_transit')
+		  AND (scheduled_start IS NULL OR scheduled_start <= NOW())
+		FOR UPDATE`)
+	if err != nil {
+		return 0, err
+	}
+	var due []events.ShipmentStatusChangedPayload
+	for rows.Next() {
+		var p events.ShipmentStatusChangedPayload
+		if err := rows.Scan(&p.TransactionID, &p.ProductID, &p.From); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.To = nextStatus(p.From)
+		due = append(due, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range due {
+		if _, err := tx.ExecContext(ctx, "UPDATE Transaction SET status = ?, updated_at = NOW() WHERE transaction_id = ?", p.To, p.TransactionID); err != nil {
+			return 0, err
+		}
+		if err := events.Enqueue(ctx, tx, events.ShipmentStatusChanged, p.TransactionID, p); err != nil {
+			return 0, err
+		}
+	}
+	return len(due), tx.Commit()
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -141,12 +191,13 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		var req ShipmentRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("invalid request body"))
 			return
 		}
 
 		if req.ProductID == "" || req.GeoID == "" {
-			http.Error(w, "product_id and geo_id are required", http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("product_id and geo_id are required").
+				Field("product_id", "is required").Field("geo_id", "is required"))
 			return
 		}
 
@@ -155,23 +206,19 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 		legType := "road"
 
 		// Determine scheduled_start:
-		// - TEST_MODE=true  → NULL (worker will process immediately on next tick)
-		// - Otherwise       → use provided scheduled_start, or NULL if not provided
+		// - TEST_MODE=true or the shipment.ignore-schedule flag → NULL (worker will process immediately on next tick)
+		// - Otherwise → use provided scheduled_start, or NULL if not provided
 		var scheduledStart *string
-		if !isTestMode() && req.ScheduledStart != "" {
+		if !ignoresSchedule(r.Context()) && req.ScheduledStart != "" {
 			scheduledStart = &req.ScheduledStart
 		}
-		// In TEST_MODE, leave scheduledStart as nil → no restriction, worker runs immediately
 
 		quantity := req.Quantity
 		if quantity < 1 {
 			quantity = 1
 		}
-		query := "INSERT INTO Transaction (transaction_id, product_id, geo_id, status, leg_type, scheduled_start, quantity) VALUES (?, ?, ?, ?, ?, ?, ?)"
-		_, err := db.Exec(query, transactionID, req.ProductID, req.GeoID, status, legType, scheduledStart, quantity)
-		if err != nil {
-			log.Printf("Error inserting transaction: %v", err)
-			http.Error(w, "Failed to create shipment", http.StatusInternalServerError)
+		if err := createShipment(r.Context(), transactionID, req, status, legType, scheduledStart, quantity); err != nil {
+			apierr.Write(w, r, apierr.Internal("Failed to create shipment", err))
 			return
 		}
 
@@ -184,7 +231,7 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 	case "GET":
 		userID := r.URL.Query().Get("userId")
 		if userID == "" {
-			http.Error(w, "userId query parameter is required", http.StatusBadRequest)
+			apierr.Write(w, r, apierr.Validation("userId query parameter is required").Field("userId", "is required"))
 			return
 		}
 
@@ -197,10 +244,9 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 			ORDER BY t.created_at DESC
 		`
 
-		rows, err := db.Query(query, userID)
+		rows, err := db.QueryContext(r.Context(), query, userID)
 		if err != nil {
-			log.Printf("Error querying shipments: %v", err)
-			http.Error(w, "Failed to retrieve shipments", http.StatusInternalServerError)
+			apierr.Write(w, r, apierr.Internal("Failed to retrieve shipments", err))
 			return
 		}
 		defer rows.Close()
@@ -209,7 +255,7 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 		for rows.Next() {
 			var s ShipmentResponse
 			if err := rows.Scan(&s.TransactionID, &s.ProductID, &s.ProductName, &s.Status, &s.PurchaseDate, &s.Quantity); err != nil {
-				log.Printf("Error scanning row: %v", err)
+				logging.From(r.Context()).Error("Error scanning row", zap.Error(err))
 				continue
 			}
 			shipments = append(shipments, s)
@@ -219,31 +265,81 @@ func handleShipment(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(shipments)
 
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierr.Write(w, r, apierr.MethodNotAllowed())
+	}
+}
+
+// createShipment inserts a leg and its first ShipmentStatusChanged event (from
+// "" to the initial status).
+func createShipment(ctx context.Context, transactionID string, req ShipmentRequest, status, legType string, scheduledStart *string, quantity int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := "INSERT INTO Transaction (transaction_id, product_id, geo_id, status, leg_type, scheduled_start, quantity) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, query, transactionID, req.ProductID, req.GeoID, status, legType, scheduledStart, quantity); err != nil {
+		return err
 	}
+	err = events.Enqueue(ctx, tx, events.ShipmentStatusChanged, transactionID, events.ShipmentStatusChangedPayload{
+		TransactionID: transactionID, ProductID: req.ProductID, To: status,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// newRedis connects to the Redis the outbox relay publishes events to.
+func newRedis() *redis.Client {
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.Redis.Addr, Password: cfg.Redis.Password.Value(), DB: cfg.Redis.DB, PoolSize: 2})
+	tracing.InstrumentRedis(rdb)
+	return rdb
 }
 
 func main() {
+	logger := logging.Init("shipment", logging.Options{})
+	defer func() { _ = logger.Sync() }()
+
+	var err error
+	if cfg, err = config.Load[Config](config.Options{}); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("config: %s", config.Dump(cfg))
+
+	shutdownTracing, err := tracing.Init(context.Background(), "shipment")
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() { _ = shutdownTracing(context.Background()) }()
+
 	initDB()
 	defer db.Close()
 
 	startBackgroundWorker()
 
+	// Status changes wait in the outbox while Redis is down, so it is optional.
+	rdb := newRedis()
+	defer rdb.Close()
+	go events.NewRelay(db, rdb, events.RelayOptions{Logger: logger}).Run(context.Background())
+	flagClient = flags.New(flags.NewSQLStore(db), flags.Options{Redis: rdb, Logger: logger})
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/shipment", handleShipment)
-	// healthcheck
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	health.New("shipment").
+		Critical("mysql", health.SQL(db)).
+		Optional("redis", health.Redis(rdb)).
+		Mount(mux)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	go func() {
+		if err := metrics.Serve(":9100"); err != nil {
+			log.Printf("metrics server: %v", err)
+		}
+	}()
 
-	log.Printf("Shipment service starting on port %s (TEST_MODE=%v)", port, isTestMode())
-	if err := http.ListenAndServe(":"+port, corsMiddleware(mux)); err != nil {
+	log.Printf("Shipment service starting on port %d (TEST_MODE=%v)", cfg.Port, isTestMode())
+	if err := http.ListenAndServe(":"+strconv.Itoa(cfg.Port), tracing.Handler(logging.Handler(metrics.Handler(corsMiddleware(mux), "shipment"), logger), "shipment")); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
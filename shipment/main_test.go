@@ -1,24 +1,38 @@
 package main
 
 import (
-	"os"
 	"testing"
+
+	"github.com/mockten/mockten/common/config"
 )
 
 func TestIsTestMode(t *testing.T) {
-	orig := os.Getenv("TEST_MODE")
-	defer os.Setenv("TEST_MODE", orig)
-
-	os.Setenv("TEST_MODE", "true")
-	if !isTestMode() {
-		t.Error("expected isTestMode() to be true when TEST_MODE=true")
-	}
-	os.Setenv("TEST_MODE", "false")
-	if isTestMode() {
-		t.Error("expected isTestMode() to be false when TEST_MODE=false")
+	t.Setenv("MYSQL_DSN", "u:p@tcp(db:3306)/mocktendb")
+	for _, c := range []struct {
+		env  string
+		want bool
+	}{{"true", true}, {"false", false}, {"", false}} {
+		t.Setenv("TEST_MODE", c.env)
+		var err error
+		if cfg, err = config.Load[Config](config.Options{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := isTestMode(); got != c.want {
+			t.Errorf("TEST_MODE=%q: isTestMode() = %v, want %v", c.env, got, c.want)
+		}
 	}
-	os.Unsetenv("TEST_MODE")
-	if isTestMode() {
-		t.Error("expected isTestMode() to be false when TEST_MODE is unset")
+}
+
+func TestNextStatus(t *testing.T) {
+	for _, c := range []struct{ from, to string }{
+		{"booked", "picked_up"},
+		{"picked_up", "in_transit"},
+		{"in_transit", "delivered"},
+		{"delivered", ""},
+		{"delayed", ""},
+	} {
+		if got := nextStatus(c.from); got != c.to {
+			t.Errorf("nextStatus(%q) = %q, want %q", c.from, got, c.to)
+		}
 	}
 }
@@ -58,7 +58,9 @@ if [ "$MOCKTEN_MODE" = "cloud" ]; then
   fi
 fi
 
-for key in GOOGLE_CLIENT_ID GOOGLE_CLIENT_SECRET FACEBOOK_CLIENT_ID FACEBOOK_CLIENT_SECRET; do
+# KEYCLOAK_ADMIN_CLIENT_SECRET is the mockten-backend service account's secret,
+# shared with the Go services that call the Admin API (common/keycloak).
+for key in GOOGLE_CLIENT_ID GOOGLE_CLIENT_SECRET FACEBOOK_CLIENT_ID FACEBOOK_CLIENT_SECRET KEYCLOAK_ADMIN_CLIENT_SECRET; do
   val="${!key}"
   if [ -n "$val" ]; then
     # Escape sed replacement metacharacters (\ & /) in the secret value.